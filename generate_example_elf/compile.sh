@@ -1,7 +1,6 @@
 #!/bin/bash
-TC_PATH="~/Downloads/riscv32-elf-ubuntu-22.04-llvm-nightly-2024.03.01-nightly/riscv/bin"
-AS="${TC_PATH}/riscv32-unknown-elf-as"
-LD="${TC_PATH}/riscv32-unknown-elf-ld"
-
-bash -c "$AS -march=rv32i -mabi=ilp32 -o hello_world.o -c hello_world.s"
-bash -c "$LD -T link.ld --no-dynamic-linker -m elf32lriscv -static -nostdlib -s -o hello.elf hello_world.o"
+# Assembles with the assembler of this repository, the sections start at
+# 0x80000000 like in link.ld. With the llvm toolchain instead:
+#   riscv32-unknown-elf-as -march=rv32i -mabi=ilp32 -o hello_world.o -c hello_world.s
+#   riscv32-unknown-elf-ld -T link.ld --no-dynamic-linker -m elf32lriscv -static -nostdlib -s -o hello.elf hello_world.o
+go run ../tools/assembler -file hello_world.s -out hello.elf -base 0x80000000
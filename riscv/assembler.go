@@ -0,0 +1,951 @@
+package riscv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Program is an assembled program, the sections are placed one after
+// another from the base address in the order they first appear.
+type Program struct {
+	Xlen int
+	// Entry is the address of _start, or the base address without it.
+	Entry    uint64
+	Sections []*ProgramSection
+	// Symbols are the addresses of the labels and the values of the .equ
+	// and .set constants, numeric labels (1:) are not symbols.
+	Symbols map[string]uint64
+
+	globals   map[string]bool
+	constants map[string]bool
+}
+
+// ProgramSection is a section of an assembled program.
+type ProgramSection struct {
+	Name string
+	Addr uint64
+	Data []byte
+	// Instructions are the instructions of the section in address order,
+	// Offsets are their offsets in Data.
+	Instructions []Instruction
+	Offsets      []uint64
+
+	// the size after the first pass and the largest alignment
+	size  uint64
+	align uint64
+}
+
+// Executable returns if the section contains code, like .text and .text.*
+func (s *ProgramSection) Executable() bool {
+	return s.Name == ".text" || strings.HasPrefix(s.Name, ".text.")
+}
+
+// NoBits returns if the section is zero initialized and not stored in the
+// elf file, like .bss.
+func (s *ProgramSection) NoBits() bool {
+	return s.Name == ".bss" || strings.HasPrefix(s.Name, ".bss.") || s.Name == ".sbss"
+}
+
+// Instructions returns the instructions of all sections.
+func (p *Program) Instructions() []Instruction {
+	var instrs []Instruction
+	for _, s := range p.Sections {
+		instrs = append(instrs, s.Instructions...)
+	}
+	return instrs
+}
+
+// Binary returns the memory image of the program from the address of the
+// first section, the gaps between the sections are zero.
+func (p *Program) Binary() []byte {
+	if len(p.Sections) == 0 {
+		return nil
+	}
+	begin := p.Sections[0].Addr
+	last := p.Sections[len(p.Sections)-1]
+	image := make([]byte, last.Addr+uint64(len(last.Data))-begin)
+	for _, s := range p.Sections {
+		copy(image[s.Addr-begin:], s.Data)
+	}
+	return image
+}
+
+// Load copies the sections to memory and points the pc to the entry point,
+// like LoadElf. The XLEN of the registers has to be the one the program was
+// assembled for.
+func (p *Program) Load(mem Memory, regs Registers) error {
+	if p.Xlen != regs.Xlen() {
+		return fmt.Errorf("program is assembled for XLEN=%d but the hart has XLEN=%d", p.Xlen, regs.Xlen())
+	}
+	for _, s := range p.Sections {
+		for i, b := range s.Data {
+			err := mem.StoreByte(s.Addr+uint64(i), uint64(b))
+			if err != nil {
+				return fmt.Errorf("can't load section %s at addr=%#x with error: %w", s.Name, s.Addr, err)
+			}
+		}
+	}
+	regs.SetPc(p.Entry)
+	return nil
+}
+
+// AssembleInstructions assembles the source at address zero and returns
+// its instructions, for programs without data.
+func AssembleInstructions(source string, xlen int) ([]Instruction, error) {
+	p, err := Assemble(source, xlen, 0)
+	if err != nil {
+		return nil, err
+	}
+	return p.Instructions(), nil
+}
+
+// Assemble assembles the source in the syntax of the GNU assembler for a
+// hart with the XLEN, the first section starts at base.
+//
+// All instructions the emulator executes are supported with the usual
+// pseudo-instructions (li, la, mv, j, call, ret, beqz, csrr, ...). Compressed
+// instructions are only emitted for the c. mnemonics, the others are never
+// compressed. Labels are symbols or numbers (1: referenced as 1b or 1f) and
+// expressions can use %hi and %lo. The directives are .text, .data, .rodata,
+// .bss, .section, .byte, .half, .word, .dword, .string, .ascii, .zero,
+// .align, .p2align, .balign, .equ, .set and .globl, others like .type or
+// .size are ignored.
+func Assemble(source string, xlen int, base uint64) (*Program, error) {
+	if xlen != XLEN_32 && xlen != XLEN_64 {
+		return nil, fmt.Errorf("can't assemble for XLEN=%d", xlen)
+	}
+	a := &assembler{
+		xlen: xlen,
+		program: &Program{
+			Xlen:      xlen,
+			Symbols:   map[string]uint64{},
+			globals:   map[string]bool{},
+			constants: map[string]bool{},
+		},
+		numericLabels: map[string][]asmNumericLabel{},
+	}
+	a.section = a.sectionNamed(".text")
+
+	// The first pass finds the size of every statement, so the second pass
+	// knows the address of every label.
+	for i, line := range strings.Split(source, "\n") {
+		if err := a.parseLine(i+1, line); err != nil {
+			return nil, err
+		}
+	}
+	a.layout(base)
+
+	a.final = true
+	for _, stmt := range a.statements {
+		if err := a.emit(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	p := a.program
+	p.Entry = base
+	if len(p.Sections) > 0 {
+		p.Entry = p.Sections[0].Addr
+	}
+	if entry, ok := p.Symbols["_start"]; ok {
+		p.Entry = entry
+	}
+	return p, nil
+}
+
+// asmStatement is an instruction or directive of the source.
+type asmStatement struct {
+	line    int
+	text    string
+	name    string
+	args    []string
+	section *ProgramSection
+	offset  uint64
+	size    uint64
+	// the index of the statement, numeric labels are found by it
+	index int
+}
+
+type asmLabel struct {
+	section *ProgramSection
+	offset  uint64
+}
+
+type asmNumericLabel struct {
+	asmLabel
+	// the index of the statement after the label
+	index int
+}
+
+type assembler struct {
+	xlen    int
+	program *Program
+	section *ProgramSection
+
+	statements    []*asmStatement
+	labels        map[string]asmLabel
+	numericLabels map[string][]asmNumericLabel
+
+	// the labels are known in the second pass, in the first one they are
+	// zero and the range of the values is not checked
+	final bool
+	stmt  *asmStatement
+	pc    uint64
+	// the first error of the statement, the operand helpers record it so
+	// the instructions can be assembled without checking every operand
+	err error
+	// if an expression used a label, whose value isn't known in the first
+	// pass
+	usedLabel bool
+}
+
+// fail records the error of the statement, only the first one is kept.
+func (a *assembler) fail(format string, args ...interface{}) {
+	if a.err == nil {
+		a.err = fmt.Errorf(format, args...)
+	}
+}
+
+func (a *assembler) statementError(stmt *asmStatement, err error) error {
+	return fmt.Errorf("line %d: %s: %w", stmt.line, strings.TrimSpace(stmt.text), err)
+}
+
+// sectionNamed returns the section, new sections are added to the program
+// by useSection once they have labels or statements.
+func (a *assembler) sectionNamed(name string) *ProgramSection {
+	for _, s := range a.program.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	if a.section != nil && a.section.Name == name {
+		return a.section
+	}
+	return &ProgramSection{Name: name, align: 4}
+}
+
+func (a *assembler) useSection(s *ProgramSection) {
+	for _, used := range a.program.Sections {
+		if used == s {
+			return
+		}
+	}
+	a.program.Sections = append(a.program.Sections, s)
+}
+
+// stripComment removes the # comment of the line, a # in a string or
+// character literal is kept.
+func stripComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+func isSymbolChar(c byte, first bool) bool {
+	return c == '_' || c == '.' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (!first && c >= '0' && c <= '9')
+}
+
+func isSymbol(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isSymbolChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isNumericLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// splitArgs splits the operands at the commas outside of parentheses and
+// quotes.
+func splitArgs(s string) []string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var args []string
+	depth := 0
+	quote := byte(0)
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+func (a *assembler) parseLine(lineNumber int, line string) error {
+	text := strings.TrimSpace(stripComment(line))
+	// a line can start with any number of labels
+	for {
+		colon := strings.IndexByte(text, ':')
+		if colon < 0 {
+			break
+		}
+		name := strings.TrimSpace(text[:colon])
+		if !isSymbol(name) && !isNumericLabel(name) {
+			break
+		}
+		if err := a.defineLabel(name); err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		text = strings.TrimSpace(text[colon+1:])
+	}
+	if text == "" {
+		return nil
+	}
+
+	name, rest, _ := strings.Cut(strings.Replace(text, "\t", " ", -1), " ")
+	stmt := &asmStatement{
+		line:    lineNumber,
+		text:    line,
+		name:    strings.ToLower(name),
+		args:    splitArgs(rest),
+		section: a.section,
+		offset:  a.section.size,
+		index:   len(a.statements),
+	}
+	a.stmt = stmt
+	a.err = nil
+	a.pc = stmt.offset
+	if strings.HasPrefix(stmt.name, ".") {
+		a.directive(stmt)
+	} else {
+		// the labels are not known yet, so only the size is used
+		instrs := a.assemble(stmt)
+		for _, instr := range instrs {
+			stmt.size += uint64(InstructionLength(instr.Encode()))
+		}
+		a.err = nil
+	}
+	if a.err != nil {
+		return a.statementError(stmt, a.err)
+	}
+	a.section = stmt.section
+	a.section.size = stmt.offset + stmt.size
+	a.useSection(a.section)
+	a.statements = append(a.statements, stmt)
+	return nil
+}
+
+func (a *assembler) defineLabel(name string) error {
+	a.useSection(a.section)
+	label := asmLabel{section: a.section, offset: a.section.size}
+	if isNumericLabel(name) {
+		a.numericLabels[name] = append(a.numericLabels[name], asmNumericLabel{asmLabel: label, index: len(a.statements)})
+		return nil
+	}
+	if a.labels == nil {
+		a.labels = map[string]asmLabel{}
+	}
+	if _, ok := a.labels[name]; ok || a.program.constants[name] {
+		return fmt.Errorf("symbol %s is already defined", name)
+	}
+	a.labels[name] = label
+	return nil
+}
+
+// layout places the sections one after another from base and computes the
+// addresses of the labels.
+func (a *assembler) layout(base uint64) {
+	addr := base
+	for _, s := range a.program.Sections {
+		addr = alignUp(addr, s.align)
+		s.Addr = addr
+		addr += s.size
+	}
+	for name, label := range a.labels {
+		a.program.Symbols[name] = label.section.Addr + label.offset
+	}
+	// sections with only labels have no data
+	sections := a.program.Sections[:0]
+	for _, s := range a.program.Sections {
+		if s.size > 0 {
+			sections = append(sections, s)
+		}
+	}
+	a.program.Sections = sections
+}
+
+func alignUp(value uint64, align uint64) uint64 {
+	return (value + align - 1) / align * align
+}
+
+// emit assembles the statement in the second pass and adds it to its
+// section.
+func (a *assembler) emit(stmt *asmStatement) error {
+	a.stmt = stmt
+	a.err = nil
+	a.pc = stmt.section.Addr + stmt.offset
+	s := stmt.section
+	if uint64(len(s.Data)) != stmt.offset {
+		return a.statementError(stmt, fmt.Errorf("internal error, statement at offset %#x but the section has %#x bytes", stmt.offset, len(s.Data)))
+	}
+
+	if strings.HasPrefix(stmt.name, ".") {
+		s.Data = append(s.Data, a.directiveData(stmt)...)
+	} else {
+		for _, instr := range a.assemble(stmt) {
+			word := instr.Encode()
+			length := InstructionLength(word)
+			s.Instructions = append(s.Instructions, instr)
+			s.Offsets = append(s.Offsets, uint64(len(s.Data)))
+			for i := uint32(0); i < length; i++ {
+				s.Data = append(s.Data, byte(word>>(8*i)))
+			}
+		}
+	}
+	if a.err != nil {
+		return a.statementError(stmt, a.err)
+	}
+	if uint64(len(s.Data)) != stmt.offset+stmt.size {
+		return a.statementError(stmt, fmt.Errorf("size changed from %d to %d bytes between the passes", stmt.size, uint64(len(s.Data))-stmt.offset))
+	}
+	return nil
+}
+
+// assemble returns the instructions of the statement.
+func (a *assembler) assemble(stmt *asmStatement) []Instruction {
+	name := stmt.name
+	if h, ok := asmInstructions[name]; ok {
+		return h(a, stmt.args)
+	}
+	if strings.HasPrefix(name, "c.") {
+		return a.assembleCompressed(name, stmt.args)
+	}
+	// the atomic instructions have the ordering bits as suffix
+	for _, suffix := range []string{".aqrl", ".aq", ".rl"} {
+		if h, ok := asmAtomics[strings.TrimSuffix(name, suffix)]; ok && strings.HasSuffix(name, suffix) {
+			return h(a, stmt.args, suffix)
+		}
+	}
+	if h, ok := asmAtomics[name]; ok {
+		return h(a, stmt.args, "")
+	}
+	a.fail("unknown instruction %s", name)
+	return nil
+}
+
+// The directives
+
+func (a *assembler) directive(stmt *asmStatement) {
+	args := stmt.args
+	switch stmt.name {
+	case ".text", ".data", ".rodata", ".bss":
+		a.switchSection(stmt, stmt.name)
+	case ".section":
+		if len(args) == 0 {
+			a.fail("missing section name")
+			return
+		}
+		a.switchSection(stmt, args[0])
+	case ".globl", ".global":
+		for _, name := range args {
+			a.program.globals[name] = true
+		}
+	case ".equ", ".set":
+		if len(args) != 2 || !isSymbol(args[0]) {
+			a.fail("usage: %s symbol, value", stmt.name)
+			return
+		}
+		value := a.constant(args[1])
+		if _, ok := a.labels[args[0]]; ok {
+			a.fail("symbol %s is already defined", args[0])
+		}
+		a.program.constants[args[0]] = true
+		a.program.Symbols[args[0]] = uint64(value)
+	case ".align", ".p2align", ".balign":
+		if len(args) == 0 {
+			a.fail("missing alignment")
+			return
+		}
+		align := uint64(a.constant(args[0]))
+		if stmt.name != ".balign" {
+			align = 1 << align
+		}
+		if align == 0 || align&(align-1) != 0 || align > 1<<16 {
+			a.fail("invalid alignment %s", args[0])
+			return
+		}
+		if align > stmt.section.align {
+			stmt.section.align = align
+		}
+		// the offset of the statement is in the section it is in
+		stmt.size = alignUp(stmt.offset, align) - stmt.offset
+	case ".byte", ".half", ".short", ".2byte", ".word", ".long", ".4byte", ".dword", ".quad", ".8byte":
+		stmt.size = uint64(len(args)) * dataSize(stmt.name)
+	case ".zero", ".space", ".skip":
+		if len(args) == 0 {
+			a.fail("missing size")
+			return
+		}
+		stmt.size = uint64(a.constant(args[0]))
+	case ".string", ".asciz", ".ascii":
+		for _, arg := range args {
+			s, err := strconv.Unquote(arg)
+			if err != nil {
+				a.fail("invalid string %s", arg)
+				return
+			}
+			stmt.size += uint64(len(s))
+			if stmt.name != ".ascii" {
+				stmt.size++
+			}
+		}
+	case ".option", ".type", ".size", ".file", ".ident", ".attribute", ".local", ".weak", ".cfi_startproc", ".cfi_endproc":
+		// only matter for the linker and the debugger
+	default:
+		a.fail("unknown directive %s", stmt.name)
+	}
+}
+
+// switchSection moves the statement and the ones after it to the end of
+// the section.
+func (a *assembler) switchSection(stmt *asmStatement, name string) {
+	stmt.section = a.sectionNamed(name)
+	stmt.offset = stmt.section.size
+}
+
+func dataSize(directive string) uint64 {
+	switch directive {
+	case ".byte":
+		return 1
+	case ".half", ".short", ".2byte":
+		return 2
+	case ".word", ".long", ".4byte":
+		return 4
+	}
+	return 8
+}
+
+// directiveData returns the bytes of a directive in the second pass.
+func (a *assembler) directiveData(stmt *asmStatement) []byte {
+	var data []byte
+	switch stmt.name {
+	case ".align", ".p2align", ".balign":
+		data = make([]byte, stmt.size)
+		if stmt.section.Executable() {
+			// code runs into the padding, so it's filled with nops
+			var nop uint32 = 0x00000013
+			for i := uint64(0); i+4 <= stmt.size; i += 4 {
+				data[i], data[i+1], data[i+2], data[i+3] = byte(nop), byte(nop>>8), byte(nop>>16), byte(nop>>24)
+			}
+			if stmt.size%4 == 2 {
+				// c.nop
+				data[stmt.size-2] = 0x01
+			}
+		}
+	case ".byte", ".half", ".short", ".2byte", ".word", ".long", ".4byte", ".dword", ".quad", ".8byte":
+		size := dataSize(stmt.name)
+		for i, arg := range stmt.args {
+			// . is the address of the value
+			a.pc = stmt.section.Addr + stmt.offset + uint64(i)*size
+			value := a.expr(arg)
+			if a.final && size < 8 && (value >= 1<<(8*size) || value < -(1<<(8*size-1))) {
+				a.fail("value %s doesn't fit in %d bytes", arg, size)
+			}
+			for i := uint64(0); i < size; i++ {
+				data = append(data, byte(uint64(value)>>(8*i)))
+			}
+		}
+	case ".zero", ".space", ".skip":
+		data = make([]byte, stmt.size)
+	case ".string", ".asciz", ".ascii":
+		for _, arg := range stmt.args {
+			s, _ := strconv.Unquote(arg)
+			data = append(data, s...)
+			if stmt.name != ".ascii" {
+				data = append(data, 0)
+			}
+		}
+	}
+	return data
+}
+
+// The operands
+
+// reg parses an integer register, x0-x31 or the ABI name.
+func (a *assembler) reg(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "fp" {
+		return reg_fp
+	}
+	for i, name := range RegisterNames {
+		if s == name || s == "x"+strconv.Itoa(i) {
+			return i
+		}
+	}
+	a.fail("invalid register %s", s)
+	return 0
+}
+
+// freg parses a floating point register, f0-f31 or the ABI name.
+func (a *assembler) freg(s string) int {
+	s = strings.TrimSpace(s)
+	for i, name := range FRegisterNames {
+		if s == name || s == "f"+strconv.Itoa(i) {
+			return i
+		}
+	}
+	a.fail("invalid floating point register %s", s)
+	return 0
+}
+
+// mem parses a memory operand, offset(reg) where the offset is optional.
+func (a *assembler) mem(s string) (int64, int) {
+	s = strings.TrimSpace(s)
+	open := strings.LastIndexByte(s, '(')
+	if open < 0 || !strings.HasSuffix(s, ")") {
+		a.fail("invalid memory operand %s, should be offset(reg)", s)
+		return 0, 0
+	}
+	reg := a.reg(s[open+1 : len(s)-1])
+	offset := int64(0)
+	if strings.TrimSpace(s[:open]) != "" {
+		offset = a.expr(s[:open])
+	}
+	return offset, reg
+}
+
+// simm returns the value of the expression, which has to fit in a signed
+// immediate of the given number of bits.
+func (a *assembler) simm(s string, bits uint) int64 {
+	value := a.expr(s)
+	a.checkSimm(s, value, bits)
+	return value
+}
+
+// uimm returns the value of the expression, which has to fit in an
+// unsigned immediate of the given number of bits.
+func (a *assembler) uimm(s string, bits uint) uint64 {
+	value := a.expr(s)
+	if a.final && (value < 0 || value >= 1<<bits) {
+		a.fail("immediate %s (%d) doesn't fit in %d unsigned bits", s, value, bits)
+	}
+	return uint64(value)
+}
+
+// target returns the offset of the jump or branch target from the pc, it
+// has to fit in the given number of bits and be even.
+func (a *assembler) target(s string, bits uint) int64 {
+	offset := a.expr(s) - int64(a.pc)
+	if offset%2 != 0 {
+		a.fail("target %s is not aligned to 2 bytes", s)
+		return 0
+	}
+	if a.final && (offset < -(1<<(bits-1)) || offset >= 1<<(bits-1)) {
+		a.fail("target %s is out of range", s)
+		return 0
+	}
+	return offset
+}
+
+// constant returns the value of an expression without labels, it's known
+// in the first pass.
+func (a *assembler) constant(s string) int64 {
+	a.usedLabel = false
+	value := a.expr(s)
+	if a.usedLabel {
+		a.fail("%s is not a constant", s)
+	}
+	return value
+}
+
+// expr evaluates an expression of numbers, character literals and symbols
+// with the C operators + - * / << >> & | ^ ~ and parentheses, the current
+// address is . and %hi(expr) and %lo(expr) split values for lui and addi.
+func (a *assembler) expr(s string) int64 {
+	p := &exprParser{a: a, s: s}
+	value := p.binary(0)
+	p.skipSpaces()
+	if p.pos < len(p.s) && a.err == nil {
+		a.fail("invalid expression %s", strings.TrimSpace(s))
+	}
+	return value
+}
+
+type exprParser struct {
+	a   *assembler
+	s   string
+	pos int
+}
+
+func (p *exprParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// the binary operators from the lowest to the highest precedence
+var exprOperators = [][]string{{"|"}, {"^"}, {"&"}, {"<<", ">>"}, {"+", "-"}, {"*", "/", "%"}}
+
+func (p *exprParser) binary(level int) int64 {
+	if level == len(exprOperators) {
+		return p.unary()
+	}
+	value := p.binary(level + 1)
+	for {
+		p.skipSpaces()
+		op := ""
+		for _, candidate := range exprOperators[level] {
+			if strings.HasPrefix(p.s[p.pos:], candidate) {
+				op = candidate
+			}
+		}
+		// %hi( is a relocation function and not the modulo operator
+		if op == "" || (op == "%" && p.pos+1 < len(p.s) && isSymbolChar(p.s[p.pos+1], true)) {
+			return value
+		}
+		p.pos += len(op)
+		rhs := p.binary(level + 1)
+		switch op {
+		case "|":
+			value |= rhs
+		case "^":
+			value ^= rhs
+		case "&":
+			value &= rhs
+		case "<<":
+			value <<= uint64(rhs)
+		case ">>":
+			value >>= uint64(rhs)
+		case "+":
+			value += rhs
+		case "-":
+			value -= rhs
+		case "*":
+			value *= rhs
+		case "/", "%":
+			if rhs == 0 {
+				p.a.fail("division by zero")
+				return 0
+			}
+			if op == "/" {
+				value /= rhs
+			} else {
+				value %= rhs
+			}
+		}
+	}
+}
+
+func (p *exprParser) unary() int64 {
+	p.skipSpaces()
+	if p.pos >= len(p.s) {
+		p.a.fail("missing value in expression %s", strings.TrimSpace(p.s))
+		return 0
+	}
+	switch c := p.s[p.pos]; {
+	case c == '-':
+		p.pos++
+		return -p.unary()
+	case c == '+':
+		p.pos++
+		return p.unary()
+	case c == '~':
+		p.pos++
+		return ^p.unary()
+	case c == '(':
+		p.pos++
+		value := p.binary(0)
+		p.expect(')')
+		return value
+	case c == '%':
+		p.pos++
+		name := p.word()
+		p.skipSpaces()
+		p.expect('(')
+		value := p.binary(0)
+		p.expect(')')
+		switch name {
+		case "hi":
+			return hi20(value)
+		case "lo":
+			return lo12(value)
+		}
+		p.a.fail("unknown relocation function %%%s", name)
+		return 0
+	case c == '\'':
+		return p.char()
+	case c >= '0' && c <= '9':
+		return p.number()
+	}
+	return p.symbol(p.word())
+}
+
+func (p *exprParser) expect(c byte) {
+	p.skipSpaces()
+	if p.pos >= len(p.s) || p.s[p.pos] != c {
+		p.a.fail("missing %c in expression %s", c, strings.TrimSpace(p.s))
+		return
+	}
+	p.pos++
+}
+
+func (p *exprParser) word() string {
+	start := p.pos
+	for p.pos < len(p.s) && isSymbolChar(p.s[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *exprParser) char() int64 {
+	// the literal ends at the next unescaped quote
+	end := p.pos + 1
+	for end < len(p.s) && p.s[end] != '\'' {
+		if p.s[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.s) {
+		p.a.fail("unterminated character literal")
+		p.pos = len(p.s)
+		return 0
+	}
+	literal := p.s[p.pos : end+1]
+	p.pos = end + 1
+	value, _, tail, err := strconv.UnquoteChar(literal[1:len(literal)-1], '\'')
+	if err != nil || tail != "" {
+		p.a.fail("invalid character literal %s", literal)
+	}
+	return int64(value)
+}
+
+func (p *exprParser) number() int64 {
+	start := p.pos
+	for p.pos < len(p.s) && isSymbolChar(p.s[p.pos], false) {
+		p.pos++
+	}
+	text := p.s[start:p.pos]
+	// 1b and 1f are the previous and next numeric label 1
+	if last := text[len(text)-1]; (last == 'b' || last == 'f') && isNumericLabel(text[:len(text)-1]) {
+		return p.numericLabel(text[:len(text)-1], last == 'f')
+	}
+	value, err := strconv.ParseInt(text, 0, 64)
+	if err != nil {
+		unsigned, uerr := strconv.ParseUint(text, 0, 64)
+		if uerr != nil {
+			p.a.fail("invalid number %s", text)
+		}
+		value = int64(unsigned)
+	}
+	return value
+}
+
+func (p *exprParser) numericLabel(name string, forward bool) int64 {
+	p.a.usedLabel = true
+	if !p.a.final {
+		return 0
+	}
+	labels := p.a.numericLabels[name]
+	index := p.a.stmt.index
+	if forward {
+		for _, label := range labels {
+			if label.index > index {
+				return int64(label.section.Addr + label.offset)
+			}
+		}
+	} else {
+		for i := len(labels) - 1; i >= 0; i-- {
+			if labels[i].index <= index {
+				return int64(labels[i].section.Addr + labels[i].offset)
+			}
+		}
+	}
+	p.a.fail("numeric label %s is not defined", name)
+	return 0
+}
+
+func (p *exprParser) symbol(name string) int64 {
+	if name == "" {
+		p.a.fail("invalid expression %s", strings.TrimSpace(p.s))
+		p.pos = len(p.s)
+		return 0
+	}
+	if name == "." {
+		p.a.usedLabel = true
+		return int64(p.a.pc)
+	}
+	if p.a.program.constants[name] {
+		return int64(p.a.program.Symbols[name])
+	}
+	p.a.usedLabel = true
+	if !p.a.final {
+		return 0
+	}
+	value, ok := p.a.program.Symbols[name]
+	if !ok {
+		p.a.fail("symbol %s is not defined", name)
+	}
+	return int64(value)
+}
+
+// hi20 is the upper 20 bits of the value for lui or auipc, rounded so the
+// sign extended lower 12 bits of lo12 can be added.
+func hi20(value int64) int64 {
+	return ((value + 0x800) >> 12) & 0xfffff
+}
+
+// lo12 is the sign extended lower 12 bits of the value.
+func lo12(value int64) int64 {
+	return int64(sext64(uint32(value)&0xfff, 11))
+}
+
+// sortedSymbols returns the names of the symbols sorted by address and name.
+func (p *Program) sortedSymbols() []string {
+	names := make([]string, 0, len(p.Symbols))
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if p.Symbols[names[i]] != p.Symbols[names[j]] {
+			return p.Symbols[names[i]] < p.Symbols[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
@@ -0,0 +1,339 @@
+package riscv
+
+import (
+	"strings"
+)
+
+// cField places the bits of an immediate in a compressed instruction, from
+// the instruction bit at downwards.
+type cField struct {
+	at   uint32
+	bits []uint32
+}
+
+// cScatter returns the bits of the value placed in the fields.
+func cScatter(value int64, fields ...cField) uint32 {
+	var raw uint32
+	for _, f := range fields {
+		for i, bit := range f.bits {
+			raw |= uint32(uint64(value)>>bit&1) << (f.at - uint32(i))
+		}
+	}
+	return raw
+}
+
+// cImm returns the immediate operand, which has to be encodable in the
+// fields: unsigned immediates have no other bits set, signed ones are sign
+// extended from their highest bit.
+func (a *assembler) cImm(s string, signed bool, nonzero bool, fields ...cField) int64 {
+	value := a.expr(s)
+	a.checkCImm(s, value, signed, nonzero, fields...)
+	return value
+}
+
+func (a *assembler) checkCImm(s string, value int64, signed bool, nonzero bool, fields ...cField) {
+	if !a.final {
+		return
+	}
+	var mask uint64
+	var top uint32
+	for _, f := range fields {
+		for _, bit := range f.bits {
+			mask |= 1 << bit
+			if bit > top {
+				top = bit
+			}
+		}
+	}
+	valid := uint64(value)&^mask == 0
+	if signed {
+		upper := ^uint64(0) << top
+		valid = uint64(value)&^(mask|upper) == 0 && (value>>top == 0 || value>>top == -1)
+	}
+	if !valid {
+		a.fail("immediate %s (%d) can't be encoded in the compressed instruction", strings.TrimSpace(s), value)
+	} else if nonzero && value == 0 {
+		a.fail("immediate %s must not be zero", strings.TrimSpace(s))
+	}
+}
+
+// cReg parses a register of the 3 bit fields, x8-x15.
+func (a *assembler) cReg(s string) uint32 {
+	return a.cRegField(strings.TrimSpace(s), a.reg(s))
+}
+
+// cFreg parses a floating point register of the 3 bit fields, f8-f15.
+func (a *assembler) cFreg(s string) uint32 {
+	return a.cRegField(strings.TrimSpace(s), a.freg(s))
+}
+
+func (a *assembler) cRegField(name string, reg int) uint32 {
+	if reg < 8 || reg > 15 {
+		a.fail("register %s can't be used in a compressed instruction, only x8-x15", name)
+		return 0
+	}
+	return uint32(reg - 8)
+}
+
+// nonzeroReg parses a register which must not be x0.
+func (a *assembler) nonzeroReg(s string) uint32 {
+	reg := a.reg(s)
+	if reg == reg_zero {
+		a.fail("x0 can't be used in this compressed instruction")
+	}
+	return uint32(reg)
+}
+
+// spOffset parses the offset(sp) operand of the stack pointer relative
+// loads and stores.
+func (a *assembler) spOffset(s string, fields ...cField) int64 {
+	offset, reg := a.mem(s)
+	if reg != reg_sp {
+		a.fail("the base register of %s has to be sp", strings.TrimSpace(s))
+	}
+	a.checkCImm(s, offset, false, false, fields...)
+	return offset
+}
+
+// cMem parses the offset(reg) operand of the loads and stores, reg has to
+// be one of x8-x15.
+func (a *assembler) cMem(s string, fields ...cField) (int64, uint32) {
+	offset, reg := a.mem(s)
+	a.checkCImm(s, offset, false, false, fields...)
+	return offset, a.cRegField(RegisterNames[reg], reg)
+}
+
+// The immediate layouts of the compressed formats
+var (
+	cImm6        = []cField{{12, []uint32{5}}, {6, []uint32{4, 3, 2, 1, 0}}}
+	cAddi4spn    = []cField{{12, []uint32{5, 4, 9, 8, 7, 6, 2, 3}}}
+	cAddi16sp    = []cField{{12, []uint32{9}}, {6, []uint32{4, 6, 8, 7, 5}}}
+	cWordMem     = []cField{{12, []uint32{5, 4, 3}}, {6, []uint32{2, 6}}}
+	cDoubleMem   = []cField{{12, []uint32{5, 4, 3}}, {6, []uint32{7, 6}}}
+	cJump        = []cField{{12, []uint32{11, 4, 9, 8, 10, 6, 7, 3, 2, 1, 5}}}
+	cBranch      = []cField{{12, []uint32{8, 4, 3}}, {6, []uint32{7, 6, 2, 1, 5}}}
+	cWordLoadSp  = []cField{{12, []uint32{5}}, {6, []uint32{4, 3, 2, 7, 6}}}
+	cDoubleLdSp  = []cField{{12, []uint32{5}}, {6, []uint32{4, 3, 8, 7, 6}}}
+	cWordStSp    = []cField{{12, []uint32{5, 4, 3, 2, 7, 6}}}
+	cDoubleStSp  = []cField{{12, []uint32{5, 4, 3, 8, 7, 6}}}
+	cShiftAmount = cImm6
+)
+
+// assembleCompressed assembles the c. instructions of the C extension.
+// Instructions which aren't prefixed with c. are never compressed.
+func (a *assembler) assembleCompressed(name string, args []string) []Instruction {
+	rv64 := a.xlen == XLEN_64
+	funct3 := func(f uint32) uint32 { return f << 13 }
+	var raw uint32
+	switch name {
+	// quadrant 0
+	case "c.addi4spn":
+		if !a.operands(args, 3) {
+			return nil
+		}
+		if a.reg(args[1]) != reg_sp {
+			a.fail("the second operand of c.addi4spn has to be sp")
+		}
+		raw = funct3(0) | cScatter(a.cImm(args[2], false, true, cAddi4spn...), cAddi4spn...) | a.cReg(args[0])<<2
+	case "c.lw", "c.ld", "c.flw", "c.fld", "c.sw", "c.sd", "c.fsw", "c.fsd":
+		if !a.operands(args, 2) || !a.cWidthSupported(name) {
+			return nil
+		}
+		f := map[string]uint32{"c.fld": 1, "c.lw": 2, "c.flw": 3, "c.ld": 3, "c.fsd": 5, "c.sw": 6, "c.fsw": 7, "c.sd": 7}[name]
+		layout := cWordMem
+		if strings.HasSuffix(name, "d") {
+			layout = cDoubleMem
+		}
+		reg := a.cReg
+		if strings.HasPrefix(name, "c.f") {
+			reg = a.cFreg
+		}
+		rd := reg(args[0])
+		offset, rs1 := a.cMem(args[1], layout...)
+		raw = funct3(f) | cScatter(offset, layout...) | rs1<<7 | rd<<2
+	// quadrant 1
+	case "c.nop":
+		a.operands(args, 0)
+		raw = 0x0001
+	case "c.addi", "c.li", "c.addiw":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		f := map[string]uint32{"c.addi": 0, "c.addiw": 1, "c.li": 2}[name]
+		if name == "c.addiw" && !rv64 {
+			a.fail("instruction is only supported on RV64")
+			return nil
+		}
+		rd := a.reg(args[0])
+		if name == "c.addiw" {
+			rd = int(a.nonzeroReg(args[0]))
+		}
+		raw = funct3(f) | cScatter(a.cImm(args[1], true, false, cImm6...), cImm6...) | uint32(rd)<<7 | C_QUADRANT_1
+	case "c.jal", "c.j":
+		if !a.operands(args, 1) {
+			return nil
+		}
+		f := uint32(5)
+		if name == "c.jal" {
+			if rv64 {
+				a.fail("c.jal is only supported on RV32")
+				return nil
+			}
+			f = 1
+		}
+		offset := a.target(args[0], 12)
+		raw = funct3(f) | cScatter(offset, cJump...) | C_QUADRANT_1
+	case "c.addi16sp":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		if a.reg(args[0]) != reg_sp {
+			a.fail("the first operand of c.addi16sp has to be sp")
+		}
+		raw = funct3(3) | cScatter(a.cImm(args[1], true, true, cAddi16sp...), cAddi16sp...) | uint32(reg_sp)<<7 | C_QUADRANT_1
+	case "c.lui":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rd := a.nonzeroReg(args[0])
+		if rd == uint32(reg_sp) {
+			a.fail("c.lui can't load sp")
+		}
+		// the operand is the value of lui, the sign extended 6 bits
+		value := a.expr(args[1])
+		if a.final && !(value >= 1 && value <= 0x1f) && !(value >= 0xfffe0 && value <= 0xfffff) {
+			a.fail("immediate %s can't be encoded in c.lui, should be 1 to 0x1f or 0xfffe0 to 0xfffff", args[1])
+		}
+		raw = funct3(3) | cScatter(value, cImm6...) | rd<<7 | C_QUADRANT_1
+	case "c.srli", "c.srai", "c.andi":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		op := map[string]uint32{"c.srli": 0, "c.srai": 1, "c.andi": 2}[name]
+		var imm int64
+		if name == "c.andi" {
+			imm = a.cImm(args[1], true, false, cImm6...)
+		} else {
+			imm = a.cShamt(args[1])
+		}
+		raw = funct3(4) | op<<10 | cScatter(imm, cImm6...) | a.cReg(args[0])<<7 | C_QUADRANT_1
+	case "c.sub", "c.xor", "c.or", "c.and", "c.subw", "c.addw":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		op := map[string]uint32{"c.sub": 0, "c.xor": 1, "c.or": 2, "c.and": 3, "c.subw": 0, "c.addw": 1}[name]
+		raw = 0x8c01 | op<<5
+		if strings.HasSuffix(name, "w") {
+			if !rv64 {
+				a.fail("instruction is only supported on RV64")
+				return nil
+			}
+			raw |= 1 << 12
+		}
+		raw |= a.cReg(args[0])<<7 | a.cReg(args[1])<<2
+	case "c.beqz", "c.bnez":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		f := uint32(6)
+		if name == "c.bnez" {
+			f = 7
+		}
+		rs1 := a.cReg(args[0])
+		raw = funct3(f) | cScatter(a.target(args[1], 9), cBranch...) | rs1<<7 | C_QUADRANT_1
+	// quadrant 2
+	case "c.slli":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rd := a.nonzeroReg(args[0])
+		raw = funct3(0) | cScatter(a.cShamt(args[1]), cShiftAmount...) | rd<<7 | C_QUADRANT_2
+	case "c.lwsp", "c.ldsp", "c.flwsp", "c.fldsp":
+		if !a.operands(args, 2) || !a.cWidthSupported(name) {
+			return nil
+		}
+		f := map[string]uint32{"c.fldsp": 1, "c.lwsp": 2, "c.flwsp": 3, "c.ldsp": 3}[name]
+		layout := cWordLoadSp
+		if strings.HasSuffix(name, "dsp") {
+			layout = cDoubleLdSp
+		}
+		var rd uint32
+		if strings.HasPrefix(name, "c.f") {
+			rd = uint32(a.freg(args[0]))
+		} else {
+			rd = a.nonzeroReg(args[0])
+		}
+		raw = funct3(f) | cScatter(a.spOffset(args[1], layout...), layout...) | rd<<7 | C_QUADRANT_2
+	case "c.swsp", "c.sdsp", "c.fswsp", "c.fsdsp":
+		if !a.operands(args, 2) || !a.cWidthSupported(name) {
+			return nil
+		}
+		f := map[string]uint32{"c.fsdsp": 5, "c.swsp": 6, "c.fswsp": 7, "c.sdsp": 7}[name]
+		layout := cWordStSp
+		if strings.HasSuffix(name, "dsp") {
+			layout = cDoubleStSp
+		}
+		var rs2 int
+		if strings.HasPrefix(name, "c.f") {
+			rs2 = a.freg(args[0])
+		} else {
+			rs2 = a.reg(args[0])
+		}
+		raw = funct3(f) | cScatter(a.spOffset(args[1], layout...), layout...) | uint32(rs2)<<2 | C_QUADRANT_2
+	case "c.jr", "c.jalr":
+		if !a.operands(args, 1) {
+			return nil
+		}
+		raw = 0x8002 | a.nonzeroReg(args[0])<<7
+		if name == "c.jalr" {
+			raw |= 1 << 12
+		}
+	case "c.mv", "c.add":
+		if !a.operands(args, 2) {
+			return nil
+		}
+		raw = 0x8002 | a.nonzeroReg(args[0])<<7 | a.nonzeroReg(args[1])<<2
+		if name == "c.add" {
+			raw |= 1 << 12
+		}
+	case "c.ebreak":
+		a.operands(args, 0)
+		raw = 0x9002
+	default:
+		a.fail("unknown instruction %s", name)
+		return nil
+	}
+
+	instr, err := decodeCompressed(raw, rv64)
+	if err != nil {
+		// keep the size, the first pass doesn't know the labels yet
+		a.fail("%v", err)
+		return []Instruction{CInstr{raw: uint16(raw)}}
+	}
+	return []Instruction{instr}
+}
+
+// cShamt parses the shift amount, 6 bits on RV64 and 5 on RV32.
+func (a *assembler) cShamt(s string) int64 {
+	fields := cShiftAmount
+	if a.xlen == XLEN_32 {
+		fields = []cField{{6, []uint32{4, 3, 2, 1, 0}}}
+	}
+	return a.cImm(s, false, true, fields...)
+}
+
+// cWidthSupported checks that the loads and stores sharing an encoding
+// are the ones of the XLEN, flw on RV32 and ld on RV64.
+func (a *assembler) cWidthSupported(name string) bool {
+	rv32Only := name == "c.flw" || name == "c.fsw" || name == "c.flwsp" || name == "c.fswsp"
+	rv64Only := name == "c.ld" || name == "c.sd" || name == "c.ldsp" || name == "c.sdsp"
+	if rv32Only && a.xlen != XLEN_32 {
+		a.fail("%s is only supported on RV32", name)
+		return false
+	}
+	if rv64Only && a.xlen != XLEN_64 {
+		a.fail("%s is only supported on RV64", name)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package riscv
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"io"
+)
+
+// EF_RISCV_RVC is the e_flags bit of elf files with compressed instructions
+const EF_RISCV_RVC uint32 = 0x1
+
+// WriteElf writes the program as an executable elf file, ELFCLASS32 or
+// ELFCLASS64 by the XLEN, which LoadElf loads like a linked one. Every
+// section is a loadable segment and the symbols are in .symtab.
+func (p *Program) WriteElf(w io.Writer) error {
+	class := elf.ELFCLASS32
+	headerSize, progSize, sectionSize, symSize, wordSize := uint64(52), uint64(32), uint64(40), uint64(16), uint64(4)
+	if p.Xlen == XLEN_64 {
+		class = elf.ELFCLASS64
+		headerSize, progSize, sectionSize, symSize, wordSize = 64, 56, 64, 24, 8
+	}
+	e := &elfWriter{class: class}
+
+	// the section headers are null, the program sections, .symtab, .strtab
+	// and .shstrtab
+	shstrtab := []byte{0}
+	name := func(s string) uint32 {
+		offset := uint32(len(shstrtab))
+		shstrtab = append(append(shstrtab, s...), 0)
+		return offset
+	}
+
+	var data bytes.Buffer
+	dataOffset := headerSize + progSize*uint64(len(p.Sections))
+	var progs, sections []elf.Section64
+	sections = append(sections, elf.Section64{})
+	for _, s := range p.Sections {
+		// the offset of a segment in the file has to be aligned like its
+		// address
+		for (dataOffset+uint64(data.Len()))%s.align != s.Addr%s.align {
+			data.WriteByte(0)
+		}
+		offset := dataOffset + uint64(data.Len())
+		flags := elf.SHF_ALLOC | elf.SHF_WRITE
+		progFlags := elf.PF_R | elf.PF_W
+		if s.Executable() {
+			flags, progFlags = elf.SHF_ALLOC|elf.SHF_EXECINSTR, elf.PF_R|elf.PF_X
+		} else if s.Name == ".rodata" {
+			flags, progFlags = elf.SHF_ALLOC, elf.PF_R
+		}
+		sectionType := elf.SHT_PROGBITS
+		fileSize := uint64(len(s.Data))
+		if s.NoBits() {
+			sectionType, fileSize = elf.SHT_NOBITS, 0
+		} else {
+			data.Write(s.Data)
+		}
+		// the program headers use the fields of the section headers
+		progs = append(progs, elf.Section64{
+			Type: uint32(elf.PT_LOAD), Flags: uint64(progFlags), Off: offset, Addr: s.Addr,
+			Size: fileSize, Entsize: uint64(len(s.Data)), Addralign: s.align,
+		})
+		sections = append(sections, elf.Section64{
+			Name: name(s.Name), Type: uint32(sectionType), Flags: uint64(flags), Addr: s.Addr,
+			Off: offset, Size: uint64(len(s.Data)), Addralign: s.align,
+		})
+	}
+
+	// the local symbols have to come before the global ones
+	strtab := []byte{0}
+	var locals, globals []elf.Sym64
+	for _, symbol := range p.sortedSymbols() {
+		value := p.Symbols[symbol]
+		sym := elf.Sym64{Name: uint32(len(strtab)), Value: value, Shndx: uint16(elf.SHN_ABS)}
+		strtab = append(append(strtab, symbol...), 0)
+		if !p.constants[symbol] {
+			for i, s := range p.Sections {
+				if value >= s.Addr && value <= s.Addr+uint64(len(s.Data)) {
+					sym.Shndx = uint16(i + 1)
+					break
+				}
+			}
+		}
+		if p.globals[symbol] {
+			sym.Info = elf.ST_INFO(elf.STB_GLOBAL, elf.STT_NOTYPE)
+			globals = append(globals, sym)
+		} else {
+			sym.Info = elf.ST_INFO(elf.STB_LOCAL, elf.STT_NOTYPE)
+			locals = append(locals, sym)
+		}
+	}
+	symbols := append(append([]elf.Sym64{{}}, locals...), globals...)
+
+	symtabIndex := uint32(len(sections))
+	for uint64(data.Len())%wordSize != 0 {
+		data.WriteByte(0)
+	}
+	offset := dataOffset + uint64(data.Len())
+	for _, sym := range symbols {
+		e.sym(&data, sym)
+	}
+	sections = append(sections, elf.Section64{
+		Name: name(".symtab"), Type: uint32(elf.SHT_SYMTAB), Off: offset, Size: symSize * uint64(len(symbols)),
+		Link: symtabIndex + 1, Info: uint32(len(locals) + 1), Addralign: wordSize, Entsize: symSize,
+	})
+	sections = append(sections, elf.Section64{
+		Name: name(".strtab"), Type: uint32(elf.SHT_STRTAB), Off: dataOffset + uint64(data.Len()),
+		Size: uint64(len(strtab)), Addralign: 1,
+	})
+	data.Write(strtab)
+	shstrtabName := name(".shstrtab")
+	sections = append(sections, elf.Section64{
+		Name: shstrtabName, Type: uint32(elf.SHT_STRTAB), Off: dataOffset + uint64(data.Len()),
+		Size: uint64(len(shstrtab)), Addralign: 1,
+	})
+	data.Write(shstrtab)
+	for uint64(data.Len())%wordSize != 0 {
+		data.WriteByte(0)
+	}
+
+	var flags uint32
+	for _, instr := range p.Instructions() {
+		if _, ok := instr.(CInstr); ok {
+			flags |= EF_RISCV_RVC
+		}
+	}
+	var out bytes.Buffer
+	e.header(&out, elf.Header64{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_RISCV), Version: uint32(elf.EV_CURRENT),
+		Entry: p.Entry, Phoff: headerSize, Shoff: dataOffset + uint64(data.Len()), Flags: flags,
+		Ehsize: uint16(headerSize), Phentsize: uint16(progSize), Phnum: uint16(len(progs)),
+		Shentsize: uint16(sectionSize), Shnum: uint16(len(sections)), Shstrndx: uint16(len(sections) - 1),
+	})
+	for _, prog := range progs {
+		e.prog(&out, prog)
+	}
+	out.Write(data.Bytes())
+	for _, section := range sections {
+		e.section(&out, section)
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// elfWriter writes the 64 bit elf structures in the layout of the class.
+type elfWriter struct {
+	class elf.Class
+}
+
+func (e *elfWriter) header(w io.Writer, h elf.Header64) {
+	copy(h.Ident[:], elf.ELFMAG)
+	h.Ident[elf.EI_CLASS] = byte(e.class)
+	h.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	h.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	if e.class == elf.ELFCLASS64 {
+		binary.Write(w, binary.LittleEndian, h)
+		return
+	}
+	binary.Write(w, binary.LittleEndian, elf.Header32{
+		Ident: h.Ident, Type: h.Type, Machine: h.Machine, Version: h.Version, Entry: uint32(h.Entry),
+		Phoff: uint32(h.Phoff), Shoff: uint32(h.Shoff), Flags: h.Flags, Ehsize: h.Ehsize,
+		Phentsize: h.Phentsize, Phnum: h.Phnum, Shentsize: h.Shentsize, Shnum: h.Shnum, Shstrndx: h.Shstrndx,
+	})
+}
+
+// prog writes a program header, passed in the fields of a section header:
+// Size is the size in the file and Entsize the size in memory.
+func (e *elfWriter) prog(w io.Writer, s elf.Section64) {
+	if e.class == elf.ELFCLASS64 {
+		binary.Write(w, binary.LittleEndian, elf.Prog64{
+			Type: s.Type, Flags: uint32(s.Flags), Off: s.Off, Vaddr: s.Addr, Paddr: s.Addr,
+			Filesz: s.Size, Memsz: s.Entsize, Align: s.Addralign,
+		})
+		return
+	}
+	binary.Write(w, binary.LittleEndian, elf.Prog32{
+		Type: s.Type, Flags: uint32(s.Flags), Off: uint32(s.Off), Vaddr: uint32(s.Addr), Paddr: uint32(s.Addr),
+		Filesz: uint32(s.Size), Memsz: uint32(s.Entsize), Align: uint32(s.Addralign),
+	})
+}
+
+func (e *elfWriter) section(w io.Writer, s elf.Section64) {
+	if e.class == elf.ELFCLASS64 {
+		binary.Write(w, binary.LittleEndian, s)
+		return
+	}
+	binary.Write(w, binary.LittleEndian, elf.Section32{
+		Name: s.Name, Type: s.Type, Flags: uint32(s.Flags), Addr: uint32(s.Addr), Off: uint32(s.Off),
+		Size: uint32(s.Size), Link: s.Link, Info: s.Info, Addralign: uint32(s.Addralign), Entsize: uint32(s.Entsize),
+	})
+}
+
+func (e *elfWriter) sym(w io.Writer, s elf.Sym64) {
+	if e.class == elf.ELFCLASS64 {
+		binary.Write(w, binary.LittleEndian, s)
+		return
+	}
+	binary.Write(w, binary.LittleEndian, elf.Sym32{
+		Name: s.Name, Value: uint32(s.Value), Size: uint32(s.Size), Info: s.Info, Other: s.Other, Shndx: s.Shndx,
+	})
+}
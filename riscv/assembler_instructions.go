@@ -0,0 +1,913 @@
+package riscv
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// asmHandler assembles the operands of an instruction, the errors are
+// recorded with assembler.fail.
+type asmHandler func(a *assembler, args []string) []Instruction
+
+// asmAtomicHandler assembles an atomic instruction, the suffix is the
+// ordering (.aq, .rl, .aqrl) or empty.
+type asmAtomicHandler func(a *assembler, args []string, suffix string) []Instruction
+
+var asmInstructions = map[string]asmHandler{}
+var asmAtomics = map[string]asmAtomicHandler{}
+
+func init() {
+	for name, create := range map[string]func(int, int, int) RInstr{
+		"add": CreateADD, "sub": CreateSUB, "sll": CreateSLL, "slt": CreateSLT, "sltu": CreateSLTU,
+		"xor": CreateXOR, "srl": CreateSRL, "sra": CreateSRA, "or": CreateOR, "and": CreateAND,
+		"mul": CreateMUL, "mulh": CreateMULH, "mulhsu": CreateMULHSU, "mulhu": CreateMULHU,
+		"div": CreateDIV, "divu": CreateDIVU, "rem": CreateREM, "remu": CreateREMU,
+	} {
+		asmInstructions[name] = asmR(create)
+	}
+	for name, create := range map[string]func(int, int, int) RInstr{
+		"addw": CreateADDW, "subw": CreateSUBW, "sllw": CreateSLLW, "srlw": CreateSRLW, "sraw": CreateSRAW,
+		"mulw": CreateMULW, "divw": CreateDIVW, "divuw": CreateDIVUW, "remw": CreateREMW, "remuw": CreateREMUW,
+	} {
+		asmInstructions[name] = asmRV64(asmR(create))
+	}
+	for name, create := range map[string]func(int, int, uint32) IInstr{
+		"addi": CreateADDI, "slti": CreateSLTI, "sltiu": CreateSLTIU, "xori": CreateXORI, "ori": CreateORI, "andi": CreateANDI,
+	} {
+		asmInstructions[name] = asmI(create)
+	}
+	asmInstructions["addiw"] = asmRV64(asmI(CreateADDIW))
+	for name, create := range map[string]func(int, int, uint32) IInstr{
+		"slli": CreateSLLI, "srli": CreateSLRI, "srai": CreateSRAI,
+	} {
+		asmInstructions[name] = asmShift(create, false)
+	}
+	for name, create := range map[string]func(int, int, uint32) IInstr{
+		"slliw": CreateSLLIW, "srliw": CreateSRLIW, "sraiw": CreateSRAIW,
+	} {
+		asmInstructions[name] = asmRV64(asmShift(create, true))
+	}
+	asmInstructions["lui"] = asmU(CreateLui)
+	asmInstructions["auipc"] = asmU(CreateAUIPC)
+
+	for name, func3 := range map[string]int8{"lb": FUNC3_LB, "lh": FUNC3_LH, "lw": FUNC3_LW, "lbu": FUNC3_LBU, "lhu": FUNC3_LHU} {
+		asmInstructions[name] = asmLoad(func3)
+	}
+	asmInstructions["ld"] = asmRV64(asmLoad(FUNC3_LD))
+	asmInstructions["lwu"] = asmRV64(asmLoad(FUNC3_LWU))
+	for name, func3 := range map[string]int8{"sb": FUNC3_SB, "sh": FUNC3_SH, "sw": FUNC3_SW} {
+		asmInstructions[name] = asmStore(func3)
+	}
+	asmInstructions["sd"] = asmRV64(asmStore(FUNC3_SD))
+
+	for name, create := range map[string]func(uint32, int, int) BInstr{
+		"beq": CreateBEQ, "bne": CreateBNE, "blt": CreateBLT, "bge": CreateBGE, "bltu": CreateBLTU, "bgeu": CreateBGEU,
+	} {
+		asmInstructions[name] = asmBranch(create, false, false)
+		// the pseudo-instructions with swapped operands
+		switch name {
+		case "blt":
+			asmInstructions["bgt"] = asmBranch(create, true, false)
+		case "bge":
+			asmInstructions["ble"] = asmBranch(create, true, false)
+		case "bltu":
+			asmInstructions["bgtu"] = asmBranch(create, true, false)
+		case "bgeu":
+			asmInstructions["bleu"] = asmBranch(create, true, false)
+		}
+	}
+	// the pseudo-instructions comparing with zero, swapped ones have x0 in rs1
+	asmInstructions["beqz"] = asmBranch(CreateBEQ, false, true)
+	asmInstructions["bnez"] = asmBranch(CreateBNE, false, true)
+	asmInstructions["bltz"] = asmBranch(CreateBLT, false, true)
+	asmInstructions["bgez"] = asmBranch(CreateBGE, false, true)
+	asmInstructions["bgtz"] = asmBranch(CreateBLT, true, true)
+	asmInstructions["blez"] = asmBranch(CreateBGE, true, true)
+
+	for name, create := range map[string]func(int, uint32, int) IInstr{
+		"csrrw": CreateCSRRW, "csrrs": CreateCSRRS, "csrrc": CreateCSRRC,
+	} {
+		asmInstructions[name] = asmCsr(create)
+	}
+	for name, create := range map[string]func(int, uint32, uint32) IInstr{
+		"csrrwi": CreateCSRRWI, "csrrsi": CreateCSRRSI, "csrrci": CreateCSRRCI,
+	} {
+		asmInstructions[name] = asmCsrImm(create)
+	}
+	for name, create := range map[string]func() IInstr{
+		"ecall": CreateECALL, "ebreak": CreateEBREAK, "wfi": CreateWFI, "sret": CreateSRET, "mret": CreateMRET,
+		"fence.tso": CreateFENCETSO, "fence.i": CreateFENCEI, "pause": CreatePAUSE, "nop": Nop,
+	} {
+		create := create
+		asmInstructions[name] = func(a *assembler, args []string) []Instruction {
+			a.operands(args, 0)
+			return []Instruction{create()}
+		}
+	}
+
+	for _, csr := range []struct {
+		name string
+		csr  uint32
+	}{{"cycle", CSR_CYCLE}, {"time", csrTime}, {"instret", CSR_INSTRET}, {"cycleh", CSR_CYCLEH}, {"timeh", csrTimeh}, {"instreth", CSR_INSTRETH}} {
+		asmInstructions["rd"+csr.name] = asmReadCsr(csr.csr)
+	}
+	for _, csr := range []struct {
+		name string
+		csr  uint32
+	}{{"csr", CSR_FCSR}, {"rm", CSR_FRM}, {"flags", CSR_FFLAGS}} {
+		asmInstructions["fr"+csr.name] = asmReadCsr(csr.csr)
+		asmInstructions["fs"+csr.name] = asmWriteFloatCsr(csr.csr)
+	}
+
+	asmAtomics["lr.w"] = asmLR(CreateLRW, false)
+	asmAtomics["lr.d"] = asmLR(CreateLRD, true)
+	for name, create := range map[string]func(int, int, int) RInstr{
+		"sc.w": CreateSCW, "amoswap.w": CreateAMOSWAPW, "amoadd.w": CreateAMOADDW, "amoxor.w": CreateAMOXORW,
+		"amoand.w": CreateAMOANDW, "amoor.w": CreateAMOORW, "amomin.w": CreateAMOMINW, "amomax.w": CreateAMOMAXW,
+		"amominu.w": CreateAMOMINUW, "amomaxu.w": CreateAMOMAXUW,
+	} {
+		asmAtomics[name] = asmAMO(create, false)
+	}
+	for name, create := range map[string]func(int, int, int) RInstr{
+		"sc.d": CreateSCD, "amoswap.d": CreateAMOSWAPD, "amoadd.d": CreateAMOADDD, "amoxor.d": CreateAMOXORD,
+		"amoand.d": CreateAMOANDD, "amoor.d": CreateAMOORD, "amomin.d": CreateAMOMIND, "amomax.d": CreateAMOMAXD,
+		"amominu.d": CreateAMOMINUD, "amomaxu.d": CreateAMOMAXUD,
+	} {
+		asmAtomics[name] = asmAMO(create, true)
+	}
+
+	asmInstructions["flw"] = asmFloatLoad(CreateFLW)
+	asmInstructions["fld"] = asmFloatLoad(CreateFLD)
+	asmInstructions["fsw"] = asmFloatStore(CreateFSW)
+	asmInstructions["fsd"] = asmFloatStore(CreateFSD)
+	for suffix, format := range map[string]int8{".s": FMT_S, ".d": FMT_D} {
+		registerFloatInstructions(suffix, format)
+	}
+	asmInstructions["fcvt.s.d"] = asmFloatConvert(func(rd int, rs1 int, rm int8) RInstr {
+		return CreateFCVTFF(FMT_S, FMT_D, rd, rs1, rm)
+	}, true, true, false)
+	asmInstructions["fcvt.d.s"] = asmFloatConvert(func(rd int, rs1 int, rm int8) RInstr {
+		return CreateFCVTFF(FMT_D, FMT_S, rd, rs1, rm)
+	}, true, true, true)
+	asmInstructions["fmv.x.w"] = asmFloatMove(CreateFMVXW, false, true)
+	asmInstructions["fmv.w.x"] = asmFloatMove(CreateFMVWX, true, false)
+	asmInstructions["fmv.x.s"] = asmInstructions["fmv.x.w"]
+	asmInstructions["fmv.s.x"] = asmInstructions["fmv.w.x"]
+	asmInstructions["fmv.x.d"] = asmRV64(asmFloatMove(CreateFMVXD, false, true))
+	asmInstructions["fmv.d.x"] = asmRV64(asmFloatMove(CreateFMVDX, true, false))
+
+	for name, handler := range map[string]asmHandler{
+		"jal": (*assembler).jal, "jalr": (*assembler).jalr, "j": (*assembler).j, "jr": (*assembler).jr,
+		"ret": (*assembler).ret, "call": (*assembler).call, "tail": (*assembler).tail,
+		"fence": (*assembler).fence, "sfence.vma": (*assembler).sfenceVma,
+		"li": (*assembler).li, "la": (*assembler).la, "lla": (*assembler).la,
+		"mv": (*assembler).mv, "not": (*assembler).not, "neg": (*assembler).neg, "negw": asmRV64((*assembler).negw),
+		"sext.w": asmRV64((*assembler).sextW), "seqz": (*assembler).seqz, "snez": (*assembler).snez,
+		"sltz": (*assembler).sltz, "sgtz": (*assembler).sgtz, "zext.b": (*assembler).zextB,
+		"csrr": (*assembler).csrr, "csrw": asmCsrWrite(CreateCSRRW), "csrs": asmCsrWrite(CreateCSRRS),
+		"csrc": asmCsrWrite(CreateCSRRC), "csrwi": asmCsrWriteImm(CreateCSRRWI),
+		"csrsi": asmCsrWriteImm(CreateCSRRSI), "csrci": asmCsrWriteImm(CreateCSRRCI),
+	} {
+		asmInstructions[name] = handler
+	}
+}
+
+func registerFloatInstructions(suffix string, format int8) {
+	for name, create := range map[string]func(int8, int, int, int, int8) RInstr{
+		"fadd": CreateFADD, "fsub": CreateFSUB, "fmul": CreateFMUL, "fdiv": CreateFDIV,
+	} {
+		create := create
+		asmInstructions[name+suffix] = func(a *assembler, args []string) []Instruction {
+			if !a.operandsBetween(args, 3, 4) {
+				return nil
+			}
+			return []Instruction{create(format, a.freg(args[0]), a.freg(args[1]), a.freg(args[2]), a.roundingMode(args, 3, false))}
+		}
+	}
+	asmInstructions["fsqrt"+suffix] = func(a *assembler, args []string) []Instruction {
+		if !a.operandsBetween(args, 2, 3) {
+			return nil
+		}
+		return []Instruction{CreateFSQRT(format, a.freg(args[0]), a.freg(args[1]), a.roundingMode(args, 2, false))}
+	}
+	for name, create := range map[string]func(int8, int, int, int) RInstr{
+		"fsgnj": CreateFSGNJ, "fsgnjn": CreateFSGNJN, "fsgnjx": CreateFSGNJX, "fmin": CreateFMIN, "fmax": CreateFMAX,
+	} {
+		create := create
+		asmInstructions[name+suffix] = func(a *assembler, args []string) []Instruction {
+			if !a.operands(args, 3) {
+				return nil
+			}
+			return []Instruction{create(format, a.freg(args[0]), a.freg(args[1]), a.freg(args[2]))}
+		}
+	}
+	// fmv, fneg and fabs are fsgnj with the same source twice
+	for name, create := range map[string]func(int8, int, int, int) RInstr{
+		"fmv": CreateFSGNJ, "fneg": CreateFSGNJN, "fabs": CreateFSGNJX,
+	} {
+		create := create
+		asmInstructions[name+suffix] = func(a *assembler, args []string) []Instruction {
+			if !a.operands(args, 2) {
+				return nil
+			}
+			rs := a.freg(args[1])
+			return []Instruction{create(format, a.freg(args[0]), rs, rs)}
+		}
+	}
+	for name, create := range map[string]func(int8, int, int, int) RInstr{
+		"feq": CreateFEQ, "flt": CreateFLT, "fle": CreateFLE,
+	} {
+		create := create
+		asmInstructions[name+suffix] = func(a *assembler, args []string) []Instruction {
+			if !a.operands(args, 3) {
+				return nil
+			}
+			return []Instruction{create(format, a.reg(args[0]), a.freg(args[1]), a.freg(args[2]))}
+		}
+	}
+	asmInstructions["fclass"+suffix] = func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		return []Instruction{CreateFCLASS(format, a.reg(args[0]), a.freg(args[1]))}
+	}
+
+	// the integer conversions, the L variants are RV64 only
+	for _, c := range []struct {
+		integer string
+		to      func(int8, int, int, int8) RInstr
+		from    func(int8, int, int, int8) RInstr
+		rv64    bool
+	}{
+		{"w", CreateFCVTW, CreateFCVTFromW, false},
+		{"wu", CreateFCVTWU, CreateFCVTFromWU, false},
+		{"l", CreateFCVTL, CreateFCVTFromL, true},
+		{"lu", CreateFCVTLU, CreateFCVTFromLU, true},
+	} {
+		c := c
+		to := asmFloatConvert(func(rd int, rs1 int, rm int8) RInstr {
+			return c.to(format, rd, rs1, rm)
+		}, false, true, false)
+		// converting a word to a double is exact, so the rounding mode
+		// defaults to rne like in the GNU assembler
+		exact := format == FMT_D && !c.rv64
+		from := asmFloatConvert(func(rd int, rs1 int, rm int8) RInstr {
+			return c.from(format, rd, rs1, rm)
+		}, true, false, exact)
+		if c.rv64 {
+			to, from = asmRV64(to), asmRV64(from)
+		}
+		asmInstructions["fcvt."+c.integer+suffix] = to
+		asmInstructions["fcvt"+suffix+"."+c.integer] = from
+	}
+
+	for name, create := range map[string]func(int8, int, int, int, int, int8) R4Instr{
+		"fmadd": CreateFMADD, "fmsub": CreateFMSUB, "fnmsub": CreateFNMSUB, "fnmadd": CreateFNMADD,
+	} {
+		create := create
+		asmInstructions[name+suffix] = func(a *assembler, args []string) []Instruction {
+			if !a.operandsBetween(args, 4, 5) {
+				return nil
+			}
+			return []Instruction{create(format, a.freg(args[0]), a.freg(args[1]), a.freg(args[2]), a.freg(args[3]), a.roundingMode(args, 4, false))}
+		}
+	}
+}
+
+// the user mode timer CSRs, the emulator doesn't implement them
+const (
+	csrTime  uint32 = 0xC01
+	csrTimeh uint32 = 0xC81
+)
+
+// The operand helpers
+
+// operands checks the number of operands.
+func (a *assembler) operands(args []string, n int) bool {
+	return a.operandsBetween(args, n, n)
+}
+
+func (a *assembler) operandsBetween(args []string, min int, max int) bool {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			a.fail("expects %d operands, got %d", min, len(args))
+		} else {
+			a.fail("expects %d to %d operands, got %d", min, max, len(args))
+		}
+		return false
+	}
+	return true
+}
+
+// roundingMode parses the optional rounding mode operand at index i.
+// Without it the rounding mode is dyn (the one in frm), or rne for the
+// exact conversions.
+func (a *assembler) roundingMode(args []string, i int, exact bool) int8 {
+	if i >= len(args) {
+		if exact {
+			return int8(RM_RNE)
+		}
+		return int8(RM_DYN)
+	}
+	for name, rm := range map[string]uint32{"rne": RM_RNE, "rtz": RM_RTZ, "rdn": RM_RDN, "rup": RM_RUP, "rmm": RM_RMM, "dyn": RM_DYN} {
+		if strings.TrimSpace(args[i]) == name {
+			return int8(rm)
+		}
+	}
+	a.fail("invalid rounding mode %s", args[i])
+	return 0
+}
+
+// csr parses a CSR operand, its name or a 12 bit number.
+func (a *assembler) csr(s string) uint32 {
+	s = strings.TrimSpace(s)
+	for csr, name := range csrNames {
+		if s == name {
+			return csr
+		}
+	}
+	switch s {
+	case "time":
+		return csrTime
+	case "timeh":
+		return csrTimeh
+	}
+	return uint32(a.uimm(s, 12))
+}
+
+// memOffset parses a memory operand with a 12 bit signed offset.
+func (a *assembler) memOffset(s string) (int32, int) {
+	offset, reg := a.mem(s)
+	a.checkSimm(s, offset, 12)
+	return int32(offset), reg
+}
+
+// checkSimm checks that the value fits in a signed immediate.
+func (a *assembler) checkSimm(s string, value int64, bits uint) {
+	if a.final && (value < -(1<<(bits-1)) || value >= 1<<(bits-1)) {
+		a.fail("immediate %s (%d) doesn't fit in %d bits", strings.TrimSpace(s), value, bits)
+	}
+}
+
+// atomicAddr parses the address operand of the atomic instructions, (reg)
+// or 0(reg).
+func (a *assembler) atomicAddr(s string) int {
+	offset, reg := a.mem(s)
+	if offset != 0 {
+		a.fail("atomic instructions have no offset, got %s", s)
+	}
+	return reg
+}
+
+// pcRelative returns the upper and lower part of the offset from the pc to
+// the symbol for auipc and a following instruction.
+func (a *assembler) pcRelative(s string) (int32, int32) {
+	offset := a.expr(s) - int64(a.pc)
+	if a.xlen == XLEN_32 {
+		// addresses wrap around on RV32
+		offset = int64(int32(offset))
+	}
+	if a.final && (offset < -(1<<31) || offset >= 1<<31-0x800) {
+		a.fail("%s is out of the range of auipc", s)
+	}
+	return int32(hi20(offset)), int32(lo12(offset))
+}
+
+// The instruction formats
+
+func asmRV64(h asmHandler) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if a.xlen != XLEN_64 {
+			a.fail("instruction is only supported on RV64")
+			return nil
+		}
+		return h(a, args)
+	}
+}
+
+func asmR(create func(int, int, int) RInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 3) {
+			return nil
+		}
+		return []Instruction{create(a.reg(args[0]), a.reg(args[1]), a.reg(args[2]))}
+	}
+}
+
+func asmI(create func(int, int, uint32) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 3) {
+			return nil
+		}
+		rd, rs1 := a.reg(args[0]), a.reg(args[1])
+		return []Instruction{create(rs1, rd, uint32(a.simm(args[2], 12)))}
+	}
+}
+
+func asmShift(create func(int, int, uint32) IInstr, word bool) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 3) {
+			return nil
+		}
+		bits := uint(5)
+		if a.xlen == XLEN_64 && !word {
+			bits = 6
+		}
+		rd, rs1 := a.reg(args[0]), a.reg(args[1])
+		shamt := a.uimm(args[2], bits) & (1<<bits - 1)
+		return []Instruction{create(rs1, rd, uint32(shamt))}
+	}
+}
+
+func asmU(create func(int32, int) UInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		return []Instruction{create(int32(a.uimm(args[1], 20)), a.reg(args[0]))}
+	}
+}
+
+// asmLoad assembles a load from offset(reg), or from a symbol with auipc
+// like the GNU assembler.
+func asmLoad(func3 int8) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rd := a.reg(args[0])
+		if !strings.HasSuffix(strings.TrimSpace(args[1]), ")") {
+			hi, lo := a.pcRelative(args[1])
+			return []Instruction{CreateAUIPC(hi, rd), CreateLoad(lo, rd, func3, rd)}
+		}
+		offset, rs1 := a.memOffset(args[1])
+		return []Instruction{CreateLoad(offset, rs1, func3, rd)}
+	}
+}
+
+func asmStore(func3 int8) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rs2 := a.reg(args[0])
+		offset, rs1 := a.memOffset(args[1])
+		return []Instruction{CreateStore(offset, rs2, rs1, func3)}
+	}
+}
+
+// asmBranch assembles the branches and their pseudo-instructions, swap
+// swaps rs1 and rs2 and zero compares rs with x0.
+func asmBranch(create func(uint32, int, int) BInstr, swap bool, zero bool) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		n := 3
+		if zero {
+			n = 2
+		}
+		if !a.operands(args, n) {
+			return nil
+		}
+		rs1 := a.reg(args[0])
+		rs2 := reg_zero
+		if !zero {
+			rs2 = a.reg(args[1])
+		}
+		if swap {
+			rs1, rs2 = rs2, rs1
+		}
+		return []Instruction{create(uint32(a.target(args[n-1], 13)), rs1, rs2)}
+	}
+}
+
+func asmCsr(create func(int, uint32, int) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 3) {
+			return nil
+		}
+		return []Instruction{create(a.reg(args[0]), a.csr(args[1]), a.reg(args[2]))}
+	}
+}
+
+func asmCsrImm(create func(int, uint32, uint32) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 3) {
+			return nil
+		}
+		return []Instruction{create(a.reg(args[0]), a.csr(args[1]), uint32(a.uimm(args[2], 5)))}
+	}
+}
+
+// asmCsrWrite assembles csrw, csrs and csrc which discard the old value.
+func asmCsrWrite(create func(int, uint32, int) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		return []Instruction{create(reg_zero, a.csr(args[0]), a.reg(args[1]))}
+	}
+}
+
+func asmCsrWriteImm(create func(int, uint32, uint32) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		return []Instruction{create(reg_zero, a.csr(args[0]), uint32(a.uimm(args[1], 5)))}
+	}
+}
+
+// asmReadCsr assembles the pseudo-instructions reading a CSR, like rdcycle.
+func asmReadCsr(csr uint32) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 1) {
+			return nil
+		}
+		return []Instruction{CreateCSRRS(a.reg(args[0]), csr, reg_zero)}
+	}
+}
+
+// asmWriteFloatCsr assembles fscsr, fsrm and fsflags, which write the CSR
+// and optionally read the old value.
+func asmWriteFloatCsr(csr uint32) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operandsBetween(args, 1, 2) {
+			return nil
+		}
+		rd := reg_zero
+		if len(args) == 2 {
+			rd = a.reg(args[0])
+		}
+		return []Instruction{CreateCSRRW(rd, csr, a.reg(args[len(args)-1]))}
+	}
+}
+
+// atomicOrdering returns the aq and rl bits of func7 for the suffix.
+func atomicOrdering(suffix string) int8 {
+	switch suffix {
+	case ".aq":
+		return 2
+	case ".rl":
+		return 1
+	case ".aqrl":
+		return 3
+	}
+	return 0
+}
+
+func asmLR(create func(int, int) RInstr, double bool) asmAtomicHandler {
+	return func(a *assembler, args []string, suffix string) []Instruction {
+		if double && a.xlen != XLEN_64 {
+			a.fail("instruction is only supported on RV64")
+			return nil
+		}
+		if !a.operands(args, 2) {
+			return nil
+		}
+		instr := create(a.reg(args[0]), a.atomicAddr(args[1]))
+		instr.func7 |= atomicOrdering(suffix)
+		return []Instruction{instr}
+	}
+}
+
+func asmAMO(create func(int, int, int) RInstr, double bool) asmAtomicHandler {
+	return func(a *assembler, args []string, suffix string) []Instruction {
+		if double && a.xlen != XLEN_64 {
+			a.fail("instruction is only supported on RV64")
+			return nil
+		}
+		if !a.operands(args, 3) {
+			return nil
+		}
+		instr := create(a.reg(args[0]), a.atomicAddr(args[2]), a.reg(args[1]))
+		instr.func7 |= atomicOrdering(suffix)
+		return []Instruction{instr}
+	}
+}
+
+func asmFloatLoad(create func(int32, int, int) IInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rd := a.freg(args[0])
+		offset, rs1 := a.memOffset(args[1])
+		return []Instruction{create(offset, rs1, rd)}
+	}
+}
+
+func asmFloatStore(create func(int32, int, int) SInstr) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rs2 := a.freg(args[0])
+		offset, rs1 := a.memOffset(args[1])
+		return []Instruction{create(offset, rs2, rs1)}
+	}
+}
+
+// asmFloatConvert assembles a conversion, floatRd and floatRs1 tell the
+// register files of the operands.
+func asmFloatConvert(create func(int, int, int8) RInstr, floatRd bool, floatRs1 bool, exact bool) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operandsBetween(args, 2, 3) {
+			return nil
+		}
+		rd, rs1 := a.reg, a.reg
+		if floatRd {
+			rd = a.freg
+		}
+		if floatRs1 {
+			rs1 = a.freg
+		}
+		return []Instruction{create(rd(args[0]), rs1(args[1]), a.roundingMode(args, 2, exact))}
+	}
+}
+
+// asmFloatMove assembles the moves between the register files.
+func asmFloatMove(create func(int, int) RInstr, floatRd bool, floatRs1 bool) asmHandler {
+	return func(a *assembler, args []string) []Instruction {
+		if !a.operands(args, 2) {
+			return nil
+		}
+		rd, rs1 := a.reg, a.reg
+		if floatRd {
+			rd = a.freg
+		}
+		if floatRs1 {
+			rs1 = a.freg
+		}
+		return []Instruction{create(rd(args[0]), rs1(args[1]))}
+	}
+}
+
+// The instructions with several forms and the pseudo-instructions
+
+func (a *assembler) jal(args []string) []Instruction {
+	if !a.operandsBetween(args, 1, 2) {
+		return nil
+	}
+	rd := reg_ra
+	if len(args) == 2 {
+		rd = a.reg(args[0])
+	}
+	return []Instruction{CreateJAL(int32(a.target(args[len(args)-1], 21)), rd)}
+}
+
+// jalr accepts jalr rs, jalr rd, rs, jalr rd, offset(rs) and
+// jalr rd, rs, offset.
+func (a *assembler) jalr(args []string) []Instruction {
+	if !a.operandsBetween(args, 1, 3) {
+		return nil
+	}
+	rd := reg_ra
+	if len(args) > 1 {
+		rd = a.reg(args[0])
+	}
+	var offset int32
+	var rs1 int
+	switch {
+	case len(args) == 3:
+		rs1 = a.reg(args[1])
+		offset = int32(a.simm(args[2], 12))
+	case strings.HasSuffix(strings.TrimSpace(args[len(args)-1]), ")"):
+		offset, rs1 = a.memOffset(args[len(args)-1])
+	default:
+		rs1 = a.reg(args[len(args)-1])
+	}
+	return []Instruction{CreateJALR(uint32(offset), rd, rs1)}
+}
+
+func (a *assembler) j(args []string) []Instruction {
+	if !a.operands(args, 1) {
+		return nil
+	}
+	return []Instruction{CreateJ(int32(a.target(args[0], 21)))}
+}
+
+func (a *assembler) jr(args []string) []Instruction {
+	if !a.operands(args, 1) {
+		return nil
+	}
+	if strings.HasSuffix(strings.TrimSpace(args[0]), ")") {
+		offset, rs1 := a.memOffset(args[0])
+		return []Instruction{CreateJALR(uint32(offset), reg_zero, rs1)}
+	}
+	return []Instruction{CreateJALR(0, reg_zero, a.reg(args[0]))}
+}
+
+func (a *assembler) ret(args []string) []Instruction {
+	a.operands(args, 0)
+	return []Instruction{CreateJALR(0, reg_zero, reg_ra)}
+}
+
+// call jumps anywhere in the +-2GiB around the pc with auipc and jalr.
+func (a *assembler) call(args []string) []Instruction {
+	if !a.operandsBetween(args, 1, 2) {
+		return nil
+	}
+	link := reg_ra
+	if len(args) == 2 {
+		link = a.reg(args[0])
+	}
+	hi, lo := a.pcRelative(args[len(args)-1])
+	return []Instruction{CreateAUIPC(hi, link), CreateJALR(uint32(lo), link, link)}
+}
+
+// tail is call without link, t1 holds the upper part of the address.
+func (a *assembler) tail(args []string) []Instruction {
+	if !a.operands(args, 1) {
+		return nil
+	}
+	hi, lo := a.pcRelative(args[0])
+	return []Instruction{CreateAUIPC(hi, reg_t1), CreateJALR(uint32(lo), reg_zero, reg_t1)}
+}
+
+// fence without operands orders everything, like fence iorw, iorw.
+func (a *assembler) fence(args []string) []Instruction {
+	if len(args) == 0 {
+		all := FENCE_I | FENCE_O | FENCE_R | FENCE_W
+		return []Instruction{CreateFENCE(all, all)}
+	}
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateFENCE(a.fenceSet(args[0]), a.fenceSet(args[1]))}
+}
+
+func (a *assembler) fenceSet(s string) uint32 {
+	s = strings.TrimSpace(s)
+	set := uint32(0)
+	// 0 is the empty set
+	if s == "0" {
+		return set
+	}
+	for _, c := range s {
+		switch c {
+		case 'i':
+			set |= FENCE_I
+		case 'o':
+			set |= FENCE_O
+		case 'r':
+			set |= FENCE_R
+		case 'w':
+			set |= FENCE_W
+		default:
+			a.fail("invalid fence operand %s, should be a combination of iorw", s)
+		}
+	}
+	return set
+}
+
+func (a *assembler) sfenceVma(args []string) []Instruction {
+	if !a.operandsBetween(args, 0, 2) {
+		return nil
+	}
+	rs1, rs2 := reg_zero, reg_zero
+	if len(args) > 0 {
+		rs1 = a.reg(args[0])
+	}
+	if len(args) > 1 {
+		rs2 = a.reg(args[1])
+	}
+	return []Instruction{CreateSFENCEVMA(rs1, rs2)}
+}
+
+// li loads a constant with the shortest sequence of lui, addi(w) and slli.
+func (a *assembler) li(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	rd := a.reg(args[0])
+	value := a.constant(args[1])
+	if a.xlen == XLEN_32 {
+		if value < -(1<<31) || value >= 1<<32 {
+			a.fail("immediate %s doesn't fit in 32 bits", args[1])
+		}
+		value = int64(int32(value))
+	}
+	return loadImmediate(rd, value, a.xlen == XLEN_64)
+}
+
+// loadImmediate returns the instructions loading the value into rd, the
+// value is sign extended from 32 bits on RV32.
+func loadImmediate(rd int, value int64, rv64 bool) []Instruction {
+	if value >= -(1<<31) && value < 1<<31 {
+		hi, lo := hi20(value), lo12(value)
+		var instrs []Instruction
+		src := reg_zero
+		if hi != 0 {
+			instrs = append(instrs, CreateLui(int32(hi), rd))
+			src = rd
+		}
+		if rv64 && hi != 0 {
+			// lui sign extends, addiw corrects the values from 0x7ffff800
+			if lo != 0 {
+				instrs = append(instrs, CreateADDIW(src, rd, uint32(lo)))
+			}
+		} else if lo != 0 || hi == 0 {
+			instrs = append(instrs, CreateADDI(src, rd, uint32(lo)))
+		}
+		return instrs
+	}
+	// load the upper bits without the trailing zeros, shift them into place
+	// and add the lower 12 bits
+	lo := lo12(value)
+	upper := (uint64(value) + 0x800) >> 12
+	shift := 12 + bits.TrailingZeros64(upper)
+	upper = upper >> (shift - 12)
+	instrs := loadImmediate(rd, int64(upper<<shift)>>shift, rv64)
+	instrs = append(instrs, CreateSLLI(rd, rd, uint32(shift)))
+	if lo != 0 {
+		instrs = append(instrs, CreateADDI(rd, rd, uint32(lo)))
+	}
+	return instrs
+}
+
+// la loads the address of a symbol relative to the pc, so it works at any
+// load address.
+func (a *assembler) la(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	rd := a.reg(args[0])
+	hi, lo := a.pcRelative(args[1])
+	return []Instruction{CreateAUIPC(hi, rd), CreateADDI(rd, rd, uint32(lo))}
+}
+
+func (a *assembler) mv(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateMV(a.reg(args[1]), a.reg(args[0]))}
+}
+
+func (a *assembler) not(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateXORI(a.reg(args[1]), a.reg(args[0]), uint32(0xffffffff))}
+}
+
+func (a *assembler) neg(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSUB(a.reg(args[0]), reg_zero, a.reg(args[1]))}
+}
+
+func (a *assembler) negw(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSUBW(a.reg(args[0]), reg_zero, a.reg(args[1]))}
+}
+
+func (a *assembler) sextW(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateADDIW(a.reg(args[1]), a.reg(args[0]), 0)}
+}
+
+func (a *assembler) seqz(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSLTIU(a.reg(args[1]), a.reg(args[0]), 1)}
+}
+
+func (a *assembler) snez(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSLTU(a.reg(args[0]), reg_zero, a.reg(args[1]))}
+}
+
+func (a *assembler) sltz(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSLT(a.reg(args[0]), a.reg(args[1]), reg_zero)}
+}
+
+func (a *assembler) sgtz(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateSLT(a.reg(args[0]), reg_zero, a.reg(args[1]))}
+}
+
+func (a *assembler) zextB(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateANDI(a.reg(args[1]), a.reg(args[0]), 0xff)}
+}
+
+func (a *assembler) csrr(args []string) []Instruction {
+	if !a.operands(args, 2) {
+		return nil
+	}
+	return []Instruction{CreateCSRRS(a.reg(args[0]), a.csr(args[1]), reg_zero)}
+}
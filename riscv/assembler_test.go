@@ -0,0 +1,595 @@
+package riscv
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the encodings are the ones of llvm-mc, the targets of the branches and
+// jumps are relative to the instruction at address 0
+var assemblerTestsRV32 = []struct {
+	source string
+	word   uint32
+}{
+	{"pause", 0x0100000f},
+	{"add a0, a1, a2", 0x00c58533},
+	{"sub t0, t1, t2", 0x407302b3},
+	{"sll s0, s1, s2", 0x01249433},
+	{"slt a0, a1, a2", 0x00c5a533},
+	{"sltu a0, a1, a2", 0x00c5b533},
+	{"xor a0, a1, a2", 0x00c5c533},
+	{"srl a0, a1, a2", 0x00c5d533},
+	{"sra a0, a1, a2", 0x40c5d533},
+	{"or a0, a1, a2", 0x00c5e533},
+	{"and a0, a1, a2", 0x00c5f533},
+	{"mul a0, a1, a2", 0x02c58533},
+	{"mulh a0, a1, a2", 0x02c59533},
+	{"mulhsu a0, a1, a2", 0x02c5a533},
+	{"mulhu a0, a1, a2", 0x02c5b533},
+	{"div a0, a1, a2", 0x02c5c533},
+	{"divu a0, a1, a2", 0x02c5d533},
+	{"rem a0, a1, a2", 0x02c5e533},
+	{"remu a0, a1, a2", 0x02c5f533},
+	{"addi a0, a1, -2048", 0x80058513},
+	{"slti a0, a1, 2047", 0x7ff5a513},
+	{"sltiu a0, a1, -1", 0xfff5b513},
+	{"xori a0, a1, 0x7f", 0x07f5c513},
+	{"ori a0, a1, -5", 0xffb5e513},
+	{"andi a0, a1, 255", 0x0ff5f513},
+	{"slli a0, a1, 31", 0x01f59513},
+	{"srli a0, a1, 1", 0x0015d513},
+	{"srai a0, a1, 17", 0x4115d513},
+	{"lui a0, 0xfffff", 0xfffff537},
+	{"auipc t0, 0x12345", 0x12345297},
+	{"lb a0, -1(a1)", 0xfff58503},
+	{"lh a0, 2(a1)", 0x00259503},
+	{"lw a0, 2047(sp)", 0x7ff12503},
+	{"lbu a0, 0(a1)", 0x0005c503},
+	{"lhu a0, (a1)", 0x0005d503},
+	{"sb a0, -2048(a1)", 0x80a58023},
+	{"sh a0, 6(a1)", 0x00a59323},
+	{"sw ra, 12(sp)", 0x00112623},
+	{"jalr ra, 4(a0)", 0x004500e7},
+	{"jalr a0", 0x000500e7},
+	{"jr t0", 0x00028067},
+	{"ret", 0x00008067},
+	{"ecall", 0x00000073},
+	{"ebreak", 0x00100073},
+	{"wfi", 0x10500073},
+	{"sret", 0x10200073},
+	{"mret", 0x30200073},
+	{"sfence.vma", 0x12000073},
+	{"sfence.vma a0, a1", 0x12b50073},
+	{"fence", 0x0ff0000f},
+	{"fence rw, w", 0x0310000f},
+	{"fence.tso", 0x8330000f},
+	{"fence.i", 0x0000100f},
+	{"csrrw a0, mstatus, a1", 0x30059573},
+	{"csrrs a0, mepc, x0", 0x34102573},
+	{"csrrc a0, 0x7c0, a1", 0x7c05b573},
+	{"csrrwi a0, mtvec, 31", 0x305fd573},
+	{"csrrsi a0, mie, 8", 0x30446573},
+	{"csrrci a0, satp, 1", 0x1800f573},
+	{"csrr a0, mhartid", 0xf1402573},
+	{"csrw mscratch, a0", 0x34051073},
+	{"csrs mie, a1", 0x3045a073},
+	{"csrc mstatus, a2", 0x30063073},
+	{"csrwi frm, 2", 0x00215073},
+	{"csrsi fflags, 1", 0x0010e073},
+	{"csrci fcsr, 3", 0x0031f073},
+	{"rdcycle a0", 0xc0002573},
+	{"rdtime a0", 0xc0102573},
+	{"rdinstret a0", 0xc0202573},
+	{"rdcycleh a0", 0xc8002573},
+	{"frcsr a0", 0x00302573},
+	{"fscsr a1", 0x00359073},
+	{"fscsr a0, a1", 0x00359573},
+	{"frrm a0", 0x00202573},
+	{"fsrm a1", 0x00259073},
+	{"frflags a0", 0x00102573},
+	{"fsflags a1", 0x00159073},
+	{"nop", 0x00000013},
+	{"mv a0, a1", 0x00058513},
+	{"not a0, a1", 0xfff5c513},
+	{"neg a0, a1", 0x40b00533},
+	{"seqz a0, a1", 0x0015b513},
+	{"snez a0, a1", 0x00b03533},
+	{"sltz a0, a1", 0x0005a533},
+	{"sgtz a0, a1", 0x00b02533},
+	{"zext.b a0, a1", 0x0ff5f513},
+	{"lr.w a0, (a1)", 0x1005a52f},
+	{"lr.w.aq a0, (a1)", 0x1405a52f},
+	{"sc.w a0, a2, (a1)", 0x18c5a52f},
+	{"sc.w.rl a0, a2, (a1)", 0x1ac5a52f},
+	{"amoswap.w a0, a2, (a1)", 0x08c5a52f},
+	{"amoadd.w.aqrl a0, a2, (a1)", 0x06c5a52f},
+	{"amoxor.w a0, a2, (a1)", 0x20c5a52f},
+	{"amoand.w a0, a2, (a1)", 0x60c5a52f},
+	{"amoor.w a0, a2, (a1)", 0x40c5a52f},
+	{"amomin.w a0, a2, (a1)", 0x80c5a52f},
+	{"amomax.w a0, a2, (a1)", 0xa0c5a52f},
+	{"amominu.w a0, a2, (a1)", 0xc0c5a52f},
+	{"amomaxu.w a0, a2, (a1)", 0xe0c5a52f},
+	{"flw fa0, 8(a0)", 0x00852507},
+	{"fld fs0, -8(sp)", 0xff813407},
+	{"fsw fa0, 8(a0)", 0x00a52427},
+	{"fsd fs0, -8(sp)", 0xfe813c27},
+	{"fadd.s fa0, fa1, fa2", 0x00c5f553},
+	{"fsub.d ft0, ft1, ft2, rtz", 0x0a209053},
+	{"fmul.s fa0, fa1, fa2, rne", 0x10c58553},
+	{"fdiv.d fa0, fa1, fa2", 0x1ac5f553},
+	{"fsqrt.s fa0, fa1", 0x5805f553},
+	{"fsqrt.d fa0, fa1, rup", 0x5a05b553},
+	{"fsgnj.s fa0, fa1, fa2", 0x20c58553},
+	{"fsgnjn.d fa0, fa1, fa2", 0x22c59553},
+	{"fsgnjx.s fa0, fa1, fa2", 0x20c5a553},
+	{"fmin.d fa0, fa1, fa2", 0x2ac58553},
+	{"fmax.s fa0, fa1, fa2", 0x28c59553},
+	{"fmv.s fa0, fa1", 0x20b58553},
+	{"fneg.d fa0, fa1", 0x22b59553},
+	{"fabs.s fa0, fa1", 0x20b5a553},
+	{"feq.s a0, fa1, fa2", 0xa0c5a553},
+	{"flt.d a0, fa1, fa2", 0xa2c59553},
+	{"fle.s a0, fa1, fa2", 0xa0c58553},
+	{"fclass.d a0, fa1", 0xe2059553},
+	{"fcvt.w.s a0, fa1", 0xc005f553},
+	{"fcvt.wu.d a0, fa1, rtz", 0xc2159553},
+	{"fcvt.s.w fa0, a1", 0xd005f553},
+	{"fcvt.s.wu fa0, a1, rmm", 0xd015c553},
+	{"fcvt.d.w fa0, a1", 0xd2058553},
+	{"fcvt.d.wu fa0, a1", 0xd2158553},
+	{"fcvt.s.d fa0, fa1", 0x4015f553},
+	{"fcvt.d.s fa0, fa1", 0x42058553},
+	{"fmv.x.w a0, fa1", 0xe0058553},
+	{"fmv.w.x fa0, a1", 0xf0058553},
+	{"fmadd.s fa0, fa1, fa2, fa3", 0x68c5f543},
+	{"fmsub.d fa0, fa1, fa2, fa3, rdn", 0x6ac5a547},
+	{"fnmsub.s fa0, fa1, fa2, fa3", 0x68c5f54b},
+	{"fnmadd.d fa0, fa1, fa2, fa3", 0x6ac5f54f},
+	{"beq a0, a1, -4096", 0x80b50063},
+	{"bne a0, zero, 4094", 0x7e051fe3},
+	{"blt a0, a1, 8", 0x00b54463},
+	{"bge a0, a1, -8", 0xfeb55ce3},
+	{"bltu a0, a1, 16", 0x00b56863},
+	{"bgeu a0, a1, 2", 0x00b57163},
+	{"beqz a0, 12", 0x00050663},
+	{"bnez a1, -12", 0xfe059ae3},
+	{"blez a0, 4", 0x00a05263},
+	{"bgez a0, 4", 0x00055263},
+	{"bltz a0, 4", 0x00054263},
+	{"bgtz a0, 4", 0x00a04263},
+	{"bgt a0, a1, 4", 0x00a5c263},
+	{"ble a0, a1, 4", 0x00a5d263},
+	{"bgtu a0, a1, 4", 0x00a5e263},
+	{"bleu a0, a1, 4", 0x00a5f263},
+	{"jal -1048576", 0x800000ef},
+	{"jal zero, 1048574", 0x7ffff06f},
+	{"j 8", 0x0080006f},
+	{"jal t0, 100", 0x064002ef},
+}
+
+var assemblerTestsRV64 = []struct {
+	source string
+	word   uint32
+}{
+	{"addw a0, a1, a2", 0x00c5853b},
+	{"subw a0, a1, a2", 0x40c5853b},
+	{"sllw a0, a1, a2", 0x00c5953b},
+	{"srlw a0, a1, a2", 0x00c5d53b},
+	{"sraw a0, a1, a2", 0x40c5d53b},
+	{"mulw a0, a1, a2", 0x02c5853b},
+	{"divw a0, a1, a2", 0x02c5c53b},
+	{"divuw a0, a1, a2", 0x02c5d53b},
+	{"remw a0, a1, a2", 0x02c5e53b},
+	{"remuw a0, a1, a2", 0x02c5f53b},
+	{"addiw a0, a1, -1", 0xfff5851b},
+	{"slliw a0, a1, 31", 0x01f5951b},
+	{"srliw a0, a1, 3", 0x0035d51b},
+	{"sraiw a0, a1, 7", 0x4075d51b},
+	{"slli a0, a1, 63", 0x03f59513},
+	{"srai a0, a1, 33", 0x4215d513},
+	{"ld a0, 8(sp)", 0x00813503},
+	{"lwu a0, -4(a1)", 0xffc5e503},
+	{"sd ra, 16(sp)", 0x00113823},
+	{"negw a0, a1", 0x40b0053b},
+	{"sext.w a0, a1", 0x0005851b},
+	{"lr.d a0, (a1)", 0x1005b52f},
+	{"sc.d.aq a0, a2, (a1)", 0x1cc5b52f},
+	{"amoswap.d a0, a2, (a1)", 0x08c5b52f},
+	{"amoadd.d a0, a2, (a1)", 0x00c5b52f},
+	{"amoxor.d a0, a2, (a1)", 0x20c5b52f},
+	{"amoand.d a0, a2, (a1)", 0x60c5b52f},
+	{"amoor.d a0, a2, (a1)", 0x40c5b52f},
+	{"amomin.d a0, a2, (a1)", 0x80c5b52f},
+	{"amomax.d a0, a2, (a1)", 0xa0c5b52f},
+	{"amominu.d a0, a2, (a1)", 0xc0c5b52f},
+	{"amomaxu.d.rl a0, a2, (a1)", 0xe2c5b52f},
+	{"fcvt.l.s a0, fa1", 0xc025f553},
+	{"fcvt.lu.d a0, fa1, rtz", 0xc2359553},
+	{"fcvt.s.l fa0, a1", 0xd025f553},
+	{"fcvt.d.lu fa0, a1", 0xd235f553},
+	{"fmv.x.d a0, fa1", 0xe2058553},
+	{"fmv.d.x fa0, a1", 0xf2058553},
+}
+
+var assemblerTestsCompressedRV32 = []struct {
+	source string
+	half   uint32
+}{
+	{"c.addi4spn a0, sp, 1020", 0x1fe8},
+	{"c.lw a0, 124(a1)", 0x5de8},
+	{"c.sw s0, 4(a5)", 0xc3c0},
+	{"c.flw fa0, 64(a1)", 0x61a8},
+	{"c.fsw fs1, 0(a2)", 0xe204},
+	{"c.fld fa0, 248(a1)", 0x3de8},
+	{"c.fsd fa0, 8(a1)", 0xa588},
+	{"c.nop", 0x0001},
+	{"c.addi a0, -32", 0x1501},
+	{"c.addi t0, 31", 0x02fd},
+	{"c.jal 2046", 0x2ffd},
+	{"c.li a0, -1", 0x557d},
+	{"c.addi16sp sp, -512", 0x7101},
+	{"c.addi16sp sp, 496", 0x617d},
+	{"c.lui a0, 0xfffe0", 0x7501},
+	{"c.lui t0, 31", 0x62fd},
+	{"c.srli a0, 31", 0x817d},
+	{"c.srai a5, 1", 0x8785},
+	{"c.andi s0, -1", 0x987d},
+	{"c.sub a0, a1", 0x8d0d},
+	{"c.xor a0, a1", 0x8d2d},
+	{"c.or a0, a1", 0x8d4d},
+	{"c.and a0, a1", 0x8d6d},
+	{"c.j -2048", 0xb001},
+	{"c.beqz a0, -256", 0xd101},
+	{"c.bnez s1, 254", 0xecfd},
+	{"c.slli a0, 31", 0x057e},
+	{"c.lwsp ra, 252(sp)", 0x50fe},
+	{"c.flwsp fa0, 4(sp)", 0x6512},
+	{"c.fldsp fs0, 504(sp)", 0x347e},
+	{"c.swsp ra, 252(sp)", 0xdf86},
+	{"c.fswsp fa0, 12(sp)", 0xe62a},
+	{"c.fsdsp fs0, 504(sp)", 0xbfa2},
+	{"c.jr ra", 0x8082},
+	{"c.mv a0, a1", 0x852e},
+	{"c.ebreak", 0x9002},
+	{"c.jalr t0", 0x9282},
+	{"c.add a0, a1", 0x952e},
+}
+
+var assemblerTestsCompressedRV64 = []struct {
+	source string
+	half   uint32
+}{
+	{"c.ld a0, 248(a1)", 0x7de8},
+	{"c.sd a0, 8(a1)", 0xe588},
+	{"c.addiw a0, -1", 0x357d},
+	{"c.subw a0, a1", 0x9d0d},
+	{"c.addw a0, a1", 0x9d2d},
+	{"c.slli a0, 63", 0x157e},
+	{"c.srli a0, 32", 0x9101},
+	{"c.ldsp ra, 504(sp)", 0x70fe},
+	{"c.sdsp ra, 8(sp)", 0xe406},
+}
+
+// checkAssembled assembles the single instruction of the source and checks
+// its encoding, and that the decoder decodes it to the same instruction.
+func checkAssembled(t *testing.T, source string, xlen int, word uint32) {
+	instrs, err := AssembleInstructions(source, xlen)
+	if err != nil {
+		t.Errorf("%s: assembling failed with error %v", source, err)
+		return
+	}
+	if len(instrs) != 1 {
+		t.Errorf("%s: assembled to %d instructions", source, len(instrs))
+		return
+	}
+	encoded := instrs[0].Encode()
+	if encoded != word {
+		t.Errorf("%s: encoded as %#08x, expected %#08x", source, encoded, word)
+		return
+	}
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	d.RegisterCompressedInstructionSet()
+	if xlen == XLEN_64 {
+		d.RegisterRV64InstructionSet()
+	}
+	decoded, err := d.Decode(word)
+	if err != nil {
+		t.Errorf("%s: decoding %#08x failed with error %v", source, word, err)
+		return
+	}
+	if !reflect.DeepEqual(decoded, instrs[0]) {
+		t.Errorf("%s: assembled to %s but decoded to %s", source, instrs[0], decoded)
+	}
+}
+
+func TestAssembleInstructions(t *testing.T) {
+	for _, test := range assemblerTestsRV32 {
+		checkAssembled(t, test.source, XLEN_32, test.word)
+	}
+	for _, test := range assemblerTestsRV64 {
+		checkAssembled(t, test.source, XLEN_64, test.word)
+	}
+	// the RV32 instructions are encoded the same on RV64
+	for _, test := range assemblerTestsRV32 {
+		checkAssembled(t, test.source, XLEN_64, test.word)
+	}
+	for _, test := range assemblerTestsCompressedRV32 {
+		checkAssembled(t, test.source, XLEN_32, test.half)
+	}
+	for _, test := range assemblerTestsCompressedRV64 {
+		checkAssembled(t, test.source, XLEN_64, test.half)
+	}
+}
+
+func TestAssembleLabels(t *testing.T) {
+	p, err := Assemble(`
+	.globl _start
+	.equ COUNT, 3
+_start:
+	li a0, COUNT
+	la a1, value
+1:	addi a0, a0, -1     # counts down to zero
+	bnez a0, 1b
+	call add_value
+	lw a3, value
+	j 1f
+	ebreak
+1:	j 1b               # loops forever
+add_value:
+	lw a2, 0(a1)
+	addi a2, a2, %lo(value) - %lo(value) + 1
+	ret
+
+	.data
+	.p2align 3
+value:
+	.word 0x41
+`, XLEN_32, 0x80000000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+	Assert(t, p.Entry, uint64(0x80000000))
+	Assert(t, p.Symbols["add_value"], uint64(0x80000030))
+	Assert(t, p.Symbols["value"], uint64(0x80000040))
+	Assert(t, p.Symbols["COUNT"], uint64(3))
+	Assert(t, len(p.Sections), 2)
+	Assert(t, p.Sections[1].Name, ".data")
+	Assert(t, p.Sections[1].Addr, uint64(0x80000040))
+
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	e, r := newTestEmulator(&mem)
+	if err := p.Load(&mem, r); err != nil {
+		t.Fatalf("Load failed with error %v", err)
+	}
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	CheckPc(0x8000002c, r, t)
+	CheckReg(reg_a0, 0, r, t)
+	CheckReg(reg_a1, 0x80000040, r, t)
+	CheckReg(reg_a2, 0x42, r, t)
+	CheckReg(reg_a3, 0x41, r, t)
+	CheckReg(reg_ra, 0x8000001c, r, t)
+}
+
+func TestAssembleLoadImmediate(t *testing.T) {
+	values := []int64{0, 1, -1, 2047, -2048, 2048, -2049, 0x800, 0x10000000, 0x7ffff800, 0x7fffffff,
+		-0x80000000, 0x12345678, -0x12345678, 0xfff}
+	for _, xlen := range []int{XLEN_32, XLEN_64} {
+		wide := values
+		if xlen == XLEN_64 {
+			wide = append(wide, 0x80000000, 0xffffffff, 0x100000000, 0x123456789abcdef0, -0x123456789abcdef0,
+				0x7fffffffffffffff, -0x8000000000000000, -0x7ffffffffffff800, -0xffffffffff001)
+		}
+		for _, value := range wide {
+			mem := NewMemory(0x100)
+			p, err := Assemble(fmt.Sprintf("li a0, %d\nj .\n", value), xlen, 0)
+			if err != nil {
+				t.Fatalf("li %d: Assemble failed with error %v", value, err)
+			}
+			r := NewRegisters(xlen)
+			d := NewDecoder()
+			d.RegisterBaseInstructionSet()
+			if xlen == XLEN_64 {
+				d.RegisterRV64InstructionSet()
+			}
+			e := NewEmulator(&mem, r, d)
+			if err := p.Load(&mem, r); err != nil {
+				t.Fatalf("Load failed with error %v", err)
+			}
+			if _, err := e.Run(100); err != nil {
+				t.Fatalf("li %d: run failed with error %v", value, err)
+			}
+			expected := uint64(value)
+			if xlen == XLEN_32 {
+				expected = uint64(uint32(value))
+			}
+			if r.Reg(reg_a0) != expected {
+				t.Errorf("li %#x on RV%d loaded %#x", value, xlen, r.Reg(reg_a0))
+			}
+		}
+	}
+
+	// the sequences of llvm-mc
+	instrs, _ := AssembleInstructions("li a0, 0x12345678", XLEN_32)
+	Assert(t, len(instrs), 2)
+	Assert(t, instrs[0].(UInstr), CreateLui(0x12345, reg_a0))
+	Assert(t, instrs[1].(IInstr), CreateADDI(reg_a0, reg_a0, 0x678))
+	instrs, _ = AssembleInstructions("li a0, 0x7ffff800", XLEN_64)
+	Assert(t, len(instrs), 2)
+	Assert(t, instrs[1].(IInstr), CreateADDIW(reg_a0, reg_a0, sext(0x800, 11)))
+}
+
+func TestAssembleDirectives(t *testing.T) {
+	p, err := Assemble(`
+	.section .rodata
+bytes:	.byte 1, 0xff, -1, 'a', '\n'
+	.balign 4
+	.half 0x1234, -2
+	.word end - bytes, . - bytes
+	.asciz "hi\t#"  # the # in the string is no comment
+	.ascii "ok"
+	.zero 3
+	.align 3
+	.dword 0x0102030405060708
+end:
+	.text
+	nop
+`, XLEN_64, 0x1000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+	Assert(t, len(p.Sections), 2)
+	rodata := p.Sections[0]
+	Assert(t, rodata.Name, ".rodata")
+	Assert(t, rodata.Addr, uint64(0x1000))
+	expected := []byte{1, 0xff, 0xff, 'a', '\n', 0, 0, 0, 0x34, 0x12, 0xfe, 0xff, 0x28, 0, 0, 0, 0x10, 0, 0, 0,
+		'h', 'i', '\t', '#', 0, 'o', 'k', 0, 0, 0, 0, 0, 8, 7, 6, 5, 4, 3, 2, 1}
+	if !bytes.Equal(rodata.Data, expected) {
+		t.Fatalf("rodata is %v, expected %v", rodata.Data, expected)
+	}
+	text := p.Sections[1]
+	Assert(t, text.Addr, uint64(0x1028))
+	Assert(t, p.Entry, uint64(0x1000))
+	Assert(t, len(p.Binary()), 0x2c)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		source string
+		xlen   int
+		err    string
+	}{
+		{"foo a0, a1", XLEN_32, "line 1: foo a0, a1: unknown instruction foo"},
+		{"nop\naddi a0, a1, 2048", XLEN_32, "line 2: addi a0, a1, 2048: immediate 2048 (2048) doesn't fit in 12 bits"},
+		{"add a0, a1", XLEN_32, "expects 3 operands, got 2"},
+		{"add a0, a1, x32", XLEN_32, "invalid register x32"},
+		{"addw a0, a1, a2", XLEN_32, "instruction is only supported on RV64"},
+		{"j missing", XLEN_32, "symbol missing is not defined"},
+		{"beq a0, a1, far\n.zero 4096\nfar: nop", XLEN_32, "target far is out of range"},
+		{"j 1b", XLEN_32, "numeric label 1 is not defined"},
+		{"a: nop\na: nop", XLEN_32, "symbol a is already defined"},
+		{"a: li a0, a", XLEN_32, "a is not a constant"},
+		{"li a0, 0x100000000", XLEN_32, "doesn't fit in 32 bits"},
+		{"sw a0, 4(a1", XLEN_32, "invalid memory operand"},
+		{"c.lw a0, 2(a1)", XLEN_32, "can't be encoded in the compressed instruction"},
+		{"c.lw a0, 4(sp)", XLEN_32, "register sp can't be used in a compressed instruction"},
+		{"c.ld a0, 8(a1)", XLEN_32, "c.ld is only supported on RV64"},
+		{".byte 256", XLEN_32, "value 256 doesn't fit in 1 bytes"},
+		{".frobnicate", XLEN_32, "unknown directive .frobnicate"},
+		{"fadd.s fa0, fa1, fa2, up", XLEN_32, "invalid rounding mode up"},
+		{"addi a0, a1, (1 + 2", XLEN_32, "missing ) in expression"},
+	}
+	for _, test := range tests {
+		_, err := Assemble(test.source, test.xlen, 0)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: expected error %q, got %v", test.source, test.err, err)
+		}
+	}
+	if _, err := Assemble("nop", 16, 0); err == nil {
+		t.Errorf("Assemble accepted XLEN=16")
+	}
+}
+
+func TestAssembleHelloWorld(t *testing.T) {
+	source, err := os.ReadFile("../generate_example_elf/hello_world.s")
+	if err != nil {
+		t.Fatalf("can't read hello_world.s with error %v", err)
+	}
+	p, err := Assemble(string(source), XLEN_32, 0x80000000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+
+	// the same as the elf file of the llvm toolchain
+	f, err := elf.Open("../elf_files/hello.elf")
+	if err != nil {
+		t.Fatalf("can't open hello.elf with error %v", err)
+	}
+	defer f.Close()
+	text, err := f.Section(".text").Data()
+	if err != nil {
+		t.Fatalf("can't read .text with error %v", err)
+	}
+	if !bytes.Equal(p.Binary(), text) {
+		t.Fatalf("assembled %x, expected %x", p.Binary(), text)
+	}
+	Assert(t, p.Entry, f.Entry)
+}
+
+func TestProgramWriteElf(t *testing.T) {
+	for _, xlen := range []int{XLEN_32, XLEN_64} {
+		p, err := Assemble(`
+	.text
+	.globl _start
+	c.nop
+_start:
+	la t0, message
+	lbu a0, 1(t0)
+	la t1, buffer
+	sb a0, 0(t1)
+	j .
+	.section .rodata
+message: .string "hi"
+	.bss
+buffer: .zero 8
+`, xlen, 0x80000000)
+		if err != nil {
+			t.Fatalf("Assemble failed with error %v", err)
+		}
+		var out bytes.Buffer
+		if err := p.WriteElf(&out); err != nil {
+			t.Fatalf("WriteElf failed with error %v", err)
+		}
+		f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+		if err != nil {
+			t.Fatalf("can't parse the elf file with error %v", err)
+		}
+		elfXlen, err := ElfXlen(f)
+		if err != nil {
+			t.Fatalf("ElfXlen failed with error %v", err)
+		}
+		Assert(t, elfXlen, xlen)
+		Assert(t, f.Entry, uint64(0x80000002))
+		// e_flags is after the entry, program and section header offsets
+		flagsOffset := 24 + 3*xlen/8
+		Assert(t, binary.LittleEndian.Uint32(out.Bytes()[flagsOffset:]), EF_RISCV_RVC)
+		Assert(t, f.Section(".bss").Type, elf.SHT_NOBITS)
+		buffer, err := ElfSymbol(f, "buffer")
+		if err != nil {
+			t.Fatalf("ElfSymbol failed with error %v", err)
+		}
+		Assert(t, buffer, p.Symbols["buffer"])
+		symbols, _ := f.Symbols()
+		// the local symbols come first
+		Assert(t, symbols[len(symbols)-1].Name, "_start")
+
+		mem := NewMemoryWithOffset(0x100, 0x80000000)
+		r := NewRegisters(xlen)
+		d := NewDecoder()
+		d.RegisterBaseInstructionSet()
+		d.RegisterCompressedInstructionSet()
+		if xlen == XLEN_64 {
+			d.RegisterRV64InstructionSet()
+		}
+		e := NewEmulator(&mem, r, d)
+		if err := LoadElf(f, &mem, r); err != nil {
+			t.Fatalf("LoadElf failed with error %v", err)
+		}
+		if _, err := e.Run(100); err != nil {
+			t.Fatalf("run failed with error %v", err)
+		}
+		stored, _ := mem.Load(buffer, 1)
+		Assert(t, stored, uint64('i'))
+	}
+}
@@ -0,0 +1,304 @@
+package riscv
+
+import (
+	"fmt"
+)
+
+const AMO int8 = 47 // 0101111
+
+// The AMO instructions use the R-type encoding, with funct5 in the upper 5
+// bits of func7 and the aq/rl ordering bits in the lower 2. The emulator
+// executes one instruction at a time, so every access is ordered and the
+// aq/rl bits are ignored.
+const (
+	FUNCT5_LR      uint32 = 2  // 00010
+	FUNCT5_SC      uint32 = 3  // 00011
+	FUNCT5_AMOSWAP uint32 = 1  // 00001
+	FUNCT5_AMOADD  uint32 = 0  // 00000
+	FUNCT5_AMOXOR  uint32 = 4  // 00100
+	FUNCT5_AMOAND  uint32 = 12 // 01100
+	FUNCT5_AMOOR   uint32 = 8  // 01000
+	FUNCT5_AMOMIN  uint32 = 16 // 10000
+	FUNCT5_AMOMAX  uint32 = 20 // 10100
+	FUNCT5_AMOMINU uint32 = 24 // 11000
+	FUNCT5_AMOMAXU uint32 = 28 // 11100
+)
+
+// The width of the atomic instruction, double words are only supported on
+// RV64.
+const (
+	FUNC3_AMO_W int8 = 2
+	FUNC3_AMO_D int8 = 3
+)
+
+// Reservation is the reservation set of a hart, registered by LR and
+// checked by SC. The reservation set is the aligned word (or double word)
+// of the LR.
+type Reservation struct {
+	valid bool
+	addr  uint64
+}
+
+func (r *Reservation) Set(addr uint64) {
+	r.valid = true
+	r.addr = addr
+}
+
+func (r *Reservation) Clear() {
+	r.valid = false
+}
+
+// Holds returns if there is a valid reservation on addr.
+func (r *Reservation) Holds(addr uint64) bool {
+	return r.valid && r.addr == addr
+}
+
+// amoOperation returns the binary operator of the AMO, which computes the
+// value it stores from the loaded value and rs2. The operands are width
+// (XLEN_32 or XLEN_64) bit values.
+func amoOperation(funct5 uint32, width int) (func(loaded uint64, rs2 uint64) uint64, error) {
+	switch funct5 {
+	case FUNCT5_AMOSWAP:
+		return func(loaded uint64, rs2 uint64) uint64 { return rs2 }, nil
+	case FUNCT5_AMOADD:
+		return func(loaded uint64, rs2 uint64) uint64 { return loaded + rs2 }, nil
+	case FUNCT5_AMOXOR:
+		return func(loaded uint64, rs2 uint64) uint64 { return loaded ^ rs2 }, nil
+	case FUNCT5_AMOAND:
+		return func(loaded uint64, rs2 uint64) uint64 { return loaded & rs2 }, nil
+	case FUNCT5_AMOOR:
+		return func(loaded uint64, rs2 uint64) uint64 { return loaded | rs2 }, nil
+	case FUNCT5_AMOMIN:
+		return func(loaded uint64, rs2 uint64) uint64 {
+			if asSigned(loaded, width) < asSigned(rs2, width) {
+				return loaded
+			}
+			return rs2
+		}, nil
+	case FUNCT5_AMOMAX:
+		return func(loaded uint64, rs2 uint64) uint64 {
+			if asSigned(loaded, width) > asSigned(rs2, width) {
+				return loaded
+			}
+			return rs2
+		}, nil
+	case FUNCT5_AMOMINU:
+		return func(loaded uint64, rs2 uint64) uint64 {
+			if loaded < rs2 {
+				return loaded
+			}
+			return rs2
+		}, nil
+	case FUNCT5_AMOMAXU:
+		return func(loaded uint64, rs2 uint64) uint64 {
+			if loaded > rs2 {
+				return loaded
+			}
+			return rs2
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid funct5(val=%v) on AMO instruction", funct5)
+}
+
+func (Inst RInstr) executeAtomic(mem Memory, regs Registers) error {
+	// the .W instructions work on the lower 32 bits of rs2 and sign extend
+	// the loaded word on RV64
+	var size uint32
+	var width int
+	if Inst.func3 == FUNC3_AMO_W {
+		size, width = 4, XLEN_32
+	} else if Inst.func3 == FUNC3_AMO_D && regs.Xlen() == XLEN_64 {
+		size, width = 8, XLEN_64
+	} else {
+		return unknownFunc3Error(Inst.func3, Inst.opcode, RInstrType)
+	}
+
+	funct5 := bitSliceBetween(uint32(Inst.func7), 2, 6)
+	addr := regs.Reg(Inst.rs1)
+	rs2 := regs.Reg(Inst.rs2) & xlenMask(width)
+	reservation := regs.Reservation()
+	misaligned := addr%uint64(size) != 0
+
+	switch funct5 {
+	case FUNCT5_LR:
+		// LR.W loads a word from the address in rs1, places the sign-extended value in rd, and registers a
+		// reservation set—a set of bytes that subsumes the bytes in the addressed word.
+		if Inst.rs2 != reg_zero {
+			return fmt.Errorf("invalid LR instruction, rs2 should be zero but is %d", Inst.rs2)
+		}
+		if misaligned {
+			return Exception{Cause: EXC_LOAD_MISALIGNED, Tval: addr}
+		}
+		paddr, err := Translate(mem, regs, addr, AccessLoad)
+		if err != nil {
+			return err
+		}
+		value, err := mem.Load(paddr, size)
+		if err != nil {
+			return Exception{Cause: EXC_LOAD_ACCESS_FAULT, Tval: addr, Err: err}
+		}
+		// the reservation is on physical memory
+		reservation.Set(paddr)
+		regs.SetReg(Inst.rd, sextAmo(value, width))
+	case FUNCT5_SC:
+		// SC.W conditionally writes a word in rs2 to the address in rs1: the SC.W succeeds only if the
+		// reservation is still valid and the reservation set contains the bytes being written. If the SC.W
+		// succeeds, the instruction writes the word in rs2 to memory, and it writes zero to rd. If the SC.W
+		// fails, the instruction does not write to memory, and it writes a nonzero value to rd. Regardless
+		// of success or failure, executing an SC.W instruction invalidates any reservation held by this hart.
+		if misaligned {
+			return Exception{Cause: EXC_STORE_MISALIGNED, Tval: addr}
+		}
+		paddr, err := Translate(mem, regs, addr, AccessStore)
+		if err != nil {
+			return err
+		}
+		if !reservation.Holds(paddr) {
+			reservation.Clear()
+			regs.SetReg(Inst.rd, 1)
+			break
+		}
+		reservation.Clear()
+		err = mem.Store(paddr, rs2, size)
+		if err != nil {
+			return Exception{Cause: EXC_STORE_ACCESS_FAULT, Tval: addr, Err: err}
+		}
+		regs.SetReg(Inst.rd, 0)
+	default:
+		// The AMOs atomically load a data value from the address in rs1, place the value into register rd,
+		// apply a binary operator to the loaded value and the original value in rs2, then store the result
+		// back to the address in rs1.
+		// a reserved funct5 is an illegal instruction, the address isn't
+		// accessed
+		operation, err := amoOperation(funct5, width)
+		if err != nil {
+			return err
+		}
+		if misaligned {
+			return Exception{Cause: EXC_STORE_MISALIGNED, Tval: addr}
+		}
+		paddr, err := Translate(mem, regs, addr, AccessStore)
+		if err != nil {
+			return err
+		}
+		loaded, err := mem.Load(paddr, size)
+		if err != nil {
+			return Exception{Cause: EXC_STORE_ACCESS_FAULT, Tval: addr, Err: err}
+		}
+		err = mem.Store(paddr, operation(loaded, rs2), size)
+		if err != nil {
+			return Exception{Cause: EXC_STORE_ACCESS_FAULT, Tval: addr, Err: err}
+		}
+		regs.SetReg(Inst.rd, sextAmo(loaded, width))
+	}
+
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+// sextAmo sign extends the loaded value of a .W instruction.
+func sextAmo(value uint64, width int) uint64 {
+	if width == XLEN_32 {
+		return sextWord(value)
+	}
+	return value
+}
+
+func createAMO(rd int, rs1 int, rs2 int, funct5 uint32) RInstr {
+	return RInstr{rd: rd, rs1: rs1, rs2: rs2, func3: FUNC3_AMO_W, func7: int8(funct5 << 2), opcode: AMO}
+}
+
+// createAMOD returns the double word variant of the atomic instruction.
+func createAMOD(rd int, rs1 int, rs2 int, funct5 uint32) RInstr {
+	instr := createAMO(rd, rs1, rs2, funct5)
+	instr.func3 = FUNC3_AMO_D
+	return instr
+}
+
+func CreateLRW(rd int, rs1 int) RInstr {
+	return createAMO(rd, rs1, reg_zero, FUNCT5_LR)
+}
+
+func CreateSCW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_SC)
+}
+
+func CreateAMOSWAPW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOSWAP)
+}
+
+func CreateAMOADDW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOADD)
+}
+
+func CreateAMOXORW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOXOR)
+}
+
+func CreateAMOANDW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOAND)
+}
+
+func CreateAMOORW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOOR)
+}
+
+func CreateAMOMINW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOMIN)
+}
+
+func CreateAMOMAXW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOMAX)
+}
+
+func CreateAMOMINUW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOMINU)
+}
+
+func CreateAMOMAXUW(rd int, rs1 int, rs2 int) RInstr {
+	return createAMO(rd, rs1, rs2, FUNCT5_AMOMAXU)
+}
+
+func CreateLRD(rd int, rs1 int) RInstr {
+	return createAMOD(rd, rs1, reg_zero, FUNCT5_LR)
+}
+
+func CreateSCD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_SC)
+}
+
+func CreateAMOSWAPD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOSWAP)
+}
+
+func CreateAMOADDD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOADD)
+}
+
+func CreateAMOXORD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOXOR)
+}
+
+func CreateAMOANDD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOAND)
+}
+
+func CreateAMOORD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOOR)
+}
+
+func CreateAMOMIND(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOMIN)
+}
+
+func CreateAMOMAXD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOMAX)
+}
+
+func CreateAMOMINUD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOMINU)
+}
+
+func CreateAMOMAXUD(rd int, rs1 int, rs2 int) RInstr {
+	return createAMOD(rd, rs1, rs2, FUNCT5_AMOMAXU)
+}
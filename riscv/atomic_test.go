@@ -0,0 +1,174 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+func CheckMem(addr uint64, expected uint64, mem Memory, t *testing.T) {
+	value, err := mem.Load(addr, 4)
+	if err != nil {
+		t.Logf("mem[%#x] can't be loaded, error=%v", addr, err)
+		t.Fail()
+	} else if value != expected {
+		t.Logf("mem[%#x]==%d and should be %d", addr, value, expected)
+		t.Fail()
+	}
+}
+
+func TestLRSC(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	mem.Store(8, 5, 4)
+	r.reg[reg_a1] = 8
+	r.reg[reg_a2] = 7
+
+	err := CreateLRW(reg_a0, reg_a1).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("lr.w failed with error %v", err)
+	}
+	CheckReg(reg_a0, 5, &r, t)
+	CheckPc(4, &r, t)
+
+	// the reservation is valid, so the store succeeds
+	err = CreateSCW(reg_a3, reg_a1, reg_a2).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("sc.w failed with error %v", err)
+	}
+	CheckReg(reg_a3, 0, &r, t)
+	CheckMem(8, 7, &mem, t)
+	CheckPc(8, &r, t)
+
+	// the reservation was consumed by the first sc.w
+	r.reg[reg_a2] = 9
+	CreateSCW(reg_a3, reg_a1, reg_a2).Execute(&mem, &r)
+	CheckReg(reg_a3, 1, &r, t)
+	CheckMem(8, 7, &mem, t)
+}
+
+func TestSCOtherAddress(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	r.reg[reg_a1] = 4
+	r.reg[reg_a2] = 7
+
+	CreateLRW(reg_a0, reg_a1).Execute(&mem, &r)
+	r.reg[reg_a1] = 8
+	CreateSCW(reg_a3, reg_a1, reg_a2).Execute(&mem, &r)
+	CheckReg(reg_a3, 1, &r, t)
+	CheckMem(8, 0, &mem, t)
+
+	// a failed sc.w also invalidates the reservation
+	r.reg[reg_a1] = 4
+	CreateSCW(reg_a3, reg_a1, reg_a2).Execute(&mem, &r)
+	CheckReg(reg_a3, 1, &r, t)
+}
+
+func TestAMO(t *testing.T) {
+	tests := []struct {
+		name     string
+		instr    Instruction
+		mem      uint32
+		rs2      uint32
+		expected uint32
+	}{
+		{"amoswap.w", CreateAMOSWAPW(reg_a0, reg_a1, reg_a2), 5, 7, 7},
+		{"amoadd.w", CreateAMOADDW(reg_a0, reg_a1, reg_a2), 5, 7, 12},
+		{"amoxor.w", CreateAMOXORW(reg_a0, reg_a1, reg_a2), 5, 3, 6},
+		{"amoand.w", CreateAMOANDW(reg_a0, reg_a1, reg_a2), 5, 3, 1},
+		{"amoor.w", CreateAMOORW(reg_a0, reg_a1, reg_a2), 5, 3, 7},
+		{"amomin.w", CreateAMOMINW(reg_a0, reg_a1, reg_a2), 5, ReinterpreteAsUnsigned(-1), ReinterpreteAsUnsigned(-1)},
+		{"amomax.w", CreateAMOMAXW(reg_a0, reg_a1, reg_a2), 5, ReinterpreteAsUnsigned(-1), 5},
+		{"amominu.w", CreateAMOMINUW(reg_a0, reg_a1, reg_a2), 5, ReinterpreteAsUnsigned(-1), 5},
+		{"amomaxu.w", CreateAMOMAXUW(reg_a0, reg_a1, reg_a2), 5, ReinterpreteAsUnsigned(-1), ReinterpreteAsUnsigned(-1)},
+	}
+
+	for _, test := range tests {
+		r := RegistersImpl{}
+		mem := NewMemory(16)
+		mem.Store(4, uint64(test.mem), 4)
+		r.reg[reg_a1] = 4
+		r.reg[reg_a2] = uint64(test.rs2)
+
+		err := test.instr.Execute(&mem, &r)
+		if err != nil {
+			t.Errorf("%s failed with error %v", test.name, err)
+			continue
+		}
+		// rd gets the old value, memory the result of the operation
+		CheckReg(reg_a0, uint64(test.mem), &r, t)
+		CheckMem(4, uint64(test.expected), &mem, t)
+		CheckPc(4, &r, t)
+	}
+}
+
+func TestAMOMisaligned(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	r.reg[reg_a1] = 2
+
+	err := CreateAMOADDW(reg_a0, reg_a1, reg_a2).Execute(&mem, &r)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != EXC_STORE_MISALIGNED {
+		t.Fatalf("misaligned amoadd.w should raise a store misaligned exception but got %v", err)
+	}
+	Assert(t, exc.Tval, uint64(2))
+
+	err = CreateLRW(reg_a0, reg_a1).Execute(&mem, &r)
+	if !errors.As(err, &exc) || exc.Cause != EXC_LOAD_MISALIGNED {
+		t.Fatalf("misaligned lr.w should raise a load misaligned exception but got %v", err)
+	}
+	CheckPc(0, &r, t)
+}
+
+func TestAMOAccessFault(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	r.reg[reg_a1] = 32
+
+	err := CreateAMOSWAPW(reg_a0, reg_a1, reg_a2).Execute(&mem, &r)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != EXC_STORE_ACCESS_FAULT {
+		t.Fatalf("amoswap.w out of memory should raise a store access fault but got %v", err)
+	}
+}
+
+// A reserved funct5 is an illegal instruction whatever the address is.
+func TestAMOReservedFunct5(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	for _, addr := range []uint64{2, 32} {
+		r.reg[reg_a1] = addr
+		err := createAMO(reg_a0, reg_a1, reg_a2, 5).Execute(&mem, &r)
+		var exc Exception
+		if err == nil || errors.As(err, &exc) {
+			t.Fatalf("amo with funct5=5 at %#x should be illegal but got %v", addr, err)
+		}
+	}
+	CheckPc(0, &r, t)
+}
+
+func TestDecodeAMO(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+
+	tests := []struct {
+		word     uint32
+		expected Instruction
+	}{
+		{0x00c5a52f, CreateAMOADDW(reg_a0, reg_a1, reg_a2)}, // amoadd.w a0, a2, (a1)
+		{0x1005a52f, CreateLRW(reg_a0, reg_a1)},             // lr.w a0, (a1)
+		{0x18c5a52f, CreateSCW(reg_a0, reg_a1, reg_a2)},     // sc.w a0, a2, (a1)
+	}
+
+	for _, test := range tests {
+		res, err := d.Decode(test.word)
+		if err != nil {
+			t.Errorf("decoding %#08x failed with error %v", test.word, err)
+			continue
+		}
+		if res != test.expected {
+			t.Errorf("decoding %#08x\nres=     %s\nexpected=%s", test.word, res.String(), test.expected.String())
+		}
+	}
+}
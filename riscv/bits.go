@@ -41,3 +41,15 @@ func sext(word uint32, signbitLocation uint32) uint32 {
 
 	return word | mask
 }
+
+// sext64 sign extends the value with the sign bit at signbitLocation to
+// 64 bits.
+func sext64(word uint32, signbitLocation uint32) uint64 {
+	return uint64(int64(int32(sext(word, signbitLocation))))
+}
+
+// sextWord sign extends the lower 32 bits, it is the result of the RV64
+// instructions that work on words (ADDW, LW, ...).
+func sextWord(value uint64) uint64 {
+	return uint64(int64(int32(value)))
+}
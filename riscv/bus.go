@@ -0,0 +1,140 @@
+package riscv
+
+import (
+	"fmt"
+)
+
+// Device is anything that can be mapped on the bus. The addresses passed
+// to a device are relative to the begin address it is mapped at, so the
+// same device can be mapped anywhere.
+//
+// MemoryImpl (without offset) implements Device and is used as RAM.
+type Device interface {
+	Store(addr uint64, data uint64, numBytes uint32) error
+	Load(addr uint64, numBytes uint32) (uint64, error)
+}
+
+type UnmappedAddressError struct {
+	Addr     uint64
+	NumBytes uint32
+}
+
+func (e UnmappedAddressError) Error() string {
+	return fmt.Sprintf("no device mapped at addr=%#x (numBytes=%d)", e.Addr, e.NumBytes)
+}
+
+type ReadOnlyError struct {
+	Addr uint64
+}
+
+func (e ReadOnlyError) Error() string {
+	return fmt.Sprintf("store to read only memory at addr=%#x", e.Addr)
+}
+
+// ROM is memory that can't be changed by the program, the content is
+// set when it is created.
+type ROM struct {
+	mem MemoryImpl
+}
+
+func NewROM(data []byte) *ROM {
+	mem := NewMemory(len(data))
+	copy(mem.data, data)
+	return &ROM{mem}
+}
+
+func (rom *ROM) Store(addr uint64, data uint64, numBytes uint32) error {
+	return ReadOnlyError{addr}
+}
+
+func (rom *ROM) Load(addr uint64, numBytes uint32) (uint64, error) {
+	return rom.mem.Load(addr, numBytes)
+}
+
+type busMapping struct {
+	name  string
+	begin uint64
+	size  uint64
+	dev   Device
+}
+
+func (m busMapping) end() uint64 {
+	return m.begin + m.size
+}
+
+func (m busMapping) contains(addr uint64, numBytes uint32) bool {
+	return addr >= m.begin && addr-m.begin < m.size && uint64(numBytes) <= m.size-(addr-m.begin)
+}
+
+// Bus routes the memory accesses to the device mapped at the address.
+type Bus struct {
+	mappings []busMapping
+}
+
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Map makes the device available in the address range [begin, begin+size).
+func (b *Bus) Map(name string, begin uint64, size uint64, dev Device) error {
+	if size == 0 {
+		return fmt.Errorf("can't map %s with size zero", name)
+	}
+	m := busMapping{name: name, begin: begin, size: size, dev: dev}
+	if m.end() < m.begin {
+		return fmt.Errorf("can't map %s at addr=%#x with size=%#x, it does not fit in the address space", name, begin, size)
+	}
+	for _, other := range b.mappings {
+		if m.begin < other.end() && other.begin < m.end() {
+			return fmt.Errorf("can't map %s at [%#x, %#x), it overlaps with %s at [%#x, %#x)",
+				name, m.begin, m.end(), other.name, other.begin, other.end())
+		}
+	}
+	b.mappings = append(b.mappings, m)
+
+	return nil
+}
+
+func (b *Bus) find(addr uint64, numBytes uint32) (busMapping, error) {
+	for _, m := range b.mappings {
+		if m.contains(addr, numBytes) {
+			return m, nil
+		}
+	}
+	return busMapping{}, UnmappedAddressError{addr, numBytes}
+}
+
+func (b *Bus) StoreByte(addr uint64, data uint64) error {
+	return b.Store(addr, data, 1)
+}
+
+func (b *Bus) Store(addr uint64, data uint64, numBytes uint32) error {
+	m, err := b.find(addr, numBytes)
+	if err != nil {
+		return err
+	}
+	return m.dev.Store(addr-m.begin, data, numBytes)
+}
+
+func (b *Bus) LoadByte(addr uint64) (uint64, error) {
+	return b.Load(addr, 1)
+}
+
+func (b *Bus) Load(addr uint64, numBytes uint32) (uint64, error) {
+	m, err := b.find(addr, numBytes)
+	if err != nil {
+		return 0, err
+	}
+	return m.dev.Load(addr-m.begin, numBytes)
+}
+
+// Len returns the end address of the highest mapped device.
+func (b *Bus) Len() int {
+	end := uint64(0)
+	for _, m := range b.mappings {
+		if m.end() > end {
+			end = m.end()
+		}
+	}
+	return int(end)
+}
@@ -0,0 +1,107 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+// recordingDevice remembers the last access.
+type recordingDevice struct {
+	addr     uint64
+	data     uint64
+	numBytes uint32
+}
+
+func (d *recordingDevice) Store(addr uint64, data uint64, numBytes uint32) error {
+	d.addr = addr
+	d.data = data
+	d.numBytes = numBytes
+	return nil
+}
+
+func (d *recordingDevice) Load(addr uint64, numBytes uint32) (uint64, error) {
+	d.addr = addr
+	d.numBytes = numBytes
+	return d.data, nil
+}
+
+func TestBusRoutesToDevice(t *testing.T) {
+	bus := NewBus()
+	ram := NewMemory(16)
+	dev := &recordingDevice{}
+	if err := bus.Map("ram", 0x80000000, 16, &ram); err != nil {
+		t.Fatalf("map ram failed with error %v", err)
+	}
+	if err := bus.Map("dev", 0x10000000, 8, dev); err != nil {
+		t.Fatalf("map dev failed with error %v", err)
+	}
+
+	bus.Store(0x80000004, 0x12345678, 4)
+	word, err := bus.Load(0x80000004, 4)
+	if err != nil {
+		t.Fatalf("load from ram failed with error %v", err)
+	}
+	Assert(t, word, uint64(0x12345678))
+	Assert(t, uint32(ram.data[4]), uint32(0x78))
+
+	// the device only sees the offset from where it is mapped
+	bus.StoreByte(0x10000005, 'h')
+	Assert(t, dev.addr, uint64(5))
+	Assert(t, dev.data, uint64('h'))
+	Assert(t, dev.numBytes, uint32(1))
+
+	Assert(t, bus.Len(), 0x80000010)
+}
+
+func TestBusUnmapped(t *testing.T) {
+	bus := NewBus()
+	ram := NewMemory(16)
+	bus.Map("ram", 0x1000, 16, &ram)
+
+	_, err := bus.Load(0x2000, 4)
+	var unmapped UnmappedAddressError
+	if !errors.As(err, &unmapped) {
+		t.Fatalf("load from unmapped address should fail with UnmappedAddressError but got %v", err)
+	}
+	Assert(t, unmapped.Addr, uint64(0x2000))
+
+	// an access crossing the end of a device is not allowed either
+	err = bus.Store(0x100e, 0, 4)
+	if !errors.As(err, &unmapped) {
+		t.Fatalf("store crossing the end of ram should fail with UnmappedAddressError but got %v", err)
+	}
+}
+
+func TestBusOverlap(t *testing.T) {
+	bus := NewBus()
+	ram := NewMemory(16)
+	if err := bus.Map("ram", 0x1000, 16, &ram); err != nil {
+		t.Fatalf("map ram failed with error %v", err)
+	}
+	if err := bus.Map("dev", 0x100f, 1, &recordingDevice{}); err == nil {
+		t.Fatalf("mapping overlapping devices should fail")
+	}
+	if err := bus.Map("dev", 0xfffffffffffffff0, 32, &recordingDevice{}); err == nil {
+		t.Fatalf("mapping outside of the address space should fail")
+	}
+	if err := bus.Map("dev", 0x1010, 1, &recordingDevice{}); err != nil {
+		t.Fatalf("mapping right after ram should work but failed with %v", err)
+	}
+}
+
+func TestROM(t *testing.T) {
+	bus := NewBus()
+	bus.Map("rom", 0x0, 4, NewROM([]byte{1, 2, 3, 4}))
+
+	word, err := bus.Load(0, 4)
+	if err != nil {
+		t.Fatalf("load from rom failed with error %v", err)
+	}
+	Assert(t, word, uint64(0x04030201))
+
+	err = bus.StoreByte(0, 5)
+	var readOnly ReadOnlyError
+	if !errors.As(err, &readOnly) {
+		t.Fatalf("store to rom should fail with ReadOnlyError but got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package riscv
+
+import (
+	"fmt"
+	"time"
+)
+
+// Register offsets of the CLINT (core-local interruptor), the layout of the
+// SiFive CLINT used by the qemu `virt` machine. There is only one hart, so
+// only the registers of hart 0 exist.
+const (
+	CLINT_MSIP     uint64 = 0x0
+	CLINT_MTIMECMP uint64 = 0x4000
+	CLINT_MTIME    uint64 = 0xbff8
+
+	// Number of bytes the CLINT occupies on the bus.
+	CLINT_SIZE uint64 = 0x10000
+)
+
+// Timebase is the clock mtime counts.
+type Timebase interface {
+	// Tick is called once before every instruction.
+	Tick()
+	// Time returns the number of clock ticks since the timebase started.
+	Time() uint64
+}
+
+// InstructionTimebase counts the executed instructions, so runs are
+// deterministic.
+type InstructionTimebase struct {
+	instructions uint64
+}
+
+func NewInstructionTimebase() *InstructionTimebase {
+	return &InstructionTimebase{}
+}
+
+func (tb *InstructionTimebase) Tick() {
+	tb.instructions++
+}
+
+func (tb *InstructionTimebase) Time() uint64 {
+	return tb.instructions
+}
+
+// HostTimebase runs at frequency Hz of the wall clock of the host, no
+// matter how fast the instructions are executed.
+type HostTimebase struct {
+	frequency uint64
+	start     time.Time
+}
+
+func NewHostTimebase(frequency uint64) *HostTimebase {
+	return &HostTimebase{frequency: frequency, start: time.Now()}
+}
+
+func (tb *HostTimebase) Tick() {}
+
+func (tb *HostTimebase) Time() uint64 {
+	elapsed := time.Since(tb.start)
+	// split in seconds and nanoseconds so it does not overflow
+	seconds := uint64(elapsed / time.Second)
+	nanos := uint64(elapsed % time.Second)
+	return seconds*tb.frequency + nanos*tb.frequency/uint64(time.Second)
+}
+
+// CLINT raises the machine software interrupt while msip is set and the
+// machine timer interrupt while mtime >= mtimecmp.
+type CLINT struct {
+	timebase Timebase
+	// mtime is the time of the timebase plus offset, so writing mtime does
+	// not change the timebase
+	offset   uint64
+	msip     bool
+	mtimecmp uint64
+
+	mtip     bool
+	software func(level bool)
+	timer    func(level bool)
+}
+
+// NewCLINT creates a CLINT with mtime counting the timebase. mtimecmp starts
+// at its maximum, so there is no timer interrupt until it is written.
+func NewCLINT(timebase Timebase) *CLINT {
+	return &CLINT{timebase: timebase, mtimecmp: ^uint64(0)}
+}
+
+// SetInterruptHandlers registers the functions called every time the level
+// of the software and timer interrupt outputs change, they are usually
+// connected to the MSIP and MTIP interrupt lines of the hart.
+func (c *CLINT) SetInterruptHandlers(software func(level bool), timer func(level bool)) {
+	c.software = software
+	c.timer = timer
+	if software != nil {
+		software(c.msip)
+	}
+	if timer != nil {
+		timer(c.mtip)
+	}
+}
+
+// Time returns the current value of mtime.
+func (c *CLINT) Time() uint64 {
+	return c.timebase.Time() + c.offset
+}
+
+// Tick advances the timebase, the emulator calls it before every
+// instruction.
+func (c *CLINT) Tick() {
+	c.timebase.Tick()
+	c.updateTimer()
+}
+
+func (c *CLINT) updateTimer() {
+	level := c.Time() >= c.mtimecmp
+	if level != c.mtip {
+		c.mtip = level
+		if c.timer != nil {
+			c.timer(level)
+		}
+	}
+}
+
+// register returns the 64 bit register the access is in and the offset of
+// the access in it. The 64 bit registers can be accessed in 32 bit halves.
+func (c *CLINT) register(addr uint64, numBytes uint32) (uint64, uint64, error) {
+	if numBytes != 4 && numBytes != 8 {
+		return 0, 0, fmt.Errorf("clint registers can only be accessed with 4 or 8 bytes, not %d", numBytes)
+	}
+	if addr%uint64(numBytes) != 0 {
+		return 0, 0, fmt.Errorf("misaligned access to clint register at offset=%#x", addr)
+	}
+	switch {
+	case addr == CLINT_MSIP && numBytes == 4:
+		return CLINT_MSIP, 0, nil
+	case addr >= CLINT_MTIMECMP && addr < CLINT_MTIMECMP+8:
+		return CLINT_MTIMECMP, addr - CLINT_MTIMECMP, nil
+	case addr >= CLINT_MTIME && addr < CLINT_MTIME+8:
+		return CLINT_MTIME, addr - CLINT_MTIME, nil
+	}
+	return 0, 0, fmt.Errorf("no clint register at offset=%#x", addr)
+}
+
+func (c *CLINT) Store(addr uint64, data uint64, numBytes uint32) error {
+	reg, offset, err := c.register(addr, numBytes)
+	if err != nil {
+		return err
+	}
+	// merge the stored bytes into the register
+	shift := offset * 8
+	mask := xlenMask(int(numBytes*8)) << shift
+	merge := func(old uint64) uint64 {
+		return old&^mask | (data<<shift)&mask
+	}
+
+	switch reg {
+	case CLINT_MSIP:
+		// only the lowest bit is writable
+		level := data&1 != 0
+		if level != c.msip {
+			c.msip = level
+			if c.software != nil {
+				c.software(level)
+			}
+		}
+	case CLINT_MTIMECMP:
+		c.mtimecmp = merge(c.mtimecmp)
+	case CLINT_MTIME:
+		c.offset = merge(c.Time()) - c.timebase.Time()
+	}
+	c.updateTimer()
+	return nil
+}
+
+func (c *CLINT) Load(addr uint64, numBytes uint32) (uint64, error) {
+	reg, offset, err := c.register(addr, numBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	var value uint64
+	switch reg {
+	case CLINT_MSIP:
+		if c.msip {
+			value = 1
+		}
+	case CLINT_MTIMECMP:
+		value = c.mtimecmp
+	case CLINT_MTIME:
+		value = c.Time()
+	}
+	return (value >> (offset * 8)) & xlenMask(int(numBytes*8)), nil
+}
@@ -0,0 +1,103 @@
+package riscv
+
+import (
+	"testing"
+)
+
+func TestCLINTTimer(t *testing.T) {
+	clint := NewCLINT(NewInstructionTimebase())
+	level := false
+	clint.SetInterruptHandlers(nil, func(l bool) { level = l })
+
+	clint.Store(CLINT_MTIMECMP, 3, 4)
+	clint.Store(CLINT_MTIMECMP+4, 0, 4)
+	clint.Tick()
+	clint.Tick()
+	Assert(t, level, false)
+	mtime, _ := clint.Load(CLINT_MTIME, 8)
+	Assert(t, mtime, uint64(2))
+
+	clint.Tick()
+	Assert(t, level, true)
+
+	// writing mtimecmp clears the interrupt
+	clint.Store(CLINT_MTIMECMP, 0x100000000, 8)
+	Assert(t, level, false)
+	high, _ := clint.Load(CLINT_MTIMECMP+4, 4)
+	Assert(t, high, uint64(1))
+
+	// and so does moving mtime back
+	clint.Store(CLINT_MTIME+4, 1, 4)
+	Assert(t, level, true)
+	clint.Store(CLINT_MTIME, 0, 8)
+	Assert(t, level, false)
+	clint.Tick()
+	mtime, _ = clint.Load(CLINT_MTIME, 8)
+	Assert(t, mtime, uint64(1))
+}
+
+func TestCLINTSoftware(t *testing.T) {
+	clint := NewCLINT(NewInstructionTimebase())
+	level := false
+	clint.SetInterruptHandlers(func(l bool) { level = l }, nil)
+
+	clint.Store(CLINT_MSIP, 0xffffffff, 4)
+	Assert(t, level, true)
+	msip, _ := clint.Load(CLINT_MSIP, 4)
+	Assert(t, msip, uint64(1))
+	clint.Store(CLINT_MSIP, 0, 4)
+	Assert(t, level, false)
+
+	for _, addr := range []uint64{CLINT_MSIP + 4, CLINT_MTIMECMP + 2, 0x1000} {
+		_, err := clint.Load(addr, 4)
+		if err == nil {
+			t.Errorf("loading clint offset %#x should fail", addr)
+		}
+	}
+}
+
+func TestRunTimerInterrupt(t *testing.T) {
+	bus := NewBus()
+	ram := NewMemory(0x100)
+	bus.Map("ram", 0, 0x100, &ram)
+	clint := NewCLINT(NewInstructionTimebase())
+	bus.Map("clint", 0x2000000, CLINT_SIZE, clint)
+	storeProgram(t, bus, 0, []uint32{
+		0x020042b7, // lui t0, 0x2004
+		0x03200313, // addi t1, zero, 50
+		0x0062a023, // sw t1, 0(t0), mtimecmp=50
+		0x0002a223, // sw zero, 4(t0)
+		0x04000293, // addi t0, zero, 0x40
+		0x30529073, // csrw mtvec, t0
+		0x08000293, // addi t0, zero, 0x80
+		0x30429073, // csrw mie, t0, MTIE
+		0x30046073, // csrsi mstatus, 8, MIE
+		0x0000006f, // j .
+	})
+	storeProgram(t, bus, 0x40, []uint32{
+		0x34202573, // csrr a0, mcause
+		0x341025f3, // csrr a1, mepc
+		0x0200c2b7, // lui t0, 0x200c
+		0xff82a603, // lw a2, -8(t0), mtime
+		0x0000006f, // j .
+	})
+	e, r := newTestEmulator(bus)
+	clint.SetInterruptHandlers(
+		func(level bool) { r.csr.SetInterruptLine(MIP_MSIP, level) },
+		func(level bool) { r.csr.SetInterruptLine(MIP_MTIP, level) })
+	e.AddTicker(clint)
+
+	reason, err := e.Run(1000)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	// the j . with interrupts enabled is not the end of the program, the
+	// one in the handler is
+	Assert(t, reason, HaltSelfLoop)
+	CheckPc(0x50, r, t)
+	CheckReg(reg_a0, 1<<31|INT_MACHINE_TIMER, r, t)
+	CheckReg(reg_a1, 0x24, r, t)
+	// the interrupt is taken before the 50th instruction
+	CheckReg(reg_a2, 53, r, t)
+}
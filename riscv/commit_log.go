@@ -0,0 +1,266 @@
+package riscv
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RegisterKind tells which register file a RegisterWrite is in.
+type RegisterKind int
+
+const (
+	RegisterInt RegisterKind = iota
+	RegisterFloat
+	RegisterCSR
+)
+
+// RegisterWrite is a register written by an instruction, Index is the CSR
+// address for RegisterCSR. Value is the value of the register after the
+// instruction.
+type RegisterWrite struct {
+	Kind  RegisterKind
+	Index int
+	Value uint64
+}
+
+// spikeKey orders the writes like spike does in its commit log.
+func (w RegisterWrite) spikeKey() int {
+	kind := map[RegisterKind]int{RegisterInt: 0, RegisterFloat: 1, RegisterCSR: 4}[w.Kind]
+	return w.Index<<4 | kind
+}
+
+// MemoryAccess is a load or store of an instruction. Value is the stored
+// value, it's zero for loads.
+type MemoryAccess struct {
+	Addr     uint64
+	Paddr    uint64
+	NumBytes uint32
+	Value    uint64
+	Write    bool
+}
+
+// CommitRecord is what a retired instruction did. Word is the raw encoding,
+// only the lower 16 bits are used for compressed instructions. Mode is the
+// privilege mode the instruction executed in.
+type CommitRecord struct {
+	Hart     uint64
+	Mode     uint64
+	Xlen     int
+	Pc       uint64
+	Word     uint32
+	Instr    Instruction
+	Writes   []RegisterWrite
+	Accesses []MemoryAccess
+}
+
+// SetCommitHandler makes the emulator call the handler after every retired
+// instruction, the record is reused so it's only valid during the call.
+// Instructions that trap are not retired, nil removes the handler. An error
+// of the handler is returned by Step, so it stops Run.
+func (e *Emulator) SetCommitHandler(handler func(c *CommitRecord) error) {
+	e.commitHandler = handler
+}
+
+// recording returns if the emulator records what the instructions do, for
+// the commit handler or the watchpoints.
+func (e *Emulator) recording() bool {
+	return e.commitHandler != nil || len(e.watchpoints) > 0
+}
+
+// startRecording returns the memory and registers the instruction at pc
+// executes with, they add its accesses and writes to e.commit.
+func (e *Emulator) startRecording(pc uint64, decoded decodedInstr) (Memory, Registers) {
+	csrs := e.regs.Csrs()
+	e.commit = CommitRecord{
+		Hart:     csrs.mhartid,
+		Mode:     csrs.Mode(),
+		Xlen:     e.regs.Xlen(),
+		Pc:       pc,
+		Word:     decoded.word,
+		Instr:    decoded.instr,
+		Writes:   e.commit.Writes[:0],
+		Accesses: e.commit.Accesses[:0],
+	}
+	csrs.recordWrites = true
+	csrs.written = csrs.written[:0]
+	return &recordingMemory{Memory: e.mem, commit: &e.commit}, &recordingRegisters{Registers: e.regs, commit: &e.commit}
+}
+
+// stopRecording adds the CSR writes and the values of the written registers
+// to e.commit.
+func (e *Emulator) stopRecording() {
+	csrs := e.regs.Csrs()
+	csrs.recordWrites = false
+	for _, csr := range csrs.written {
+		e.commit.Writes = append(e.commit.Writes, RegisterWrite{Kind: RegisterCSR, Index: int(csr)})
+	}
+
+	writes := e.commit.Writes
+	sort.SliceStable(writes, func(i, j int) bool { return writes[i].spikeKey() < writes[j].spikeKey() })
+	unique := writes[:0]
+	for i, w := range writes {
+		if i > 0 && w.spikeKey() == writes[i-1].spikeKey() {
+			continue
+		}
+		switch w.Kind {
+		case RegisterInt:
+			w.Value = e.regs.Reg(w.Index)
+		case RegisterFloat:
+			w.Value = e.regs.FReg(w.Index)
+		case RegisterCSR:
+			w.Value, _ = csrs.Read(uint32(w.Index))
+		}
+		unique = append(unique, w)
+	}
+	e.commit.Writes = unique
+}
+
+// recordingMemory is the memory the instructions access while the emulator
+// records them.
+type recordingMemory struct {
+	Memory
+	commit *CommitRecord
+	// the last translation, the accesses of an instruction are all in the
+	// page of its last translation
+	vaddr          uint64
+	paddr          uint64
+	hasTranslation bool
+}
+
+func (m *recordingMemory) physical() Memory {
+	return m.Memory
+}
+
+func (m *recordingMemory) translated(vaddr uint64, paddr uint64) {
+	m.vaddr = vaddr
+	m.paddr = paddr
+	m.hasTranslation = true
+}
+
+func (m *recordingMemory) record(paddr uint64, numBytes uint32, value uint64, write bool) {
+	vaddr := paddr
+	if m.hasTranslation {
+		vaddr = m.vaddr + (paddr - m.paddr)
+	}
+	if numBytes < 8 {
+		value &= 1<<(8*numBytes) - 1
+	}
+	m.commit.Accesses = append(m.commit.Accesses, MemoryAccess{Addr: vaddr, Paddr: paddr, NumBytes: numBytes, Value: value, Write: write})
+}
+
+func (m *recordingMemory) StoreByte(addr uint64, data uint64) error {
+	m.record(addr, 1, data, true)
+	return m.Memory.StoreByte(addr, data)
+}
+
+func (m *recordingMemory) Store(addr uint64, data uint64, numBytes uint32) error {
+	m.record(addr, numBytes, data, true)
+	return m.Memory.Store(addr, data, numBytes)
+}
+
+func (m *recordingMemory) LoadByte(addr uint64) (uint64, error) {
+	m.record(addr, 1, 0, false)
+	return m.Memory.LoadByte(addr)
+}
+
+func (m *recordingMemory) Load(addr uint64, numBytes uint32) (uint64, error) {
+	m.record(addr, numBytes, 0, false)
+	return m.Memory.Load(addr, numBytes)
+}
+
+// recordingRegisters are the registers the instructions write while the
+// emulator records them, the CSRs are recorded by the CSRFile itself.
+type recordingRegisters struct {
+	Registers
+	commit *CommitRecord
+}
+
+func (r *recordingRegisters) SetReg(i int, data uint64) {
+	r.Registers.SetReg(i, data)
+	// writes to x0 are discarded, so they are not in the commit log either
+	if i != reg_zero {
+		r.commit.Writes = append(r.commit.Writes, RegisterWrite{Kind: RegisterInt, Index: i})
+	}
+}
+
+func (r *recordingRegisters) SetFReg(i int, data uint64) {
+	r.Registers.SetFReg(i, data)
+	r.commit.Writes = append(r.commit.Writes, RegisterWrite{Kind: RegisterFloat, Index: i})
+}
+
+// spikeHex formats the value with the hex digits of a bits wide value, like
+// spike does.
+func spikeHex(b *strings.Builder, value uint64, bits int) {
+	fmt.Fprintf(b, "0x%0*x", bits/4, value)
+}
+
+// commitDisassemblers disassemble the instructions of the commit logs, they
+// have no symbols like the ones of spike.
+var commitDisassemblers = map[int]*Disassembler{
+	XLEN_32: NewDisassembler(XLEN_32, nil),
+	XLEN_64: NewDisassembler(XLEN_64, nil),
+}
+
+// Disassembly returns the instruction in assembly.
+func (c *CommitRecord) Disassembly() string {
+	return commitDisassemblers[c.Xlen].Disassemble(c.Instr, c.Pc)
+}
+
+// SpikeDisassemblyString returns the line spike -l prints before the
+// commit of the instruction, like
+//
+//	core   0: 0x80000008 (0x00a58023) sb a0, 0(a1)
+func (c *CommitRecord) SpikeDisassemblyString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "core%4d: ", c.Hart)
+	spikeHex(&b, c.Pc, c.Xlen)
+	b.WriteString(" (")
+	spikeHex(&b, uint64(c.Word), 8*int(InstructionLength(c.Word)))
+	b.WriteString(") ")
+	b.WriteString(c.Disassembly())
+	return b.String()
+}
+
+// SpikeString returns the line spike --log-commits prints for the
+// instruction, like
+//
+//	core   0: 3 0x80000008 (0x00a58023) mem 0x10000000 0x68
+func (c *CommitRecord) SpikeString() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "core%4d: %d ", c.Hart, c.Mode)
+	spikeHex(&b, c.Pc, c.Xlen)
+	b.WriteString(" (")
+	spikeHex(&b, uint64(c.Word), 8*int(InstructionLength(c.Word)))
+	b.WriteString(")")
+
+	for _, w := range c.Writes {
+		switch w.Kind {
+		case RegisterInt:
+			fmt.Fprintf(&b, " x%-2d ", w.Index)
+			spikeHex(&b, w.Value, c.Xlen)
+		case RegisterFloat:
+			fmt.Fprintf(&b, " f%-2d ", w.Index)
+			spikeHex(&b, w.Value, 64)
+		case RegisterCSR:
+			fmt.Fprintf(&b, " c%d_%s ", w.Index, CsrName(uint32(w.Index)))
+			spikeHex(&b, w.Value, c.Xlen)
+		}
+	}
+	// spike lists the loads before the stores
+	for _, a := range c.Accesses {
+		if !a.Write {
+			b.WriteString(" mem ")
+			spikeHex(&b, a.Addr, c.Xlen)
+		}
+	}
+	for _, a := range c.Accesses {
+		if a.Write {
+			b.WriteString(" mem ")
+			spikeHex(&b, a.Addr, c.Xlen)
+			b.WriteString(" ")
+			spikeHex(&b, a.Value, 8*int(a.NumBytes))
+		}
+	}
+	return b.String()
+}
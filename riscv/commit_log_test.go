@@ -0,0 +1,94 @@
+package riscv
+
+import (
+	"strings"
+	"testing"
+)
+
+// newCommitTestEmulator returns an emulator with a program that writes
+// registers, a CSR and memory.
+func newCommitTestEmulator(t *testing.T) *Emulator {
+	mem := NewMemoryWithOffset(64, 0x80000000)
+	storeProgram(t, &mem, 0x80000000, []uint32{
+		0x06800513, // addi a0, zero, 0x68
+		0x00000597, // auipc a1, 0
+		0x02a58023, // sb a0, 32(a1)
+		0x0205a603, // lw a2, 32(a1)
+		0x340516f3, // csrrw a3, mscratch, a0
+		0x00010505, // c.addi a0, 1; c.nop
+		0x00a5a72f, // amoadd.w a4, a0, (a1)
+		0x0000006f, // j .
+	})
+	r := &RegistersImpl{}
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	d.RegisterCompressedInstructionSet()
+	r.SetPc(0x80000000)
+	return NewEmulator(&mem, r, d)
+}
+
+// commitTestLog is the spike commit log of the newCommitTestEmulator program.
+var commitTestLog = []string{
+	"core   0: 3 0x80000000 (0x06800513) x10 0x00000068",
+	"core   0: 3 0x80000004 (0x00000597) x11 0x80000004",
+	"core   0: 3 0x80000008 (0x02a58023) mem 0x80000024 0x68",
+	"core   0: 3 0x8000000c (0x0205a603) x12 0x00000068 mem 0x80000024",
+	"core   0: 3 0x80000010 (0x340516f3) x13 0x00000000 c832_mscratch 0x00000068",
+	"core   0: 3 0x80000014 (0x0505) x10 0x00000069",
+	"core   0: 3 0x80000016 (0x0001)",
+	"core   0: 3 0x80000018 (0x00a5a72f) x14 0x00000597 mem 0x80000004 mem 0x80000004 0x00000600",
+	"core   0: 3 0x8000001c (0x0000006f)",
+}
+
+func TestSpikeCommitLog(t *testing.T) {
+	e := newCommitTestEmulator(t)
+
+	var lines, withDisassembly []string
+	e.SetCommitHandler(func(c *CommitRecord) error {
+		lines = append(lines, c.SpikeString())
+		withDisassembly = append(withDisassembly, c.SpikeDisassemblyString(), c.SpikeString())
+		return nil
+	})
+	reason, err := e.Run(0)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	Assert(t, strings.Join(lines, "\n"), strings.Join(commitTestLog, "\n"))
+	Assert(t, withDisassembly[4], "core   0: 0x80000008 (0x02a58023) sb a0, 32(a1)")
+
+	// the disassembly lines of spike -l are skipped by the lockstep
+	l, err := runLockstep(t, strings.Join(withDisassembly, "\n"))
+	if err != nil {
+		t.Fatalf("lockstep failed with error %v", err)
+	}
+	Assert(t, l.Matched(), uint64(len(commitTestLog)))
+}
+
+func TestCommitLogVirtualAddress(t *testing.T) {
+	// 0x00400000 is mapped to 0x3000, see newPagedMemory
+	mem := newPagedMemory(t)
+	storeProgram(t, &mem, 0x3000, []uint32{
+		0x0205a603, // lw a2, 32(a1)
+	})
+	r := newPagedRegisters(MODE_S)
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	e := NewEmulator(&mem, r, d)
+	r.SetPc(0x400000)
+	r.SetReg(reg_a1, 0x400000)
+
+	var record CommitRecord
+	e.SetCommitHandler(func(c *CommitRecord) error {
+		record = *c
+		return nil
+	})
+	if _, err := e.Step(); err != nil {
+		t.Fatalf("step failed with error %v", err)
+	}
+	// the page table walk is not an access of the instruction
+	Assert(t, len(record.Accesses), 1)
+	Assert(t, record.Accesses[0], MemoryAccess{Addr: 0x400020, Paddr: 0x3020, NumBytes: 4})
+	Assert(t, record.SpikeString(), "core   0: 1 0x00400000 (0x0205a603) x12 0x00000000 mem 0x00400020")
+	Assert(t, record.SpikeDisassemblyString(), "core   0: 0x00400000 (0x0205a603) lw a2, 32(a1)")
+}
@@ -0,0 +1,399 @@
+package riscv
+
+import (
+	"fmt"
+)
+
+// InstructionLength returns the number of bytes of the instruction that
+// starts with the given (lowest) 16 bits. Only the 16 and 32 bit
+// encodings are supported.
+func InstructionLength(lowHalf uint32) uint32 {
+	if lowHalf&3 == 3 {
+		return 4
+	}
+	return 2
+}
+
+// CInstr is a 16 bit compressed instruction. Every compressed instruction
+// has a 32 bit equivalent, which is what gets executed. The only difference
+// is that the next instruction (and the link address) is at pc+2.
+type CInstr struct {
+	raw   uint16
+	instr Instruction
+}
+
+func (Instr CInstr) String() string {
+	return fmt.Sprintf("CInstr{raw=%#04x, expanded=%s}", Instr.raw, Instr.instr.String())
+}
+
+// Expanded returns the 32 bit instruction the compressed one is equal to.
+func (Instr CInstr) Expanded() Instruction {
+	return Instr.instr
+}
+
+func (Instr CInstr) Execute(mem Memory, regs Registers) error {
+	pc := regs.Pc()
+	switch instr := Instr.instr.(type) {
+	case BInstr:
+		taken, err := instr.taken(regs)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			regs.SetPc(pc + 2)
+			return nil
+		}
+		return instr.Execute(mem, regs)
+	case JInstr:
+		err := instr.Execute(mem, regs)
+		if err != nil {
+			return err
+		}
+		regs.SetReg(instr.rd, pc+2)
+	case IInstr:
+		err := instr.Execute(mem, regs)
+		if err != nil {
+			return err
+		}
+		if instr.opcode == JALR {
+			regs.SetReg(instr.rd, pc+2)
+		} else {
+			regs.SetPc(pc + 2)
+		}
+	default:
+		err := instr.Execute(mem, regs)
+		if err != nil {
+			return err
+		}
+		regs.SetPc(pc + 2)
+	}
+	return nil
+}
+
+// Compressed instruction quadrants, the lowest 2 bits of the instruction.
+const (
+	C_QUADRANT_0 uint32 = 0
+	C_QUADRANT_1 uint32 = 1
+	C_QUADRANT_2 uint32 = 2
+)
+
+// The 3 bit register fields address x8-x15.
+func cReg(field uint32) int {
+	return int(field) + 8
+}
+
+func illegalCompressed(half uint32) error {
+	return fmt.Errorf("illegal compressed instruction %#04x", half)
+}
+
+// ciImm is the 6 bit sign extended immediate of C.ADDI, C.LI and C.ANDI:
+// imm[5]=inst[12], imm[4:0]=inst[6:2]
+func ciImm(half uint32) uint32 {
+	imm := (bitSliceBetween(half, 12, 12) << 5) | bitSliceBetween(half, 2, 6)
+	return sext(imm, 5)
+}
+
+// cjImm is the offset of C.J and C.JAL:
+// inst[12:2] = offset[11|4|9:8|10|6|7|3:1|5]
+func cjImm(half uint32) int32 {
+	imm := (bitSliceBetween(half, 12, 12) << 11) |
+		(bitSliceBetween(half, 11, 11) << 4) |
+		(bitSliceBetween(half, 9, 10) << 8) |
+		(bitSliceBetween(half, 8, 8) << 10) |
+		(bitSliceBetween(half, 7, 7) << 6) |
+		(bitSliceBetween(half, 6, 6) << 7) |
+		(bitSliceBetween(half, 3, 5) << 1) |
+		(bitSliceBetween(half, 2, 2) << 5)
+	return ReinterpreteAsSigned(sext(imm, 11))
+}
+
+// cbImm is the offset of C.BEQZ and C.BNEZ:
+// inst[12:10] = offset[8|4:3], inst[6:2] = offset[7:6|2:1|5]
+func cbImm(half uint32) uint32 {
+	imm := (bitSliceBetween(half, 12, 12) << 8) |
+		(bitSliceBetween(half, 10, 11) << 3) |
+		(bitSliceBetween(half, 5, 6) << 6) |
+		(bitSliceBetween(half, 3, 4) << 1) |
+		(bitSliceBetween(half, 2, 2) << 5)
+	return sext(imm, 8)
+}
+
+// clOffset is the word offset of C.LW and C.SW:
+// inst[12:10] = offset[5:3], inst[6:5] = offset[2|6]
+func clOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 10, 12) << 3) |
+		(bitSliceBetween(half, 6, 6) << 2) |
+		(bitSliceBetween(half, 5, 5) << 6)
+	return int32(imm)
+}
+
+// cdOffset is the double word offset of C.FLD and C.FSD:
+// inst[12:10] = offset[5:3], inst[6:5] = offset[7:6]
+func cdOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 10, 12) << 3) |
+		(bitSliceBetween(half, 5, 6) << 6)
+	return int32(imm)
+}
+
+// lwspOffset is the offset of C.LWSP and C.FLWSP:
+// inst[12] = offset[5], inst[6:2] = offset[4:2|7:6]
+func lwspOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 12, 12) << 5) |
+		(bitSliceBetween(half, 4, 6) << 2) |
+		(bitSliceBetween(half, 2, 3) << 6)
+	return int32(imm)
+}
+
+// swspOffset is the offset of C.SWSP and C.FSWSP:
+// inst[12:7] = offset[5:2|7:6]
+func swspOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 9, 12) << 2) |
+		(bitSliceBetween(half, 7, 8) << 6)
+	return int32(imm)
+}
+
+// ldspOffset is the offset of C.LDSP and C.FLDSP:
+// inst[12] = offset[5], inst[6:2] = offset[4:3|8:6]
+func ldspOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 12, 12) << 5) |
+		(bitSliceBetween(half, 5, 6) << 3) |
+		(bitSliceBetween(half, 2, 4) << 6)
+	return int32(imm)
+}
+
+// sdspOffset is the offset of C.SDSP and C.FSDSP:
+// inst[12:7] = offset[5:3|8:6]
+func sdspOffset(half uint32) int32 {
+	imm := (bitSliceBetween(half, 10, 12) << 3) |
+		(bitSliceBetween(half, 7, 9) << 6)
+	return int32(imm)
+}
+
+// DecodeCompressed expands a 16 bit instruction in the equivalent 32 bit
+// RV32 instruction.
+func DecodeCompressed(half uint32) (Instruction, error) {
+	return decodeCompressed(half, false)
+}
+
+// DecodeCompressedRV64 expands a 16 bit instruction in the equivalent 32 bit
+// RV64 instruction, some encodings of RV32 are other instructions on RV64.
+func DecodeCompressedRV64(half uint32) (Instruction, error) {
+	return decodeCompressed(half, true)
+}
+
+func decodeCompressed(half uint32, rv64 bool) (Instruction, error) {
+	instr, err := expandCompressed(half, rv64)
+	if err != nil {
+		return nil, err
+	}
+	return CInstr{raw: uint16(half), instr: instr}, nil
+}
+
+func expandCompressed(half uint32, rv64 bool) (Instruction, error) {
+	funct3 := bitSliceBetween(half, 13, 15)
+	// full 5 bit register fields
+	rd := int(bitSliceBetween(half, 7, 11))
+	rs2 := int(bitSliceBetween(half, 2, 6))
+	// 3 bit register fields
+	rdp := cReg(bitSliceBetween(half, 2, 4))
+	rs1p := cReg(bitSliceBetween(half, 7, 9))
+	// the shift amount is 6 bits on RV64, shamt[5] must be zero on RV32
+	shamt := (bitSliceBetween(half, 12, 12) << 5) | bitSliceBetween(half, 2, 6)
+	shamtValid := rv64 || shamt < 32
+
+	switch bitSliceBetween(half, 0, 1) {
+	case C_QUADRANT_0:
+		switch funct3 {
+		case 0:
+			// C.ADDI4SPN: addi rd', x2, nzuimm[9:2]
+			// inst[12:5] = nzuimm[5:4|9:6|2|3]
+			imm := (bitSliceBetween(half, 11, 12) << 4) |
+				(bitSliceBetween(half, 7, 10) << 6) |
+				(bitSliceBetween(half, 6, 6) << 2) |
+				(bitSliceBetween(half, 5, 5) << 3)
+			if imm == 0 {
+				// also covers the all zero instruction, which is defined illegal
+				return nil, illegalCompressed(half)
+			}
+			return CreateADDI(reg_sp, rdp, imm), nil
+		case 1:
+			// C.FLD: fld rd', offset(rs1')
+			return CreateFLD(cdOffset(half), rs1p, rdp), nil
+		case 2:
+			// C.LW: lw rd', offset(rs1')
+			return CreateLW(clOffset(half), rs1p, rdp), nil
+		case 3:
+			if rv64 {
+				// C.LD: ld rd', offset(rs1') (RV64 only)
+				return CreateLD(cdOffset(half), rs1p, rdp), nil
+			}
+			// C.FLW: flw rd', offset(rs1') (RV32 only)
+			return CreateFLW(clOffset(half), rs1p, rdp), nil
+		case 5:
+			// C.FSD: fsd rs2', offset(rs1')
+			return CreateFSD(cdOffset(half), rdp, rs1p), nil
+		case 6:
+			// C.SW: sw rs2', offset(rs1')
+			return CreateSW(clOffset(half), rdp, rs1p), nil
+		case 7:
+			if rv64 {
+				// C.SD: sd rs2', offset(rs1') (RV64 only)
+				return CreateSD(cdOffset(half), rdp, rs1p), nil
+			}
+			// C.FSW: fsw rs2', offset(rs1') (RV32 only)
+			return CreateFSW(clOffset(half), rdp, rs1p), nil
+		}
+	case C_QUADRANT_1:
+		switch funct3 {
+		case 0:
+			// C.ADDI: addi rd, rd, nzimm[5:0] (C.NOP when rd=x0)
+			return CreateADDI(rd, rd, ciImm(half)), nil
+		case 1:
+			if rv64 {
+				// C.ADDIW: addiw rd, rd, imm[5:0] (RV64 only)
+				if rd == reg_zero {
+					return nil, illegalCompressed(half)
+				}
+				return CreateADDIW(rd, rd, ciImm(half)), nil
+			}
+			// C.JAL: jal x1, offset[11:1] (RV32 only)
+			return CreateJAL(cjImm(half), reg_ra), nil
+		case 2:
+			// C.LI: addi rd, x0, imm[5:0]
+			return CreateADDI(reg_zero, rd, ciImm(half)), nil
+		case 3:
+			if rd == reg_sp {
+				// C.ADDI16SP: addi x2, x2, nzimm[9:4]
+				// inst[12] = nzimm[9], inst[6:2] = nzimm[4|6|8:7|5]
+				imm := (bitSliceBetween(half, 12, 12) << 9) |
+					(bitSliceBetween(half, 6, 6) << 4) |
+					(bitSliceBetween(half, 5, 5) << 6) |
+					(bitSliceBetween(half, 3, 4) << 7) |
+					(bitSliceBetween(half, 2, 2) << 5)
+				if imm == 0 {
+					return nil, illegalCompressed(half)
+				}
+				return CreateADDI(reg_sp, reg_sp, sext(imm, 9)), nil
+			}
+			// C.LUI: lui rd, nzimm[17:12]
+			imm := ciImm(half)
+			if imm == 0 || rd == reg_zero {
+				return nil, illegalCompressed(half)
+			}
+			return CreateLui(ReinterpreteAsSigned(imm), rd), nil
+		case 4:
+			switch bitSliceBetween(half, 10, 11) {
+			case 0:
+				// C.SRLI: srli rd', rd', shamt
+				if !shamtValid {
+					return nil, illegalCompressed(half)
+				}
+				return CreateSLRI(rs1p, rs1p, shamt), nil
+			case 1:
+				// C.SRAI: srai rd', rd', shamt
+				if !shamtValid {
+					return nil, illegalCompressed(half)
+				}
+				return CreateSRAI(rs1p, rs1p, shamt), nil
+			case 2:
+				// C.ANDI: andi rd', rd', imm[5:0]
+				return CreateANDI(rs1p, rs1p, ciImm(half)), nil
+			case 3:
+				if bitSliceBetween(half, 12, 12) != 0 {
+					// C.SUBW and C.ADDW are RV64 only, the other two encodings are reserved
+					switch {
+					case rv64 && bitSliceBetween(half, 5, 6) == 0:
+						return CreateSUBW(rs1p, rs1p, rdp), nil
+					case rv64 && bitSliceBetween(half, 5, 6) == 1:
+						return CreateADDW(rs1p, rs1p, rdp), nil
+					}
+					return nil, illegalCompressed(half)
+				}
+				switch bitSliceBetween(half, 5, 6) {
+				case 0:
+					return CreateSUB(rs1p, rs1p, rdp), nil
+				case 1:
+					return CreateXOR(rs1p, rs1p, rdp), nil
+				case 2:
+					return CreateOR(rs1p, rs1p, rdp), nil
+				case 3:
+					return CreateAND(rs1p, rs1p, rdp), nil
+				}
+			}
+		case 5:
+			// C.J: jal x0, offset[11:1]
+			return CreateJ(cjImm(half)), nil
+		case 6:
+			// C.BEQZ: beq rs1', x0, offset[8:1]
+			return CreateBEQ(cbImm(half), rs1p, reg_zero), nil
+		case 7:
+			// C.BNEZ: bne rs1', x0, offset[8:1]
+			return CreateBNE(cbImm(half), rs1p, reg_zero), nil
+		}
+	case C_QUADRANT_2:
+		switch funct3 {
+		case 0:
+			// C.SLLI: slli rd, rd, shamt
+			if !shamtValid {
+				return nil, illegalCompressed(half)
+			}
+			return CreateSLLI(rd, rd, shamt), nil
+		case 1:
+			// C.FLDSP: fld rd, offset(x2)
+			return CreateFLD(ldspOffset(half), reg_sp, rd), nil
+		case 2:
+			// C.LWSP: lw rd, offset(x2)
+			if rd == reg_zero {
+				return nil, illegalCompressed(half)
+			}
+			return CreateLW(lwspOffset(half), reg_sp, rd), nil
+		case 3:
+			if rv64 {
+				// C.LDSP: ld rd, offset(x2) (RV64 only)
+				if rd == reg_zero {
+					return nil, illegalCompressed(half)
+				}
+				return CreateLD(ldspOffset(half), reg_sp, rd), nil
+			}
+			// C.FLWSP: flw rd, offset(x2) (RV32 only)
+			return CreateFLW(lwspOffset(half), reg_sp, rd), nil
+		case 4:
+			if bitSliceBetween(half, 12, 12) == 0 {
+				if rs2 == reg_zero {
+					// C.JR: jalr x0, 0(rs1)
+					if rd == reg_zero {
+						return nil, illegalCompressed(half)
+					}
+					return CreateJALR(0, reg_zero, rd), nil
+				}
+				// C.MV: add rd, x0, rs2
+				return CreateADD(rd, reg_zero, rs2), nil
+			}
+			if rs2 == reg_zero {
+				if rd == reg_zero {
+					// C.EBREAK
+					return CreateEBREAK(), nil
+				}
+				// C.JALR: jalr x1, 0(rs1)
+				return CreateJALR(0, reg_ra, rd), nil
+			}
+			// C.ADD: add rd, rd, rs2
+			return CreateADD(rd, rd, rs2), nil
+		case 5:
+			// C.FSDSP: fsd rs2, offset(x2)
+			return CreateFSD(sdspOffset(half), rs2, reg_sp), nil
+		case 6:
+			// C.SWSP: sw rs2, offset(x2)
+			return CreateSW(swspOffset(half), rs2, reg_sp), nil
+		case 7:
+			if rv64 {
+				// C.SDSP: sd rs2, offset(x2) (RV64 only)
+				return CreateSD(sdspOffset(half), rs2, reg_sp), nil
+			}
+			// C.FSWSP: fsw rs2, offset(x2) (RV32 only)
+			return CreateFSW(swspOffset(half), rs2, reg_sp), nil
+		}
+	}
+
+	return nil, illegalCompressed(half)
+}
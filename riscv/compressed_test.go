@@ -0,0 +1,161 @@
+package riscv
+
+import (
+	"testing"
+)
+
+func storeCompressedProgram(t *testing.T, mem Memory, addr uint64, program []uint32) {
+	for _, instr := range program {
+		numBytes := InstructionLength(instr)
+		err := mem.Store(addr, uint64(instr), numBytes)
+		if err != nil {
+			t.Fatalf("failed to store instruction at %#x with error %v", addr, err)
+		}
+		addr += uint64(numBytes)
+	}
+}
+
+func TestDecodeCompressed(t *testing.T) {
+	tests := []struct {
+		half     uint32
+		expected Instruction
+	}{
+		{0x4515, CreateADDI(reg_zero, reg_a0, 5)},                         // c.li a0, 5
+		{0x157d, CreateADDI(reg_a0, reg_a0, ReinterpreteAsUnsigned(-1))},  // c.addi a0, -1
+		{0x0808, CreateADDI(reg_sp, reg_a0, 16)},                          // c.addi4spn a0, sp, 16
+		{0x717d, CreateADDI(reg_sp, reg_sp, ReinterpreteAsUnsigned(-16))}, // c.addi16sp sp, -16
+		{0x6505, CreateLui(1, reg_a0)},                                    // c.lui a0, 1
+		{0x4512, CreateLW(4, reg_sp, reg_a0)},                             // c.lwsp a0, 4(sp)
+		{0xc22a, CreateSW(4, reg_a0, reg_sp)},                             // c.swsp a0, 4(sp)
+		{0x85aa, CreateADD(reg_a1, reg_zero, reg_a0)},                     // c.mv a1, a0
+		{0x8082, CreateJALR(0, reg_zero, reg_ra)},                         // c.jr ra
+		{0xa001, CreateJ(0)},                                              // c.j .
+		{0x2021, CreateJAL(8, reg_ra)},                                    // c.jal 8
+		{0xfd7d, CreateBNE(ReinterpreteAsUnsigned(-2), reg_a0, reg_zero)}, // c.bnez a0, -2
+		{0x9002, CreateEBREAK()},                                          // c.ebreak
+		{0x2522, CreateFLD(8, reg_sp, 10)},                                // c.fldsp fa0, 8(sp)
+		{0xa42a, CreateFSD(8, 10, reg_sp)},                                // c.fsdsp fa0, 8(sp)
+		{0x61c8, CreateFLW(4, reg_a1, 10)},                                // c.flw fa0, 4(a1)
+		{0xe1c0, CreateFSW(4, 8, reg_a1)},                                 // c.fsw fs0, 4(a1)
+		{0x2584, CreateFLD(8, reg_a1, 9)},                                 // c.fld fs1, 8(a1)
+		{0xa584, CreateFSD(8, 9, reg_a1)},                                 // c.fsd fs1, 8(a1)
+		{0x6512, CreateFLW(4, reg_sp, 10)},                                // c.flwsp fa0, 4(sp)
+		{0xe22a, CreateFSW(4, 10, reg_sp)},                                // c.fswsp fa0, 4(sp)
+	}
+
+	for _, test := range tests {
+		res, err := DecodeCompressed(test.half)
+		if err != nil {
+			t.Errorf("decoding %#04x failed with error %v", test.half, err)
+			continue
+		}
+		expanded := res.(CInstr).Expanded()
+		if expanded != test.expected {
+			t.Errorf("decoding %#04x\nres=     %s\nexpected=%s", test.half, expanded.String(), test.expected.String())
+		}
+	}
+}
+
+func TestDecodeCompressedIllegal(t *testing.T) {
+	// the all zero instruction, c.addi4spn with nzuimm=0 and c.lui with nzimm=0
+	for _, half := range []uint32{0x0000, 0x0008, 0x6501} {
+		_, err := DecodeCompressed(half)
+		if err == nil {
+			t.Errorf("decoding %#04x should fail", half)
+		}
+	}
+}
+
+func TestDecodeCompressedRV64(t *testing.T) {
+	tests := []struct {
+		half     uint32
+		expected Instruction
+	}{
+		{0x6588, CreateLD(8, reg_a1, reg_a0)},                             // c.ld a0, 8(a1)
+		{0xe988, CreateSD(16, reg_a0, reg_a1)},                            // c.sd a0, 16(a1)
+		{0x357d, CreateADDIW(reg_a0, reg_a0, ReinterpreteAsUnsigned(-1))}, // c.addiw a0, -1
+		{0x9d0d, CreateSUBW(reg_a0, reg_a0, reg_a1)},                      // c.subw a0, a1
+		{0x9d2d, CreateADDW(reg_a0, reg_a0, reg_a1)},                      // c.addw a0, a1
+		{0x6522, CreateLD(8, reg_sp, reg_a0)},                             // c.ldsp a0, 8(sp)
+		{0xe82a, CreateSD(16, reg_a0, reg_sp)},                            // c.sdsp a0, 16(sp)
+		{0x1522, CreateSLLI(reg_a0, reg_a0, 40)},                          // c.slli a0, 40
+	}
+
+	for _, test := range tests {
+		res, err := DecodeCompressedRV64(test.half)
+		if err != nil {
+			t.Errorf("decoding %#04x failed with error %v", test.half, err)
+			continue
+		}
+		expanded := res.(CInstr).Expanded()
+		if expanded != test.expected {
+			t.Errorf("decoding %#04x\nres=     %s\nexpected=%s", test.half, expanded.String(), test.expected.String())
+		}
+	}
+
+	// on RV32 c.addiw is c.jal, c.subw and shifts by more than 31 are
+	// illegal
+	res, err := DecodeCompressed(0x357d)
+	if err != nil || res.(CInstr).Expanded() != CreateJAL(-338, reg_ra) {
+		t.Errorf("decoding %#04x on RV32 should be c.jal but got %v, %v", 0x357d, res, err)
+	}
+	for _, half := range []uint32{0x9d0d, 0x1522} {
+		_, err := DecodeCompressed(half)
+		if err == nil {
+			t.Errorf("decoding %#04x on RV32 should fail", half)
+		}
+	}
+}
+
+func TestDecoderCompressedNotRegistered(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	_, err := d.Decode(0x4515)
+	if err == nil {
+		t.Fatalf("decoding a compressed instruction without the C extension should fail")
+	}
+}
+
+func TestRunCompressedLoop(t *testing.T) {
+	mem := NewMemory(64)
+	storeCompressedProgram(t, &mem, 0, []uint32{
+		0x4515, // c.li a0, 5
+		0x157d, // loop: c.addi a0, -1
+		0xfd7d, // c.bnez a0, loop
+		0xa001, // c.j .
+	})
+	e, r := newTestEmulator(&mem)
+	e.decoder.RegisterCompressedInstructionSet()
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_a0, 0, r, t)
+	CheckPc(6, r, t)
+	Assert(t, e.Steps(), uint64(1+5*2+1))
+}
+
+func TestRunCompressedCall(t *testing.T) {
+	mem := NewMemory(64)
+	storeCompressedProgram(t, &mem, 0, []uint32{
+		0x2021,     // c.jal func
+		0x00100593, // li a1, 1 (32 bit instruction on a 2 byte boundary)
+		0xa001,     // c.j .
+		0x4515,     // func: c.li a0, 5
+		0x8082,     // c.jr ra
+	})
+	e, r := newTestEmulator(&mem)
+	e.decoder.RegisterCompressedInstructionSet()
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_ra, 2, r, t)
+	CheckReg(reg_a0, 5, r, t)
+	CheckReg(reg_a1, 1, r, t)
+	CheckPc(6, r, t)
+}
@@ -0,0 +1,574 @@
+package riscv
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Machine mode CSR addresses
+const (
+	CSR_MSTATUS    uint32 = 0x300
+	CSR_MISA       uint32 = 0x301
+	CSR_MEDELEG    uint32 = 0x302
+	CSR_MIDELEG    uint32 = 0x303
+	CSR_MIE        uint32 = 0x304
+	CSR_MTVEC      uint32 = 0x305
+	CSR_MCOUNTEREN uint32 = 0x306
+	CSR_MSTATUSH   uint32 = 0x310
+	CSR_MSCRATCH   uint32 = 0x340
+	CSR_MEPC       uint32 = 0x341
+	CSR_MCAUSE     uint32 = 0x342
+	CSR_MTVAL      uint32 = 0x343
+	CSR_MIP        uint32 = 0x344
+	CSR_MCYCLE     uint32 = 0xB00
+	CSR_MINSTRET   uint32 = 0xB02
+	CSR_MCYCLEH    uint32 = 0xB80
+	CSR_MINSTRETH  uint32 = 0xB82
+	CSR_MVENDORID  uint32 = 0xF11
+	CSR_MARCHID    uint32 = 0xF12
+	CSR_MIMPID     uint32 = 0xF13
+	CSR_MHARTID    uint32 = 0xF14
+)
+
+// Supervisor mode CSR addresses, sstatus, sie and sip are restricted views
+// of the machine mode registers.
+const (
+	CSR_SSTATUS    uint32 = 0x100
+	CSR_SIE        uint32 = 0x104
+	CSR_STVEC      uint32 = 0x105
+	CSR_SCOUNTEREN uint32 = 0x106
+	CSR_SSCRATCH   uint32 = 0x140
+	CSR_SEPC       uint32 = 0x141
+	CSR_SCAUSE     uint32 = 0x142
+	CSR_STVAL      uint32 = 0x143
+	CSR_SIP        uint32 = 0x144
+	CSR_SATP       uint32 = 0x180
+)
+
+// Floating point CSRs, fcsr is frm and fflags combined
+const (
+	CSR_FFLAGS uint32 = 0x001
+	CSR_FRM    uint32 = 0x002
+	CSR_FCSR   uint32 = 0x003
+)
+
+// Unprivileged read only shadows of the machine counters
+const (
+	CSR_CYCLE    uint32 = 0xC00
+	CSR_INSTRET  uint32 = 0xC02
+	CSR_CYCLEH   uint32 = 0xC80
+	CSR_INSTRETH uint32 = 0xC82
+)
+
+var csrNames = map[uint32]string{
+	CSR_MSTATUS: "mstatus", CSR_MISA: "misa", CSR_MEDELEG: "medeleg", CSR_MIDELEG: "mideleg",
+	CSR_MIE: "mie", CSR_MTVEC: "mtvec", CSR_MCOUNTEREN: "mcounteren", CSR_MSTATUSH: "mstatush",
+	CSR_MSCRATCH: "mscratch", CSR_MEPC: "mepc", CSR_MCAUSE: "mcause", CSR_MTVAL: "mtval",
+	CSR_MIP: "mip", CSR_MCYCLE: "mcycle", CSR_MINSTRET: "minstret", CSR_MCYCLEH: "mcycleh",
+	CSR_MINSTRETH: "minstreth", CSR_MVENDORID: "mvendorid", CSR_MARCHID: "marchid", CSR_MIMPID: "mimpid",
+	CSR_MHARTID: "mhartid", CSR_SSTATUS: "sstatus", CSR_SIE: "sie", CSR_STVEC: "stvec",
+	CSR_SCOUNTEREN: "scounteren", CSR_SSCRATCH: "sscratch", CSR_SEPC: "sepc", CSR_SCAUSE: "scause",
+	CSR_STVAL: "stval", CSR_SIP: "sip", CSR_SATP: "satp", CSR_FFLAGS: "fflags",
+	CSR_FRM: "frm", CSR_FCSR: "fcsr", CSR_CYCLE: "cycle", CSR_INSTRET: "instret",
+	CSR_CYCLEH: "cycleh", CSR_INSTRETH: "instreth",
+}
+
+// CsrName returns the name of the CSR, or its address in hex when the
+// emulator doesn't implement it.
+func CsrName(csr uint32) string {
+	name, ok := csrNames[csr]
+	if !ok {
+		return fmt.Sprintf("%#x", csr)
+	}
+	return name
+}
+
+// Privilege modes, encoded like in MPP and in bits 9:8 of the csr address
+const (
+	MODE_U uint64 = 0
+	MODE_S uint64 = 1
+	MODE_M uint64 = 3
+)
+
+// mstatus bits
+const (
+	MSTATUS_SIE  uint64 = 1 << 1
+	MSTATUS_MIE  uint64 = 1 << 3
+	MSTATUS_SPIE uint64 = 1 << 5
+	MSTATUS_MPIE uint64 = 1 << 7
+	MSTATUS_SPP  uint64 = 1 << 8
+	MSTATUS_MPP  uint64 = 3 << 11
+	MSTATUS_FS   uint64 = 3 << 13
+	MSTATUS_MPRV uint64 = 1 << 17
+	MSTATUS_SUM  uint64 = 1 << 18
+	MSTATUS_MXR  uint64 = 1 << 19
+	MSTATUS_TVM  uint64 = 1 << 20
+	MSTATUS_TW   uint64 = 1 << 21
+	MSTATUS_TSR  uint64 = 1 << 22
+	MSTATUS_SD   uint64 = 1 << 31
+	// RV64 only, UXL and SXL are the XLEN of user and supervisor mode and
+	// SD moves to the highest bit
+	MSTATUS_UXL  uint64 = 3 << 32
+	MSTATUS_SXL  uint64 = 3 << 34
+	MSTATUS_SD64 uint64 = 1 << 63
+
+	MSTATUS_MPP_SHIFT = 11
+	MSTATUS_UXL_SHIFT = 32
+	MSTATUS_SXL_SHIFT = 34
+
+	mstatusWritable = MSTATUS_SIE | MSTATUS_MIE | MSTATUS_SPIE | MSTATUS_MPIE | MSTATUS_SPP | MSTATUS_MPP |
+		MSTATUS_MPRV | MSTATUS_SUM | MSTATUS_MXR | MSTATUS_TVM | MSTATUS_TW | MSTATUS_TSR
+	sstatusWritable = MSTATUS_SIE | MSTATUS_SPIE | MSTATUS_SPP | MSTATUS_SUM | MSTATUS_MXR
+	// sstatus also shows the read only fp state
+	sstatusReadable = sstatusWritable | MSTATUS_FS | MSTATUS_SD | MSTATUS_UXL | MSTATUS_SD64
+)
+
+// mie/mip bits
+const (
+	MIP_SSIP uint64 = 1 << 1
+	MIP_MSIP uint64 = 1 << 3
+	MIP_STIP uint64 = 1 << 5
+	MIP_MTIP uint64 = 1 << 7
+	MIP_SEIP uint64 = 1 << 9
+	MIP_MEIP uint64 = 1 << 11
+
+	// the supervisor interrupts, which are the only ones that can be
+	// delegated and set by machine mode software
+	supervisorInterrupts = MIP_SSIP | MIP_STIP | MIP_SEIP
+	machineInterrupts    = MIP_MSIP | MIP_MTIP | MIP_MEIP
+)
+
+// mcounteren/scounteren bits, a clear bit makes the counter illegal to read
+// in the next less privileged mode.
+const (
+	COUNTEREN_CY uint64 = 1 << 0
+	COUNTEREN_TM uint64 = 1 << 1
+	COUNTEREN_IR uint64 = 1 << 2
+)
+
+// satp fields, MODE is bare (0) or Sv32 (1)
+const (
+	SATP_MODE uint64 = 1 << 31
+	SATP_ASID uint64 = 0x1ff << SATP_ASID_SHIFT
+	SATP_PPN  uint64 = 0x3fffff
+
+	SATP_ASID_SHIFT = 22
+)
+
+// RV64 satp fields, MODE is bare (0) or Sv39 (8)
+const (
+	SATP64_MODE uint64 = 0xf << SATP64_MODE_SHIFT
+	SATP64_ASID uint64 = 0xffff << SATP64_ASID_SHIFT
+	SATP64_PPN  uint64 = 1<<44 - 1
+
+	SATP64_MODE_SHIFT        = 60
+	SATP64_ASID_SHIFT        = 44
+	SATP_MODE_SV39    uint64 = 8
+)
+
+// All exceptions except an ecall from machine mode can be delegated to
+// supervisor mode, the other bits are causes that do not exist.
+const medelegWritable = 1<<EXC_INSTRUCTION_MISALIGNED | 1<<EXC_INSTRUCTION_ACCESS_FAULT |
+	1<<EXC_ILLEGAL_INSTRUCTION | 1<<EXC_BREAKPOINT | 1<<EXC_LOAD_MISALIGNED | 1<<EXC_LOAD_ACCESS_FAULT |
+	1<<EXC_STORE_MISALIGNED | 1<<EXC_STORE_ACCESS_FAULT | 1<<EXC_ECALL_U | 1<<EXC_ECALL_S |
+	1<<EXC_INSTRUCTION_PAGE_FAULT | 1<<EXC_LOAD_PAGE_FAULT | 1<<EXC_STORE_PAGE_FAULT
+
+const (
+	MISA_MXL_32 uint64 = 1 << 30
+	MISA_MXL_64 uint64 = 2 << 62
+	MTVEC_MODE  uint64 = 3
+	FFLAGS_MASK uint32 = 0x1f
+	FRM_MASK    uint32 = 7
+
+	// the encoding of XLEN=64 in the UXL and SXL fields of mstatus
+	XL_64 uint64 = 2
+)
+
+// misaExtension returns the misa bit of the extension with the given letter.
+func misaExtension(letter byte) uint64 {
+	return 1 << (letter - 'A')
+}
+
+// The extensions reported in misa, writes to misa are ignored.
+var misaExtensions = misaExtension('I') | misaExtension('M') | misaExtension('A') |
+	misaExtension('F') | misaExtension('D') | misaExtension('C') |
+	misaExtension('S') | misaExtension('U')
+
+type IllegalCSRAccessError struct {
+	Csr   uint32
+	Write bool
+}
+
+func (e IllegalCSRAccessError) Error() string {
+	if e.Write {
+		return fmt.Sprintf("illegal write to csr=%#x", e.Csr)
+	}
+	return fmt.Sprintf("illegal read of csr=%#x", e.Csr)
+}
+
+// CSRFile holds the control and status registers and the privilege mode of
+// a hart. The zero value is an RV32 hart with id 0 right after reset.
+type CSRFile struct {
+	// the interrupt pending bits driven by the interrupt sources, like the
+	// CLINT and PLIC. The sources can run on other goroutines, so lines is
+	// only accessed atomically (it's the first field to be 64 bit aligned).
+	lines uint64
+	// RV64 harts have 64 bit CSRs, the upper half of the counters is in the
+	// counters themselves instead of the ...H CSRs
+	rv64 bool
+	// the privilege mode xor MODE_M, so the zero value runs in machine mode
+	mode       uint64
+	mstatus    uint64
+	medeleg    uint64
+	mideleg    uint64
+	mie        uint64
+	mip        uint64
+	mtvec      uint64
+	mcounteren uint64
+	mscratch   uint64
+	mepc       uint64
+	mcause     uint64
+	mtval      uint64
+	mhartid    uint64
+	stvec      uint64
+	scounteren uint64
+	sscratch   uint64
+	sepc       uint64
+	scause     uint64
+	stval      uint64
+	satp       uint64
+	cycle      uint64
+	instret    uint64
+	fflags     uint32
+	frm        uint32
+
+	// the CSRs written by the current instruction, only collected while
+	// the emulator records the commits
+	recordWrites bool
+	written      []uint32
+}
+
+func NewCSRFile(hartid uint64, xlen int) CSRFile {
+	return CSRFile{mhartid: hartid, rv64: xlen == XLEN_64}
+}
+
+// Xlen returns the width of the integer registers, XLEN_32 or XLEN_64.
+func (c *CSRFile) Xlen() int {
+	if c.rv64 {
+		return XLEN_64
+	}
+	return XLEN_32
+}
+
+func isReadOnlyCsr(csr uint32) bool {
+	// csr[11:10]==11 are the read only registers
+	return bitSliceBetween(csr, 10, 11) == 3
+}
+
+// isHighHalfCsr returns if the csr is the upper half of a 64 bit CSR on
+// RV32, they don't exist on RV64.
+func isHighHalfCsr(csr uint32) bool {
+	switch csr {
+	case CSR_MSTATUSH, CSR_MCYCLEH, CSR_MINSTRETH, CSR_CYCLEH, CSR_INSTRETH:
+		return true
+	}
+	return false
+}
+
+// Mode returns the privilege mode the hart is running in.
+func (c *CSRFile) Mode() uint64 {
+	return c.mode ^ MODE_M
+}
+
+func (c *CSRFile) SetMode(mode uint64) {
+	c.mode = mode ^ MODE_M
+}
+
+// checkAccess returns an error if the csr can't be accessed from the
+// current privilege mode, csr[9:8] is the lowest mode allowed to access it.
+func (c *CSRFile) checkAccess(csr uint32, write bool) error {
+	mode := c.Mode()
+	if mode < uint64(bitSliceBetween(csr, 8, 9)) {
+		return IllegalCSRAccessError{Csr: csr, Write: write}
+	}
+
+	switch csr {
+	case CSR_SATP:
+		// with TVM supervisor mode can't change the address translation
+		if mode == MODE_S && c.mstatus&MSTATUS_TVM != 0 {
+			return IllegalCSRAccessError{Csr: csr, Write: write}
+		}
+	case CSR_CYCLE, CSR_CYCLEH, CSR_INSTRET, CSR_INSTRETH:
+		// the low bits of the address are the index of the counter
+		bit := uint64(1) << (csr & 0x1f)
+		if (mode < MODE_M && c.mcounteren&bit == 0) || (mode == MODE_U && c.scounteren&bit == 0) {
+			return IllegalCSRAccessError{Csr: csr, Write: write}
+		}
+	}
+	return nil
+}
+
+// Retire counts one executed instruction, every instruction takes one cycle.
+func (c *CSRFile) Retire() {
+	c.cycle++
+	c.instret++
+}
+
+// status returns mstatus including the read only fields.
+func (c *CSRFile) status() uint64 {
+	// The floating point unit is always on and FS is hardwired to dirty,
+	// so the fp registers are always saved on a context switch.
+	if c.rv64 {
+		return c.mstatus | MSTATUS_FS | XL_64<<MSTATUS_UXL_SHIFT | XL_64<<MSTATUS_SXL_SHIFT | MSTATUS_SD64
+	}
+	return c.mstatus | MSTATUS_FS | MSTATUS_SD
+}
+
+// misa returns the value of misa, the extensions are the same for both
+// XLENs.
+func (c *CSRFile) misa() uint64 {
+	if c.rv64 {
+		return MISA_MXL_64 | misaExtensions
+	}
+	return MISA_MXL_32 | misaExtensions
+}
+
+// SetInterruptLine sets or clears the interrupt pending bits in mask, it's
+// how interrupt sources raise interrupts. The pending bits in mip are the
+// software set bits or'ed with the lines. It's safe to call from another
+// goroutine.
+func (c *CSRFile) SetInterruptLine(mask uint64, level bool) {
+	for {
+		old := atomic.LoadUint64(&c.lines)
+		lines := old &^ mask
+		if level {
+			lines |= mask
+		}
+		if atomic.CompareAndSwapUint64(&c.lines, old, lines) {
+			return
+		}
+	}
+}
+
+// pending returns the pending interrupts as shown in mip.
+func (c *CSRFile) pending() uint64 {
+	return c.mip | atomic.LoadUint64(&c.lines)
+}
+
+// counter returns the value of the counter CSR, the full counter on RV64
+// and the lower 32 bits on RV32.
+func (c *CSRFile) counter(value uint64) uint64 {
+	if c.rv64 {
+		return value
+	}
+	return value & 0xffffffff
+}
+
+func (c *CSRFile) Read(csr uint32) (uint64, error) {
+	if c.rv64 && isHighHalfCsr(csr) {
+		return 0, IllegalCSRAccessError{Csr: csr}
+	}
+
+	switch csr {
+	case CSR_MSTATUS:
+		return c.status(), nil
+	case CSR_MSTATUSH:
+		// only little endian is supported, so all the fields are zero
+		return 0, nil
+	case CSR_MISA:
+		return c.misa(), nil
+	case CSR_MEDELEG:
+		return c.medeleg, nil
+	case CSR_MIDELEG:
+		return c.mideleg, nil
+	case CSR_MIE:
+		return c.mie, nil
+	case CSR_MIP:
+		return c.pending(), nil
+	case CSR_MTVEC:
+		return c.mtvec, nil
+	case CSR_MCOUNTEREN:
+		return c.mcounteren, nil
+	case CSR_MSCRATCH:
+		return c.mscratch, nil
+	case CSR_MEPC:
+		return c.mepc, nil
+	case CSR_MCAUSE:
+		return c.mcause, nil
+	case CSR_MTVAL:
+		return c.mtval, nil
+	case CSR_MCYCLE, CSR_CYCLE:
+		return c.counter(c.cycle), nil
+	case CSR_MCYCLEH, CSR_CYCLEH:
+		return c.cycle >> 32, nil
+	case CSR_MINSTRET, CSR_INSTRET:
+		return c.counter(c.instret), nil
+	case CSR_MINSTRETH, CSR_INSTRETH:
+		return c.instret >> 32, nil
+	case CSR_MVENDORID, CSR_MARCHID, CSR_MIMPID:
+		// not implemented, which is allowed to be reported as zero
+		return 0, nil
+	case CSR_MHARTID:
+		return c.mhartid, nil
+	case CSR_SSTATUS:
+		return c.status() & sstatusReadable, nil
+	case CSR_SIE:
+		// only the delegated interrupts are visible in supervisor mode
+		return c.mie & c.mideleg, nil
+	case CSR_SIP:
+		return c.pending() & c.mideleg, nil
+	case CSR_STVEC:
+		return c.stvec, nil
+	case CSR_SCOUNTEREN:
+		return c.scounteren, nil
+	case CSR_SSCRATCH:
+		return c.sscratch, nil
+	case CSR_SEPC:
+		return c.sepc, nil
+	case CSR_SCAUSE:
+		return c.scause, nil
+	case CSR_STVAL:
+		return c.stval, nil
+	case CSR_SATP:
+		return c.satp, nil
+	case CSR_FFLAGS:
+		return uint64(c.fflags), nil
+	case CSR_FRM:
+		return uint64(c.frm), nil
+	case CSR_FCSR:
+		return uint64(c.frm<<5 | c.fflags), nil
+	}
+
+	return 0, IllegalCSRAccessError{Csr: csr}
+}
+
+func (c *CSRFile) Write(csr uint32, value uint64) error {
+	err := c.write(csr, value)
+	if err == nil && c.recordWrites {
+		c.written = append(c.written, csr)
+	}
+	return err
+}
+
+func (c *CSRFile) write(csr uint32, value uint64) error {
+	if isReadOnlyCsr(csr) || (c.rv64 && isHighHalfCsr(csr)) {
+		return IllegalCSRAccessError{Csr: csr, Write: true}
+	}
+
+	switch csr {
+	case CSR_MSTATUS:
+		// MPP=2 is a reserved mode, keep the old one when writing it
+		if (value&MSTATUS_MPP)>>MSTATUS_MPP_SHIFT == 2 {
+			value = (value &^ MSTATUS_MPP) | (c.mstatus & MSTATUS_MPP)
+		}
+		c.mstatus = value & mstatusWritable
+	case CSR_MSTATUSH:
+		// only little endian is supported
+	case CSR_MISA:
+		// WARL, the extensions can't be turned off so writes are ignored
+	case CSR_MEDELEG:
+		c.medeleg = value & medelegWritable
+	case CSR_MIDELEG:
+		c.mideleg = value & supervisorInterrupts
+	case CSR_MIE:
+		c.mie = value & (machineInterrupts | supervisorInterrupts)
+	case CSR_MIP:
+		// the machine interrupt pending bits are set by the interrupt
+		// sources and are read only for software, machine mode can raise
+		// the supervisor ones
+		c.mip = (c.mip &^ supervisorInterrupts) | (value & supervisorInterrupts)
+	case CSR_MTVEC:
+		c.mtvec = writeTvec(c.mtvec, value)
+	case CSR_MCOUNTEREN:
+		c.mcounteren = value & (COUNTEREN_CY | COUNTEREN_TM | COUNTEREN_IR)
+	case CSR_MSCRATCH:
+		c.mscratch = value
+	case CSR_MEPC:
+		// with the C extension instructions are 2 byte aligned, so the lowest bit is always zero
+		c.mepc = value &^ 1
+	case CSR_MCAUSE:
+		c.mcause = value
+	case CSR_MTVAL:
+		c.mtval = value
+	case CSR_MCYCLE:
+		c.cycle = writeCounter(c.cycle, value, c.rv64)
+	case CSR_MCYCLEH:
+		c.cycle = (c.cycle & 0xffffffff) | (value << 32)
+	case CSR_MINSTRET:
+		c.instret = writeCounter(c.instret, value, c.rv64)
+	case CSR_MINSTRETH:
+		c.instret = (c.instret & 0xffffffff) | (value << 32)
+	case CSR_SSTATUS:
+		c.mstatus = (c.mstatus &^ sstatusWritable) | (value & sstatusWritable)
+	case CSR_SIE:
+		c.mie = (c.mie &^ c.mideleg) | (value & c.mideleg)
+	case CSR_SIP:
+		// only the software interrupt can be raised from supervisor mode
+		writable := c.mideleg & MIP_SSIP
+		c.mip = (c.mip &^ writable) | (value & writable)
+	case CSR_STVEC:
+		c.stvec = writeTvec(c.stvec, value)
+	case CSR_SCOUNTEREN:
+		c.scounteren = value & (COUNTEREN_CY | COUNTEREN_TM | COUNTEREN_IR)
+	case CSR_SSCRATCH:
+		c.sscratch = value
+	case CSR_SEPC:
+		c.sepc = value &^ 1
+	case CSR_SCAUSE:
+		c.scause = value
+	case CSR_STVAL:
+		c.stval = value
+	case CSR_SATP:
+		// writing an unsupported mode has no effect at all, Sv39 is the
+		// only mode besides bare on RV64
+		if c.rv64 {
+			mode := value >> SATP64_MODE_SHIFT
+			if mode != 0 && mode != SATP_MODE_SV39 {
+				return nil
+			}
+		}
+		// the translations are cached in the TLB, so changing satp
+		// only takes effect for new ones until SFENCE.VMA
+		c.satp = value
+	case CSR_FFLAGS:
+		c.fflags = uint32(value) & FFLAGS_MASK
+	case CSR_FRM:
+		// invalid rounding modes can be written, the instructions that
+		// use them are illegal
+		c.frm = uint32(value) & FRM_MASK
+	case CSR_FCSR:
+		c.fflags = uint32(value) & FFLAGS_MASK
+		c.frm = uint32(value>>5) & FRM_MASK
+	default:
+		return IllegalCSRAccessError{Csr: csr, Write: true}
+	}
+
+	return nil
+}
+
+// accrueFlags sets the fp exception flags raised by an instruction.
+func (c *CSRFile) accrueFlags(flags uint32) {
+	c.fflags |= flags
+	if flags != 0 && c.recordWrites {
+		c.written = append(c.written, CSR_FFLAGS)
+	}
+}
+
+// writeCounter returns the counter after writing mcycle or minstret, on
+// RV32 only the lower half is written.
+func writeCounter(old uint64, value uint64, rv64 bool) uint64 {
+	if rv64 {
+		return value
+	}
+	return (old &^ 0xffffffff) | (value & 0xffffffff)
+}
+
+// writeTvec returns the new value of mtvec or stvec. Only direct (0) and
+// vectored (1) mode are valid, the old mode is kept when writing a reserved
+// one.
+func writeTvec(old uint64, value uint64) uint64 {
+	if value&MTVEC_MODE > 1 {
+		value = (value &^ MTVEC_MODE) | (old & MTVEC_MODE)
+	}
+	return value
+}
@@ -0,0 +1,277 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+func CheckCsr(csr uint32, expected uint64, r Registers, t *testing.T) {
+	value, err := r.Csrs().Read(csr)
+	if err != nil {
+		t.Logf("csr[%#x] can't be read, error=%v", csr, err)
+		t.Fail()
+	} else if value != expected {
+		t.Logf("csr[%#x]==%#x and should be %#x", csr, value, expected)
+		t.Fail()
+	}
+}
+
+func TestCSRRW(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.csr.mscratch = 5
+	r.reg[reg_a1] = 7
+
+	I := CreateCSRRW(reg_a0, CSR_MSCRATCH, reg_a1)
+	err := I.Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("csrrw failed with error %v", err)
+	}
+
+	CheckReg(reg_a0, 5, &r, t)
+	CheckCsr(CSR_MSCRATCH, 7, &r, t)
+	CheckPc(4, &r, t)
+}
+
+func TestCSRRSCSRRC(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.csr.mscratch = 5 // 0101
+	r.reg[reg_a1] = 3  // 0011
+
+	CreateCSRRS(reg_a0, CSR_MSCRATCH, reg_a1).Execute(&mem, &r)
+	CheckReg(reg_a0, 5, &r, t)
+	CheckCsr(CSR_MSCRATCH, 7, &r, t) // 0111
+
+	CreateCSRRC(reg_a0, CSR_MSCRATCH, reg_a1).Execute(&mem, &r)
+	CheckReg(reg_a0, 7, &r, t)
+	CheckCsr(CSR_MSCRATCH, 4, &r, t) // 0100
+}
+
+func TestCSRImmediate(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	CreateCSRRWI(reg_zero, CSR_MSCRATCH, 16).Execute(&mem, &r)
+	CheckCsr(CSR_MSCRATCH, 16, &r, t)
+
+	CreateCSRRSI(reg_a0, CSR_MSCRATCH, 3).Execute(&mem, &r)
+	CheckReg(reg_a0, 16, &r, t)
+	CheckCsr(CSR_MSCRATCH, 19, &r, t)
+
+	CreateCSRRCI(reg_a0, CSR_MSCRATCH, 17).Execute(&mem, &r)
+	CheckReg(reg_a0, 19, &r, t)
+	CheckCsr(CSR_MSCRATCH, 2, &r, t)
+}
+
+func TestCSRReadOnly(t *testing.T) {
+	r := RegistersImpl{csr: NewCSRFile(3, XLEN_32)}
+	mem := NewMemory(0)
+	r.reg[reg_a1] = 1
+
+	// reading a read only csr without writing is fine
+	err := CreateCSRRS(reg_a0, CSR_MHARTID, reg_zero).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("reading mhartid failed with error %v", err)
+	}
+	CheckReg(reg_a0, 3, &r, t)
+
+	// writing it is an illegal instruction
+	err = CreateCSRRW(reg_a0, CSR_MHARTID, reg_a1).Execute(&mem, &r)
+	var illegal IllegalCSRAccessError
+	if !errors.As(err, &illegal) {
+		t.Fatalf("writing mhartid should fail with IllegalCSRAccessError but got %v", err)
+	}
+	CheckCsr(CSR_MHARTID, 3, &r, t)
+
+	// as is accessing a csr that does not exist
+	err = CreateCSRRS(reg_a0, 0x7ff, reg_zero).Execute(&mem, &r)
+	if !errors.As(err, &illegal) {
+		t.Fatalf("reading an unknown csr should fail with IllegalCSRAccessError but got %v", err)
+	}
+}
+
+func TestCSRWarl(t *testing.T) {
+	c := CSRFile{}
+
+	c.Write(CSR_MISA, 0)
+	misa, _ := c.Read(CSR_MISA)
+	Assert(t, misa, MISA_MXL_32|misaExtensions)
+	Assert(t, misa&misaExtension('I'), misaExtension('I'))
+
+	// the fp state is always dirty, the other unsupported fields are zero
+	c.Write(CSR_MSTATUS, 0xffffffff)
+	mstatus, _ := c.Read(CSR_MSTATUS)
+	Assert(t, mstatus, mstatusWritable|MSTATUS_FS|MSTATUS_SD)
+
+	// MPP=2 is reserved, so MPP keeps its old value
+	c.Write(CSR_MSTATUS, 2<<MSTATUS_MPP_SHIFT)
+	mstatus, _ = c.Read(CSR_MSTATUS)
+	Assert(t, mstatus&MSTATUS_MPP, MSTATUS_MPP)
+
+	c.Write(CSR_MEPC, 0x80000003)
+	mepc, _ := c.Read(CSR_MEPC)
+	Assert(t, mepc, uint64(0x80000002))
+
+	c.Write(CSR_MTVEC, 0x80000001)
+	c.Write(CSR_MTVEC, 0x80000102)
+	mtvec, _ := c.Read(CSR_MTVEC)
+	Assert(t, mtvec, uint64(0x80000101))
+
+	// only the supervisor interrupts can be raised by software
+	c.Write(CSR_MIP, 0xffffffff)
+	mip, _ := c.Read(CSR_MIP)
+	Assert(t, mip, MIP_SSIP|MIP_STIP|MIP_SEIP)
+
+	c.Write(CSR_MEDELEG, 0xffffffff)
+	medeleg, _ := c.Read(CSR_MEDELEG)
+	Assert(t, medeleg&(1<<EXC_ECALL_M), uint64(0))
+	Assert(t, medeleg&(1<<EXC_ECALL_U), uint64(1<<EXC_ECALL_U))
+
+	// Sv32 and all asid bits are supported
+	c.Write(CSR_SATP, 0xffffffff)
+	satp, _ := c.Read(CSR_SATP)
+	Assert(t, satp, uint64(0xffffffff))
+}
+
+func TestCSRRV64(t *testing.T) {
+	c := NewCSRFile(0, XLEN_64)
+
+	misa, _ := c.Read(CSR_MISA)
+	Assert(t, misa, MISA_MXL_64|misaExtensions)
+
+	// UXL and SXL are read only and always 64
+	c.Write(CSR_MSTATUS, 0)
+	mstatus, _ := c.Read(CSR_MSTATUS)
+	Assert(t, mstatus, MSTATUS_FS|XL_64<<MSTATUS_UXL_SHIFT|XL_64<<MSTATUS_SXL_SHIFT|MSTATUS_SD64)
+	sstatus, _ := c.Read(CSR_SSTATUS)
+	Assert(t, sstatus, MSTATUS_FS|XL_64<<MSTATUS_UXL_SHIFT|MSTATUS_SD64)
+
+	// the counters are 64 bits wide and have no upper half
+	c.Write(CSR_MCYCLE, 0x123456789)
+	cycle, _ := c.Read(CSR_CYCLE)
+	Assert(t, cycle, uint64(0x123456789))
+	var illegal IllegalCSRAccessError
+	for _, csr := range []uint32{CSR_MSTATUSH, CSR_MCYCLEH, CSR_CYCLEH} {
+		_, err := c.Read(csr)
+		if !errors.As(err, &illegal) {
+			t.Errorf("reading csr[%#x] on RV64 should fail with IllegalCSRAccessError but got %v", csr, err)
+		}
+	}
+
+	// only bare and Sv39 are supported, other modes leave satp unchanged
+	c.Write(CSR_SATP, SATP_MODE_SV39<<SATP64_MODE_SHIFT|0x1234)
+	c.Write(CSR_SATP, 9<<SATP64_MODE_SHIFT|0x5678)
+	satp, _ := c.Read(CSR_SATP)
+	Assert(t, satp, SATP_MODE_SV39<<SATP64_MODE_SHIFT|0x1234)
+}
+
+func TestCSRSupervisorViews(t *testing.T) {
+	c := CSRFile{}
+
+	// sstatus is a view of mstatus without the machine mode fields
+	c.Write(CSR_MSTATUS, MSTATUS_MIE|MSTATUS_SIE|MSTATUS_TSR)
+	sstatus, _ := c.Read(CSR_SSTATUS)
+	Assert(t, sstatus, MSTATUS_SIE|MSTATUS_FS|MSTATUS_SD)
+	c.Write(CSR_SSTATUS, MSTATUS_SPP)
+	mstatus, _ := c.Read(CSR_MSTATUS)
+	Assert(t, mstatus, MSTATUS_MIE|MSTATUS_SPP|MSTATUS_TSR|MSTATUS_FS|MSTATUS_SD)
+
+	// sie and sip only show the delegated interrupts
+	c.Write(CSR_MIE, MIP_MTIP|MIP_STIP|MIP_SSIP)
+	c.Write(CSR_MIDELEG, MIP_STIP|MIP_MTIP)
+	sie, _ := c.Read(CSR_SIE)
+	Assert(t, sie, MIP_STIP)
+	c.Write(CSR_SIE, 0)
+	mie, _ := c.Read(CSR_MIE)
+	Assert(t, mie, MIP_MTIP|MIP_SSIP)
+
+	// the software interrupt can't be raised from supervisor mode unless
+	// it is delegated
+	c.Write(CSR_SIP, MIP_SSIP)
+	sip, _ := c.Read(CSR_MIP)
+	Assert(t, sip, uint64(0))
+	c.Write(CSR_MIDELEG, MIP_SSIP)
+	c.Write(CSR_SIP, MIP_SSIP)
+	sip, _ = c.Read(CSR_SIP)
+	Assert(t, sip, MIP_SSIP)
+}
+
+func TestCSRPrivilege(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+	var illegal IllegalCSRAccessError
+
+	// supervisor mode can't access the machine mode CSRs
+	r.csr.SetMode(MODE_S)
+	err := CreateCSRRS(reg_a0, CSR_MSTATUS, reg_zero).Execute(&mem, &r)
+	if !errors.As(err, &illegal) {
+		t.Fatalf("reading mstatus in supervisor mode should fail with IllegalCSRAccessError but got %v", err)
+	}
+	err = CreateCSRRW(reg_zero, CSR_SSCRATCH, reg_a1).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("writing sscratch in supervisor mode failed with error %v", err)
+	}
+
+	// TVM traps satp accesses in supervisor mode
+	r.csr.mstatus = MSTATUS_TVM
+	err = CreateCSRRS(reg_a0, CSR_SATP, reg_zero).Execute(&mem, &r)
+	if !errors.As(err, &illegal) {
+		t.Fatalf("reading satp with TVM should fail with IllegalCSRAccessError but got %v", err)
+	}
+
+	// the counters are only readable when enabled by all the more
+	// privileged modes
+	r.csr.SetMode(MODE_U)
+	r.csr.mcounteren = COUNTEREN_CY
+	err = CreateCSRRS(reg_a0, CSR_CYCLE, reg_zero).Execute(&mem, &r)
+	if !errors.As(err, &illegal) {
+		t.Fatalf("reading cycle without scounteren.CY should fail with IllegalCSRAccessError but got %v", err)
+	}
+	r.csr.scounteren = COUNTEREN_CY
+	err = CreateCSRRS(reg_a0, CSR_CYCLE, reg_zero).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("reading cycle in user mode failed with error %v", err)
+	}
+	err = CreateCSRRS(reg_a0, CSR_INSTRET, reg_zero).Execute(&mem, &r)
+	if !errors.As(err, &illegal) {
+		t.Fatalf("reading instret without mcounteren.IR should fail with IllegalCSRAccessError but got %v", err)
+	}
+}
+
+func TestCSRCounters(t *testing.T) {
+	c := CSRFile{}
+	c.Write(CSR_MINSTRET, 0xffffffff)
+	c.Retire()
+
+	instret, _ := c.Read(CSR_INSTRET)
+	instreth, _ := c.Read(CSR_MINSTRETH)
+	cycle, _ := c.Read(CSR_CYCLE)
+	Assert(t, instret, uint64(0))
+	Assert(t, instreth, uint64(1))
+	Assert(t, cycle, uint64(1))
+
+	err := c.Write(CSR_CYCLE, 0)
+	if err == nil {
+		t.Fatalf("the cycle csr should be read only")
+	}
+}
+
+func TestCSRFcsr(t *testing.T) {
+	c := CSRFile{}
+
+	c.Write(CSR_FCSR, 0xffffffff)
+	fcsr, _ := c.Read(CSR_FCSR)
+	Assert(t, fcsr, uint64(0xff))
+
+	c.Write(CSR_FFLAGS, uint64(FFLAGS_NX|FFLAGS_DZ))
+	c.Write(CSR_FRM, uint64(RM_RUP))
+	fflags, _ := c.Read(CSR_FFLAGS)
+	frm, _ := c.Read(CSR_FRM)
+	fcsr, _ = c.Read(CSR_FCSR)
+	Assert(t, fflags, uint64(FFLAGS_NX|FFLAGS_DZ))
+	Assert(t, frm, uint64(RM_RUP))
+	Assert(t, fcsr, uint64(RM_RUP<<5|FFLAGS_NX|FFLAGS_DZ))
+}
@@ -0,0 +1,122 @@
+package riscv
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// WatchKind is the kind of memory access a watchpoint stops on.
+type WatchKind int
+
+const (
+	WatchWrite WatchKind = iota
+	WatchRead
+	WatchAccess
+)
+
+// Watchpoint stops Run after an instruction accessed memory in
+// [Addr, Addr+Len). The addresses are physical, the reads of a page table
+// walk don't count.
+type Watchpoint struct {
+	Addr uint64
+	Len  uint64
+	Kind WatchKind
+}
+
+func (w Watchpoint) matches(addr uint64, numBytes uint32, write bool) bool {
+	if addr >= w.Addr+w.Len || w.Addr >= addr+uint64(numBytes) {
+		return false
+	}
+	switch w.Kind {
+	case WatchWrite:
+		return write
+	case WatchRead:
+		return !write
+	}
+	return true
+}
+
+// WatchHit is the access that hit a watchpoint.
+type WatchHit struct {
+	Watchpoint Watchpoint
+	Addr       uint64
+	Write      bool
+}
+
+// SetBreakpoint makes Run stop before executing the instruction at the
+// virtual address.
+func (e *Emulator) SetBreakpoint(addr uint64) {
+	if e.breakpoints == nil {
+		e.breakpoints = map[uint64]bool{}
+	}
+	e.breakpoints[addr] = true
+}
+
+// ClearBreakpoint removes the breakpoint, it returns false when there was
+// none at the address.
+func (e *Emulator) ClearBreakpoint(addr uint64) bool {
+	if !e.breakpoints[addr] {
+		return false
+	}
+	delete(e.breakpoints, addr)
+	return true
+}
+
+// Breakpoints returns the addresses of the breakpoints in ascending order.
+func (e *Emulator) Breakpoints() []uint64 {
+	addrs := make([]uint64, 0, len(e.breakpoints))
+	for addr := range e.breakpoints {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return addrs[i] < addrs[j] })
+	return addrs
+}
+
+// SetWatchpoint makes Run stop after an instruction accessed the watched
+// memory.
+func (e *Emulator) SetWatchpoint(w Watchpoint) {
+	e.watchpoints = append(e.watchpoints, w)
+}
+
+// ClearWatchpoint removes the watchpoint, it returns false when it was not
+// set.
+func (e *Emulator) ClearWatchpoint(w Watchpoint) bool {
+	for i, other := range e.watchpoints {
+		if other == w {
+			e.watchpoints = append(e.watchpoints[:i], e.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// WatchHit returns the access of the last instruction that hit a
+// watchpoint, or nil.
+func (e *Emulator) WatchHit() *WatchHit {
+	return e.watchHit
+}
+
+// Interrupt makes Run stop before the next instruction, it's safe to call
+// from another goroutine (e.g. when the user hits Ctrl-C in the debugger).
+func (e *Emulator) Interrupt() {
+	atomic.StoreInt32(&e.interrupted, 1)
+}
+
+// ClearInterrupt drops an Interrupt that came too late to stop Run, so the
+// next Run doesn't stop before its first instruction.
+func (e *Emulator) ClearInterrupt() {
+	atomic.StoreInt32(&e.interrupted, 0)
+}
+
+// checkWatchpoints returns the first access of the last instruction that
+// hit a watchpoint, or nil.
+func (e *Emulator) checkWatchpoints(accesses []MemoryAccess) *WatchHit {
+	for _, a := range accesses {
+		for _, w := range e.watchpoints {
+			if w.matches(a.Paddr, a.NumBytes, a.Write) {
+				return &WatchHit{Watchpoint: w, Addr: a.Paddr, Write: a.Write}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package riscv
+
+import (
+	"testing"
+)
+
+var debugProgram = []uint32{
+	0x00300513, // addi a0, zero, 3
+	0x02a02023, // sw a0, 32(zero)
+	0x02002583, // lw a1, 32(zero)
+	0x0000006f, // j .
+}
+
+func TestRunBreakpoint(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, debugProgram)
+	e, r := newTestEmulator(&mem)
+
+	e.SetBreakpoint(8)
+	e.SetBreakpoint(4)
+	Assert(t, len(e.Breakpoints()), 2)
+	Assert(t, e.Breakpoints()[0], uint64(4))
+
+	reason, err := e.Run(0)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltBreakpoint)
+	CheckPc(4, r, t)
+
+	// running again continues from the breakpoint
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltBreakpoint)
+	CheckPc(8, r, t)
+
+	Assert(t, e.ClearBreakpoint(8), true)
+	Assert(t, e.ClearBreakpoint(8), false)
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_a1, 3, r, t)
+}
+
+func TestRunWatchpoint(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, debugProgram)
+	e, r := newTestEmulator(&mem)
+
+	read := Watchpoint{Addr: 34, Len: 1, Kind: WatchRead}
+	e.SetWatchpoint(read)
+	e.SetWatchpoint(Watchpoint{Addr: 32, Len: 4, Kind: WatchWrite})
+
+	reason, _ := e.Run(0)
+	Assert(t, reason, HaltWatchpoint)
+	// the store already happened
+	CheckPc(8, r, t)
+	CheckMem(32, 3, &mem, t)
+	Assert(t, *e.WatchHit(), WatchHit{Watchpoint: Watchpoint{Addr: 32, Len: 4, Kind: WatchWrite}, Addr: 32, Write: true})
+
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltWatchpoint)
+	CheckPc(12, r, t)
+	Assert(t, e.WatchHit().Watchpoint, read)
+	Assert(t, e.WatchHit().Write, false)
+
+	Assert(t, e.ClearWatchpoint(read), true)
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltSelfLoop)
+	if e.WatchHit() != nil {
+		t.Errorf("the jump should not hit a watchpoint")
+	}
+}
+
+func TestRunInterrupted(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, debugProgram)
+	e, r := newTestEmulator(&mem)
+
+	e.Interrupt()
+	reason, _ := e.Run(0)
+	Assert(t, reason, HaltInterrupted)
+	CheckPc(0, r, t)
+
+	// the interrupt is only reported once
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltSelfLoop)
+
+	r.SetPc(0)
+	e.Interrupt()
+	e.ClearInterrupt()
+	reason, _ = e.Run(0)
+	Assert(t, reason, HaltSelfLoop)
+}
@@ -0,0 +1,605 @@
+package riscv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Disassembler prints instructions like objdump does, with the ABI register
+// names and the pseudo-instructions, in the syntax the assembler reads. The
+// targets of jumps and branches are absolute addresses with the symbol they
+// are in.
+type Disassembler struct {
+	xlen    int
+	symbols *SymbolTable
+	// Print the instructions instead of the pseudo-instructions they
+	// implement, like objdump -M no-aliases.
+	NoAliases bool
+}
+
+// NewDisassembler returns a disassembler of RV32 or RV64 instructions, the
+// symbols can be nil.
+func NewDisassembler(xlen int, symbols *SymbolTable) *Disassembler {
+	return &Disassembler{xlen: xlen, symbols: symbols}
+}
+
+// Disassemble returns the instruction at pc in assembly. The encodings
+// which aren't instructions of the XLEN, like reserved fields which aren't
+// zero, are printed as the .4byte (or .2byte) directive of the word.
+func (d *Disassembler) Disassemble(instr Instruction, pc uint64) string {
+	// compressed instructions are printed as the instruction they expand to
+	if c, ok := instr.(CInstr); ok {
+		if text := d.disassemble(c.instr, pc); text != "" {
+			return text
+		}
+		return fmt.Sprintf(".2byte %#04x", c.raw)
+	}
+	if text := d.disassemble(instr, pc); text != "" {
+		return text
+	}
+	return fmt.Sprintf(".4byte %#08x", instr.Encode())
+}
+
+// disassemble returns "" for invalid encodings.
+func (d *Disassembler) disassemble(instr Instruction, pc uint64) string {
+	switch instr := instr.(type) {
+	case RInstr:
+		switch instr.opcode {
+		case OP, OP_32:
+			return d.op(instr)
+		case AMO:
+			return d.amo(instr)
+		case OP_FP:
+			return d.opFp(instr)
+		}
+	case IInstr:
+		switch instr.opcode {
+		case OP_IMM, OP_IMM_32:
+			return d.opImm(instr)
+		case JALR:
+			return d.jalr(instr)
+		case LOAD:
+			return d.load(instr)
+		case LOAD_FP:
+			return d.loadFp(instr)
+		case MISC_MEM:
+			return d.miscMem(instr)
+		case SYSTEM:
+			return d.system(instr)
+		}
+	case SInstr:
+		return d.store(instr)
+	case BInstr:
+		return d.branch(instr, pc)
+	case UInstr:
+		name := "lui"
+		if instr.opcode == AUIPC {
+			name = "auipc"
+		}
+		// the immediate of a negative c.lui is sign extended
+		return asm(name, intReg(instr.rd), fmt.Sprintf("%#x", instr.imm&0xfffff))
+	case JInstr:
+		return d.jal(instr, pc)
+	case R4Instr:
+		return d.fma(instr)
+	}
+	return ""
+}
+
+// asm joins the mnemonic and the operands.
+func asm(name string, args ...string) string {
+	if len(args) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(args, ", ")
+}
+
+// intReg returns the ABI name of the integer register.
+func intReg(reg int) string {
+	return RegisterNames[reg]
+}
+
+// fpReg returns the ABI name of the fp register.
+func fpReg(reg int) string {
+	return FRegisterNames[reg]
+}
+
+func memOperand(offset int32, rs1 int) string {
+	return fmt.Sprintf("%d(%s)", offset, intReg(rs1))
+}
+
+// signedImm returns the 12 bit immediate of the instruction.
+func (Inst IInstr) signedImm() int32 {
+	return int32(sext(Inst.imm&0xfff, 11))
+}
+
+// target returns the address pc+offset with the symbol it's in.
+func (d *Disassembler) target(pc uint64, offset int32) string {
+	return d.symbols.AddrString((pc + uint64(int64(offset))) & xlenMask(d.xlen))
+}
+
+func (d *Disassembler) rv64() bool {
+	return d.xlen == XLEN_64
+}
+
+func (d *Disassembler) op(instr RInstr) string {
+	word := instr.opcode == OP_32
+	var names map[int8]string
+	switch {
+	case instr.func7 == 0 && !word:
+		names = map[int8]string{FUNC3_ADD: "add", FUNC3_SLL: "sll", FUNC3_SLT: "slt", FUNC3_SLTU: "sltu",
+			FUNC3_XOR: "xor", FUNC3_SRL: "srl", FUNC3_OR: "or", FUNC3_AND: "and"}
+	case instr.func7 == 0:
+		names = map[int8]string{FUNC3_ADD: "addw", FUNC3_SLL: "sllw", FUNC3_SRL: "srlw"}
+	case instr.func7 == 0x20 && !word:
+		names = map[int8]string{FUNC3_SUB: "sub", FUNC3_SRA: "sra"}
+	case instr.func7 == 0x20:
+		names = map[int8]string{FUNC3_SUB: "subw", FUNC3_SRA: "sraw"}
+	case instr.func7 == 1 && !word:
+		names = map[int8]string{FUNC3_MUL: "mul", FUNC3_MULH: "mulh", FUNC3_MULHSU: "mulhsu", FUNC3_MULHU: "mulhu",
+			FUNC3_DIV: "div", FUNC3_DIVU: "divu", FUNC3_REM: "rem", FUNC3_REMU: "remu"}
+	case instr.func7 == 1:
+		names = map[int8]string{FUNC3_MUL: "mulw", FUNC3_DIV: "divw", FUNC3_DIVU: "divuw", FUNC3_REM: "remw", FUNC3_REMU: "remuw"}
+	}
+	name, ok := names[instr.func3]
+	if !ok {
+		return ""
+	}
+	if !d.NoAliases {
+		switch {
+		case (name == "sub" || name == "subw") && instr.rs1 == reg_zero:
+			return asm(strings.Replace(name, "sub", "neg", 1), intReg(instr.rd), intReg(instr.rs2))
+		case name == "sltu" && instr.rs1 == reg_zero:
+			return asm("snez", intReg(instr.rd), intReg(instr.rs2))
+		case name == "slt" && instr.rs2 == reg_zero:
+			return asm("sltz", intReg(instr.rd), intReg(instr.rs1))
+		case name == "slt" && instr.rs1 == reg_zero:
+			return asm("sgtz", intReg(instr.rd), intReg(instr.rs2))
+		}
+	}
+	return asm(name, intReg(instr.rd), intReg(instr.rs1), intReg(instr.rs2))
+}
+
+func (d *Disassembler) opImm(instr IInstr) string {
+	word := instr.opcode == OP_IMM_32
+	suffix := ""
+	if word {
+		suffix = "w"
+	}
+	imm := instr.signedImm()
+	rd, rs1 := intReg(instr.rd), intReg(instr.rs1)
+	switch instr.func3 {
+	case FUNC3_SLLI, FUNC3_SRLI:
+		// the shift amount is 5 bits, or 6 bits for the shifts of RV64,
+		// the bits above it tell srli and srai apart
+		shamtBits := uint32(5)
+		if d.rv64() && !word {
+			shamtBits = 6
+		}
+		shamt := instr.imm & (1<<shamtBits - 1)
+		switch funct := instr.imm & 0xfff >> shamtBits; {
+		case funct == 0 && instr.func3 == FUNC3_SLLI:
+			return asm("slli"+suffix, rd, rs1, fmt.Sprint(shamt))
+		case funct == 0:
+			return asm("srli"+suffix, rd, rs1, fmt.Sprint(shamt))
+		case funct == 0x400>>shamtBits && instr.func3 == FUNC3_SRAI:
+			return asm("srai"+suffix, rd, rs1, fmt.Sprint(shamt))
+		}
+		return ""
+	}
+	if word {
+		if instr.func3 != FUNC3_ADDI {
+			return ""
+		}
+		if imm == 0 && !d.NoAliases {
+			return asm("sext.w", rd, rs1)
+		}
+		return asm("addiw", rd, rs1, fmt.Sprint(imm))
+	}
+	if !d.NoAliases {
+		switch {
+		case instr.func3 == FUNC3_ADDI && instr.rd == reg_zero && instr.rs1 == reg_zero && imm == 0:
+			return "nop"
+		case instr.func3 == FUNC3_ADDI && instr.rs1 == reg_zero:
+			return asm("li", rd, fmt.Sprint(imm))
+		case instr.func3 == FUNC3_ADDI && imm == 0:
+			return asm("mv", rd, rs1)
+		case instr.func3 == FUNC3_XORI && imm == -1:
+			return asm("not", rd, rs1)
+		case instr.func3 == FUNC3_SLTIU && imm == 1:
+			return asm("seqz", rd, rs1)
+		}
+	}
+	name := map[int8]string{FUNC3_ADDI: "addi", FUNC3_SLTI: "slti", FUNC3_SLTIU: "sltiu", FUNC3_XORI: "xori",
+		FUNC3_ORI: "ori", FUNC3_ANDI: "andi"}[instr.func3]
+	return asm(name, rd, rs1, fmt.Sprint(imm))
+}
+
+func (d *Disassembler) jal(instr JInstr, pc uint64) string {
+	target := d.target(pc, int32(instr.Imm()))
+	switch {
+	case d.NoAliases:
+		return asm("jal", intReg(instr.rd), target)
+	case instr.rd == reg_zero:
+		return asm("j", target)
+	case instr.rd == reg_ra:
+		return asm("jal", target)
+	}
+	return asm("jal", intReg(instr.rd), target)
+}
+
+func (d *Disassembler) jalr(instr IInstr) string {
+	if instr.func3 != 0 {
+		return ""
+	}
+	imm := instr.signedImm()
+	if !d.NoAliases {
+		switch {
+		case instr.rd == reg_zero && instr.rs1 == reg_ra && imm == 0:
+			return "ret"
+		case instr.rd == reg_zero && imm == 0:
+			return asm("jr", intReg(instr.rs1))
+		case instr.rd == reg_zero:
+			return asm("jr", memOperand(imm, instr.rs1))
+		case instr.rd == reg_ra && imm == 0:
+			return asm("jalr", intReg(instr.rs1))
+		}
+	}
+	return asm("jalr", intReg(instr.rd), memOperand(imm, instr.rs1))
+}
+
+func (d *Disassembler) branch(instr BInstr, pc uint64) string {
+	name, ok := map[uint32]string{FUNC3_BEQ: "beq", FUNC3_BNE: "bne", FUNC3_BLT: "blt", FUNC3_BGE: "bge",
+		FUNC3_BLTU: "bltu", FUNC3_BGEU: "bgeu"}[instr.func3]
+	if !ok {
+		return ""
+	}
+	target := d.target(pc, instr.immSigned())
+	if !d.NoAliases {
+		// the comparisons with zero
+		switch {
+		case instr.rs2 == reg_zero && (name == "beq" || name == "bne" || name == "blt" || name == "bge"):
+			return asm(name+"z", intReg(instr.rs1), target)
+		case instr.rs1 == reg_zero && name == "blt":
+			return asm("bgtz", intReg(instr.rs2), target)
+		case instr.rs1 == reg_zero && name == "bge":
+			return asm("blez", intReg(instr.rs2), target)
+		}
+	}
+	return asm(name, intReg(instr.rs1), intReg(instr.rs2), target)
+}
+
+func (d *Disassembler) load(instr IInstr) string {
+	names := map[int8]string{FUNC3_LB: "lb", FUNC3_LH: "lh", FUNC3_LW: "lw", FUNC3_LBU: "lbu", FUNC3_LHU: "lhu"}
+	if d.rv64() {
+		names[FUNC3_LD] = "ld"
+		names[FUNC3_LWU] = "lwu"
+	}
+	name, ok := names[instr.func3]
+	if !ok {
+		return ""
+	}
+	return asm(name, intReg(instr.rd), memOperand(instr.signedImm(), instr.rs1))
+}
+
+func (d *Disassembler) store(instr SInstr) string {
+	offset := int32(sext(instr.imm(), 11))
+	if instr.opcode == STORE_FP {
+		name, ok := map[int8]string{FUNC3_FSW: "fsw", FUNC3_FSD: "fsd"}[instr.func3]
+		if !ok {
+			return ""
+		}
+		return asm(name, fpReg(instr.rs2), memOperand(offset, instr.rs1))
+	}
+	names := map[int8]string{FUNC3_SB: "sb", FUNC3_SH: "sh", FUNC3_SW: "sw"}
+	if d.rv64() {
+		names[FUNC3_SD] = "sd"
+	}
+	name, ok := names[instr.func3]
+	if !ok {
+		return ""
+	}
+	return asm(name, intReg(instr.rs2), memOperand(offset, instr.rs1))
+}
+
+// fenceOperand returns the fence operand of the pred or succ bits, like rw.
+func fenceOperand(set uint32) string {
+	s := ""
+	for _, bit := range []struct {
+		mask uint32
+		name string
+	}{{FENCE_I, "i"}, {FENCE_O, "o"}, {FENCE_R, "r"}, {FENCE_W, "w"}} {
+		if set&bit.mask != 0 {
+			s += bit.name
+		}
+	}
+	if s == "" {
+		return "0"
+	}
+	return s
+}
+
+func (d *Disassembler) miscMem(instr IInstr) string {
+	if instr.rd != reg_zero || instr.rs1 != reg_zero {
+		return ""
+	}
+	switch instr.func3 {
+	case FUNC3_FENCE_I:
+		if instr.imm != 0 {
+			return ""
+		}
+		return "fence.i"
+	case FUNC3_FENCE:
+		fm, pred, succ := instr.imm>>8&0xf, instr.imm>>4&0xf, instr.imm&0xf
+		all := FENCE_I | FENCE_O | FENCE_R | FENCE_W
+		switch {
+		case fm == FENCE_FM_TSO && pred == FENCE_R|FENCE_W && succ == FENCE_R|FENCE_W:
+			return "fence.tso"
+		case fm != 0:
+			return ""
+		case d.NoAliases:
+		case pred == all && succ == all:
+			return "fence"
+		case pred == FENCE_W && succ == 0:
+			return "pause"
+		}
+		return asm("fence", fenceOperand(pred), fenceOperand(succ))
+	}
+	return ""
+}
+
+// csrOperand returns the name of the CSR, or its number.
+func csrOperand(csr uint32) string {
+	switch csr {
+	case csrTime:
+		return "time"
+	case csrTimeh:
+		return "timeh"
+	}
+	return CsrName(csr)
+}
+
+func (d *Disassembler) system(instr IInstr) string {
+	if instr.func3 == FUNC3_PRIV {
+		if instr.rd != reg_zero {
+			return ""
+		}
+		if instr.imm>>5 == FUNCT7_SFENCE_VMA {
+			rs2 := int(instr.imm & 0x1f)
+			switch {
+			case d.NoAliases:
+			case instr.rs1 == reg_zero && rs2 == reg_zero:
+				return "sfence.vma"
+			case rs2 == reg_zero:
+				return asm("sfence.vma", intReg(instr.rs1))
+			}
+			return asm("sfence.vma", intReg(instr.rs1), intReg(rs2))
+		}
+		if instr.rs1 != reg_zero {
+			return ""
+		}
+		name, ok := map[uint32]string{PRIV_ECALL: "ecall", PRIV_EBREAK: "ebreak", PRIV_SRET: "sret",
+			PRIV_WFI: "wfi", PRIV_MRET: "mret"}[instr.imm]
+		if !ok {
+			return ""
+		}
+		return name
+	}
+
+	name, ok := map[int8]string{FUNC3_CSRRW: "csrrw", FUNC3_CSRRS: "csrrs", FUNC3_CSRRC: "csrrc",
+		FUNC3_CSRRWI: "csrrwi", FUNC3_CSRRSI: "csrrsi", FUNC3_CSRRCI: "csrrci"}[instr.func3]
+	if !ok {
+		return ""
+	}
+	number := instr.imm & 0xfff
+	rd, src := intReg(instr.rd), intReg(instr.rs1)
+	if instr.func3 >= FUNC3_CSRRWI {
+		// rs1 is the 5 bit immediate
+		src = fmt.Sprint(instr.rs1)
+	}
+	if d.NoAliases {
+		return asm(name, rd, csrOperand(number), src)
+	}
+
+	// the counters and the fp CSRs have their own pseudo-instructions
+	counters := map[uint32]string{CSR_CYCLE: "rdcycle", csrTime: "rdtime", CSR_INSTRET: "rdinstret"}
+	if !d.rv64() {
+		counters[CSR_CYCLEH] = "rdcycleh"
+		counters[csrTimeh] = "rdtimeh"
+		counters[CSR_INSTRETH] = "rdinstreth"
+	}
+	floatCsrs := map[uint32]string{CSR_FCSR: "csr", CSR_FRM: "rm", CSR_FFLAGS: "flags"}
+	switch {
+	case name == "csrrs" && instr.rs1 == reg_zero:
+		if counter, ok := counters[number]; ok {
+			return asm(counter, rd)
+		}
+		if floatCsr, ok := floatCsrs[number]; ok {
+			return asm("fr"+floatCsr, rd)
+		}
+		return asm("csrr", rd, csrOperand(number))
+	case name == "csrrw" && floatCsrs[number] != "":
+		if instr.rd == reg_zero {
+			return asm("fs"+floatCsrs[number], src)
+		}
+		return asm("fs"+floatCsrs[number], rd, src)
+	case instr.rd == reg_zero:
+		// csrrw zero, csr, rs1 is csrw csr, rs1
+		return asm(strings.Replace(name, "csrr", "csr", 1), csrOperand(number), src)
+	}
+	return asm(name, rd, csrOperand(number), src)
+}
+
+func (d *Disassembler) amo(instr RInstr) string {
+	var width string
+	switch {
+	case instr.func3 == FUNC3_AMO_W:
+		width = ".w"
+	case instr.func3 == FUNC3_AMO_D && d.rv64():
+		width = ".d"
+	default:
+		return ""
+	}
+	funct5 := uint32(instr.func7) >> 2
+	ordering := []string{"", ".rl", ".aq", ".aqrl"}[instr.func7&3]
+	addr := "(" + intReg(instr.rs1) + ")"
+	if funct5 == FUNCT5_LR {
+		if instr.rs2 != reg_zero {
+			return ""
+		}
+		return asm("lr"+width+ordering, intReg(instr.rd), addr)
+	}
+	name, ok := map[uint32]string{FUNCT5_SC: "sc", FUNCT5_AMOSWAP: "amoswap", FUNCT5_AMOADD: "amoadd",
+		FUNCT5_AMOXOR: "amoxor", FUNCT5_AMOAND: "amoand", FUNCT5_AMOOR: "amoor", FUNCT5_AMOMIN: "amomin",
+		FUNCT5_AMOMAX: "amomax", FUNCT5_AMOMINU: "amominu", FUNCT5_AMOMAXU: "amomaxu"}[funct5]
+	if !ok {
+		return ""
+	}
+	return asm(name+width+ordering, intReg(instr.rd), intReg(instr.rs2), addr)
+}
+
+func (d *Disassembler) loadFp(instr IInstr) string {
+	name, ok := map[int8]string{FUNC3_FLW: "flw", FUNC3_FLD: "fld"}[instr.func3]
+	if !ok {
+		return ""
+	}
+	return asm(name, fpReg(instr.rd), memOperand(instr.signedImm(), instr.rs1))
+}
+
+// formatSuffix returns the suffix of the fmt field, .s or .d.
+func formatSuffix(format int8) (string, bool) {
+	switch format {
+	case FMT_S:
+		return ".s", true
+	case FMT_D:
+		return ".d", true
+	}
+	return "", false
+}
+
+// rmOperand appends the rounding mode operand to the operands, the
+// default one is left out: dyn, or rne for the exact conversions.
+func rmOperand(args []string, rm int8, exact bool) ([]string, bool) {
+	switch {
+	case uint32(rm) == RM_DYN && !exact, uint32(rm) == RM_RNE && exact:
+		return args, true
+	case uint32(rm) > RM_RMM && uint32(rm) != RM_DYN:
+		return nil, false
+	}
+	return append(args, []string{"rne", "rtz", "rdn", "rup", "rmm", "", "", "dyn"}[rm]), true
+}
+
+func (d *Disassembler) opFp(instr RInstr) string {
+	funct5 := uint32(instr.func7) >> 2
+	suffix, ok := formatSuffix(instr.func7 & 3)
+	if !ok {
+		return ""
+	}
+	withRm := func(name string, exact bool, args ...string) string {
+		args, ok := rmOperand(args, instr.func3, exact)
+		if !ok {
+			return ""
+		}
+		return asm(name, args...)
+	}
+	// the integer registers of the conversions
+	integers := map[int]string{FCVT_W: "w", FCVT_WU: "wu"}
+	if d.rv64() {
+		integers[FCVT_L] = "l"
+		integers[FCVT_LU] = "lu"
+	}
+	fd, fs1, fs2 := fpReg(instr.rd), fpReg(instr.rs1), fpReg(instr.rs2)
+
+	switch funct5 {
+	case FUNCT5_FADD, FUNCT5_FSUB, FUNCT5_FMUL, FUNCT5_FDIV:
+		name := []string{"fadd", "fsub", "fmul", "fdiv"}[funct5]
+		return withRm(name+suffix, false, fd, fs1, fs2)
+	case FUNCT5_FSQRT:
+		if instr.rs2 != 0 {
+			return ""
+		}
+		return withRm("fsqrt"+suffix, false, fd, fs1)
+	case FUNCT5_FSGNJ:
+		name, ok := map[int8]string{FUNC3_FSGNJ: "fsgnj", FUNC3_FSGNJN: "fsgnjn", FUNC3_FSGNJX: "fsgnjx"}[instr.func3]
+		if !ok {
+			return ""
+		}
+		if instr.rs1 == instr.rs2 && !d.NoAliases {
+			// fmv, fneg and fabs are fsgnj with the same source twice
+			alias := map[string]string{"fsgnj": "fmv", "fsgnjn": "fneg", "fsgnjx": "fabs"}[name]
+			return asm(alias+suffix, fd, fs1)
+		}
+		return asm(name+suffix, fd, fs1, fs2)
+	case FUNCT5_FMINMAX:
+		name, ok := map[int8]string{FUNC3_FMIN: "fmin", FUNC3_FMAX: "fmax"}[instr.func3]
+		if !ok {
+			return ""
+		}
+		return asm(name+suffix, fd, fs1, fs2)
+	case FUNCT5_FCVT_FF:
+		// fmt is the destination and rs2 the source format
+		from, ok := formatSuffix(int8(instr.rs2))
+		if !ok || from == suffix {
+			return ""
+		}
+		return withRm("fcvt"+suffix+from, suffix == ".d", fd, fs1)
+	case FUNCT5_FCMP:
+		name, ok := map[int8]string{FUNC3_FLE: "fle", FUNC3_FLT: "flt", FUNC3_FEQ: "feq"}[instr.func3]
+		if !ok {
+			return ""
+		}
+		return asm(name+suffix, intReg(instr.rd), fs1, fs2)
+	case FUNCT5_FCVT_W:
+		integer, ok := integers[instr.rs2]
+		if !ok {
+			return ""
+		}
+		return withRm("fcvt."+integer+suffix, false, intReg(instr.rd), fs1)
+	case FUNCT5_FCVT_F_W:
+		integer, ok := integers[instr.rs2]
+		if !ok {
+			return ""
+		}
+		// a word converted to a double is exact
+		exact := suffix == ".d" && (integer == "w" || integer == "wu")
+		return withRm("fcvt"+suffix+"."+integer, exact, fd, intReg(instr.rs1))
+	case FUNCT5_FMV_X:
+		if instr.rs2 != 0 {
+			return ""
+		}
+		switch {
+		case instr.func3 == FUNC3_FCLASS:
+			return asm("fclass"+suffix, intReg(instr.rd), fs1)
+		case instr.func3 != FUNC3_FMV_X:
+			return ""
+		case suffix == ".s":
+			return asm("fmv.x.w", intReg(instr.rd), fs1)
+		case d.rv64():
+			return asm("fmv.x.d", intReg(instr.rd), fs1)
+		}
+	case FUNCT5_FMV_F:
+		if instr.rs2 != 0 || instr.func3 != 0 {
+			return ""
+		}
+		switch {
+		case suffix == ".s":
+			return asm("fmv.w.x", fd, intReg(instr.rs1))
+		case d.rv64():
+			return asm("fmv.d.x", fd, intReg(instr.rs1))
+		}
+	}
+	return ""
+}
+
+func (d *Disassembler) fma(instr R4Instr) string {
+	suffix, ok := formatSuffix(instr.format)
+	if !ok {
+		return ""
+	}
+	name := map[int8]string{MADD: "fmadd", MSUB: "fmsub", NMSUB: "fnmsub", NMADD: "fnmadd"}[instr.opcode]
+	args, ok := rmOperand([]string{fpReg(instr.rd), fpReg(instr.rs1), fpReg(instr.rs2), fpReg(instr.rs3)}, instr.func3, false)
+	if !ok {
+		return ""
+	}
+	return asm(name+suffix, args...)
+}
@@ -0,0 +1,242 @@
+package riscv
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"testing"
+)
+
+// Every instruction the decoder accepts disassembles to assembly which
+// assembles to the same word, with and without the pseudo-instructions.
+func TestDisassembleRoundTrip(t *testing.T) {
+	pc := uint64(0x80000000)
+	for _, xlen := range []int{XLEN_32, XLEN_64} {
+		d := NewDecoder()
+		d.RegisterBaseInstructionSet()
+		if xlen == XLEN_64 {
+			d.RegisterRV64InstructionSet()
+		}
+		for _, noAliases := range []bool{false, true} {
+			dis := NewDisassembler(xlen, nil)
+			dis.NoAliases = noAliases
+			for opcode := range d.OpcodeToInstrType {
+				for _, word := range randomWords(opcode, 1000) {
+					instr, err := d.Decode(word)
+					if err != nil {
+						continue
+					}
+					text := dis.Disassemble(instr, pc)
+					p, err := Assemble(text, xlen, pc)
+					if err != nil {
+						t.Fatalf("%#08x disassembled to %q which can't be assembled with error %v", word, text, err)
+					}
+					if len(p.Binary()) != 4 || binary.LittleEndian.Uint32(p.Binary()) != word {
+						t.Fatalf("%#08x disassembled to %q which assembles to %x", word, text, p.Binary())
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	for _, test := range []struct {
+		xlen      int
+		source    string
+		expected  string
+		noAliases bool
+	}{
+		{XLEN_32, "addi a0, zero, 104", "li a0, 104", false},
+		{XLEN_32, "addi a0, zero, 104", "addi a0, zero, 104", true},
+		{XLEN_32, "addi zero, zero, 0", "nop", false},
+		{XLEN_32, "addi sp, sp, -16", "addi sp, sp, -16", false},
+		{XLEN_32, "addi a0, a1, 0", "mv a0, a1", false},
+		{XLEN_32, "xori a0, a1, -1", "not a0, a1", false},
+		{XLEN_32, "sub a0, zero, a1", "neg a0, a1", false},
+		{XLEN_32, "sltiu a0, a1, 1", "seqz a0, a1", false},
+		{XLEN_32, "sltu a0, zero, a1", "snez a0, a1", false},
+		{XLEN_32, "lui a1, 0x10000", "lui a1, 0x10000", false},
+		{XLEN_32, "srai a0, a1, 31", "srai a0, a1, 31", false},
+		{XLEN_64, "srai a0, a1, 63", "srai a0, a1, 63", false},
+		{XLEN_64, "addiw a0, a1, 0", "sext.w a0, a1", false},
+		{XLEN_32, "sb a0, (a1)", "sb a0, 0(a1)", false},
+		{XLEN_32, "lw ra, 12(sp)", "lw ra, 12(sp)", false},
+		{XLEN_64, "ld s0, -8(s0)", "ld s0, -8(s0)", false},
+		{XLEN_32, "j .+8", "j 0x80000008", false},
+		{XLEN_32, "j .+8", "jal zero, 0x80000008", true},
+		{XLEN_32, "jal .-4", "jal 0x7ffffffc", false},
+		{XLEN_32, "jal t0, .", "jal t0, 0x80000000", false},
+		{XLEN_32, "jalr zero, 0(ra)", "ret", false},
+		{XLEN_32, "jalr zero, 0(ra)", "jalr zero, 0(ra)", true},
+		{XLEN_32, "jalr zero, 4(a0)", "jr 4(a0)", false},
+		{XLEN_32, "jalr ra, 0(a0)", "jalr a0", false},
+		{XLEN_32, "beq a0, zero, .+16", "beqz a0, 0x80000010", false},
+		{XLEN_32, "bge zero, a0, .-16", "blez a0, 0x7ffffff0", false},
+		{XLEN_32, "bltu a0, a1, .+2048", "bltu a0, a1, 0x80000800", false},
+		{XLEN_32, "csrrs a0, mstatus, zero", "csrr a0, mstatus", false},
+		{XLEN_32, "csrrw zero, mtvec, t0", "csrw mtvec, t0", false},
+		{XLEN_32, "csrrsi zero, mie, 8", "csrsi mie, 8", false},
+		{XLEN_32, "csrrs a0, 0x7c0, a1", "csrrs a0, 0x7c0, a1", false},
+		{XLEN_32, "csrrs a0, cycleh, zero", "rdcycleh a0", false},
+		{XLEN_32, "csrrs a0, time, zero", "rdtime a0", false},
+		{XLEN_32, "csrrw a0, frm, a1", "fsrm a0, a1", false},
+		{XLEN_32, "csrrs a0, fflags, zero", "frflags a0", false},
+		{XLEN_32, "ecall", "ecall", false},
+		{XLEN_32, "mret", "mret", false},
+		{XLEN_32, "sfence.vma a0", "sfence.vma a0", false},
+		{XLEN_32, "fence iorw, iorw", "fence", false},
+		{XLEN_32, "fence rw, w", "fence rw, w", false},
+		{XLEN_32, "fence w, 0", "pause", false},
+		{XLEN_32, "fence.tso", "fence.tso", false},
+		{XLEN_32, "lr.w.aq a0, (a1)", "lr.w.aq a0, (a1)", false},
+		{XLEN_64, "amoadd.d.aqrl a0, a1, (a2)", "amoadd.d.aqrl a0, a1, (a2)", false},
+		{XLEN_32, "fadd.s fa0, fa1, fa2", "fadd.s fa0, fa1, fa2", false},
+		{XLEN_32, "fadd.d fa0, fa1, fa2, rtz", "fadd.d fa0, fa1, fa2, rtz", false},
+		{XLEN_32, "fsgnjn.d fa0, fa1, fa1", "fneg.d fa0, fa1", false},
+		{XLEN_32, "fcvt.d.w fa0, a0", "fcvt.d.w fa0, a0", false},
+		{XLEN_32, "fcvt.w.d a0, fa0, rtz", "fcvt.w.d a0, fa0, rtz", false},
+		{XLEN_32, "fcvt.s.d fa0, fa1", "fcvt.s.d fa0, fa1", false},
+		{XLEN_32, "feq.s a0, fa0, fa1", "feq.s a0, fa0, fa1", false},
+		{XLEN_32, "fsd fs0, 8(sp)", "fsd fs0, 8(sp)", false},
+		{XLEN_32, "fmadd.s fa0, fa1, fa2, fa3, rmm", "fmadd.s fa0, fa1, fa2, fa3, rmm", false},
+	} {
+		p, err := Assemble(test.source, test.xlen, 0x80000000)
+		if err != nil {
+			t.Fatalf("can't assemble %s with error %v", test.source, err)
+		}
+		dis := NewDisassembler(test.xlen, nil)
+		dis.NoAliases = test.noAliases
+		Assert(t, dis.Disassemble(p.Instructions()[0], 0x80000000), test.expected)
+	}
+}
+
+// The encodings which aren't instructions of the XLEN are data.
+func TestDisassembleInvalid(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	d.RegisterCompressedInstructionSet()
+	for _, test := range []struct {
+		xlen     int
+		word     uint32
+		expected string
+	}{
+		// ld on RV32
+		{XLEN_32, 0x00053503, ".4byte 0x00053503"},
+		{XLEN_64, 0x00053503, "ld a0, 0(a0)"},
+		// slli with a shift amount of 32 on RV32
+		{XLEN_32, 0x02051513, ".4byte 0x02051513"},
+		// a branch with func3=2
+		{XLEN_32, 0x00b52063, ".4byte 0x00b52063"},
+		// fadd.s with the reserved rounding mode 5
+		{XLEN_32, 0x00c5d553, ".4byte 0x00c5d553"},
+		// c.ld on RV32 is c.flw
+		{XLEN_32, 0x6188, "flw fa0, 0(a1)"},
+	} {
+		instr, err := d.Decode(test.word)
+		if err != nil {
+			t.Fatalf("can't decode %#08x with error %v", test.word, err)
+		}
+		Assert(t, NewDisassembler(test.xlen, nil).Disassemble(instr, 0), test.expected)
+	}
+}
+
+// Compressed instructions are printed as the instructions they expand to.
+func TestDisassembleCompressed(t *testing.T) {
+	p, err := Assemble("c.li a0, 5\nc.j .-2\nc.addi16sp sp, -32\nc.jr ra\nc.lui ra, 0xfffe0", XLEN_32, 0x80000000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+	dis := NewDisassembler(XLEN_32, nil)
+	var lines []string
+	pc := uint64(0x80000000)
+	for _, instr := range p.Instructions() {
+		lines = append(lines, dis.Disassemble(instr, pc))
+		pc += 2
+	}
+	Assert(t, len(lines), 5)
+	Assert(t, lines[0], "li a0, 5")
+	Assert(t, lines[1], "j 0x80000000")
+	Assert(t, lines[2], "addi sp, sp, -32")
+	Assert(t, lines[3], "ret")
+	Assert(t, lines[4], "lui ra, 0xfffe0")
+}
+
+// The targets are shown with the symbols of the elf file.
+func TestDisassembleSymbols(t *testing.T) {
+	source := `
+	.global _start
+_start:
+	call main
+loop:
+	j loop
+main:
+	beqz a0, 1f
+	addi a0, a0, -1
+1:	ret
+	.data
+counter:
+	.word 0
+`
+	p, err := Assemble(source, XLEN_64, 0x80000000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+	var out bytes.Buffer
+	if err := p.WriteElf(&out); err != nil {
+		t.Fatalf("WriteElf failed with error %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatalf("can't read the elf file with error %v", err)
+	}
+	symbols := ElfSymbols(f)
+	Assert(t, symbols.AddrString(0x80000000), "0x80000000 <_start>")
+	Assert(t, symbols.AddrString(0x80000014), "0x80000014 <main+0x8>")
+	Assert(t, symbols.AddrString(0x7ffffffc), "0x7ffffffc")
+	counter, _ := symbols.Lookup("counter")
+	Assert(t, counter.Value, p.Symbols["counter"])
+
+	dis := NewDisassembler(XLEN_64, symbols)
+	var lines []string
+	pc := uint64(0x80000000)
+	for _, instr := range p.Instructions() {
+		lines = append(lines, dis.Disassemble(instr, pc))
+		pc += 4
+	}
+	Assert(t, len(lines), 6)
+	Assert(t, lines[0], "auipc ra, 0x0")
+	Assert(t, lines[1], "jalr ra, 12(ra)")
+	Assert(t, lines[2], "j 0x80000008 <loop>")
+	Assert(t, lines[3], "beqz a0, 0x80000014 <main+0x8>")
+	Assert(t, lines[5], "ret")
+}
+
+func TestDisassembleHelloWorld(t *testing.T) {
+	f, err := elf.Open("../elf_files/hello.elf")
+	if err != nil {
+		t.Fatalf("can't open hello.elf with error %v", err)
+	}
+	defer f.Close()
+	text := f.Section(".text")
+	data, err := text.Data()
+	if err != nil {
+		t.Fatalf("can't read .text with error %v", err)
+	}
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	dis := NewDisassembler(XLEN_32, ElfSymbols(f))
+	var lines []string
+	for i := 0; i+4 <= len(data); i += 4 {
+		instr, err := d.Decode(binary.LittleEndian.Uint32(data[i:]))
+		if err != nil {
+			t.Fatalf("can't decode instruction %d with error %v", i/4, err)
+		}
+		lines = append(lines, dis.Disassemble(instr, text.Addr+uint64(i)))
+	}
+	Assert(t, len(lines), 12)
+	Assert(t, lines[0], "li a0, 104")
+	Assert(t, lines[1], "lui a1, 0x10000")
+	Assert(t, lines[2], "sb a0, 0(a1)")
+	Assert(t, lines[11], "j 0x8000002c")
+}
@@ -0,0 +1,191 @@
+package riscv
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ElfXlen returns the XLEN the elf file is built for, ELFCLASS32 files are
+// RV32 and ELFCLASS64 files RV64 programs.
+func ElfXlen(f *elf.File) (int, error) {
+	if f.Machine != elf.EM_RISCV {
+		return 0, fmt.Errorf("elf file has machine=%s but should be %s", f.Machine.String(), elf.EM_RISCV.String())
+	}
+	switch f.Class {
+	case elf.ELFCLASS32:
+		return XLEN_32, nil
+	case elf.ELFCLASS64:
+		return XLEN_64, nil
+	}
+	return 0, fmt.Errorf("elf file has class=%s but only %s and %s are supported",
+		f.Class.String(), elf.ELFCLASS32.String(), elf.ELFCLASS64.String())
+}
+
+// ElfLoadRange returns the lowest address and the end address (exclusive)
+// of all loadable segments, this is the memory the program needs.
+func ElfLoadRange(f *elf.File) (uint64, uint64, error) {
+	xlen, err := ElfXlen(f)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	found := false
+	begin := uint64(0)
+	end := uint64(0)
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Memsz == 0 {
+			continue
+		}
+		if !found || prog.Paddr < begin {
+			begin = prog.Paddr
+		}
+		if !found || prog.Paddr+prog.Memsz > end {
+			end = prog.Paddr + prog.Memsz
+		}
+		found = true
+	}
+
+	if !found {
+		return 0, 0, fmt.Errorf("elf file has no loadable segments")
+	}
+	if xlen == XLEN_32 && end > 1<<32 {
+		return 0, 0, fmt.Errorf("loadable segments end at %#x which is outside of the 32 bit address space", end)
+	}
+
+	return begin, end, nil
+}
+
+// ElfSymbol returns the address of the symbol with the name, like tohost
+// of riscv-tests.
+func ElfSymbol(f *elf.File, name string) (uint64, error) {
+	symbols, err := f.Symbols()
+	if err != nil {
+		return 0, fmt.Errorf("can't read the symbols of the elf file with error: %w", err)
+	}
+	for _, s := range symbols {
+		if s.Name == name && s.Section != elf.SHN_UNDEF {
+			return s.Value, nil
+		}
+	}
+	return 0, fmt.Errorf("elf file has no symbol %s", name)
+}
+
+// SymbolTable finds the function, object and label symbols of an elf file
+// by address.
+type SymbolTable struct {
+	// sorted by address
+	symbols []elf.Symbol
+}
+
+// ElfSymbols returns the symbol table of the elf file, it's empty for a
+// stripped one.
+func ElfSymbols(f *elf.File) *SymbolTable {
+	t := &SymbolTable{}
+	// a stripped elf file has no symbols, so the error is ignored
+	symbols, _ := f.Symbols()
+	for _, s := range symbols {
+		typ := elf.ST_TYPE(s.Info)
+		if s.Name == "" || s.Section == elf.SHN_UNDEF || (typ != elf.STT_FUNC && typ != elf.STT_OBJECT && typ != elf.STT_NOTYPE) {
+			continue
+		}
+		t.symbols = append(t.symbols, s)
+	}
+	sort.SliceStable(t.symbols, func(i, j int) bool { return t.symbols[i].Value < t.symbols[j].Value })
+	return t
+}
+
+// Lookup returns the symbol with the name.
+func (t *SymbolTable) Lookup(name string) (elf.Symbol, bool) {
+	if t != nil {
+		for _, s := range t.symbols {
+			if s.Name == name {
+				return s, true
+			}
+		}
+	}
+	return elf.Symbol{}, false
+}
+
+// At returns the symbol containing addr.
+func (t *SymbolTable) At(addr uint64) (elf.Symbol, bool) {
+	if t == nil {
+		return elf.Symbol{}, false
+	}
+	i := sort.Search(len(t.symbols), func(i int) bool { return t.symbols[i].Value > addr }) - 1
+	// symbols without size (labels in assembly) cover everything up to the
+	// next symbol
+	for ; i >= 0; i-- {
+		s := t.symbols[i]
+		if s.Size == 0 || addr < s.Value+s.Size {
+			return s, true
+		}
+	}
+	return elf.Symbol{}, false
+}
+
+// AddrString returns the address with the symbol it's in, like
+// 0x80000010 <main+0x8>. The table can be nil.
+func (t *SymbolTable) AddrString(addr uint64) string {
+	s, ok := t.At(addr)
+	if !ok {
+		return fmt.Sprintf("%#x", addr)
+	}
+	if addr == s.Value {
+		return fmt.Sprintf("%#x <%s>", addr, s.Name)
+	}
+	return fmt.Sprintf("%#x <%s+%#x>", addr, s.Name, addr-s.Value)
+}
+
+func loadSegment(prog *elf.Prog, mem Memory) error {
+	data, err := io.ReadAll(prog.Open())
+	if err != nil {
+		return fmt.Errorf("can't read segment at addr=%#x with error: %w", prog.Paddr, err)
+	}
+
+	addr := prog.Paddr
+	for i, b := range data {
+		err = mem.StoreByte(addr+uint64(i), uint64(b))
+		if err != nil {
+			return fmt.Errorf("can't load segment at addr=%#x with error: %w", prog.Paddr, err)
+		}
+	}
+
+	// The part of the segment that is not in the file (e.g. .bss) is
+	// zero initialized.
+	for i := prog.Filesz; i < prog.Memsz; i++ {
+		err = mem.StoreByte(addr+i, 0)
+		if err != nil {
+			return fmt.Errorf("can't zero fill segment at addr=%#x with error: %w", prog.Paddr, err)
+		}
+	}
+
+	return nil
+}
+
+// LoadElf copies every loadable segment of the elf file to its physical
+// address in memory and points the pc to the entry point. The elf class
+// has to match the XLEN of the registers.
+func LoadElf(f *elf.File, mem Memory, regs Registers) error {
+	xlen, err := ElfXlen(f)
+	if err != nil {
+		return err
+	}
+	if xlen != regs.Xlen() {
+		return fmt.Errorf("elf file has class=%s but the hart has XLEN=%d", f.Class.String(), regs.Xlen())
+	}
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD {
+			continue
+		}
+		err = loadSegment(prog, mem)
+		if err != nil {
+			return err
+		}
+	}
+
+	regs.SetPc(f.Entry)
+	return nil
+}
@@ -0,0 +1,305 @@
+package riscv
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"sort"
+	"testing"
+)
+
+type testSegment struct {
+	addr  uint64
+	data  []byte
+	memsz uint64
+}
+
+// buildElf creates an executable riscv elf file with one PT_LOAD program
+// header per segment and no sections.
+func buildElf(t *testing.T, entry uint32, segments []testSegment) *elf.File {
+	return buildElfWithSymbols(t, entry, segments, nil)
+}
+
+// buildElfWithSymbols is buildElf with a symbol table of absolute symbols,
+// the file has no other sections.
+func buildElfWithSymbols(t *testing.T, entry uint32, segments []testSegment, symbols map[string]uint32) *elf.File {
+	headerSize := uint32(52)
+	progSize := uint32(32)
+	dataOffset := headerSize + progSize*uint32(len(segments))
+
+	header := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_RISCV),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Phoff:     headerSize,
+		Ehsize:    uint16(headerSize),
+		Phentsize: uint16(progSize),
+		Phnum:     uint16(len(segments)),
+		Shentsize: 40,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	var data bytes.Buffer
+	for _, s := range segments {
+		data.Write(s.data)
+	}
+
+	// the sections are the null section, .symtab, .strtab and .shstrtab
+	var sections []elf.Section32
+	if symbols != nil {
+		names := make([]string, 0, len(symbols))
+		for name := range symbols {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		strtab := []byte{0}
+		var symtab bytes.Buffer
+		binary.Write(&symtab, binary.LittleEndian, elf.Sym32{})
+		for _, name := range names {
+			binary.Write(&symtab, binary.LittleEndian, elf.Sym32{
+				Name:  uint32(len(strtab)),
+				Value: symbols[name],
+				Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT),
+				Shndx: uint16(elf.SHN_ABS),
+			})
+			strtab = append(append(strtab, name...), 0)
+		}
+		shstrtab := []byte("\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+		offset := dataOffset + uint32(data.Len())
+		sections = []elf.Section32{
+			{},
+			{Name: 1, Type: uint32(elf.SHT_SYMTAB), Off: offset, Size: uint32(symtab.Len()), Link: 2, Info: 1, Addralign: 4, Entsize: 16},
+			{Name: 9, Type: uint32(elf.SHT_STRTAB), Off: offset + uint32(symtab.Len()), Size: uint32(len(strtab)), Addralign: 1},
+			{Name: 17, Type: uint32(elf.SHT_STRTAB), Off: offset + uint32(symtab.Len()+len(strtab)), Size: uint32(len(shstrtab)), Addralign: 1},
+		}
+		data.Write(symtab.Bytes())
+		data.Write(strtab)
+		data.Write(shstrtab)
+		header.Shoff = dataOffset + uint32(data.Len())
+		header.Shnum = uint16(len(sections))
+		header.Shstrndx = 3
+	}
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, header)
+	offset := dataOffset
+	for _, s := range segments {
+		prog := elf.Prog32{
+			Type:   uint32(elf.PT_LOAD),
+			Off:    offset,
+			Vaddr:  uint32(s.addr),
+			Paddr:  uint32(s.addr),
+			Filesz: uint32(len(s.data)),
+			Memsz:  uint32(s.memsz),
+			Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+			Align:  4,
+		}
+		binary.Write(buf, binary.LittleEndian, prog)
+		offset += uint32(len(s.data))
+	}
+	buf.Write(data.Bytes())
+	for _, section := range sections {
+		binary.Write(buf, binary.LittleEndian, section)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse generated elf file with error %v", err)
+	}
+	return f
+}
+
+// buildElf64 is buildElf for ELFCLASS64 files.
+func buildElf64(t *testing.T, entry uint64, segments []testSegment) *elf.File {
+	headerSize := uint64(64)
+	progSize := uint64(56)
+	dataOffset := headerSize + progSize*uint64(len(segments))
+
+	header := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_RISCV),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     entry,
+		Phoff:     headerSize,
+		Ehsize:    uint16(headerSize),
+		Phentsize: uint16(progSize),
+		Phnum:     uint16(len(segments)),
+		Shentsize: 64,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, header)
+	offset := dataOffset
+	for _, s := range segments {
+		prog := elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+			Off:    offset,
+			Vaddr:  s.addr,
+			Paddr:  s.addr,
+			Filesz: uint64(len(s.data)),
+			Memsz:  s.memsz,
+			Align:  4,
+		}
+		binary.Write(buf, binary.LittleEndian, prog)
+		offset += uint64(len(s.data))
+	}
+	for _, s := range segments {
+		buf.Write(s.data)
+	}
+
+	f, err := elf.NewFile(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to parse generated elf file with error %v", err)
+	}
+	return f
+}
+
+func TestElfLoadRange(t *testing.T) {
+	f := buildElf(t, 0x1000, []testSegment{
+		{addr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 4},
+		{addr: 0x2000, data: []byte{5, 6}, memsz: 16},
+	})
+
+	begin, end, err := ElfLoadRange(f)
+	if err != nil {
+		t.Fatalf("ElfLoadRange failed with error %v", err)
+	}
+	Assert(t, begin, uint64(0x1000))
+	Assert(t, end, uint64(0x2010))
+}
+
+func TestLoadElf(t *testing.T) {
+	f := buildElf(t, 0x1004, []testSegment{
+		{addr: 0x1000, data: []byte{1, 2, 3, 4, 5, 6, 7, 8}, memsz: 8},
+		// .bss like segment, only the first 2 bytes are in the file
+		{addr: 0x1010, data: []byte{9, 10}, memsz: 8},
+	})
+
+	mem := NewMemoryWithOffset(0x40, 0x1000)
+	// garbage that should be overwritten by the zero fill
+	mem.Store(0x1014, 0xffffffff, 4)
+	r := RegistersImpl{}
+
+	err := LoadElf(f, &mem, &r)
+	if err != nil {
+		t.Fatalf("LoadElf failed with error %v", err)
+	}
+
+	CheckPc(0x1004, &r, t)
+
+	word, _ := mem.Load(0x1000, 4)
+	Assert(t, word, uint64(0x04030201))
+	word, _ = mem.Load(0x1004, 4)
+	Assert(t, word, uint64(0x08070605))
+	word, _ = mem.Load(0x1010, 4)
+	Assert(t, word, uint64(0x00000a09))
+	word, _ = mem.Load(0x1014, 4)
+	Assert(t, word, uint64(0))
+}
+
+func TestLoadElfOutOfMemory(t *testing.T) {
+	f := buildElf(t, 0x1000, []testSegment{
+		{addr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 4},
+	})
+
+	mem := NewMemory(0x100)
+	r := RegistersImpl{}
+
+	err := LoadElf(f, &mem, &r)
+	if err == nil {
+		t.Fatalf("loading a segment outside of memory should fail")
+	}
+}
+
+func TestLoadElf64(t *testing.T) {
+	f := buildElf64(t, 0x100000004, []testSegment{
+		{addr: 0x100000000, data: []byte{1, 2, 3, 4, 5, 6, 7, 8}, memsz: 16},
+	})
+
+	xlen, err := ElfXlen(f)
+	if err != nil {
+		t.Fatalf("ElfXlen failed with error %v", err)
+	}
+	Assert(t, xlen, XLEN_64)
+	// the 4GiB limit only applies to RV32
+	begin, end, err := ElfLoadRange(f)
+	if err != nil {
+		t.Fatalf("ElfLoadRange failed with error %v", err)
+	}
+	Assert(t, begin, uint64(0x100000000))
+	Assert(t, end, uint64(0x100000010))
+
+	mem := NewMemoryWithOffset(0x10, 0x100000000)
+	err = LoadElf(f, &mem, &RegistersImpl{})
+	if err == nil {
+		t.Fatalf("loading an ELFCLASS64 file on RV32 should fail")
+	}
+
+	r := NewRegisters(XLEN_64)
+	err = LoadElf(f, &mem, r)
+	if err != nil {
+		t.Fatalf("LoadElf failed with error %v", err)
+	}
+	CheckPc(0x100000004, r, t)
+	word, _ := mem.Load(0x100000000, 8)
+	Assert(t, word, uint64(0x0807060504030201))
+}
+
+func TestLoadHelloElf(t *testing.T) {
+	f, err := elf.Open("../elf_files/hello.elf")
+	if err != nil {
+		t.Fatalf("can't open hello.elf with error %v", err)
+	}
+	defer f.Close()
+
+	begin, end, err := ElfLoadRange(f)
+	if err != nil {
+		t.Fatalf("ElfLoadRange failed with error %v", err)
+	}
+	Assert(t, begin, uint64(0x80000000))
+	Assert(t, end, uint64(0x80000030))
+
+	mem := NewMemoryWithOffset(int(end-begin), begin)
+	r := RegistersImpl{}
+	err = LoadElf(f, &mem, &r)
+	if err != nil {
+		t.Fatalf("LoadElf failed with error %v", err)
+	}
+
+	CheckPc(0x80000000, &r, t)
+	// addi a0, x0, 0x68
+	word, _ := mem.Load(0x80000000, 4)
+	Assert(t, word, uint64(0x06800513))
+	// loop: j loop
+	word, _ = mem.Load(0x8000002c, 4)
+	Assert(t, word, uint64(0x0000006f))
+}
+
+func TestElfSymbol(t *testing.T) {
+	f := buildElfWithSymbols(t, 0x1000, []testSegment{{addr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 4}},
+		map[string]uint32{"tohost": 0x1000, "fromhost": 0x1008})
+
+	addr, err := ElfSymbol(f, "fromhost")
+	if err != nil {
+		t.Fatalf("ElfSymbol failed with error %v", err)
+	}
+	Assert(t, addr, uint64(0x1008))
+	if _, err := ElfSymbol(f, "begin_signature"); err == nil {
+		t.Fatalf("ElfSymbol found a missing symbol")
+	}
+	// a file without sections has no symbol table
+	if _, err := ElfSymbol(buildElf(t, 0x1000, nil), "tohost"); err == nil {
+		t.Fatalf("ElfSymbol found a symbol without symbol table")
+	}
+}
@@ -1,25 +1,313 @@
 package riscv
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"sync/atomic"
 )
 
-type emulator struct {
-	mem  Memory
-	regs Registers
+// HaltReason describes why Run stopped executing instructions.
+type HaltReason int
+
+const (
+	// The maximum number of instructions passed to Run are executed.
+	HaltStepLimit HaltReason = iota
+	// An instruction jumped to itself (e.g. `loop: j loop`) with all the
+	// interrupts disabled, nothing can break out of that loop so the
+	// program is considered done.
+	HaltSelfLoop
+	// A trap was taken but the trap handler can't be fetched.
+	HaltError
+	// The next instruction has a breakpoint.
+	HaltBreakpoint
+	// The last instruction accessed memory with a watchpoint, see WatchHit.
+	HaltWatchpoint
+	// Interrupt was called.
+	HaltInterrupted
+)
+
+func (h HaltReason) String() string {
+	switch h {
+	case HaltStepLimit:
+		return "step limit reached"
+	case HaltSelfLoop:
+		return "jump to self"
+	case HaltError:
+		return "error"
+	case HaltBreakpoint:
+		return "breakpoint"
+	case HaltWatchpoint:
+		return "watchpoint"
+	case HaltInterrupted:
+		return "interrupted"
+	default:
+		return fmt.Sprintf("Unknown HaltReason (val=%d)", int(h))
+	}
 }
 
-func (e emulator) Start(program []Instruction) {
-	log.Println("Starting emulator...")
-	for index, inst := range program {
-		err := inst.Execute(e.mem, e.regs)
-		if err != nil {
-			log.Printf("Error at instruction %d with error=%s", index, err.Error())
+// Ticker is a device whose state changes as time passes, like the timer of
+// the CLINT.
+type Ticker interface {
+	// Tick is called by the emulator before every instruction.
+	Tick()
+}
+
+type Emulator struct {
+	mem     Memory
+	regs    Registers
+	decoder *Decoder
+	steps   uint64
+	tickers []Ticker
+
+	// The decoded instructions by physical address. Stores to instruction
+	// memory only have to be visible to instruction fetches after a
+	// FENCE.I, which drops them.
+	decoded map[uint64]decodedInstr
+
+	lastTrap   *Exception
+	lastTrapPc uint64
+
+	// debugger state, see debug.go
+	breakpoints map[uint64]bool
+	watchpoints []Watchpoint
+	watchHit    *WatchHit
+	interrupted int32
+
+	// what the current instruction did, only recorded for the commit
+	// handler and the watchpoints, see commit_log.go
+	commit        CommitRecord
+	commitHandler func(c *CommitRecord) error
+
+	// Log every instruction before it is executed.
+	Trace bool
+	// the disassembler and the symbols of the trace, see SetSymbols
+	dis     *Disassembler
+	symbols *SymbolTable
+}
+
+func NewEmulator(mem Memory, regs Registers, decoder *Decoder) *Emulator {
+	return &Emulator{
+		mem: mem, regs: regs, decoder: decoder, decoded: map[uint64]decodedInstr{},
+		dis: NewDisassembler(regs.Xlen(), nil),
+	}
+}
+
+// SetSymbols makes the trace show the addresses with the symbols they are
+// in, nil removes them.
+func (e *Emulator) SetSymbols(symbols *SymbolTable) {
+	e.symbols = symbols
+	e.dis = NewDisassembler(e.regs.Xlen(), symbols)
+}
+
+func (e *Emulator) Memory() Memory {
+	return e.mem
+}
+
+func (e *Emulator) Registers() Registers {
+	return e.regs
+}
+
+// AddTicker makes the emulator tick the device before every instruction.
+func (e *Emulator) AddTicker(t Ticker) {
+	e.tickers = append(e.tickers, t)
+}
+
+// Steps returns the number of instructions executed so far.
+func (e *Emulator) Steps() uint64 {
+	return e.steps
+}
+
+// Fetch loads the instruction the pc points to. The lowest 16 bits are
+// fetched first, they tell if the instruction is 16 or 32 bits long. The
+// error is an instruction page fault or access fault exception.
+func (e *Emulator) Fetch() (uint32, error) {
+	pc := e.regs.Pc()
+	low, err := e.fetchParcel(pc)
+	if err != nil {
+		return 0, err
+	}
+	if InstructionLength(low) == 2 {
+		return low, nil
+	}
+
+	// the upper half can be on the next page
+	high, err := e.fetchParcel((pc + 2) & xlenMask(e.regs.Xlen()))
+	if err != nil {
+		return 0, err
+	}
+	return low | (high << 16), nil
+}
+
+// fetchParcel loads the 16 bits at the virtual address.
+func (e *Emulator) fetchParcel(addr uint64) (uint32, error) {
+	paddr, err := Translate(e.mem, e.regs, addr, AccessFetch)
+	if err != nil {
+		return 0, err
+	}
+	value, err := e.mem.Load(paddr, 2)
+	if err != nil {
+		return 0, accessFault(AccessFetch, addr, fmt.Errorf("fetch at pc=%#x failed: %w", addr, err))
+	}
+	return uint32(value), nil
+}
+
+// FlushDecoded drops the decoded instructions, so changes to instruction
+// memory made outside of the guest (e.g. by a debugger) are picked up.
+func (e *Emulator) FlushDecoded() {
+	e.decoded = map[uint64]decodedInstr{}
+}
+
+type decodedInstr struct {
+	word  uint32
+	instr Instruction
+}
+
+// fetchDecoded returns the decoded instruction at pc, the instruction is
+// only fetched and decoded the first time. The instructions are kept by
+// physical address, so they stay valid when the page tables change.
+func (e *Emulator) fetchDecoded(pc uint64) (decodedInstr, *Exception) {
+	var exc Exception
+	paddr, err := Translate(e.mem, e.regs, pc, AccessFetch)
+	if err != nil {
+		errors.As(err, &exc)
+		return decodedInstr{}, &exc
+	}
+	decoded, ok := e.decoded[paddr]
+	if ok {
+		return decoded, nil
+	}
+
+	word, err := e.Fetch()
+	if err != nil {
+		errors.As(err, &exc)
+		return decoded, &exc
+	}
+	instr, err := e.decoder.Decode(word)
+	if err != nil {
+		return decoded, &Exception{Cause: EXC_ILLEGAL_INSTRUCTION, Tval: uint64(word), Err: err}
+	}
+
+	decoded = decodedInstr{word: word, instr: instr}
+	// an instruction crossing a page boundary depends on two translations,
+	// so it is decoded again every time
+	if InstructionLength(word) == 4 && pc%PAGE_SIZE > PAGE_SIZE-4 {
+		return decoded, nil
+	}
+	if e.decoded == nil {
+		e.decoded = map[uint64]decodedInstr{}
+	}
+	e.decoded[paddr] = decoded
+	return decoded, nil
+}
+
+// Step fetches, decodes and executes a single instruction. The instruction
+// itself is responsible for moving the pc to the next instruction. When the
+// instruction raises an exception the trap is taken instead, an error is
+// only returned when the trap handler itself can't be fetched. A pending
+// interrupt is taken before the instruction, so the instruction executed is
+// the first one of the interrupt handler. The error of the commit handler
+// is returned as well, after the instruction retired.
+func (e *Emulator) Step() (Instruction, error) {
+	e.watchHit = nil
+	for _, t := range e.tickers {
+		t.Tick()
+	}
+	if cause, ok := e.regs.Csrs().PendingInterrupt(); ok {
+		if e.Trace {
+			log.Printf("interrupt at pc=%#x with %s", e.regs.Pc(), InterruptString(cause))
+		}
+		TakeInterrupt(e.regs, cause)
+	}
+
+	pc := e.regs.Pc()
+	decoded, fetchExc := e.fetchDecoded(pc)
+	if fetchExc != nil {
+		return nil, e.trap(*fetchExc)
+	}
+	instr := decoded.instr
+
+	if e.Trace {
+		log.Printf("executing instruction at pc=%s: %s", e.symbols.AddrString(pc), e.dis.Disassemble(instr, pc))
+	}
+	var err error
+	recording := e.recording()
+	if recording {
+		mem, regs := e.startRecording(pc, decoded)
+		err = instr.Execute(mem, regs)
+		e.stopRecording()
+		e.watchHit = e.checkWatchpoints(e.commit.Accesses)
+	} else {
+		err = instr.Execute(e.mem, e.regs)
+	}
+	if err != nil {
+		// Errors that are not an exception are invalid encodings that
+		// got through the decoder.
+		var exc Exception
+		if !errors.As(err, &exc) {
+			exc = Exception{Cause: EXC_ILLEGAL_INSTRUCTION, Tval: uint64(decoded.word), Err: err}
 		}
+		return instr, e.trap(exc)
+	}
+	if isFenceI(instr) {
+		e.FlushDecoded()
 	}
+	e.regs.Csrs().Retire()
+	e.steps++
+	if recording && e.commitHandler != nil {
+		if err := e.commitHandler(&e.commit); err != nil {
+			return instr, err
+		}
+	}
+
+	return instr, nil
 }
 
-func NewEmulator() *emulator {
-	a := new(emulator)
-	return a
+func (e *Emulator) trap(exc Exception) error {
+	pc := e.regs.Pc()
+	if exc.Cause == EXC_INSTRUCTION_ACCESS_FAULT && pc == e.regs.Csrs().trapHandler(exc.Cause) {
+		// the trap would jump right back to the same handler
+		if e.lastTrap != nil {
+			return fmt.Errorf("trap handler at pc=%#x can't be fetched (previous trap: %v at pc=%#x): %w",
+				pc, e.lastTrap.Error(), e.lastTrapPc, exc)
+		}
+		return fmt.Errorf("trap handler at pc=%#x can't be fetched: %w", pc, exc)
+	}
+
+	if e.Trace {
+		log.Printf("trap at pc=%#x with %v", pc, exc.Error())
+	}
+	e.lastTrap = &exc
+	e.lastTrapPc = pc
+	TakeTrap(e.regs, exc.Cause, exc.Tval)
+	return nil
+}
+
+// Run keeps executing instructions until a halt condition is hit. A maxSteps
+// of zero means there is no limit on the number of instructions. The first
+// instruction is executed even when it has a breakpoint, so Run continues
+// from the breakpoint it stopped at.
+func (e *Emulator) Run(maxSteps uint64) (HaltReason, error) {
+	for i := uint64(0); maxSteps == 0 || i < maxSteps; i++ {
+		if atomic.CompareAndSwapInt32(&e.interrupted, 1, 0) {
+			return HaltInterrupted, nil
+		}
+		pc := e.regs.Pc()
+		_, err := e.Step()
+		if err != nil {
+			return HaltError, err
+		}
+		if e.watchHit != nil {
+			return HaltWatchpoint, nil
+		}
+		if e.breakpoints[e.regs.Pc()] {
+			return HaltBreakpoint, nil
+		}
+		// an interrupt can still break out of the loop when one is enabled
+		if e.regs.Pc() == pc && e.regs.Csrs().enabledInterrupts() == 0 {
+			return HaltSelfLoop, nil
+		}
+	}
+
+	return HaltStepLimit, nil
 }
@@ -0,0 +1,143 @@
+package riscv
+
+import (
+	"testing"
+)
+
+func storeProgram(t *testing.T, mem Memory, addr uint64, program []uint32) {
+	for i, word := range program {
+		err := mem.Store(addr+uint64(i*4), uint64(word), 4)
+		if err != nil {
+			t.Fatalf("failed to store program word %d with error %v", i, err)
+		}
+	}
+}
+
+func newTestEmulator(mem Memory) (*Emulator, *RegistersImpl) {
+	r := &RegistersImpl{}
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	return NewEmulator(mem, r, d), r
+}
+
+func TestRunLoop(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0x00300513, // addi a0, zero, 3
+		0xfff50513, // loop: addi a0, a0, -1
+		0xfe051ee3, // bnez a0, loop
+		0x0000006f, // j .
+	})
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(0)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_a0, 0, r, t)
+	CheckPc(12, r, t)
+	// 1 addi + 3 iterations of the loop + the final jump
+	Assert(t, e.Steps(), uint64(1+3*2+1))
+}
+
+func TestRunRV64(t *testing.T) {
+	mem := NewMemory(0x108)
+	storeProgram(t, &mem, 0, []uint32{
+		0xfff00513, // addi a0, zero, -1
+		0x00155513, // srli a0, a0, 1
+		0x0015059b, // addiw a1, a0, 1
+		0x10a03023, // sd a0, 0x100(zero)
+		0x10003603, // ld a2, 0x100(zero)
+		0x00a506bb, // addw a3, a0, a0
+		0x0000006f, // j .
+	})
+	r := NewRegisters(XLEN_64)
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	d.RegisterRV64InstructionSet()
+	e := NewEmulator(&mem, r, d)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_a0, 0x7fffffffffffffff, r, t)
+	CheckReg(reg_a1, 0, r, t)
+	CheckReg(reg_a2, 0x7fffffffffffffff, r, t)
+	CheckReg(reg_a3, 0xfffffffffffffffe, r, t)
+
+	// without the RV64 instruction set the W instructions are illegal
+	d = NewDecoder()
+	d.RegisterBaseInstructionSet()
+	_, err = d.Decode(0x00a506bb)
+	if err == nil {
+		t.Fatalf("decoding addw without the RV64 instruction set should fail")
+	}
+}
+
+func TestRunStepLimit(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0x00150513, // loop: addi a0, a0, 1
+		0xffdff06f, // j loop
+	})
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(10)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltStepLimit)
+	CheckReg(reg_a0, 5, r, t)
+	CheckPc(0, r, t)
+}
+
+func TestRunFetchOutOfRange(t *testing.T) {
+	mem := NewMemory(8)
+	storeProgram(t, &mem, 0, []uint32{
+		0x00000013, // nop
+		0x00000013, // nop
+	})
+	e, r := newTestEmulator(&mem)
+	// there is no trap handler at mtvec
+	r.csr.mtvec = 0x100
+
+	reason, err := e.Run(0)
+	Assert(t, reason, HaltError)
+	if err == nil {
+		t.Fatalf("running past the end of memory without a trap handler should fail")
+	}
+	Assert(t, e.Steps(), uint64(2))
+	CheckCsr(CSR_MCAUSE, EXC_INSTRUCTION_ACCESS_FAULT, r, t)
+	CheckCsr(CSR_MEPC, 8, r, t)
+	CheckCsr(CSR_MTVAL, 8, r, t)
+}
+
+func TestRunFenceI(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0x018000ef, // jal ra, f
+		0x02002283, // lw t0, 32(zero)
+		0x00502c23, // sw t0, 24(zero) (patch f)
+		0x0000100f, // fence.i
+		0x008000ef, // jal ra, f
+		0x0000006f, // j .
+		0x00150513, // f: addi a0, a0, 1
+		0x00008067, // ret
+		0x01050513, // addi a0, a0, 16
+	})
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	// the first call used the original f, the second the patched one
+	CheckReg(reg_a0, 17, r, t)
+}
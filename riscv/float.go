@@ -0,0 +1,606 @@
+package riscv
+
+import (
+	"math/big"
+)
+
+// The accrued exception flags in fflags
+const (
+	FFLAGS_NX uint32 = 1 << 0 // inexact
+	FFLAGS_UF uint32 = 1 << 1 // underflow
+	FFLAGS_OF uint32 = 1 << 2 // overflow
+	FFLAGS_DZ uint32 = 1 << 3 // divide by zero
+	FFLAGS_NV uint32 = 1 << 4 // invalid operation
+)
+
+// The rounding modes of the rm field and frm
+const (
+	RM_RNE uint32 = 0 // round to nearest, ties to even
+	RM_RTZ uint32 = 1 // round towards zero
+	RM_RDN uint32 = 2 // round down (towards -inf)
+	RM_RUP uint32 = 3 // round up (towards +inf)
+	RM_RMM uint32 = 4 // round to nearest, ties to max magnitude
+	RM_DYN uint32 = 7 // use the rounding mode in frm
+)
+
+// floatFormat is an IEEE-754 binary format, values are passed around as
+// their raw bits in the low bits of an uint64.
+//
+// The arithmetic is done on exact integer mantissas (math/big), so every
+// result is rounded only once, which gives the correctly rounded results
+// and exception flags for all rounding modes.
+type floatFormat struct {
+	expBits  uint
+	fracBits uint
+}
+
+var (
+	float32Format = floatFormat{expBits: 8, fracBits: 23}
+	float64Format = floatFormat{expBits: 11, fracBits: 52}
+)
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func (f floatFormat) bias() int {
+	return 1<<(f.expBits-1) - 1
+}
+
+func (f floatFormat) signBit() uint64 {
+	return 1 << (f.expBits + f.fracBits)
+}
+
+func (f floatFormat) fracMask() uint64 {
+	return 1<<f.fracBits - 1
+}
+
+func (f floatFormat) maxExp() uint64 {
+	return 1<<f.expBits - 1
+}
+
+func (f floatFormat) exp(bits uint64) uint64 {
+	return (bits >> f.fracBits) & f.maxExp()
+}
+
+func (f floatFormat) zero(neg bool) uint64 {
+	if neg {
+		return f.signBit()
+	}
+	return 0
+}
+
+func (f floatFormat) inf(neg bool) uint64 {
+	return f.zero(neg) | f.maxExp()<<f.fracBits
+}
+
+func (f floatFormat) maxFinite(neg bool) uint64 {
+	return f.zero(neg) | (f.maxExp()-1)<<f.fracBits | f.fracMask()
+}
+
+// canonicalNaN is the NaN every operation returns when the result is NaN.
+func (f floatFormat) canonicalNaN() uint64 {
+	return f.maxExp()<<f.fracBits | 1<<(f.fracBits-1)
+}
+
+func (f floatFormat) isNeg(bits uint64) bool {
+	return bits&f.signBit() != 0
+}
+
+func (f floatFormat) isZero(bits uint64) bool {
+	return bits&^f.signBit() == 0
+}
+
+func (f floatFormat) isInf(bits uint64) bool {
+	return f.exp(bits) == f.maxExp() && bits&f.fracMask() == 0
+}
+
+func (f floatFormat) isNaN(bits uint64) bool {
+	return f.exp(bits) == f.maxExp() && bits&f.fracMask() != 0
+}
+
+// isSignalingNaN returns if bits is a NaN with the quiet bit (the highest
+// fraction bit) cleared.
+func (f floatFormat) isSignalingNaN(bits uint64) bool {
+	return f.isNaN(bits) && bits&(1<<(f.fracBits-1)) == 0
+}
+
+// nanResult returns the canonical NaN, the invalid flag is raised if one of
+// the operands is a signaling NaN.
+func (f floatFormat) nanResult(operands ...uint64) (uint64, uint32) {
+	var flags uint32
+	for _, op := range operands {
+		if f.isSignalingNaN(op) {
+			flags |= FFLAGS_NV
+		}
+	}
+	return f.canonicalNaN(), flags
+}
+
+// zeroSum is the zero x+y returns when both are zero or cancel out exactly:
+// the common sign, or +0 for mixed signs, except when rounding down.
+func (f floatFormat) zeroSum(negX bool, negY bool, rm uint32) uint64 {
+	if negX == negY {
+		return f.zero(negX)
+	}
+	return f.zero(rm == RM_RDN)
+}
+
+// unpack returns the finite value bits as (-1)^neg * mant * 2^exp.
+func (f floatFormat) unpack(bits uint64) (bool, *big.Int, int) {
+	frac := bits & f.fracMask()
+	exp := int(f.exp(bits))
+	if exp == 0 {
+		// subnormal, no implicit leading one
+		exp = 1
+	} else {
+		frac |= 1 << f.fracBits
+	}
+	return f.isNeg(bits), new(big.Int).SetUint64(frac), exp - f.bias() - int(f.fracBits)
+}
+
+// shiftRound shifts mant right by shift bits rounding with rm, and returns
+// the result and if it is inexact. sticky reports nonzero bits that were
+// already lost below mant, which requires shift to be at least 1 so the
+// round bit is known.
+func shiftRound(neg bool, mant *big.Int, shift int, sticky bool, rm uint32) (*big.Int, bool) {
+	if shift <= 0 {
+		return new(big.Int).Lsh(mant, uint(-shift)), sticky
+	}
+
+	q := new(big.Int).Rsh(mant, uint(shift))
+	round := mant.Bit(shift-1) == 1
+	sticky = sticky || (mant.Sign() != 0 && int(mant.TrailingZeroBits()) < shift-1)
+	inexact := round || sticky
+
+	var up bool
+	switch rm {
+	case RM_RNE:
+		up = round && (sticky || q.Bit(0) == 1)
+	case RM_RDN:
+		up = inexact && neg
+	case RM_RUP:
+		up = inexact && !neg
+	case RM_RMM:
+		up = round
+	}
+	if up {
+		q.Add(q, big.NewInt(1))
+	}
+	return q, inexact
+}
+
+func (f floatFormat) overflow(neg bool, rm uint32) uint64 {
+	switch rm {
+	case RM_RTZ:
+		return f.maxFinite(neg)
+	case RM_RDN:
+		if !neg {
+			return f.maxFinite(neg)
+		}
+	case RM_RUP:
+		if neg {
+			return f.maxFinite(neg)
+		}
+	}
+	return f.inf(neg)
+}
+
+// roundPack rounds (-1)^neg * mant * 2^exp to the format and returns the
+// bits and the raised exception flags. sticky reports nonzero bits that
+// were lost below mant, mant must then have at least 2 bits more than the
+// precision of the format.
+func (f floatFormat) roundPack(neg bool, mant *big.Int, exp int, sticky bool, rm uint32) (uint64, uint32) {
+	if mant.Sign() == 0 {
+		return f.zero(neg), 0
+	}
+
+	p := int(f.fracBits) + 1
+	emin := 1 - f.bias()
+	emax := f.bias()
+	// the exponent of the leading one
+	e := exp + mant.BitLen() - 1
+
+	// the exponent of the last bit that fits, subnormals have less
+	// precision as the exponent can't go below emin
+	lsb := maxInt(e, emin) - int(f.fracBits)
+	q, inexact := shiftRound(neg, mant, lsb-exp, sticky, rm)
+
+	var flags uint32
+	if inexact {
+		flags |= FFLAGS_NX
+		// tininess is detected after rounding, the result is tiny when it
+		// is below the smallest normal number after rounding to p bits with
+		// an unbounded exponent
+		if e < emin {
+			wide, _ := shiftRound(neg, mant, e-int(f.fracBits)-exp, sticky, rm)
+			if e+wide.BitLen()-p < emin {
+				flags |= FFLAGS_UF
+			}
+		}
+	}
+
+	if q.BitLen() > p {
+		// rounding carried into a new leading bit, the dropped bit is zero
+		q.Rsh(q, 1)
+		lsb++
+	}
+	if q.BitLen() < p {
+		// subnormal, or zero when everything is rounded away
+		return f.zero(neg) | q.Uint64(), flags
+	}
+	if lsb+int(f.fracBits) > emax {
+		return f.overflow(neg, rm), flags | FFLAGS_OF | FFLAGS_NX
+	}
+	biased := uint64(lsb + int(f.fracBits) + f.bias())
+	return f.zero(neg) | biased<<f.fracBits | q.Uint64()&f.fracMask(), flags
+}
+
+// addExact returns the exact sum of two unpacked values.
+func addExact(negX bool, mantX *big.Int, expX int, negY bool, mantY *big.Int, expY int) (bool, *big.Int, int) {
+	exp := minInt(expX, expY)
+	x := new(big.Int).Lsh(mantX, uint(expX-exp))
+	if negX {
+		x.Neg(x)
+	}
+	y := new(big.Int).Lsh(mantY, uint(expY-exp))
+	if negY {
+		y.Neg(y)
+	}
+	x.Add(x, y)
+	neg := x.Sign() < 0
+	return neg, x.Abs(x), exp
+}
+
+func (f floatFormat) add(x uint64, y uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		return f.nanResult(x, y)
+	}
+	if f.isInf(x) || f.isInf(y) {
+		if f.isInf(x) && f.isInf(y) && f.isNeg(x) != f.isNeg(y) {
+			// inf - inf
+			return f.canonicalNaN(), FFLAGS_NV
+		}
+		if f.isInf(x) {
+			return x, 0
+		}
+		return y, 0
+	}
+	if f.isZero(x) && f.isZero(y) {
+		return f.zeroSum(f.isNeg(x), f.isNeg(y), rm), 0
+	}
+
+	negX, mantX, expX := f.unpack(x)
+	negY, mantY, expY := f.unpack(y)
+	neg, mant, exp := addExact(negX, mantX, expX, negY, mantY, expY)
+	if mant.Sign() == 0 {
+		return f.zeroSum(negX, negY, rm), 0
+	}
+	return f.roundPack(neg, mant, exp, false, rm)
+}
+
+func (f floatFormat) sub(x uint64, y uint64, rm uint32) (uint64, uint32) {
+	return f.add(x, y^f.signBit(), rm)
+}
+
+func (f floatFormat) mul(x uint64, y uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		return f.nanResult(x, y)
+	}
+	neg := f.isNeg(x) != f.isNeg(y)
+	if f.isInf(x) || f.isInf(y) {
+		if f.isZero(x) || f.isZero(y) {
+			// inf * 0
+			return f.canonicalNaN(), FFLAGS_NV
+		}
+		return f.inf(neg), 0
+	}
+	if f.isZero(x) || f.isZero(y) {
+		return f.zero(neg), 0
+	}
+
+	_, mantX, expX := f.unpack(x)
+	_, mantY, expY := f.unpack(y)
+	return f.roundPack(neg, mantX.Mul(mantX, mantY), expX+expY, false, rm)
+}
+
+func (f floatFormat) div(x uint64, y uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		return f.nanResult(x, y)
+	}
+	neg := f.isNeg(x) != f.isNeg(y)
+	if f.isInf(x) {
+		if f.isInf(y) {
+			// inf / inf
+			return f.canonicalNaN(), FFLAGS_NV
+		}
+		return f.inf(neg), 0
+	}
+	if f.isInf(y) {
+		return f.zero(neg), 0
+	}
+	if f.isZero(y) {
+		if f.isZero(x) {
+			// 0 / 0
+			return f.canonicalNaN(), FFLAGS_NV
+		}
+		return f.inf(neg), FFLAGS_DZ
+	}
+	if f.isZero(x) {
+		return f.zero(neg), 0
+	}
+
+	_, mantX, expX := f.unpack(x)
+	_, mantY, expY := f.unpack(y)
+	// scale the dividend so the quotient has at least 2 bits more than the
+	// precision, the remainder is the sticky bit
+	shift := mantY.BitLen() + int(f.fracBits) + 3
+	mantX.Lsh(mantX, uint(shift))
+	q, r := new(big.Int).QuoRem(mantX, mantY, new(big.Int))
+	return f.roundPack(neg, q, expX-expY-shift, r.Sign() != 0, rm)
+}
+
+func (f floatFormat) sqrt(x uint64, rm uint32) (uint64, uint32) {
+	if f.isNaN(x) {
+		return f.nanResult(x)
+	}
+	if f.isZero(x) {
+		// sqrt(-0) = -0
+		return x, 0
+	}
+	if f.isNeg(x) {
+		return f.canonicalNaN(), FFLAGS_NV
+	}
+	if f.isInf(x) {
+		return x, 0
+	}
+
+	_, mant, exp := f.unpack(x)
+	// scale the mantissa so the root has at least 2 bits more than the
+	// precision and the exponent is even
+	shift := 2 * (int(f.fracBits) + 3)
+	if (exp-shift)%2 != 0 {
+		shift++
+	}
+	mant.Lsh(mant, uint(shift))
+	root := new(big.Int).Sqrt(mant)
+	inexact := new(big.Int).Mul(root, root).Cmp(mant) != 0
+	return f.roundPack(false, root, (exp-shift)/2, inexact, rm)
+}
+
+// fma computes (x*y)+z with a single rounding, negProduct and negAddend
+// negate the product and the addend for FMSUB, FNMSUB and FNMADD.
+func (f floatFormat) fma(x uint64, y uint64, z uint64, negProduct bool, negAddend bool, rm uint32) (uint64, uint32) {
+	// inf * 0 is invalid, even when the addend is a quiet NaN
+	if (f.isInf(x) && f.isZero(y)) || (f.isZero(x) && f.isInf(y)) {
+		return f.canonicalNaN(), FFLAGS_NV
+	}
+	if f.isNaN(x) || f.isNaN(y) || f.isNaN(z) {
+		return f.nanResult(x, y, z)
+	}
+
+	negP := f.isNeg(x) != f.isNeg(y) != negProduct
+	negZ := f.isNeg(z) != negAddend
+	if f.isInf(x) || f.isInf(y) {
+		if f.isInf(z) && negZ != negP {
+			return f.canonicalNaN(), FFLAGS_NV
+		}
+		return f.inf(negP), 0
+	}
+	if f.isInf(z) {
+		return f.inf(negZ), 0
+	}
+	if f.isZero(x) || f.isZero(y) {
+		if f.isZero(z) {
+			return f.zeroSum(negP, negZ, rm), 0
+		}
+		return z&^f.signBit() | f.zero(negZ), 0
+	}
+
+	_, mantX, expX := f.unpack(x)
+	_, mantY, expY := f.unpack(y)
+	_, mantZ, expZ := f.unpack(z)
+	neg, mant, exp := addExact(negP, mantX.Mul(mantX, mantY), expX+expY, negZ, mantZ, expZ)
+	if mant.Sign() == 0 {
+		return f.zeroSum(negP, negZ, rm), 0
+	}
+	return f.roundPack(neg, mant, exp, false, rm)
+}
+
+// orderKey maps the non-NaN bits to an integer with the same order, -0 and
+// +0 are equal.
+func (f floatFormat) orderKey(bits uint64) int64 {
+	magnitude := int64(bits &^ f.signBit())
+	if f.isNeg(bits) {
+		return -magnitude
+	}
+	return magnitude
+}
+
+// minMax implements FMIN and FMAX, which return the other operand when
+// one of them is NaN and order -0 below +0.
+func (f floatFormat) minMax(x uint64, y uint64, max bool) (uint64, uint32) {
+	var flags uint32
+	if f.isSignalingNaN(x) || f.isSignalingNaN(y) {
+		flags = FFLAGS_NV
+	}
+	switch {
+	case f.isNaN(x) && f.isNaN(y):
+		return f.canonicalNaN(), flags
+	case f.isNaN(x):
+		return y, flags
+	case f.isNaN(y):
+		return x, flags
+	case f.isZero(x) && f.isZero(y):
+		if max {
+			return x & y, flags
+		}
+		return x | y, flags
+	}
+	if (f.orderKey(x) < f.orderKey(y)) != max {
+		return x, flags
+	}
+	return y, flags
+}
+
+// eq is the quiet comparison of FEQ, only signaling NaNs are invalid.
+func (f floatFormat) eq(x uint64, y uint64) (bool, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		_, flags := f.nanResult(x, y)
+		return false, flags
+	}
+	return f.orderKey(x) == f.orderKey(y), 0
+}
+
+// lt and le are the signaling comparisons of FLT and FLE, any NaN is
+// invalid.
+func (f floatFormat) lt(x uint64, y uint64) (bool, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		return false, FFLAGS_NV
+	}
+	return f.orderKey(x) < f.orderKey(y), 0
+}
+
+func (f floatFormat) le(x uint64, y uint64) (bool, uint32) {
+	if f.isNaN(x) || f.isNaN(y) {
+		return false, FFLAGS_NV
+	}
+	return f.orderKey(x) <= f.orderKey(y), 0
+}
+
+// The bits of the FCLASS result
+const (
+	FCLASS_NEG_INF       uint32 = 1 << 0
+	FCLASS_NEG_NORMAL    uint32 = 1 << 1
+	FCLASS_NEG_SUBNORMAL uint32 = 1 << 2
+	FCLASS_NEG_ZERO      uint32 = 1 << 3
+	FCLASS_POS_ZERO      uint32 = 1 << 4
+	FCLASS_POS_SUBNORMAL uint32 = 1 << 5
+	FCLASS_POS_NORMAL    uint32 = 1 << 6
+	FCLASS_POS_INF       uint32 = 1 << 7
+	FCLASS_SNAN          uint32 = 1 << 8
+	FCLASS_QNAN          uint32 = 1 << 9
+)
+
+func (f floatFormat) classify(bits uint64) uint32 {
+	neg := f.isNeg(bits)
+	switch {
+	case f.isSignalingNaN(bits):
+		return FCLASS_SNAN
+	case f.isNaN(bits):
+		return FCLASS_QNAN
+	case f.isInf(bits) && neg:
+		return FCLASS_NEG_INF
+	case f.isInf(bits):
+		return FCLASS_POS_INF
+	case f.isZero(bits) && neg:
+		return FCLASS_NEG_ZERO
+	case f.isZero(bits):
+		return FCLASS_POS_ZERO
+	case f.exp(bits) == 0 && neg:
+		return FCLASS_NEG_SUBNORMAL
+	case f.exp(bits) == 0:
+		return FCLASS_POS_SUBNORMAL
+	case neg:
+		return FCLASS_NEG_NORMAL
+	}
+	return FCLASS_POS_NORMAL
+}
+
+// toInt converts to a width (32 or 64) bit (un)signed integer rounding with
+// rm. NaN and out of range values are invalid and saturate, NaN to the
+// largest value. Negative results are sign extended to 64 bits.
+func (f floatFormat) toInt(bits uint64, signed bool, width int, rm uint32) (uint64, uint32) {
+	lo := big.NewInt(0)
+	hi := new(big.Int).Lsh(big.NewInt(1), uint(width))
+	if signed {
+		lo.Neg(hi).Rsh(lo, 1)
+		hi.Rsh(hi, 1)
+	}
+	hi.Sub(hi, big.NewInt(1))
+	neg := f.isNeg(bits)
+	if f.isNaN(bits) {
+		return intBits(hi), FFLAGS_NV
+	}
+	if f.isInf(bits) {
+		if neg {
+			return intBits(lo), FFLAGS_NV
+		}
+		return intBits(hi), FFLAGS_NV
+	}
+	if f.isZero(bits) {
+		return 0, 0
+	}
+
+	_, mant, exp := f.unpack(bits)
+	if exp+mant.BitLen() > width+1 {
+		// way out of range, don't bother shifting
+		if neg {
+			return intBits(lo), FFLAGS_NV
+		}
+		return intBits(hi), FFLAGS_NV
+	}
+	value, inexact := shiftRound(neg, mant, -exp, false, rm)
+	if neg {
+		value.Neg(value)
+	}
+	if value.Cmp(lo) < 0 {
+		return intBits(lo), FFLAGS_NV
+	}
+	if value.Cmp(hi) > 0 {
+		return intBits(hi), FFLAGS_NV
+	}
+	if inexact {
+		return intBits(value), FFLAGS_NX
+	}
+	return intBits(value), 0
+}
+
+// intBits returns the two's complement of a value in the int64 or uint64
+// range.
+func intBits(value *big.Int) uint64 {
+	if value.Sign() < 0 {
+		return uint64(value.Int64())
+	}
+	return value.Uint64()
+}
+
+// fromInt converts a width (32 or 64) bit (un)signed integer rounding with
+// rm, the bits above width are ignored.
+func (f floatFormat) fromInt(value uint64, signed bool, width int, rm uint32) (uint64, uint32) {
+	value &= xlenMask(width)
+	neg := signed && asSigned(value, width) < 0
+	magnitude := new(big.Int).SetUint64(value)
+	if neg {
+		magnitude.SetInt64(asSigned(value, width))
+		magnitude.Neg(magnitude)
+	}
+	return f.roundPack(neg, magnitude, 0, false, rm)
+}
+
+// convertFloat converts bits from one format to the other rounding with rm.
+func convertFloat(bits uint64, from floatFormat, to floatFormat, rm uint32) (uint64, uint32) {
+	if from.isNaN(bits) {
+		_, flags := from.nanResult(bits)
+		return to.canonicalNaN(), flags
+	}
+	neg := from.isNeg(bits)
+	if from.isInf(bits) {
+		return to.inf(neg), 0
+	}
+	if from.isZero(bits) {
+		return to.zero(neg), 0
+	}
+	_, mant, exp := from.unpack(bits)
+	return to.roundPack(neg, mant, exp, false, rm)
+}
@@ -0,0 +1,570 @@
+package riscv
+
+import (
+	"fmt"
+)
+
+// F and D extension opcodes
+const (
+	LOAD_FP  int8 = 7  // 0000111
+	STORE_FP int8 = 39 // 0100111
+	MADD     int8 = 67 // 1000011
+	MSUB     int8 = 71 // 1000111
+	NMSUB    int8 = 75 // 1001011
+	NMADD    int8 = 79 // 1001111
+	OP_FP    int8 = 83 // 1010011
+)
+
+// The fmt field, the lowest 2 bits of func7 (OP_FP) or bits 26:25 (R4)
+const (
+	FMT_S int8 = 0
+	FMT_D int8 = 1
+)
+
+// LOAD_FP and STORE_FP
+const (
+	FUNC3_FLW int8 = 2
+	FUNC3_FLD int8 = 3
+	FUNC3_FSW int8 = 2
+	FUNC3_FSD int8 = 3
+)
+
+// OP_FP instructions are told apart by the upper 5 bits of func7, func3 is
+// the rounding mode or selects the variant.
+const (
+	FUNCT5_FADD     uint32 = 0  // 00000
+	FUNCT5_FSUB     uint32 = 1  // 00001
+	FUNCT5_FMUL     uint32 = 2  // 00010
+	FUNCT5_FDIV     uint32 = 3  // 00011
+	FUNCT5_FSGNJ    uint32 = 4  // 00100
+	FUNCT5_FMINMAX  uint32 = 5  // 00101
+	FUNCT5_FCVT_FF  uint32 = 8  // 01000
+	FUNCT5_FSQRT    uint32 = 11 // 01011
+	FUNCT5_FCMP     uint32 = 20 // 10100
+	FUNCT5_FCVT_W   uint32 = 24 // 11000
+	FUNCT5_FCVT_F_W uint32 = 26 // 11010
+	FUNCT5_FMV_X    uint32 = 28 // 11100
+	FUNCT5_FMV_F    uint32 = 30 // 11110
+)
+
+const (
+	FUNC3_FSGNJ  int8 = 0
+	FUNC3_FSGNJN int8 = 1
+	FUNC3_FSGNJX int8 = 2
+	FUNC3_FMIN   int8 = 0
+	FUNC3_FMAX   int8 = 1
+	FUNC3_FLE    int8 = 0
+	FUNC3_FLT    int8 = 1
+	FUNC3_FEQ    int8 = 2
+	FUNC3_FMV_X  int8 = 0
+	FUNC3_FCLASS int8 = 1
+)
+
+// The rs2 field of the conversions selects the integer type, or the source
+// format for FCVT.S.D and FCVT.D.S
+const (
+	FCVT_W  int = 0
+	FCVT_WU int = 1
+	FCVT_L  int = 2 // RV64 only
+	FCVT_LU int = 3 // RV64 only
+)
+
+func floatFormatOf(format int8) (floatFormat, error) {
+	switch format {
+	case FMT_S:
+		return float32Format, nil
+	case FMT_D:
+		return float64Format, nil
+	}
+	return floatFormat{}, fmt.Errorf("unsupported floating point format fmt=%d", format)
+}
+
+// readFloat reads fp register i in the given format. Single precision values
+// have to be NaN-boxed (the upper 32 bits all ones), otherwise the value is
+// the canonical NaN.
+func readFloat(regs Registers, i int, format int8) uint64 {
+	value := regs.FReg(i)
+	if format == FMT_S {
+		if value>>32 != 0xffffffff {
+			return float32Format.canonicalNaN()
+		}
+		return value & 0xffffffff
+	}
+	return value
+}
+
+// writeFloat writes fp register i, single precision values are NaN-boxed.
+func writeFloat(regs Registers, i int, format int8, value uint64) {
+	if format == FMT_S {
+		value |= 0xffffffff00000000
+	}
+	regs.SetFReg(i, value)
+}
+
+// roundingMode resolves the rm field, the dynamic rounding mode reads frm.
+// Reserved rounding modes are illegal instructions.
+func roundingMode(regs Registers, rm int8) (uint32, error) {
+	mode := uint32(rm)
+	if mode == RM_DYN {
+		mode = regs.Csrs().frm
+	}
+	if mode > RM_RMM {
+		return 0, fmt.Errorf("invalid rounding mode rm=%d", mode)
+	}
+	return mode, nil
+}
+
+// fcvtWidth returns the width of the integer of a conversion, rs2 selects
+// the integer type.
+func fcvtWidth(regs Registers, rs2 int) (int, error) {
+	switch rs2 {
+	case FCVT_W, FCVT_WU:
+		return XLEN_32, nil
+	case FCVT_L, FCVT_LU:
+		if regs.Xlen() == XLEN_64 {
+			return XLEN_64, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid FCVT integer type, rs2(val=%d) should be 0 or 1 (2 or 3 on RV64)", rs2)
+}
+
+func (Inst IInstr) executeLoadFloat(mem Memory, regs Registers) error {
+	// FLW loads a single-precision floating-point value from memory into floating-point register rd. FLD
+	// loads a double-precision value. The single-precision value is NaN-boxed.
+	var format int8
+	switch Inst.func3 {
+	case FUNC3_FLW:
+		format = FMT_S
+	case FUNC3_FLD:
+		format = FMT_D
+	default:
+		return unknownFunc3Error(Inst.func3, Inst.opcode, IInstrType)
+	}
+
+	addr := effectiveAddress(regs, Inst.rs1, Inst.imm)
+	size := loadSize(Inst.func3)
+	if addr%uint64(size) != 0 {
+		return Exception{Cause: EXC_LOAD_MISALIGNED, Tval: addr}
+	}
+	paddr, err := Translate(mem, regs, addr, AccessLoad)
+	if err != nil {
+		return err
+	}
+
+	value, err := mem.Load(paddr, size)
+	if err != nil {
+		return Exception{Cause: EXC_LOAD_ACCESS_FAULT, Tval: addr, Err: err}
+	}
+
+	writeFloat(regs, Inst.rd, format, value)
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+func (Instr SInstr) executeStoreFloat(mem Memory, regs Registers) error {
+	// FSW and FSD store the low 32 or all 64 bits of floating-point register rs2 to memory, the bits are
+	// not modified in the transfer.
+	if Instr.func3 != FUNC3_FSW && Instr.func3 != FUNC3_FSD {
+		return unknownFunc3Error(Instr.func3, Instr.opcode, SInstrType)
+	}
+
+	addr := effectiveAddress(regs, Instr.rs1, Instr.imm())
+	size := loadSize(Instr.func3)
+	if addr%uint64(size) != 0 {
+		return Exception{Cause: EXC_STORE_MISALIGNED, Tval: addr}
+	}
+	paddr, err := Translate(mem, regs, addr, AccessStore)
+	if err != nil {
+		return err
+	}
+
+	err = mem.Store(paddr, regs.FReg(Instr.rs2), size)
+	if err != nil {
+		return Exception{Cause: EXC_STORE_ACCESS_FAULT, Tval: addr, Err: err}
+	}
+
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+func (Inst RInstr) executeFloat(regs Registers) error {
+	funct5 := bitSliceBetween(uint32(Inst.func7), 2, 6)
+	format := Inst.func7 & 3
+	f, err := floatFormatOf(format)
+	if err != nil {
+		return err
+	}
+
+	rs1 := readFloat(regs, Inst.rs1, format)
+	rs2 := readFloat(regs, Inst.rs2, format)
+	var flags uint32
+
+	switch funct5 {
+	case FUNCT5_FADD, FUNCT5_FSUB, FUNCT5_FMUL, FUNCT5_FDIV, FUNCT5_FSQRT:
+		// FADD, FSUB, FMUL and FDIV perform the addition, subtraction, multiplication and division of rs1
+		// and rs2. FSQRT computes the square root of rs1. The result is rounded with the rounding mode
+		// and written to rd.
+		rm, err := roundingMode(regs, Inst.func3)
+		if err != nil {
+			return err
+		}
+		var result uint64
+		switch funct5 {
+		case FUNCT5_FADD:
+			result, flags = f.add(rs1, rs2, rm)
+		case FUNCT5_FSUB:
+			result, flags = f.sub(rs1, rs2, rm)
+		case FUNCT5_FMUL:
+			result, flags = f.mul(rs1, rs2, rm)
+		case FUNCT5_FDIV:
+			result, flags = f.div(rs1, rs2, rm)
+		default: // FUNCT5_FSQRT
+			if Inst.rs2 != reg_zero {
+				return fmt.Errorf("invalid FSQRT instruction, rs2 should be zero but is %d", Inst.rs2)
+			}
+			result, flags = f.sqrt(rs1, rm)
+		}
+		writeFloat(regs, Inst.rd, format, result)
+	case FUNCT5_FSGNJ:
+		// FSGNJ, FSGNJN and FSGNJX produce a result that takes all bits except the sign bit from rs1. The
+		// sign bit is the sign of rs2, the opposite of the sign of rs2 or the xor of the sign bits of rs1
+		// and rs2. No exceptions are signaled, and the NaN payload is kept.
+		sign := rs2 & f.signBit()
+		switch Inst.func3 {
+		case FUNC3_FSGNJ:
+		case FUNC3_FSGNJN:
+			sign ^= f.signBit()
+		case FUNC3_FSGNJX:
+			sign ^= rs1 & f.signBit()
+		default:
+			return unknownFunc3Error(Inst.func3, Inst.opcode, RInstrType)
+		}
+		writeFloat(regs, Inst.rd, format, rs1&^f.signBit()|sign)
+	case FUNCT5_FMINMAX:
+		// FMIN and FMAX write the smaller or larger of rs1 and rs2 to rd, -0.0 is considered to be less
+		// than +0.0. If only one operand is a NaN, the result is the non-NaN operand.
+		if Inst.func3 != FUNC3_FMIN && Inst.func3 != FUNC3_FMAX {
+			return unknownFunc3Error(Inst.func3, Inst.opcode, RInstrType)
+		}
+		var result uint64
+		result, flags = f.minMax(rs1, rs2, Inst.func3 == FUNC3_FMAX)
+		writeFloat(regs, Inst.rd, format, result)
+	case FUNCT5_FCVT_FF:
+		// FCVT.S.D rounds the double-precision value in rs1 to single-precision, FCVT.D.S converts the
+		// single-precision value to double-precision, which is always exact. The source format is in rs2.
+		from := int8(Inst.rs2)
+		source, err := floatFormatOf(from)
+		if err != nil || from == format {
+			return fmt.Errorf("invalid floating point conversion from fmt=%d to fmt=%d", from, format)
+		}
+		rm, err := roundingMode(regs, Inst.func3)
+		if err != nil {
+			return err
+		}
+		var result uint64
+		result, flags = convertFloat(readFloat(regs, Inst.rs1, from), source, f, rm)
+		writeFloat(regs, Inst.rd, format, result)
+	case FUNCT5_FCMP:
+		// FEQ, FLT and FLE write 1 to the integer register rd if the condition holds and 0 otherwise.
+		// FLT and FLE signal the invalid operation exception if either input is NaN, FEQ only for
+		// signaling NaNs.
+		var holds bool
+		switch Inst.func3 {
+		case FUNC3_FEQ:
+			holds, flags = f.eq(rs1, rs2)
+		case FUNC3_FLT:
+			holds, flags = f.lt(rs1, rs2)
+		case FUNC3_FLE:
+			holds, flags = f.le(rs1, rs2)
+		default:
+			return unknownFunc3Error(Inst.func3, Inst.opcode, RInstrType)
+		}
+		if holds {
+			regs.SetReg(Inst.rd, 1)
+		} else {
+			regs.SetReg(Inst.rd, 0)
+		}
+	case FUNCT5_FCVT_W:
+		// FCVT.W and FCVT.WU convert the floating-point number in rs1 to a signed or unsigned 32-bit
+		// integer in the integer register rd, FCVT.L and FCVT.LU to a 64-bit integer. Out of range values
+		// and NaN saturate and are invalid. On RV64 the 32-bit result is sign extended, also for FCVT.WU.
+		width, err := fcvtWidth(regs, Inst.rs2)
+		if err != nil {
+			return err
+		}
+		rm, err := roundingMode(regs, Inst.func3)
+		if err != nil {
+			return err
+		}
+		var result uint64
+		result, flags = f.toInt(rs1, Inst.rs2 == FCVT_W || Inst.rs2 == FCVT_L, width, rm)
+		if width == XLEN_32 {
+			result = sextWord(result)
+		}
+		regs.SetReg(Inst.rd, result)
+	case FUNCT5_FCVT_F_W:
+		// FCVT.S.W and FCVT.S.WU (and the D variants) convert the signed or unsigned 32-bit integer in the
+		// integer register rs1 into a floating-point number in rd, FCVT.S.L and FCVT.S.LU the 64-bit one.
+		width, err := fcvtWidth(regs, Inst.rs2)
+		if err != nil {
+			return err
+		}
+		rm, err := roundingMode(regs, Inst.func3)
+		if err != nil {
+			return err
+		}
+		var result uint64
+		result, flags = f.fromInt(regs.Reg(Inst.rs1), Inst.rs2 == FCVT_W || Inst.rs2 == FCVT_L, width, rm)
+		writeFloat(regs, Inst.rd, format, result)
+	case FUNCT5_FMV_X:
+		if Inst.rs2 != reg_zero {
+			return fmt.Errorf("invalid FMV.X.W/FCLASS instruction, rs2 should be zero but is %d", Inst.rs2)
+		}
+		switch {
+		case Inst.func3 == FUNC3_FMV_X && format == FMT_S:
+			// FMV.X.W moves the low 32 bits of rs1 to the integer register rd, the bits are not modified
+			// and the NaN-boxing is not checked. On RV64 they are sign extended.
+			regs.SetReg(Inst.rd, sextWord(regs.FReg(Inst.rs1)))
+		case Inst.func3 == FUNC3_FMV_X && format == FMT_D && regs.Xlen() == XLEN_64:
+			// FMV.X.D moves all 64 bits of rs1 to the integer register rd, RV64 only.
+			regs.SetReg(Inst.rd, regs.FReg(Inst.rs1))
+		case Inst.func3 == FUNC3_FCLASS:
+			// FCLASS examines the value in rs1 and writes a 10-bit mask that indicates the class of the
+			// floating-point number to the integer register rd.
+			regs.SetReg(Inst.rd, uint64(f.classify(rs1)))
+		default:
+			return unknownFunc3Error(Inst.func3, Inst.opcode, RInstrType)
+		}
+	case FUNCT5_FMV_F:
+		// FMV.W.X moves the low 32 bits of the integer register rs1 to rd, NaN-boxed. FMV.D.X moves all
+		// 64 bits, RV64 only.
+		if Inst.func3 != 0 || Inst.rs2 != reg_zero || (format == FMT_D && regs.Xlen() != XLEN_64) {
+			return fmt.Errorf("invalid FMV.W.X/FMV.D.X instruction")
+		}
+		value := regs.Reg(Inst.rs1)
+		if format == FMT_S {
+			value &= 0xffffffff
+		}
+		writeFloat(regs, Inst.rd, format, value)
+	default:
+		return fmt.Errorf("invalid funct5(val=%v) on OP_FP instruction", funct5)
+	}
+
+	regs.Csrs().accrueFlags(flags)
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+// R4Instr is the format of the fused multiply-add instructions, which have a
+// third source register.
+type R4Instr struct {
+	rs3    int
+	format int8
+	rs2    int
+	rs1    int
+	func3  int8
+	rd     int
+	opcode int8
+}
+
+func (Instr R4Instr) String() string {
+	return fmt.Sprintf("R4Instr{rs3=%d, fmt=%d, rs2=%d, rs1=%d, func3=%d, rd=%d, opcode=%d}",
+		Instr.rs3,
+		Instr.format,
+		Instr.rs2,
+		Instr.rs1,
+		Instr.func3,
+		Instr.rd,
+		Instr.opcode)
+}
+
+func (Inst R4Instr) Execute(mem Memory, regs Registers) error {
+	// FMADD computes (rs1×rs2)+rs3, FMSUB (rs1×rs2)-rs3, FNMSUB -(rs1×rs2)+rs3 and FNMADD
+	// -(rs1×rs2)-rs3, with a single rounding of the result.
+	var negProduct, negAddend bool
+	switch Inst.opcode {
+	case MADD:
+	case MSUB:
+		negAddend = true
+	case NMSUB:
+		negProduct = true
+	case NMADD:
+		negProduct = true
+		negAddend = true
+	default:
+		return unknowOpcodeError(Inst.opcode, R4InstrType)
+	}
+
+	f, err := floatFormatOf(Inst.format)
+	if err != nil {
+		return err
+	}
+	rm, err := roundingMode(regs, Inst.func3)
+	if err != nil {
+		return err
+	}
+
+	rs1 := readFloat(regs, Inst.rs1, Inst.format)
+	rs2 := readFloat(regs, Inst.rs2, Inst.format)
+	rs3 := readFloat(regs, Inst.rs3, Inst.format)
+	result, flags := f.fma(rs1, rs2, rs3, negProduct, negAddend, rm)
+
+	writeFloat(regs, Inst.rd, Inst.format, result)
+	regs.Csrs().accrueFlags(flags)
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+func CreateFLW(offset int32, addr int, dst int) IInstr {
+	return IInstr{imm: ReinterpreteAsUnsigned(offset), rs1: addr, func3: FUNC3_FLW, rd: dst, opcode: LOAD_FP}
+}
+
+func CreateFLD(offset int32, addr int, dst int) IInstr {
+	return IInstr{imm: ReinterpreteAsUnsigned(offset), rs1: addr, func3: FUNC3_FLD, rd: dst, opcode: LOAD_FP}
+}
+
+func CreateFSW(offset int32, data int, addr int) SInstr {
+	instr := CreateStore(offset, data, addr, FUNC3_FSW)
+	instr.opcode = STORE_FP
+	return instr
+}
+
+func CreateFSD(offset int32, data int, addr int) SInstr {
+	instr := CreateStore(offset, data, addr, FUNC3_FSD)
+	instr.opcode = STORE_FP
+	return instr
+}
+
+func createOpFp(funct5 uint32, format int8, rd int, rs1 int, rs2 int, func3 int8) RInstr {
+	return RInstr{func7: int8(funct5<<2) | format, rs2: rs2, rs1: rs1, func3: func3, rd: rd, opcode: OP_FP}
+}
+
+func CreateFADD(format int8, rd int, rs1 int, rs2 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FADD, format, rd, rs1, rs2, rm)
+}
+
+func CreateFSUB(format int8, rd int, rs1 int, rs2 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FSUB, format, rd, rs1, rs2, rm)
+}
+
+func CreateFMUL(format int8, rd int, rs1 int, rs2 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FMUL, format, rd, rs1, rs2, rm)
+}
+
+func CreateFDIV(format int8, rd int, rs1 int, rs2 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FDIV, format, rd, rs1, rs2, rm)
+}
+
+func CreateFSQRT(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FSQRT, format, rd, rs1, reg_zero, rm)
+}
+
+func CreateFSGNJ(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FSGNJ, format, rd, rs1, rs2, FUNC3_FSGNJ)
+}
+
+func CreateFSGNJN(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FSGNJ, format, rd, rs1, rs2, FUNC3_FSGNJN)
+}
+
+func CreateFSGNJX(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FSGNJ, format, rd, rs1, rs2, FUNC3_FSGNJX)
+}
+
+func CreateFMIN(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FMINMAX, format, rd, rs1, rs2, FUNC3_FMIN)
+}
+
+func CreateFMAX(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FMINMAX, format, rd, rs1, rs2, FUNC3_FMAX)
+}
+
+// CreateFCVTFF converts from the format from to the format to, FCVT.S.D
+// and FCVT.D.S.
+func CreateFCVTFF(to int8, from int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_FF, to, rd, rs1, int(from), rm)
+}
+
+func CreateFEQ(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FCMP, format, rd, rs1, rs2, FUNC3_FEQ)
+}
+
+func CreateFLT(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FCMP, format, rd, rs1, rs2, FUNC3_FLT)
+}
+
+func CreateFLE(format int8, rd int, rs1 int, rs2 int) RInstr {
+	return createOpFp(FUNCT5_FCMP, format, rd, rs1, rs2, FUNC3_FLE)
+}
+
+func CreateFCVTW(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_W, format, rd, rs1, FCVT_W, rm)
+}
+
+func CreateFCVTWU(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_W, format, rd, rs1, FCVT_WU, rm)
+}
+
+func CreateFCVTFromW(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_F_W, format, rd, rs1, FCVT_W, rm)
+}
+
+func CreateFCVTFromWU(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_F_W, format, rd, rs1, FCVT_WU, rm)
+}
+
+func CreateFCVTL(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_W, format, rd, rs1, FCVT_L, rm)
+}
+
+func CreateFCVTLU(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_W, format, rd, rs1, FCVT_LU, rm)
+}
+
+func CreateFCVTFromL(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_F_W, format, rd, rs1, FCVT_L, rm)
+}
+
+func CreateFCVTFromLU(format int8, rd int, rs1 int, rm int8) RInstr {
+	return createOpFp(FUNCT5_FCVT_F_W, format, rd, rs1, FCVT_LU, rm)
+}
+
+func CreateFMVXW(rd int, rs1 int) RInstr {
+	return createOpFp(FUNCT5_FMV_X, FMT_S, rd, rs1, reg_zero, FUNC3_FMV_X)
+}
+
+func CreateFCLASS(format int8, rd int, rs1 int) RInstr {
+	return createOpFp(FUNCT5_FMV_X, format, rd, rs1, reg_zero, FUNC3_FCLASS)
+}
+
+func CreateFMVWX(rd int, rs1 int) RInstr {
+	return createOpFp(FUNCT5_FMV_F, FMT_S, rd, rs1, reg_zero, 0)
+}
+
+func CreateFMVXD(rd int, rs1 int) RInstr {
+	return createOpFp(FUNCT5_FMV_X, FMT_D, rd, rs1, reg_zero, FUNC3_FMV_X)
+}
+
+func CreateFMVDX(rd int, rs1 int) RInstr {
+	return createOpFp(FUNCT5_FMV_F, FMT_D, rd, rs1, reg_zero, 0)
+}
+
+func createFusedMulAdd(opcode int8, format int8, rd int, rs1 int, rs2 int, rs3 int, rm int8) R4Instr {
+	return R4Instr{rs3: rs3, format: format, rs2: rs2, rs1: rs1, func3: rm, rd: rd, opcode: opcode}
+}
+
+func CreateFMADD(format int8, rd int, rs1 int, rs2 int, rs3 int, rm int8) R4Instr {
+	return createFusedMulAdd(MADD, format, rd, rs1, rs2, rs3, rm)
+}
+
+func CreateFMSUB(format int8, rd int, rs1 int, rs2 int, rs3 int, rm int8) R4Instr {
+	return createFusedMulAdd(MSUB, format, rd, rs1, rs2, rs3, rm)
+}
+
+func CreateFNMSUB(format int8, rd int, rs1 int, rs2 int, rs3 int, rm int8) R4Instr {
+	return createFusedMulAdd(NMSUB, format, rd, rs1, rs2, rs3, rm)
+}
+
+func CreateFNMADD(format int8, rd int, rs1 int, rs2 int, rs3 int, rm int8) R4Instr {
+	return createFusedMulAdd(NMADD, format, rd, rs1, rs2, rs3, rm)
+}
@@ -0,0 +1,262 @@
+package riscv
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func CheckFReg(regIndex int, expected uint64, r Registers, t *testing.T) {
+	if r.FReg(regIndex) != expected {
+		t.Logf("freg[%d]==%#x and should be %#x", regIndex, r.FReg(regIndex), expected)
+		t.Fail()
+	}
+}
+
+func boxed(f float32) uint64 {
+	return 0xffffffff00000000 | uint64(math.Float32bits(f))
+}
+
+func TestFLWFSW(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(16)
+	mem.Store(4, uint64(math.Float32bits(1.5)), 4)
+	r.reg[reg_a1] = 4
+
+	err := CreateFLW(0, reg_a1, 1).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("flw failed with error %v", err)
+	}
+	// single precision values are NaN-boxed
+	CheckFReg(1, boxed(1.5), &r, t)
+	CheckPc(4, &r, t)
+
+	err = CreateFSW(4, 1, reg_a1).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("fsw failed with error %v", err)
+	}
+	CheckMem(8, uint64(math.Float32bits(1.5)), &mem, t)
+	CheckPc(8, &r, t)
+}
+
+func TestFLDFSD(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(32)
+	value := math.Float64bits(-2.25)
+	r.reg[reg_a1] = 8
+	r.freg[2] = value
+
+	err := CreateFSD(8, 2, reg_a1).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("fsd failed with error %v", err)
+	}
+	CheckMem(16, value&0xffffffff, &mem, t)
+	CheckMem(20, value>>32, &mem, t)
+
+	err = CreateFLD(8, reg_a1, 3).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("fld failed with error %v", err)
+	}
+	CheckFReg(3, value, &r, t)
+
+	// doubles have to be 8 byte aligned
+	r.reg[reg_a1] = 4
+	err = CreateFLD(0, reg_a1, 3).Execute(&mem, &r)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != EXC_LOAD_MISALIGNED {
+		t.Fatalf("misaligned fld should raise a load misaligned exception but got %v", err)
+	}
+}
+
+func TestFADDDynamicRounding(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+	r.freg[1] = boxed(1)
+	r.freg[2] = boxed(3)
+
+	// 1/3 rounded up with the rounding mode in frm, the flags accrue
+	r.csr.frm = RM_RUP
+	err := CreateFDIV(FMT_S, 3, 1, 2, int8(RM_DYN)).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("fdiv.s failed with error %v", err)
+	}
+	CheckFReg(3, 0xffffffff3eaaaaab, &r, t)
+	CheckCsr(CSR_FFLAGS, uint64(FFLAGS_NX), &r, t)
+
+	// unlike x0, f0 is a normal register
+	r.freg[0] = boxed(0)
+	CreateFDIV(FMT_S, 3, 1, 0, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(3, boxed(float32(math.Inf(1))), &r, t)
+	CheckCsr(CSR_FFLAGS, uint64(FFLAGS_NX|FFLAGS_DZ), &r, t)
+
+	// reserved rounding modes are illegal
+	r.csr.frm = 5
+	err = CreateFADD(FMT_S, 3, 1, 2, int8(RM_DYN)).Execute(&mem, &r)
+	if err == nil {
+		t.Fatalf("fadd.s with an invalid frm should fail")
+	}
+}
+
+func TestFloatNaNBoxing(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// a single precision operand that is not NaN-boxed is the canonical NaN
+	r.freg[1] = uint64(math.Float32bits(1))
+	r.freg[2] = boxed(2)
+	CreateFADD(FMT_S, 3, 1, 2, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(3, 0xffffffff7fc00000, &r, t)
+
+	// but moves transfer the bits unchanged
+	CreateFMVXW(reg_a0, 1).Execute(&mem, &r)
+	CheckReg(reg_a0, uint64(math.Float32bits(1)), &r, t)
+	r.reg[reg_a1] = uint64(math.Float32bits(4))
+	CreateFMVWX(4, reg_a1).Execute(&mem, &r)
+	CheckFReg(4, boxed(4), &r, t)
+
+	// the double precision operations see the whole register
+	r.freg[5] = math.Float64bits(1)
+	CreateFCVTFF(FMT_S, FMT_D, 6, 5, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(6, boxed(1), &r, t)
+	CreateFCVTFF(FMT_D, FMT_S, 7, 6, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(7, math.Float64bits(1), &r, t)
+}
+
+func TestFloatToFromInteger(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.reg[reg_a1] = uint64(ReinterpreteAsUnsigned(-7))
+	CreateFCVTFromW(FMT_D, 1, reg_a1, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(1, math.Float64bits(-7), &r, t)
+	CreateFCVTFromWU(FMT_D, 1, reg_a1, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(1, math.Float64bits(4294967289), &r, t)
+
+	r.freg[2] = math.Float64bits(-7.5)
+	CreateFCVTW(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, &r)
+	CheckReg(reg_a0, uint64(ReinterpreteAsUnsigned(-7)), &r, t)
+	CreateFCVTWU(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, &r)
+	CheckReg(reg_a0, 0, &r, t)
+	CheckCsr(CSR_FFLAGS, uint64(FFLAGS_NX|FFLAGS_NV), &r, t)
+}
+
+func TestFloatRV64Integer(t *testing.T) {
+	r := NewRegisters(XLEN_64)
+	mem := NewMemory(0)
+
+	// the 32-bit results are sign extended, also for the unsigned conversion
+	r.freg[2] = math.Float64bits(-7.5)
+	CreateFCVTW(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, r)
+	CheckReg(reg_a0, 0xfffffffffffffff9, r, t)
+	r.freg[2] = math.Float64bits(3e9)
+	CreateFCVTWU(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, r)
+	CheckReg(reg_a0, 0xffffffffb2d05e00, r, t)
+	CreateFCVTL(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, r)
+	CheckReg(reg_a0, 3000000000, r, t)
+	r.freg[2] = math.Float64bits(1e19)
+	CreateFCVTL(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, r)
+	CheckReg(reg_a0, 0x7fffffffffffffff, r, t)
+	CreateFCVTLU(FMT_D, reg_a0, 2, int8(RM_RTZ)).Execute(&mem, r)
+	CheckReg(reg_a0, 10000000000000000000, r, t)
+	CheckCsr(CSR_FFLAGS, uint64(FFLAGS_NX|FFLAGS_NV), r, t)
+
+	r.reg[reg_a1] = 0xffffffffffffffff
+	CreateFCVTFromL(FMT_D, 1, reg_a1, int8(RM_RNE)).Execute(&mem, r)
+	CheckFReg(1, math.Float64bits(-1), r, t)
+	CreateFCVTFromLU(FMT_D, 1, reg_a1, int8(RM_RNE)).Execute(&mem, r)
+	CheckFReg(1, math.Float64bits(18446744073709551615), r, t)
+
+	// fmv.x.w sign extends, the D moves transfer all 64 bits
+	r.freg[4] = boxed(-2)
+	CreateFMVXW(reg_a0, 4).Execute(&mem, r)
+	CheckReg(reg_a0, 0xffffffffc0000000, r, t)
+	CreateFMVXD(reg_a0, 1).Execute(&mem, r)
+	CheckReg(reg_a0, math.Float64bits(1<<64), r, t)
+	r.reg[reg_a1] = math.Float64bits(-2.5)
+	CreateFMVDX(3, reg_a1).Execute(&mem, r)
+	CheckFReg(3, math.Float64bits(-2.5), r, t)
+
+	// the 64-bit conversions and moves are RV64 only
+	r32 := RegistersImpl{}
+	for _, instr := range []Instruction{
+		CreateFCVTL(FMT_D, reg_a0, 2, int8(RM_RTZ)),
+		CreateFCVTFromLU(FMT_S, 1, reg_a1, int8(RM_RNE)),
+		CreateFMVXD(reg_a0, 1),
+		CreateFMVDX(1, reg_a0),
+	} {
+		if instr.Execute(&mem, &r32) == nil {
+			t.Errorf("%s should fail on RV32", instr.String())
+		}
+	}
+}
+
+func TestFloatCompareSignClass(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+	r.freg[1] = math.Float64bits(1)
+	r.freg[2] = math.Float64bits(-2)
+
+	CreateFLT(FMT_D, reg_a0, 2, 1).Execute(&mem, &r)
+	CheckReg(reg_a0, 1, &r, t)
+	CreateFEQ(FMT_D, reg_a0, 2, 1).Execute(&mem, &r)
+	CheckReg(reg_a0, 0, &r, t)
+
+	CreateFSGNJN(FMT_D, 3, 1, 2).Execute(&mem, &r)
+	CheckFReg(3, math.Float64bits(1), &r, t)
+	CreateFSGNJX(FMT_D, 3, 2, 2).Execute(&mem, &r)
+	CheckFReg(3, math.Float64bits(2), &r, t)
+	CreateFMIN(FMT_D, 3, 1, 2).Execute(&mem, &r)
+	CheckFReg(3, math.Float64bits(-2), &r, t)
+
+	CreateFCLASS(FMT_D, reg_a0, 2).Execute(&mem, &r)
+	CheckReg(reg_a0, uint64(FCLASS_NEG_NORMAL), &r, t)
+	CheckCsr(CSR_FFLAGS, 0, &r, t)
+}
+
+func TestFMADD(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+	// (1+2^-52)*(1-2^-52) = 1-2^-104, which is only exact with a single rounding
+	r.freg[1] = math.Float64bits(1 + 0x1p-52)
+	r.freg[2] = math.Float64bits(1 - 0x1p-52)
+	r.freg[3] = math.Float64bits(-1)
+
+	err := CreateFMADD(FMT_D, 4, 1, 2, 3, int8(RM_RNE)).Execute(&mem, &r)
+	if err != nil {
+		t.Fatalf("fmadd.d failed with error %v", err)
+	}
+	CheckFReg(4, math.Float64bits(-0x1p-104), &r, t)
+	CheckPc(4, &r, t)
+
+	CreateFNMADD(FMT_D, 4, 1, 2, 3, int8(RM_RNE)).Execute(&mem, &r)
+	CheckFReg(4, math.Float64bits(0x1p-104), &r, t)
+}
+
+func TestDecodeFloat(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+
+	tests := []struct {
+		word     uint32
+		expected Instruction
+	}{
+		{0x00c5f553, CreateFADD(FMT_S, 10, 11, 12, int8(RM_DYN))},      // fadd.s fa0, fa1, fa2
+		{0x6ac5f543, CreateFMADD(FMT_D, 10, 11, 12, 13, int8(RM_DYN))}, // fmadd.d fa0, fa1, fa2, fa3
+		{0x00813507, CreateFLD(8, reg_sp, 10)},                         // fld fa0, 8(sp)
+		{0x00a13427, CreateFSD(8, 10, reg_sp)},                         // fsd fa0, 8(sp)
+		{0xc2059553, CreateFCVTW(FMT_D, reg_a0, 11, int8(RM_RTZ))},     // fcvt.w.d a0, fa1, rtz
+		{0xe0058553, CreateFMVXW(reg_a0, 11)},                          // fmv.x.w a0, fa1
+		{0xe0059553, CreateFCLASS(FMT_S, reg_a0, 11)},                  // fclass.s a0, fa1
+	}
+
+	for _, test := range tests {
+		res, err := d.Decode(test.word)
+		if err != nil {
+			t.Errorf("decoding %#08x failed with error %v", test.word, err)
+			continue
+		}
+		if res != test.expected {
+			t.Errorf("decoding %#08x\nres=     %s\nexpected=%s", test.word, res.String(), test.expected.String())
+		}
+	}
+}
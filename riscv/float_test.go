@@ -0,0 +1,255 @@
+package riscv
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"testing"
+)
+
+// randomFloatBits returns random bit patterns, biased towards exponents
+// close to each other and special values, so the rounding, subnormal and
+// cancellation paths are all hit.
+func randomFloatBits(rnd *rand.Rand, f floatFormat) uint64 {
+	switch rnd.Intn(8) {
+	case 0:
+		specials := []uint64{0, f.signBit(), f.inf(false), f.inf(true), f.canonicalNaN(), 1, f.maxFinite(false)}
+		return specials[rnd.Intn(len(specials))]
+	case 1:
+		// subnormal
+		return rnd.Uint64() & (f.signBit() | f.fracMask())
+	case 2, 3, 4:
+		// exponent around 1.0
+		exp := uint64(f.bias()-8+rnd.Intn(16)) << f.fracBits
+		return rnd.Uint64()&(f.signBit()|f.fracMask()) | exp
+	}
+	return rnd.Uint64() & (f.signBit()<<1 - 1)
+}
+
+// checkNative compares against the result of the (round to nearest even)
+// native arithmetic, NaN results only have to be the canonical NaN.
+func checkNative(t *testing.T, f floatFormat, name string, operands []uint64, res uint64, native uint64) {
+	if f.isNaN(native) {
+		if res != f.canonicalNaN() {
+			t.Errorf("%s%#x = %#x should be the canonical NaN", name, operands, res)
+		}
+	} else if res != native {
+		t.Errorf("%s%#x = %#x should be %#x", name, operands, res, native)
+	}
+}
+
+func TestFloat64Native(t *testing.T) {
+	f := float64Format
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		x, y, z := randomFloatBits(rnd, f), randomFloatBits(rnd, f), randomFloatBits(rnd, f)
+		fx, fy, fz := math.Float64frombits(x), math.Float64frombits(y), math.Float64frombits(z)
+		ops := []uint64{x, y}
+
+		res, _ := f.add(x, y, RM_RNE)
+		checkNative(t, f, "add", ops, res, math.Float64bits(fx+fy))
+		res, _ = f.sub(x, y, RM_RNE)
+		checkNative(t, f, "sub", ops, res, math.Float64bits(fx-fy))
+		res, _ = f.mul(x, y, RM_RNE)
+		checkNative(t, f, "mul", ops, res, math.Float64bits(fx*fy))
+		res, _ = f.div(x, y, RM_RNE)
+		checkNative(t, f, "div", ops, res, math.Float64bits(fx/fy))
+		res, _ = f.sqrt(x, RM_RNE)
+		checkNative(t, f, "sqrt", ops[:1], res, math.Float64bits(math.Sqrt(fx)))
+		res, _ = f.fma(x, y, z, false, false, RM_RNE)
+		checkNative(t, f, "fma", []uint64{x, y, z}, res, math.Float64bits(math.FMA(fx, fy, fz)))
+		res, _ = convertFloat(x, f, float32Format, RM_RNE)
+		checkNative(t, float32Format, "cvt.s.d", ops[:1], res, uint64(math.Float32bits(float32(fx))))
+	}
+}
+
+func TestFloat32Native(t *testing.T) {
+	f := float32Format
+	rnd := rand.New(rand.NewSource(2))
+	for i := 0; i < 5000; i++ {
+		x, y := randomFloatBits(rnd, f), randomFloatBits(rnd, f)
+		fx, fy := math.Float32frombits(uint32(x)), math.Float32frombits(uint32(y))
+		ops := []uint64{x, y}
+
+		res, _ := f.add(x, y, RM_RNE)
+		checkNative(t, f, "add", ops, res, uint64(math.Float32bits(fx+fy)))
+		res, _ = f.mul(x, y, RM_RNE)
+		checkNative(t, f, "mul", ops, res, uint64(math.Float32bits(fx*fy)))
+		res, _ = f.div(x, y, RM_RNE)
+		checkNative(t, f, "div", ops, res, uint64(math.Float32bits(fx/fy)))
+		res, _ = convertFloat(x, f, float64Format, RM_RNE)
+		checkNative(t, float64Format, "cvt.d.s", ops[:1], res, math.Float64bits(float64(fx)))
+
+		i := uint32(rnd.Uint64())
+		res, _ = f.fromInt(uint64(i), true, XLEN_32, RM_RNE)
+		checkNative(t, f, "cvt.s.w", []uint64{uint64(i)}, res, uint64(math.Float32bits(float32(int32(i)))))
+	}
+}
+
+func TestFloatRoundingModes(t *testing.T) {
+	f := float32Format
+	one := uint64(math.Float32bits(1))
+	three := uint64(math.Float32bits(3))
+	minusOne := uint64(math.Float32bits(-1))
+
+	tests := []struct {
+		rm       uint32
+		x        uint64
+		expected uint64
+	}{
+		{RM_RNE, one, 0x3eaaaaab},
+		{RM_RTZ, one, 0x3eaaaaaa},
+		{RM_RDN, one, 0x3eaaaaaa},
+		{RM_RUP, one, 0x3eaaaaab},
+		{RM_RMM, one, 0x3eaaaaab},
+		{RM_RTZ, minusOne, 0xbeaaaaaa},
+		{RM_RDN, minusOne, 0xbeaaaaab},
+		{RM_RUP, minusOne, 0xbeaaaaaa},
+	}
+	for _, test := range tests {
+		res, flags := f.div(test.x, three, test.rm)
+		if res != test.expected || flags != FFLAGS_NX {
+			t.Errorf("%#x/3 with rm=%d = (%#x, flags=%#x) should be (%#x, flags=%#x)", test.x, test.rm, res, flags, test.expected, FFLAGS_NX)
+		}
+	}
+
+	// ties: 1 + 2^-24 is exactly between 1 and the next float
+	half := uint64(math.Float32bits(1.0 / (1 << 24)))
+	res, _ := f.add(one, half, RM_RNE)
+	Assert(t, res, one)
+	res, _ = f.add(one, half, RM_RMM)
+	Assert(t, res, one+1)
+
+	// an exact zero sum is -0 when rounding down
+	res, _ = f.sub(one, one, RM_RNE)
+	Assert(t, res, uint64(0))
+	res, _ = f.sub(one, one, RM_RDN)
+	Assert(t, res, f.signBit())
+}
+
+func TestFloatOverflowUnderflow(t *testing.T) {
+	f := float32Format
+	max := f.maxFinite(false)
+	two := uint64(math.Float32bits(2))
+	half := uint64(math.Float32bits(0.5))
+
+	res, flags := f.mul(max, two, RM_RNE)
+	Assert(t, res, f.inf(false))
+	Assert(t, flags, FFLAGS_OF|FFLAGS_NX)
+	res, _ = f.mul(max, two, RM_RTZ)
+	Assert(t, res, max)
+	res, _ = f.mul(max|f.signBit(), two, RM_RUP)
+	Assert(t, res, max|f.signBit())
+
+	// halving the smallest normal number is exact
+	res, flags = f.mul(0x00800000, half, RM_RNE)
+	Assert(t, res, uint64(0x00400000))
+	Assert(t, flags, uint32(0))
+
+	// halving the smallest subnormal rounds to zero (even) or up
+	res, flags = f.mul(1, half, RM_RNE)
+	Assert(t, res, uint64(0))
+	Assert(t, flags, FFLAGS_UF|FFLAGS_NX)
+	res, _ = f.mul(1, half, RM_RUP)
+	Assert(t, res, uint64(1))
+
+	// tininess is detected after rounding: 2^-126*(1-2^-24) is tiny as it
+	// fits in 24 bits, 2^-126*(1-2^-25) rounds to 2^-126 with 24 bits
+	res, flags = f.roundPack(false, big.NewInt(1<<24-1), -150, false, RM_RNE)
+	Assert(t, res, uint64(0x00800000))
+	Assert(t, flags, FFLAGS_UF|FFLAGS_NX)
+	res, flags = f.roundPack(false, big.NewInt(1<<25-1), -151, false, RM_RNE)
+	Assert(t, res, uint64(0x00800000))
+	Assert(t, flags, FFLAGS_NX)
+}
+
+func TestFloatInvalid(t *testing.T) {
+	f := float64Format
+	inf := f.inf(false)
+	snan := f.inf(false) | 1
+	one := math.Float64bits(1)
+
+	res, flags := f.sub(inf, inf, RM_RNE)
+	Assert(t, res, f.canonicalNaN())
+	Assert(t, flags, FFLAGS_NV)
+
+	_, flags = f.div(one, 0, RM_RNE)
+	Assert(t, flags, FFLAGS_DZ)
+
+	_, flags = f.sqrt(one|f.signBit(), RM_RNE)
+	Assert(t, flags, FFLAGS_NV)
+
+	// quiet NaNs propagate without raising invalid, signaling ones raise it
+	_, flags = f.add(f.canonicalNaN(), one, RM_RNE)
+	Assert(t, flags, uint32(0))
+	_, flags = f.add(snan, one, RM_RNE)
+	Assert(t, flags, FFLAGS_NV)
+
+	// inf*0 in a fused multiply add is invalid even with a quiet NaN addend
+	res, flags = f.fma(inf, 0, f.canonicalNaN(), false, false, RM_RNE)
+	Assert(t, res, f.canonicalNaN())
+	Assert(t, flags, FFLAGS_NV)
+}
+
+func TestFloatToInt(t *testing.T) {
+	f := float64Format
+	tests := []struct {
+		x        float64
+		signed   bool
+		rm       uint32
+		expected uint32
+		flags    uint32
+	}{
+		{2.5, true, RM_RNE, 2, FFLAGS_NX},
+		{2.5, true, RM_RMM, 3, FFLAGS_NX},
+		{-2.5, true, RM_RDN, ReinterpreteAsUnsigned(-3), FFLAGS_NX},
+		{-2.5, true, RM_RTZ, ReinterpreteAsUnsigned(-2), FFLAGS_NX},
+		{-2147483648, true, RM_RNE, 0x80000000, 0},
+		{3e9, true, RM_RNE, 0x7fffffff, FFLAGS_NV},
+		{3e9, false, RM_RNE, 3000000000, 0},
+		{-0.25, false, RM_RTZ, 0, FFLAGS_NX},
+		{-1, false, RM_RNE, 0, FFLAGS_NV},
+		{-1e300, true, RM_RNE, 0x80000000, FFLAGS_NV},
+		{math.NaN(), true, RM_RNE, 0x7fffffff, FFLAGS_NV},
+		{math.NaN(), false, RM_RNE, 0xffffffff, FFLAGS_NV},
+	}
+	for _, test := range tests {
+		res, flags := f.toInt(math.Float64bits(test.x), test.signed, XLEN_32, test.rm)
+		if uint32(res) != test.expected || flags != test.flags {
+			t.Errorf("cvt %v (signed=%v, rm=%d) = (%#x, flags=%#x) should be (%#x, flags=%#x)",
+				test.x, test.signed, test.rm, res, flags, test.expected, test.flags)
+		}
+	}
+}
+
+func TestFloatMinMaxCompare(t *testing.T) {
+	f := float32Format
+	one := uint64(math.Float32bits(1))
+	snan := f.inf(false) | 1
+
+	res, _ := f.minMax(f.signBit(), 0, false)
+	Assert(t, res, f.signBit())
+	res, _ = f.minMax(f.signBit(), 0, true)
+	Assert(t, res, uint64(0))
+	res, flags := f.minMax(snan, one, false)
+	Assert(t, res, one)
+	Assert(t, flags, FFLAGS_NV)
+	res, _ = f.minMax(f.canonicalNaN(), f.canonicalNaN(), true)
+	Assert(t, res, f.canonicalNaN())
+
+	eq, flags := f.eq(f.canonicalNaN(), one)
+	Assert(t, eq, false)
+	Assert(t, flags, uint32(0))
+	lt, flags := f.lt(f.canonicalNaN(), one)
+	Assert(t, lt, false)
+	Assert(t, flags, FFLAGS_NV)
+	le, _ := f.le(f.signBit(), 0)
+	Assert(t, le, true)
+
+	Assert(t, f.classify(f.inf(true)), FCLASS_NEG_INF)
+	Assert(t, f.classify(1), FCLASS_POS_SUBNORMAL)
+	Assert(t, f.classify(f.signBit()), FCLASS_NEG_ZERO)
+	Assert(t, f.classify(one), FCLASS_POS_NORMAL)
+	Assert(t, f.classify(snan), FCLASS_SNAN)
+	Assert(t, f.classify(f.canonicalNaN()), FCLASS_QNAN)
+}
@@ -0,0 +1,625 @@
+package riscv
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// The register numbers gdb uses for riscv, the CSRs are GDB_REG_CSR0+csr.
+const (
+	GDB_REG_PC     = 32
+	GDB_REG_FP0    = 33
+	GDB_REG_CSR0   = 65
+	GDB_REG_FFLAGS = GDB_REG_CSR0 + 0x001
+	GDB_REG_FRM    = GDB_REG_CSR0 + 0x002
+	GDB_REG_FCSR   = GDB_REG_CSR0 + 0x003
+	GDB_REG_PRIV   = GDB_REG_CSR0 + 0x1000
+)
+
+// The CSRs in the target description, the other ones can't be shown by gdb.
+var gdbCsrs = []struct {
+	name string
+	csr  uint32
+}{
+	{"mstatus", CSR_MSTATUS}, {"misa", CSR_MISA}, {"medeleg", CSR_MEDELEG}, {"mideleg", CSR_MIDELEG},
+	{"mie", CSR_MIE}, {"mtvec", CSR_MTVEC}, {"mscratch", CSR_MSCRATCH}, {"mepc", CSR_MEPC},
+	{"mcause", CSR_MCAUSE}, {"mtval", CSR_MTVAL}, {"mip", CSR_MIP}, {"sstatus", CSR_SSTATUS},
+	{"sie", CSR_SIE}, {"stvec", CSR_STVEC}, {"sscratch", CSR_SSCRATCH}, {"sepc", CSR_SEPC},
+	{"scause", CSR_SCAUSE}, {"stval", CSR_STVAL}, {"sip", CSR_SIP}, {"satp", CSR_SATP},
+}
+
+// Signals in the stop replies
+const (
+	gdbSigInt  = 2
+	gdbSigTrap = 5
+	gdbSigSegv = 11
+)
+
+// gdbPacketSize is the largest packet gdb may send, in hex characters, so
+// memory reads are at most half of it in bytes.
+const gdbPacketSize = 0x4000
+
+// GDBServer lets gdb debug the program running on the emulator over the gdb
+// remote serial protocol. Memory is accessed with physical addresses, so
+// the program has to run without address translation to be debugged with
+// symbols.
+type GDBServer struct {
+	emu *Emulator
+	// the breakpoints set with Z1, to tell hardware and software
+	// breakpoints apart in the stop reply
+	hwBreakpoints map[uint64]bool
+	noAck         bool
+
+	in  chan byte
+	out io.Writer
+}
+
+func NewGDBServer(emu *Emulator) *GDBServer {
+	return &GDBServer{emu: emu, hwBreakpoints: map[uint64]bool{}}
+}
+
+// Serve handles the gdb connection until gdb detaches or kills the program,
+// or the connection is closed.
+func (s *GDBServer) Serve(conn io.ReadWriter) error {
+	s.in = make(chan byte, 4096)
+	s.out = conn
+	s.noAck = false
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			for _, b := range buf[:n] {
+				s.in <- b
+			}
+			if err != nil {
+				close(s.in)
+				return
+			}
+		}
+	}()
+
+	for {
+		packet, err := s.readPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if packet == "k" {
+			// there is no reply to a kill
+			return nil
+		}
+		reply, done := s.handle(packet)
+		err = s.writePacket(reply)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// readPacket returns the data of the next $data#checksum packet, bytes in
+// between packets (acks and interrupts while the program is stopped) are
+// dropped.
+func (s *GDBServer) readPacket() (string, error) {
+	for {
+		b, ok := <-s.in
+		if !ok {
+			return "", io.EOF
+		}
+		if b != '$' {
+			continue
+		}
+
+		var data []byte
+		for {
+			b, ok = <-s.in
+			if !ok {
+				return "", io.EOF
+			}
+			if b == '#' {
+				break
+			}
+			data = append(data, b)
+		}
+		var checksum [2]byte
+		for i := range checksum {
+			checksum[i], ok = <-s.in
+			if !ok {
+				return "", io.EOF
+			}
+		}
+
+		if s.noAck {
+			return string(data), nil
+		}
+		expected, err := strconv.ParseUint(string(checksum[:]), 16, 8)
+		if err != nil || uint8(expected) != gdbChecksum(data) {
+			// ask for a retransmission
+			if _, err := s.out.Write([]byte{'-'}); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if _, err := s.out.Write([]byte{'+'}); err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+}
+
+func gdbChecksum(data []byte) uint8 {
+	sum := uint8(0)
+	for _, b := range data {
+		sum += b
+	}
+	return sum
+}
+
+// writePacket sends the reply, the ack of gdb is not waited for as a
+// reliable connection is assumed.
+func (s *GDBServer) writePacket(data string) error {
+	escaped := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i++ {
+		switch b := data[i]; b {
+		case '$', '#', '}', '*':
+			escaped = append(escaped, '}', b^0x20)
+		default:
+			escaped = append(escaped, b)
+		}
+	}
+	_, err := fmt.Fprintf(s.out, "$%s#%02x", escaped, gdbChecksum(escaped))
+	return err
+}
+
+// handle executes the command in the packet and returns the reply, done is
+// true when the connection should be closed after the reply.
+func (s *GDBServer) handle(packet string) (reply string, done bool) {
+	if packet == "" {
+		return "", false
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return s.stopReply(gdbSigTrap), false
+	case 'q':
+		return s.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			s.noAck = true
+			return "OK", false
+		}
+		return "", false
+	case 'H':
+		// there is only one thread
+		return "OK", false
+	case 'T':
+		return "OK", false
+	case 'g':
+		return s.readRegisters(), false
+	case 'G':
+		return s.writeRegisters(args), false
+	case 'p':
+		return s.readRegister(args), false
+	case 'P':
+		return s.writeRegister(args), false
+	case 'm':
+		return s.readMemory(args), false
+	case 'M':
+		return s.writeMemory(args), false
+	case 's':
+		return s.step(args), false
+	case 'c':
+		return s.cont(args), false
+	case 'Z', 'z':
+		return s.breakpoint(packet[0] == 'Z', args), false
+	case 'D':
+		return "OK", true
+	}
+	// an empty reply tells gdb the packet is not supported
+	return "", false
+}
+
+func (s *GDBServer) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		return fmt.Sprintf("PacketSize=%x;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+", gdbPacketSize)
+	case args == "Attached":
+		// the program already runs, so gdb should detach instead of kill
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		var offset, length int
+		_, err := fmt.Sscanf(strings.TrimPrefix(args, "Xfer:features:read:target.xml:"), "%x,%x", &offset, &length)
+		if err != nil {
+			return "E01"
+		}
+		xml := s.targetXML()
+		if offset >= len(xml) {
+			return "l"
+		}
+		if offset+length >= len(xml) {
+			return "l" + xml[offset:]
+		}
+		return "m" + xml[offset:offset+length]
+	}
+	return ""
+}
+
+// targetXML returns the target description of the hart, the registers have
+// the names and numbers of the ones built into gdb.
+func (s *GDBServer) targetXML() string {
+	xlen := s.emu.regs.Xlen()
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0"?><!DOCTYPE target SYSTEM "gdb-target.dtd"><target version="1.0">`)
+	fmt.Fprintf(&b, "<architecture>riscv:rv%d</architecture>", xlen)
+
+	b.WriteString(`<feature name="org.gnu.gdb.riscv.cpu">`)
+	for i, name := range RegisterNames {
+		typ := "int"
+		if i == reg_sp || i == reg_gp || i == reg_tp || i == reg_fp {
+			typ = "data_ptr"
+		} else if i == reg_ra {
+			typ = "code_ptr"
+		}
+		fmt.Fprintf(&b, `<reg name="%s" bitsize="%d" type="%s" regnum="%d"/>`, name, xlen, typ, i)
+	}
+	fmt.Fprintf(&b, `<reg name="pc" bitsize="%d" type="code_ptr" regnum="%d"/>`, xlen, GDB_REG_PC)
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`<feature name="org.gnu.gdb.riscv.fpu">`)
+	for i, name := range FRegisterNames {
+		fmt.Fprintf(&b, `<reg name="%s" bitsize="64" type="ieee_double" regnum="%d"/>`, name, GDB_REG_FP0+i)
+	}
+	fmt.Fprintf(&b, `<reg name="fflags" bitsize="32" type="int" regnum="%d"/>`, GDB_REG_FFLAGS)
+	fmt.Fprintf(&b, `<reg name="frm" bitsize="32" type="int" regnum="%d"/>`, GDB_REG_FRM)
+	fmt.Fprintf(&b, `<reg name="fcsr" bitsize="32" type="int" regnum="%d"/>`, GDB_REG_FCSR)
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`<feature name="org.gnu.gdb.riscv.csr">`)
+	for _, csr := range gdbCsrs {
+		fmt.Fprintf(&b, `<reg name="%s" bitsize="%d" type="int" regnum="%d"/>`, csr.name, xlen, GDB_REG_CSR0+int(csr.csr))
+	}
+	b.WriteString(`</feature>`)
+
+	b.WriteString(`<feature name="org.gnu.gdb.riscv.virtual">`)
+	fmt.Fprintf(&b, `<reg name="priv" bitsize="%d" type="int" regnum="%d"/>`, xlen, GDB_REG_PRIV)
+	b.WriteString(`</feature></target>`)
+	return b.String()
+}
+
+// encodeValue returns the little endian hex encoding of the size byte value.
+func encodeValue(value uint64, size int) string {
+	buf := make([]byte, size)
+	for i := range buf {
+		buf[i] = byte(value >> (8 * i))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func decodeValue(s string) (uint64, error) {
+	buf, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) > 8 {
+		return 0, fmt.Errorf("register value %s is too big", s)
+	}
+	value := uint64(0)
+	for i, b := range buf {
+		value |= uint64(b) << (8 * i)
+	}
+	return value, nil
+}
+
+// registerSize returns the size of the register in bytes, or zero when it
+// does not exist.
+func (s *GDBServer) registerSize(regnum int) int {
+	xlenBytes := s.emu.regs.Xlen() / 8
+	switch {
+	case regnum <= GDB_REG_PC:
+		return xlenBytes
+	case regnum < GDB_REG_CSR0:
+		return 8
+	case regnum == GDB_REG_FFLAGS || regnum == GDB_REG_FRM || regnum == GDB_REG_FCSR:
+		return 4
+	case regnum == GDB_REG_PRIV:
+		return xlenBytes
+	case regnum < GDB_REG_PRIV:
+		return xlenBytes
+	}
+	return 0
+}
+
+func (s *GDBServer) getRegister(regnum int) (uint64, error) {
+	regs := s.emu.regs
+	switch {
+	case regnum < GDB_REG_PC:
+		return regs.Reg(regnum), nil
+	case regnum == GDB_REG_PC:
+		return regs.Pc(), nil
+	case regnum < GDB_REG_CSR0:
+		return regs.FReg(regnum - GDB_REG_FP0), nil
+	case regnum == GDB_REG_PRIV:
+		return regs.Csrs().Mode(), nil
+	case regnum < GDB_REG_PRIV:
+		return regs.Csrs().Read(uint32(regnum - GDB_REG_CSR0))
+	}
+	return 0, fmt.Errorf("no register %d", regnum)
+}
+
+func (s *GDBServer) setRegister(regnum int, value uint64) error {
+	regs := s.emu.regs
+	switch {
+	case regnum < GDB_REG_PC:
+		regs.SetReg(regnum, value)
+	case regnum == GDB_REG_PC:
+		regs.SetPc(value)
+	case regnum < GDB_REG_CSR0:
+		regs.SetFReg(regnum-GDB_REG_FP0, value)
+	case regnum == GDB_REG_PRIV:
+		if value != MODE_U && value != MODE_S && value != MODE_M {
+			return fmt.Errorf("invalid privilege mode %d", value)
+		}
+		regs.Csrs().SetMode(value)
+		regs.TLB().Flush(0, 0, true, true)
+	case regnum < GDB_REG_PRIV:
+		return regs.Csrs().Write(uint32(regnum-GDB_REG_CSR0), value)
+	default:
+		return fmt.Errorf("no register %d", regnum)
+	}
+	return nil
+}
+
+// readRegisters returns the integer registers and the pc, gdb reads the
+// others one by one.
+func (s *GDBServer) readRegisters() string {
+	var b strings.Builder
+	for regnum := 0; regnum <= GDB_REG_PC; regnum++ {
+		value, _ := s.getRegister(regnum)
+		b.WriteString(encodeValue(value, s.registerSize(regnum)))
+	}
+	return b.String()
+}
+
+func (s *GDBServer) writeRegisters(args string) string {
+	size := 2 * s.registerSize(0)
+	for regnum := 0; regnum <= GDB_REG_PC && len(args) >= size; regnum++ {
+		value, err := decodeValue(args[:size])
+		if err != nil {
+			return "E01"
+		}
+		s.setRegister(regnum, value)
+		args = args[size:]
+	}
+	return "OK"
+}
+
+func (s *GDBServer) readRegister(args string) string {
+	regnum, err := strconv.ParseUint(args, 16, 32)
+	if err != nil {
+		return "E01"
+	}
+	size := s.registerSize(int(regnum))
+	value, err := s.getRegister(int(regnum))
+	if size == 0 || err != nil {
+		return "E01"
+	}
+	return encodeValue(value, size)
+}
+
+func (s *GDBServer) writeRegister(args string) string {
+	regnumStr, valueStr, ok := strings.Cut(args, "=")
+	if !ok {
+		return "E01"
+	}
+	regnum, err := strconv.ParseUint(regnumStr, 16, 32)
+	if err != nil {
+		return "E01"
+	}
+	value, err := decodeValue(valueStr)
+	if err != nil || s.setRegister(int(regnum), value) != nil {
+		return "E01"
+	}
+	return "OK"
+}
+
+// parseAddrLength parses the addr,length arguments of the memory and
+// breakpoint packets.
+func parseAddrLength(args string) (uint64, uint64, error) {
+	addrStr, lengthStr, ok := strings.Cut(args, ",")
+	if !ok {
+		return 0, 0, fmt.Errorf("missing length in %s", args)
+	}
+	addr, err := strconv.ParseUint(addrStr, 16, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err := strconv.ParseUint(lengthStr, 16, 64)
+	return addr, length, err
+}
+
+func (s *GDBServer) readMemory(args string) string {
+	addr, length, err := parseAddrLength(args)
+	if err != nil || length > gdbPacketSize/2 {
+		return "E01"
+	}
+	buf := make([]byte, 0, length)
+	for i := uint64(0); i < length; i++ {
+		value, err := s.emu.mem.LoadByte(addr + i)
+		if err != nil {
+			// a partial read is fine, but not an empty one
+			if i == 0 {
+				return "E14"
+			}
+			break
+		}
+		buf = append(buf, byte(value))
+	}
+	return hex.EncodeToString(buf)
+}
+
+func (s *GDBServer) writeMemory(args string) string {
+	addrLength, data, ok := strings.Cut(args, ":")
+	if !ok {
+		return "E01"
+	}
+	addr, length, err := parseAddrLength(addrLength)
+	if err != nil {
+		return "E01"
+	}
+	buf, err := hex.DecodeString(data)
+	if err != nil || uint64(len(buf)) != length {
+		return "E01"
+	}
+	for i, b := range buf {
+		if err := s.emu.mem.StoreByte(addr+uint64(i), uint64(b)); err != nil {
+			return "E14"
+		}
+	}
+	// the memory can contain instructions
+	s.emu.FlushDecoded()
+	return "OK"
+}
+
+// resume sets the pc for the s and c packets with an address.
+func (s *GDBServer) resume(args string) error {
+	if args == "" {
+		return nil
+	}
+	addr, err := strconv.ParseUint(args, 16, 64)
+	if err != nil {
+		return err
+	}
+	s.emu.regs.SetPc(addr)
+	return nil
+}
+
+func (s *GDBServer) step(args string) string {
+	if s.resume(args) != nil {
+		return "E01"
+	}
+	_, err := s.emu.Step()
+	if err != nil {
+		log.Printf("gdb: step stopped with error: %v", err)
+		return s.stopReply(gdbSigSegv)
+	}
+	if s.emu.WatchHit() != nil {
+		return s.watchReply()
+	}
+	return s.stopReply(gdbSigTrap)
+}
+
+// cont runs the program until it hits a breakpoint or watchpoint, or gdb
+// interrupts it.
+func (s *GDBServer) cont(args string) string {
+	if s.resume(args) != nil {
+		return "E01"
+	}
+
+	// gdb sends a single 0x03 byte to interrupt the program
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case b, ok := <-s.in:
+				if !ok || b == 0x03 {
+					s.emu.Interrupt()
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+	reason, err := s.emu.Run(0)
+	close(stop)
+	<-done
+	// a 0x03 which arrived after Run stopped for another reason must not
+	// stop the next continue
+	if reason != HaltInterrupted {
+		s.emu.ClearInterrupt()
+	}
+
+	switch reason {
+	case HaltBreakpoint:
+		if s.hwBreakpoints[s.emu.regs.Pc()] {
+			return fmt.Sprintf("T%02xhwbreak:;", gdbSigTrap)
+		}
+		return fmt.Sprintf("T%02xswbreak:;", gdbSigTrap)
+	case HaltWatchpoint:
+		return s.watchReply()
+	case HaltInterrupted:
+		return s.stopReply(gdbSigInt)
+	case HaltError:
+		log.Printf("gdb: run stopped with error: %v", err)
+		return s.stopReply(gdbSigSegv)
+	}
+	// the program jumps to itself, it's stopped there so its end state
+	// can be inspected
+	return s.stopReply(gdbSigTrap)
+}
+
+func (s *GDBServer) stopReply(signal int) string {
+	return fmt.Sprintf("S%02x", signal)
+}
+
+func (s *GDBServer) watchReply() string {
+	hit := s.emu.WatchHit()
+	kind := "awatch"
+	switch hit.Watchpoint.Kind {
+	case WatchWrite:
+		kind = "watch"
+	case WatchRead:
+		kind = "rwatch"
+	}
+	return fmt.Sprintf("T%02x%s:%x;", gdbSigTrap, kind, hit.Addr)
+}
+
+// breakpoint handles Z (insert) and z (remove) for software (0) and
+// hardware (1) breakpoints and write (2), read (3) and access (4)
+// watchpoints. Software breakpoints are not written to memory, the
+// emulator checks the pc like it does for hardware breakpoints.
+func (s *GDBServer) breakpoint(insert bool, args string) string {
+	typ, rest, ok := strings.Cut(args, ",")
+	if !ok {
+		return "E01"
+	}
+	// the kind of a breakpoint is the length of the instruction
+	addr, length, err := parseAddrLength(rest)
+	if err != nil {
+		return "E01"
+	}
+
+	switch typ {
+	case "0", "1":
+		if insert {
+			s.emu.SetBreakpoint(addr)
+			s.hwBreakpoints[addr] = typ == "1"
+		} else {
+			s.emu.ClearBreakpoint(addr)
+			delete(s.hwBreakpoints, addr)
+		}
+		return "OK"
+	case "2", "3", "4":
+		w := Watchpoint{Addr: addr, Len: length, Kind: map[string]WatchKind{"2": WatchWrite, "3": WatchRead, "4": WatchAccess}[typ]}
+		if insert {
+			s.emu.SetWatchpoint(w)
+		} else {
+			s.emu.ClearWatchpoint(w)
+		}
+		return "OK"
+	}
+	return ""
+}
@@ -0,0 +1,180 @@
+package riscv
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// gdbClient talks to a GDBServer like gdb does.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+}
+
+func newGDBClient(t *testing.T, e *Emulator) (*gdbClient, chan error) {
+	client, server := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewGDBServer(e).Serve(server)
+		server.Close()
+	}()
+	return &gdbClient{t: t, conn: client, in: bufio.NewReader(client)}, done
+}
+
+func (c *gdbClient) send(packet string) {
+	_, err := fmt.Fprintf(c.conn, "$%s#%02x", packet, gdbChecksum([]byte(packet)))
+	if err != nil {
+		c.t.Fatalf("sending %s failed with error %v", packet, err)
+	}
+}
+
+// receive returns the data of the next packet, acks are skipped.
+func (c *gdbClient) receive() string {
+	if _, err := c.in.ReadString('$'); err != nil {
+		c.t.Fatalf("receiving failed with error %v", err)
+	}
+	data, err := c.in.ReadString('#')
+	if err != nil {
+		c.t.Fatalf("receiving failed with error %v", err)
+	}
+	data = strings.TrimSuffix(data, "#")
+	checksum := make([]byte, 2)
+	c.in.Read(checksum)
+	Assert(c.t, string(checksum), fmt.Sprintf("%02x", gdbChecksum([]byte(data))))
+	return data
+}
+
+func (c *gdbClient) command(packet string, expected string) {
+	c.send(packet)
+	reply := c.receive()
+	if reply != expected {
+		c.t.Errorf("%s: reply=%q expected=%q", packet, reply, expected)
+	}
+}
+
+func TestGDBServer(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, debugProgram)
+	e, r := newTestEmulator(&mem)
+	r.reg[reg_sp] = 0x12345678
+	c, done := newGDBClient(t, e)
+
+	c.command("qSupported:multiprocess+;swbreak+;hwbreak+", "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+")
+	c.command("?", "S05")
+	c.command("vMustReplyEmpty", "")
+
+	// registers
+	c.command("p2", "78563412")
+	c.command("p20", "00000000")
+	c.command("P20=08000000", "OK")
+	CheckPc(8, r, t)
+	c.send("g")
+	regs := c.receive()
+	Assert(t, len(regs), 33*8)
+	Assert(t, regs[2*8:3*8], "78563412")
+	c.command("P20=00000000", "OK")
+	c.command(fmt.Sprintf("p%x", GDB_REG_CSR0+CSR_MISA), encodeValue(MISA_MXL_32|misaExtensions, 4))
+	c.command(fmt.Sprintf("p%x", GDB_REG_PRIV), "03000000")
+	c.command(fmt.Sprintf("p%x", GDB_REG_FCSR), "00000000")
+	c.command("p1000", "E01")
+
+	// memory, the second word is sw a0, 32(zero)
+	c.command("m4,4", "2320a002")
+	c.command("M20,2:abcd", "OK")
+	CheckMem(32, 0xcdab, &mem, t)
+	c.command("m3e,4", "0000")
+	c.command("m40,4", "E14")
+	// more than fits in a reply packet
+	c.command("m0,ffffffffffffffff", "E01")
+	c.command("m0,2001", "E01")
+
+	// software breakpoint and single step
+	c.command("Z0,8,4", "OK")
+	c.command("c", "T05swbreak:;")
+	CheckPc(8, r, t)
+	CheckMem(32, 3, &mem, t)
+	c.command("s", "S05")
+	CheckReg(reg_a1, 3, r, t)
+	c.command("z0,8,4", "OK")
+
+	// write watchpoint on the word stored by the program
+	c.command("P20=04000000", "OK")
+	c.command("Z2,20,4", "OK")
+	c.command("c", "T05watch:20;")
+	CheckPc(8, r, t)
+	c.command("z2,20,4", "OK")
+	c.command("Z3,22,1", "OK")
+	c.command("c", "T05rwatch:20;")
+	c.command("z3,22,1", "OK")
+
+	c.command("c", "S05")
+	c.command("D", "OK")
+	if err := <-done; err != nil {
+		t.Errorf("serve failed with error %v", err)
+	}
+}
+
+func TestGDBServerInterrupt(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0x00150513, // loop: addi a0, a0, 1
+		0xffdff06f, // j loop
+	})
+	e, _ := newTestEmulator(&mem)
+	c, done := newGDBClient(t, e)
+
+	c.command("QStartNoAckMode", "OK")
+	c.command("Z1,4,4", "OK")
+	c.command("c", "T05hwbreak:;")
+	c.command("z1,4,4", "OK")
+
+	c.send("c")
+	c.conn.Write([]byte{0x03})
+	Assert(t, c.receive(), "S02")
+	c.conn.Close()
+	if err := <-done; err != nil {
+		t.Errorf("serve failed with error %v", err)
+	}
+}
+
+func TestGDBTargetXML(t *testing.T) {
+	mem := NewMemory(64)
+	e, _ := newTestEmulator(&mem)
+	c, done := newGDBClient(t, e)
+
+	// read the description in small pieces like gdb does
+	xml := ""
+	for {
+		c.send(fmt.Sprintf("qXfer:features:read:target.xml:%x,100", len(xml)))
+		reply := c.receive()
+		xml += reply[1:]
+		if reply[0] == 'l' {
+			break
+		}
+		Assert(t, reply[0], byte('m'))
+	}
+	Assert(t, xml, NewGDBServer(e).targetXML())
+	for _, s := range []string{
+		"<architecture>riscv:rv32</architecture>",
+		`<reg name="sp" bitsize="32" type="data_ptr" regnum="2"/>`,
+		`<reg name="pc" bitsize="32" type="code_ptr" regnum="32"/>`,
+		`<reg name="ft0" bitsize="64" type="ieee_double" regnum="33"/>`,
+		`<reg name="fcsr" bitsize="32" type="int" regnum="68"/>`,
+		`<reg name="mstatus" bitsize="32" type="int" regnum="833"/>`,
+	} {
+		if !strings.Contains(xml, s) {
+			t.Errorf("target.xml should contain %s", s)
+		}
+	}
+
+	c.send("k")
+	ack, _ := c.in.ReadByte()
+	Assert(t, ack, byte('+'))
+	if err := <-done; err != nil {
+		t.Errorf("serve failed with error %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package riscv
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+// HTIF devices and their commands, the device is in bits 63:56 of tohost,
+// the command in bits 55:48 and the payload in the lower 48 bits.
+const (
+	HTIF_DEV_SYSCALL uint64 = 0
+	HTIF_DEV_CONSOLE uint64 = 1
+
+	HTIF_CMD_SYSCALL  uint64 = 0
+	HTIF_CMD_GETCHAR  uint64 = 0
+	HTIF_CMD_PUTCHAR  uint64 = 1
+	htifPayloadMask   uint64 = 1<<48 - 1
+	htifConsoleReady  uint64 = 0x100
+	htifSyscallArgs          = 8
+	htifSyscallNoSys  uint64 = 1<<64 - 38 // -ENOSYS
+	htifSyscallResult uint64 = 1
+)
+
+// The syscalls of the proxy kernel the HTIF implements, the numbers are the
+// ones of the riscv linux ABI.
+const (
+	HTIF_SYS_WRITE uint64 = 64
+	HTIF_SYS_EXIT  uint64 = 93
+)
+
+// HTIF is the host target interface of spike, used by riscv-tests and the
+// proxy kernel to exit and print. The program writes a command to the 64
+// bit tohost variable and the host answers in fromhost. Like the frontend
+// server of spike the HTIF polls tohost, it's a Ticker that checks it
+// before every instruction. A command is handled once tohost didn't change
+// for an instruction, as RV32 programs write it with two stores.
+type HTIF struct {
+	mem      Memory
+	tohost   uint64
+	fromhost uint64
+	out      io.Writer
+
+	// the last value of tohost, the command is handled when it's stable
+	last uint64
+	// the number of console reads waiting for input
+	pendingReads int
+
+	lock sync.Mutex
+	rx   []byte
+
+	exited      bool
+	exitCode    uint64
+	exitHandler func(code uint64)
+}
+
+// NewHTIF creates the HTIF for the tohost and fromhost variables at the
+// physical addresses, fromhost zero means the program has none. The output
+// of the program is written to out and the console reads get the bytes
+// read from in when in is not nil.
+func NewHTIF(mem Memory, tohost uint64, fromhost uint64, in io.Reader, out io.Writer) *HTIF {
+	h := &HTIF{mem: mem, tohost: tohost, fromhost: fromhost, out: out}
+	if in != nil {
+		go h.readFrom(in)
+	}
+	return h
+}
+
+func (h *HTIF) readFrom(in io.Reader) {
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			h.lock.Lock()
+			h.rx = append(h.rx, buf[:n]...)
+			h.lock.Unlock()
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("htif stopped reading input with error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// SetExitHandler registers the function that is called when the program
+// exits, riscv-tests pass with exit code zero and fail with the number of
+// the failed test.
+func (h *HTIF) SetExitHandler(handler func(code uint64)) {
+	h.exitHandler = handler
+}
+
+// Exited returns the exit code when the program exited.
+func (h *HTIF) Exited() (uint64, bool) {
+	return h.exitCode, h.exited
+}
+
+func (h *HTIF) Tick() {
+	if h.exited {
+		return
+	}
+	h.answerReads()
+
+	tohost, err := h.mem.Load(h.tohost, 8)
+	if err != nil || tohost == 0 || tohost != h.last {
+		h.last = tohost
+		return
+	}
+	h.handle(tohost)
+}
+
+// Poll handles the command in tohost without waiting for it to be stable,
+// it's called once the program halted, which it does before the next tick
+// when it spins on j . right after writing tohost.
+func (h *HTIF) Poll() {
+	if h.exited {
+		return
+	}
+	tohost, err := h.mem.Load(h.tohost, 8)
+	if err != nil || tohost == 0 {
+		return
+	}
+	h.handle(tohost)
+}
+
+// handle clears tohost and executes the command.
+func (h *HTIF) handle(tohost uint64) {
+	h.last = 0
+	h.mem.Store(h.tohost, 0, 8)
+
+	device := tohost >> 56
+	cmd := (tohost >> 48) & 0xff
+	payload := tohost & htifPayloadMask
+	switch {
+	case device == HTIF_DEV_SYSCALL && cmd == HTIF_CMD_SYSCALL:
+		if payload&1 != 0 {
+			h.exit(payload >> 1)
+			return
+		}
+		h.syscall(payload)
+	case device == HTIF_DEV_CONSOLE && cmd == HTIF_CMD_PUTCHAR:
+		h.out.Write([]byte{byte(payload)})
+	case device == HTIF_DEV_CONSOLE && cmd == HTIF_CMD_GETCHAR:
+		h.pendingReads++
+		h.answerReads()
+	default:
+		log.Printf("htif ignores unknown command %#x", tohost)
+	}
+}
+
+func (h *HTIF) exit(code uint64) {
+	h.exited = true
+	h.exitCode = code
+	if h.exitHandler != nil {
+		h.exitHandler(code)
+	}
+}
+
+// respond writes the answer to a command to fromhost.
+func (h *HTIF) respond(device uint64, cmd uint64, payload uint64) {
+	if h.fromhost != 0 {
+		h.mem.Store(h.fromhost, device<<56|cmd<<48|payload, 8)
+	}
+}
+
+// answerReads answers a pending console read when there is input and the
+// program took the previous answer from fromhost.
+func (h *HTIF) answerReads() {
+	if h.pendingReads == 0 || h.fromhost == 0 {
+		return
+	}
+	fromhost, err := h.mem.Load(h.fromhost, 8)
+	if err != nil || fromhost != 0 {
+		return
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if len(h.rx) == 0 {
+		return
+	}
+	ch := h.rx[0]
+	h.rx = h.rx[1:]
+	h.pendingReads--
+	h.respond(HTIF_DEV_CONSOLE, HTIF_CMD_GETCHAR, htifConsoleReady|uint64(ch))
+}
+
+// syscall executes the system call in the 8 64 bit words at addr, the
+// number followed by the arguments. The result replaces the number.
+func (h *HTIF) syscall(addr uint64) {
+	var args [htifSyscallArgs]uint64
+	for i := range args {
+		value, err := h.mem.Load(addr+8*uint64(i), 8)
+		if err != nil {
+			log.Printf("htif can't load syscall at %#x with error: %v", addr, err)
+			return
+		}
+		args[i] = value
+	}
+
+	result := htifSyscallNoSys
+	switch args[0] {
+	case HTIF_SYS_WRITE:
+		// write(fd, buf, len), stdout and stderr both go to out
+		fd, buf, length := args[1], args[2], args[3]
+		if fd != 1 && fd != 2 {
+			break
+		}
+		// the length is the guest's, the write stops at the end of the memory
+		var data []byte
+		for i := uint64(0); i < length; i++ {
+			b, err := h.mem.LoadByte(buf + i)
+			if err != nil {
+				break
+			}
+			data = append(data, byte(b))
+		}
+		h.out.Write(data)
+		result = uint64(len(data))
+	case HTIF_SYS_EXIT:
+		h.exit(args[1])
+		return
+	default:
+		log.Printf("htif ignores unknown syscall %d", args[0])
+	}
+	h.mem.Store(addr, result, 8)
+	h.respond(HTIF_DEV_SYSCALL, HTIF_CMD_SYSCALL, htifSyscallResult)
+}
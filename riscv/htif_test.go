@@ -0,0 +1,174 @@
+package riscv
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testTohost   = 0x80000040
+	testFromhost = 0x80000048
+)
+
+func TestHTIFExit(t *testing.T) {
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	// write_tohost of riscv-tests, the test number 3 failed
+	storeProgram(t, &mem, 0x80000000, []uint32{
+		0x00700513, // addi a0, zero, 7
+		0x00000297, // auipc t0, 0
+		0x02a2ae23, // 1: sw a0, 60(t0), tohost
+		0x0402a023, // sw zero, 64(t0), tohost+4
+		0xff9ff06f, // j 1b
+	})
+	r := &RegistersImpl{}
+	r.SetPc(0x80000000)
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	e := NewEmulator(&mem, r, d)
+
+	htif := NewHTIF(&mem, testTohost, testFromhost, nil, &bytes.Buffer{})
+	htif.SetExitHandler(func(code uint64) { e.Interrupt() })
+	e.AddTicker(htif)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltInterrupted)
+	code, exited := htif.Exited()
+	Assert(t, exited, true)
+	Assert(t, code, uint64(3))
+	tohost, _ := mem.Load(testTohost, 8)
+	Assert(t, tohost, uint64(0))
+}
+
+// A program which spins right after writing tohost halts before the HTIF
+// sees the command twice, Poll handles it.
+func TestHTIFPollAfterSelfLoop(t *testing.T) {
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	storeProgram(t, &mem, 0x80000000, []uint32{
+		0x00700513, // addi a0, zero, 7
+		0x00000297, // auipc t0, 0
+		0x02a2ae23, // sw a0, 60(t0), tohost
+		0x0000006f, // j .
+	})
+	r := &RegistersImpl{}
+	r.SetPc(0x80000000)
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	e := NewEmulator(&mem, r, d)
+
+	htif := NewHTIF(&mem, testTohost, testFromhost, nil, &bytes.Buffer{})
+	e.AddTicker(htif)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+	Assert(t, reason, HaltSelfLoop)
+	_, exited := htif.Exited()
+	Assert(t, exited, false)
+	htif.Poll()
+	code, exited := htif.Exited()
+	Assert(t, exited, true)
+	Assert(t, code, uint64(3))
+	tohost, _ := mem.Load(testTohost, 8)
+	Assert(t, tohost, uint64(0))
+}
+
+// tickHTIF stores the command to tohost and ticks the HTIF until it handled
+// the command.
+func tickHTIF(h *HTIF, mem Memory, cmd uint64) {
+	mem.Store(testTohost, cmd, 8)
+	h.Tick()
+	h.Tick()
+}
+
+func TestHTIFSyscall(t *testing.T) {
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	var out bytes.Buffer
+	h := NewHTIF(&mem, testTohost, testFromhost, nil, &out)
+
+	// write(1, "hi\n", 3) with the syscall at 0x80000080
+	mem.Store(0x80000080, HTIF_SYS_WRITE, 8)
+	mem.Store(0x80000088, 1, 8)
+	mem.Store(0x80000090, 0x800000c0, 8)
+	mem.Store(0x80000098, 3, 8)
+	mem.Store(0x800000c0, 0x0a6968, 4)
+	tickHTIF(h, &mem, 0x80000080)
+	Assert(t, out.String(), "hi\n")
+	result, _ := mem.Load(0x80000080, 8)
+	Assert(t, result, uint64(3))
+	fromhost, _ := mem.Load(testFromhost, 8)
+	Assert(t, fromhost, uint64(1))
+
+	// a length beyond the memory writes the bytes up to its end
+	out.Reset()
+	mem.Store(testFromhost, 0, 8)
+	mem.Store(0x80000080, HTIF_SYS_WRITE, 8)
+	mem.Store(0x80000090, 0x800000fe, 8)
+	mem.Store(0x80000098, ^uint64(0), 8)
+	mem.Store(0x800000fe, 0x6b6f, 2)
+	tickHTIF(h, &mem, 0x80000080)
+	Assert(t, out.String(), "ok")
+	result, _ = mem.Load(0x80000080, 8)
+	Assert(t, result, uint64(2))
+
+	// unknown syscalls fail with -ENOSYS
+	mem.Store(testFromhost, 0, 8)
+	mem.Store(0x80000080, 57, 8)
+	tickHTIF(h, &mem, 0x80000080)
+	result, _ = mem.Load(0x80000080, 8)
+	Assert(t, result, uint64(1<<64-38))
+
+	// exit(5)
+	mem.Store(0x80000080, HTIF_SYS_EXIT, 8)
+	mem.Store(0x80000088, 5, 8)
+	tickHTIF(h, &mem, 0x80000080)
+	code, exited := h.Exited()
+	Assert(t, exited, true)
+	Assert(t, code, uint64(5))
+}
+
+func TestHTIFConsole(t *testing.T) {
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	var out bytes.Buffer
+	h := NewHTIF(&mem, testTohost, testFromhost, nil, &out)
+	h.rx = []byte("ab")
+
+	tickHTIF(h, &mem, HTIF_DEV_CONSOLE<<56|HTIF_CMD_PUTCHAR<<48|'x')
+	Assert(t, out.String(), "x")
+
+	getchar := HTIF_DEV_CONSOLE<<56 | HTIF_CMD_GETCHAR<<48
+	tickHTIF(h, &mem, getchar)
+	fromhost, _ := mem.Load(testFromhost, 8)
+	Assert(t, fromhost, getchar|0x100|'a')
+
+	// the next character waits until the program took the first one
+	tickHTIF(h, &mem, getchar)
+	fromhost, _ = mem.Load(testFromhost, 8)
+	Assert(t, fromhost, getchar|0x100|'a')
+	mem.Store(testFromhost, 0, 8)
+	h.Tick()
+	fromhost, _ = mem.Load(testFromhost, 8)
+	Assert(t, fromhost, getchar|0x100|'b')
+}
+
+func TestHTIFInput(t *testing.T) {
+	mem := NewMemoryWithOffset(0x100, 0x80000000)
+	h := NewHTIF(&mem, testTohost, testFromhost, strings.NewReader("z"), &bytes.Buffer{})
+
+	tickHTIF(h, &mem, HTIF_DEV_CONSOLE<<56|HTIF_CMD_GETCHAR<<48)
+	// the input is read by a goroutine
+	for i := 0; i < 1000; i++ {
+		if fromhost, _ := mem.Load(testFromhost, 8); fromhost != 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+		h.Tick()
+	}
+	fromhost, _ := mem.Load(testFromhost, 8)
+	Assert(t, fromhost, HTIF_DEV_CONSOLE<<56|0x100|'z')
+}
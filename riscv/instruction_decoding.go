@@ -15,7 +15,7 @@ func DecodeIInstr(word uint32) IInstr {
 	opcode := bitSliceBetween(word, 0, 6)
 
 	return IInstr{
-		imm:    sext(imm, 12),
+		imm:    sext(imm, 11),
 		rs1:    int(rs1),
 		func3:  int8(func3),
 		rd:     int(rd),
@@ -42,8 +42,8 @@ func DecodeSInstr(word uint32) Instruction {
 }
 
 func (Instr BInstr) immSigned() int32 {
-	// 12 bit imm so sign bit on position 11
-	sext_imm := sext(Instr.imm(), 11)
+	// 13 bit imm (imm[12:1]) so sign bit on position 12
+	sext_imm := sext(Instr.imm(), 12)
 	return ReinterpreteAsSigned(sext_imm)
 }
 
@@ -89,7 +89,7 @@ func DecodeUInstr(word uint32) UInstr {
 	opcode := bitSliceBetween(word, 0, 6)
 
 	return UInstr{
-		imm:    sext(imm, 12),
+		imm:    imm,
 		rd:     int(rd),
 		opcode: int8(opcode),
 	}
@@ -130,12 +130,37 @@ func DecodeRInstr(word uint32) Instruction {
 	}
 }
 
+func DecodeR4Instr(word uint32) Instruction {
+	rs3 := bitSliceBetween(word, 27, 31)
+	format := bitSliceBetween(word, 25, 26)
+	rs2 := bitSliceBetween(word, 20, 24)
+	rs1 := bitSliceBetween(word, 15, 19)
+	func3 := bitSliceBetween(word, 12, 14)
+	rd := bitSliceBetween(word, 7, 11)
+	opcode := bitSliceBetween(word, 0, 6)
+
+	return R4Instr{
+		rs3:    int(rs3),
+		format: int8(format),
+		rs2:    int(rs2),
+		rs1:    int(rs1),
+		func3:  int8(func3),
+		rd:     int(rd),
+		opcode: int8(opcode),
+	}
+}
+
 func DecodePInstr(word uint32) Instruction {
 	return nil
 }
 
 type Decoder struct {
 	OpcodeToInstrType map[int8]int8
+	// Decode 16 bit instructions of the C extension
+	Compressed bool
+	// Decode the RV64 instructions, this also changes the meaning of some
+	// 16 bit instructions
+	RV64 bool
 }
 
 func NewDecoder() *Decoder {
@@ -155,12 +180,34 @@ func (d *Decoder) RegisterBaseInstructionSet() {
 	d.Register(OP, RInstrType)
 	d.Register(JAL, JInstrType)
 	d.Register(JALR, IInstrType)
-	d.Register(BRANCH, IInstrType)
+	d.Register(BRANCH, BInstrType)
 	d.Register(LOAD, IInstrType)
 	d.Register(STORE, SInstrType)
 	d.Register(SYSTEM, IInstrType)
-	// d.Register(MISC_MEM, ??? ) -> TODO: add when implementin git
+	d.Register(MISC_MEM, IInstrType)
+	d.Register(AMO, RInstrType)
+	d.Register(LOAD_FP, IInstrType)
+	d.Register(STORE_FP, SInstrType)
+	d.Register(OP_FP, RInstrType)
+	d.Register(MADD, R4InstrType)
+	d.Register(MSUB, R4InstrType)
+	d.Register(NMSUB, R4InstrType)
+	d.Register(NMADD, R4InstrType)
+}
 
+func (d *Decoder) RegisterCompressedInstructionSet() {
+	d.Compressed = true
+}
+
+// RegisterRV64InstructionSet adds the word instructions of RV64I (and
+// RV64M), the decoder has to be used for harts with XLEN=64 only.
+func (d *Decoder) RegisterRV64InstructionSet() {
+	if d.OpcodeToInstrType == nil {
+		log.Panic("invalid decoder no map present")
+	}
+	d.Register(OP_IMM_32, IInstrType)
+	d.Register(OP_32, RInstrType)
+	d.RV64 = true
 }
 
 func (d *Decoder) Register(opcode int8, instrType int8) error {
@@ -183,6 +230,13 @@ func ByteArrayToWord(wordArray [4]byte) uint32 {
 }
 
 func (d Decoder) Decode(word uint32) (Instruction, error) {
+	if InstructionLength(word) == 2 {
+		if !d.Compressed {
+			return nil, fmt.Errorf("compressed instruction (%#04x) but the C extension is not registered in the decoder", word&0xffff)
+		}
+		return decodeCompressed(word&0xffff, d.RV64)
+	}
+
 	opcode := int8(bitSliceBetween(word, 0, 6))
 	instrType, isPresent := d.OpcodeToInstrType[opcode]
 
@@ -197,10 +251,14 @@ func (d Decoder) Decode(word uint32) (Instruction, error) {
 		return DecodeIInstr(word), nil
 	case SInstrType:
 		return DecodeSInstr(word), nil
+	case BInstrType:
+		return DecodeBInstr(word), nil
 	case UInstrType:
 		return DecodeUInstr(word), nil
 	case JInstrType:
 		return DecodeJInstr(word), nil
+	case R4InstrType:
+		return DecodeR4Instr(word), nil
 	}
 
 	return nil, fmt.Errorf("invalid instrtype(%v) ", ToStringInstrType(instrType))
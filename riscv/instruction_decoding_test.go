@@ -169,3 +169,46 @@ func TestByteArrayToWord(t *testing.T) {
 	expect = uint32(256)
 	Assert(t, res, expect)
 }
+
+func TestDecodeMul(t *testing.T) {
+	// mul a0, a1, a2
+	expected := CreateMUL(reg_a0, reg_a1, reg_a2)
+
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	res, err := d.Decode(0x02c58533)
+	if err != nil {
+		t.Fatalf("decode failed with error %v", err)
+	}
+
+	if expected != res {
+		t.Fatalf("\nres=%s\nexpected=%s", res.String(), expected.String())
+	}
+}
+
+func TestDecodeFence(t *testing.T) {
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+
+	tests := []struct {
+		word     uint32
+		expected Instruction
+	}{
+		{0x0330000f, CreateFENCE(FENCE_R|FENCE_W, FENCE_R|FENCE_W)}, // fence rw, rw
+		{0x8330000f, CreateFENCETSO()},                              // fence.tso
+		{0x0100000f, CreatePAUSE()},                                 // pause
+		{0x0000100f, CreateFENCEI()},                                // fence.i
+		{0x12b50073, CreateSFENCEVMA(reg_a0, reg_a1)},               // sfence.vma a0, a1
+	}
+
+	for _, test := range tests {
+		res, err := d.Decode(test.word)
+		if err != nil {
+			t.Errorf("decoding %#08x failed with error %v", test.word, err)
+			continue
+		}
+		if res != test.expected {
+			t.Errorf("decoding %#08x\nres=     %s\nexpected=%s", test.word, res.String(), test.expected.String())
+		}
+	}
+}
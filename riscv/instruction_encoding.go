@@ -0,0 +1,57 @@
+package riscv
+
+// The Encode methods are the inverse of Decoder.Decode, they put the fields
+// back into the instruction word. The fields are masked to their width, so
+// the sign extended immediates of the decoder encode to their 12 bits.
+
+// encodeField returns the lowest bits of the value placed at bits from to
+// to (inclusive).
+func encodeField(value uint32, from uint32, to uint32) uint32 {
+	return bitSliceBetween(value, 0, to-from) << from
+}
+
+// encodeReg returns the 5 bit register field at bit from.
+func encodeReg(reg int, from uint32) uint32 {
+	return encodeField(uint32(reg), from, from+4)
+}
+
+func (Inst RInstr) Encode() uint32 {
+	return encodeField(uint32(uint8(Inst.func7)), 25, 31) | encodeReg(Inst.rs2, 20) | encodeReg(Inst.rs1, 15) |
+		encodeField(uint32(Inst.func3), 12, 14) | encodeReg(Inst.rd, 7) | encodeField(uint32(Inst.opcode), 0, 6)
+}
+
+func (Inst IInstr) Encode() uint32 {
+	return encodeField(Inst.imm, 20, 31) | encodeReg(Inst.rs1, 15) | encodeField(uint32(Inst.func3), 12, 14) |
+		encodeReg(Inst.rd, 7) | encodeField(uint32(Inst.opcode), 0, 6)
+}
+
+func (Instr SInstr) Encode() uint32 {
+	return encodeField(Instr.imm1, 25, 31) | encodeReg(Instr.rs2, 20) | encodeReg(Instr.rs1, 15) |
+		encodeField(uint32(Instr.func3), 12, 14) | encodeField(Instr.imm0, 7, 11) | encodeField(uint32(Instr.opcode), 0, 6)
+}
+
+func (Instr BInstr) Encode() uint32 {
+	return encodeField(Instr.imm3, 31, 31) | encodeField(Instr.imm2, 25, 30) | encodeReg(Instr.rs2, 20) |
+		encodeReg(Instr.rs1, 15) | encodeField(Instr.func3, 12, 14) | encodeField(Instr.imm1, 8, 11) |
+		encodeField(Instr.imm0, 7, 7) | encodeField(uint32(Instr.opcode), 0, 6)
+}
+
+func (Inst UInstr) Encode() uint32 {
+	return encodeField(Inst.imm, 12, 31) | encodeReg(Inst.rd, 7) | encodeField(uint32(Inst.opcode), 0, 6)
+}
+
+func (Instr JInstr) Encode() uint32 {
+	return encodeField(Instr.imm3, 31, 31) | encodeField(Instr.imm2, 21, 30) | encodeField(Instr.imm1, 20, 20) |
+		encodeField(Instr.imm0, 12, 19) | encodeReg(Instr.rd, 7) | encodeField(uint32(Instr.opcode), 0, 6)
+}
+
+func (Inst R4Instr) Encode() uint32 {
+	return encodeReg(Inst.rs3, 27) | encodeField(uint32(Inst.format), 25, 26) | encodeReg(Inst.rs2, 20) |
+		encodeReg(Inst.rs1, 15) | encodeField(uint32(Inst.func3), 12, 14) | encodeReg(Inst.rd, 7) |
+		encodeField(uint32(Inst.opcode), 0, 6)
+}
+
+// Encode returns the 16 bit instruction, not the one it expands to.
+func (Instr CInstr) Encode() uint32 {
+	return uint32(Instr.raw)
+}
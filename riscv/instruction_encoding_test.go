@@ -0,0 +1,125 @@
+package riscv
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomWords returns random instruction words with the opcode, the same
+// ones in every run.
+func randomWords(opcode int8, n int) []uint32 {
+	r := rand.New(rand.NewSource(int64(opcode)))
+	words := make([]uint32, n)
+	for i := range words {
+		words[i] = r.Uint32()&^0x7f | uint32(opcode)
+	}
+	return words
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, rv64 := range []bool{false, true} {
+		d := NewDecoder()
+		d.RegisterBaseInstructionSet()
+		if rv64 {
+			d.RegisterRV64InstructionSet()
+		}
+		for opcode := range d.OpcodeToInstrType {
+			for _, word := range randomWords(opcode, 1000) {
+				instr, err := d.Decode(word)
+				if err != nil {
+					continue
+				}
+				if instr.Encode() != word {
+					t.Fatalf("%#08x decoded to %s which encodes to %#08x", word, instr, instr.Encode())
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeCompressedRoundTrip(t *testing.T) {
+	for _, rv64 := range []bool{false, true} {
+		for half := uint32(0); half < 1<<16; half++ {
+			if InstructionLength(half) != 2 {
+				continue
+			}
+			instr, err := decodeCompressed(half, rv64)
+			if err != nil {
+				continue
+			}
+			if instr.Encode() != half {
+				t.Fatalf("%#04x decoded to %s which encodes to %#04x", half, instr, instr.Encode())
+			}
+		}
+	}
+}
+
+// The immediates of the decoded instructions have to be the ones of the
+// formats in the spec.
+func TestDecodeImmediates(t *testing.T) {
+	bits := func(word uint32, from uint32, to uint32) uint32 {
+		return bitSliceBetween(word, from, to)
+	}
+	for _, word := range randomWords(OP_IMM, 1000) {
+		Assert(t, DecodeIInstr(word).imm, sext(bits(word, 20, 31), 11))
+		Assert(t, DecodeUInstr(word).imm, bits(word, 12, 31))
+		Assert(t, DecodeSInstr(word).(SInstr).imm(), bits(word, 25, 31)<<5|bits(word, 7, 11))
+		Assert(t, DecodeBInstr(word).imm(), bits(word, 31, 31)<<12|bits(word, 7, 7)<<11|bits(word, 25, 30)<<5|bits(word, 8, 11)<<1)
+		Assert(t, DecodeJInstr(word).(JInstr).Imm(), sext(bits(word, 31, 31)<<20|bits(word, 12, 19)<<12|bits(word, 20, 20)<<11|bits(word, 21, 30)<<1, 20))
+	}
+}
+
+// The instructions of the constructors encode to words which decode to the
+// same instructions.
+func TestEncodeCreated(t *testing.T) {
+	instrs := []Instruction{
+		CreateADDI(reg_a1, reg_a0, ReinterpreteAsUnsigned(-2048)),
+		CreateSRAI(reg_a1, reg_a0, 63),
+		CreateSRAIW(reg_a1, reg_a0, 31),
+		CreateLui(0xfffff, reg_a0),
+		CreateAUIPC(0x12345, reg_t0),
+		CreateADD(reg_a0, reg_a1, reg_a2),
+		CreateSUBW(reg_a0, reg_a1, reg_a2),
+		CreateJAL(-1048576, reg_ra),
+		CreateJAL(1048574, reg_zero),
+		CreateJALR(ReinterpreteAsUnsigned(-4), reg_ra, reg_a0),
+		CreateBEQ(ReinterpreteAsUnsigned(-4096), reg_a0, reg_a1),
+		CreateBGEU(4094, reg_a0, reg_a1),
+		CreateLW(-2048, reg_sp, reg_a0),
+		CreateLD(2047, reg_sp, reg_a0),
+		CreateSB(-1, reg_a0, reg_a1),
+		CreateSD(-2048, reg_ra, reg_sp),
+		CreateCSRRW(reg_a0, CSR_MSTATUS, reg_a1),
+		CreateCSRRSI(reg_a0, CSR_CYCLE, 31),
+		CreateECALL(),
+		CreateMRET(),
+		CreateSFENCEVMA(reg_a0, reg_a1),
+		CreateFENCE(FENCE_R|FENCE_W, FENCE_W),
+		CreateFENCETSO(),
+		CreateFENCEI(),
+		CreateMULHSU(reg_a0, reg_a1, reg_a2),
+		CreateREMUW(reg_a0, reg_a1, reg_a2),
+		CreateAMOMAXUD(reg_a0, reg_a1, reg_a2),
+		CreateLRW(reg_a0, reg_a1),
+		CreateFLD(-8, reg_sp, 8),
+		CreateFSW(-8, 10, reg_sp),
+		CreateFDIV(FMT_D, 10, 11, 12, int8(RM_DYN)),
+		CreateFCVTFF(FMT_S, FMT_D, 10, 11, int8(RM_RTZ)),
+		CreateFCVTFromLU(FMT_D, 10, reg_a1, int8(RM_RNE)),
+		CreateFMVXD(reg_a0, 11),
+		CreateFNMADD(FMT_S, 10, 11, 12, 13, int8(RM_RMM)),
+	}
+	d := NewDecoder()
+	d.RegisterBaseInstructionSet()
+	d.RegisterRV64InstructionSet()
+	for _, instr := range instrs {
+		decoded, err := d.Decode(instr.Encode())
+		if err != nil {
+			t.Fatalf("%s encoded to %#08x which can't be decoded with error %v", instr, instr.Encode(), err)
+		}
+		if !reflect.DeepEqual(decoded, instr) {
+			t.Errorf("%s encoded to %#08x which decodes to %s", instr, instr.Encode(), decoded)
+		}
+	}
+}
@@ -3,6 +3,8 @@ package riscv
 import (
 	"fmt"
 	"log"
+	"math"
+	"math/bits"
 )
 
 func unknowOpcodeError(opcode int8, instrType int8) error {
@@ -27,6 +29,10 @@ func ToStringInstrType(instrType int8) string {
 		return "JInstrType"
 	case IImmInstrType:
 		return "IImmInstrType"
+	case BInstrType:
+		return "BInstrType"
+	case R4InstrType:
+		return "R4InstrType"
 	default:
 		return fmt.Sprintf("Unknown InstrType (val=%v)", instrType)
 	}
@@ -39,42 +45,51 @@ const (
 	UInstrType    int8 = 4
 	JInstrType    int8 = 5
 	IImmInstrType int8 = 6
+	BInstrType    int8 = 7
+	R4InstrType   int8 = 8
 )
 
 const (
-	STORE    int8 = 0   // 0000011
-	MISC_MEM int8 = 15  // 0001111
-	OP_IMM   int8 = 19  // 0010011
-	AUIPC    int8 = 23  // 0010111
-	LOAD     int8 = 35  // 0100011
-	OP       int8 = 51  // 0110011
-	LUI      int8 = 55  // 0110111
-	BRANCH   int8 = 99  // 1100011
-	JALR     int8 = 103 // 1100111
-	JAL      int8 = 111 // 1101111
-	SYSTEM   int8 = 115 // 1110011
+	LOAD      int8 = 3   // 0000011
+	MISC_MEM  int8 = 15  // 0001111
+	OP_IMM    int8 = 19  // 0010011
+	AUIPC     int8 = 23  // 0010111
+	OP_IMM_32 int8 = 27  // 0011011, RV64 only
+	STORE     int8 = 35  // 0100011
+	OP        int8 = 51  // 0110011
+	LUI       int8 = 55  // 0110111
+	OP_32     int8 = 59  // 0111011, RV64 only
+	BRANCH    int8 = 99  // 1100011
+	JALR      int8 = 103 // 1100111
+	JAL       int8 = 111 // 1101111
+	SYSTEM    int8 = 115 // 1110011
 )
 
 const (
 	FUNC7_RINST_0 int8 = 0
 	FUNC7_RINST_1 int8 = 32
+	FUNC7_MULDIV  int8 = 1 // M extension
 )
 
 // IInstr
 const (
-	FUNC3_ADDI int8 = 0
-	FUNC3_SLTI int8 = 1
-	FUNC3_ANDI int8 = 2
-	FUNC3_ORI  int8 = 3
-	FUNC3_XORI int8 = 4
-	FUNC3_SLLI int8 = 5
-	FUNC3_SRLI int8 = 6
-	FUNC3_SRAI int8 = 7
+	FUNC3_ADDI  int8 = 0
+	FUNC3_SLLI  int8 = 1
+	FUNC3_SLTI  int8 = 2
+	FUNC3_SLTIU int8 = 3
+	FUNC3_XORI  int8 = 4
+	FUNC3_SRLI  int8 = 5
+	FUNC3_SRAI  int8 = 5 // SRLI and SRAI are told apart by imm[11:5]
+	FUNC3_ORI   int8 = 6
+	FUNC3_ANDI  int8 = 7
 )
 
 type Instruction interface {
 	Execute(mem Memory, regs Registers) error
 	String() string
+	// Encode returns the instruction word, the lower 16 bits of it for
+	// compressed instructions
+	Encode() uint32
 }
 
 type RInstr struct {
@@ -125,61 +140,189 @@ const (
 
 )
 
-func (Inst RInstr) Execute(mem Memory, regs Registers) error {
+// RInstr with func7=FUNC7_MULDIV
+const (
+	FUNC3_MUL    int8 = 0
+	FUNC3_MULH   int8 = 1
+	FUNC3_MULHSU int8 = 2
+	FUNC3_MULHU  int8 = 3
+	FUNC3_DIV    int8 = 4
+	FUNC3_DIVU   int8 = 5
+	FUNC3_REM    int8 = 6
+	FUNC3_REMU   int8 = 7
+)
+
+// mulHigh returns the upper XLEN bits of the 2×XLEN-bit product, the operands
+// are signed or unsigned XLEN bit values.
+func mulHigh(rs1 uint64, rs2 uint64, rs1Signed bool, rs2Signed bool, xlen int) uint64 {
+	if xlen == XLEN_32 {
+		// the full product fits in 64 bits
+		a, b := int64(rs1), int64(rs2)
+		if rs1Signed {
+			a = asSigned(rs1, xlen)
+		}
+		if rs2Signed {
+			b = asSigned(rs2, xlen)
+		}
+		return uint64(a*b) >> 32
+	}
+	// the signed product is the unsigned one minus the other operand for
+	// every negative operand, shifted by 64
+	hi, _ := bits.Mul64(rs1, rs2)
+	if rs1Signed && int64(rs1) < 0 {
+		hi -= rs2
+	}
+	if rs2Signed && int64(rs2) < 0 {
+		hi -= rs1
+	}
+	return hi
+}
+
+func (Inst RInstr) executeMulDiv(rs1 uint64, rs2 uint64, xlen int) uint64 {
+	rs1_signed := asSigned(rs1, xlen)
+	rs2_signed := asSigned(rs2, xlen)
+	// the most negative XLEN bit number
+	minSigned := int64(-1) << (xlen - 1)
+	switch Inst.func3 {
+	case FUNC3_MUL:
+		// MUL performs an XLEN-bit×XLEN-bit multiplication of rs1 by rs2 and places the lower XLEN bits
+		// in the destination register.
+		return rs1 * rs2
+	case FUNC3_MULH:
+		// MULH, MULHU, and MULHSU perform the same multiplication but return the upper XLEN bits of the
+		// full 2×XLEN-bit product, for signed×signed, unsigned×unsigned, and signed rs1×unsigned rs2
+		// multiplication, respectively.
+		return mulHigh(rs1, rs2, true, true, xlen)
+	case FUNC3_MULHSU:
+		return mulHigh(rs1, rs2, true, false, xlen)
+	case FUNC3_MULHU:
+		return mulHigh(rs1, rs2, false, false, xlen)
+	case FUNC3_DIV:
+		// DIV and DIVU perform an XLEN bits by XLEN bits signed and unsigned integer division of rs1 by
+		// rs2, rounding towards zero. The quotient of division by zero has all bits set, and the
+		// signed overflow (most negative number divided by -1) results in the dividend.
+		if rs2 == 0 {
+			return math.MaxUint64
+		}
+		if rs1_signed == minSigned && rs2_signed == -1 {
+			return rs1
+		}
+		return uint64(rs1_signed / rs2_signed)
+	case FUNC3_DIVU:
+		if rs2 == 0 {
+			return math.MaxUint64
+		}
+		return rs1 / rs2
+	case FUNC3_REM:
+		// REM and REMU provide the remainder of the corresponding division operation, the sign of the
+		// result equals the sign of the dividend. The remainder of division by zero equals the
+		// dividend and the remainder of the signed overflow is zero.
+		if rs2 == 0 {
+			return rs1
+		}
+		if rs1_signed == minSigned && rs2_signed == -1 {
+			return 0
+		}
+		return uint64(rs1_signed % rs2_signed)
+	default: // FUNC3_REMU
+		if rs2 == 0 {
+			return rs1
+		}
+		return rs1 % rs2
+	}
+}
+
+// executeOp returns the result of the OP instruction on the XLEN bit values
+// of rs1 and rs2, the upper bits of the result are ignored on RV32.
+func (Inst RInstr) executeOp(rs1 uint64, rs2 uint64, xlen int) (uint64, error) {
+	// SLL, SRL, and SRA perform logical left, logical right, and arithmetic right shifts on the value in
+	// register rs1 by the shift amount held in the lower 5 bits of register rs2 (6 bits on RV64).
+	shamt := rs2 & uint64(xlen-1)
+
 	// ADD performs the addition of rs1 and rs2. SUB performs the subtraction of rs2 from rs1. Overflows
 	// are ignored and the low XLEN bits of results are written to the destination rd.
-	if Inst.opcode == OP {
-		rs1 := regs.Reg(Inst.rs1)
-		rs2 := regs.Reg(Inst.rs2)
-		var rd uint32
-		if Inst.func7 == FUNC7_ADD && Inst.func3 == FUNC3_ADD {
-			// ignore overflow
-			rd = rs1 + rs2
-		} else if Inst.func7 == FUNC7_SUB && Inst.func3 == FUNC3_SUB {
-			// ignore overfloat
-			rd = rs1 - rs2
-		} else if Inst.func7 == FUNC7_SLTU && Inst.func3 == FUNC3_SLTU {
-			// SLT and SLTU perform signed and unsigned compares respectively, writing 1 to rd if rs1 < rs2, 0 otherwise. Note
-			// SLTU rd, x0, rs2 sets rd to 1 if rs2 is not equal to zero, otherwise sets rd to zero (assembler
-			// pseudoinstruction SNEZ rd, rs).
-			unsigned_rs1 := IntAbs(ReinterpreteAsSigned(rs1))
-			unsigned_rs2 := IntAbs(ReinterpreteAsSigned(rs2))
-			if unsigned_rs1 < unsigned_rs2 {
-				rd = 1
-			} else {
-				rd = 0
-			}
-		} else if Inst.func7 == FUNC7_SLT && Inst.func3 == FUNC3_SLT {
-			if rs1 < rs2 {
-				rd = 1
-			} else {
-				rd = 0
-			}
-		} else if Inst.func7 == FUNC7_AND && Inst.func3 == FUNC3_AND {
-			// AND, OR, and XOR perform bitwise logical operations.regs.pc = regs.pc + 1
-			rd = rs1 & rs2
-		} else if Inst.func7 == FUNC7_OR && Inst.func3 == FUNC3_OR {
-			rd = rs1 | rs2
-		} else if Inst.func7 == FUNC7_XOR && Inst.func3 == FUNC3_XOR {
-			rd = rs1 ^ rs2
-		} else if Inst.func7 == FUNC7_SLL && Inst.func3 == FUNC3_SLL {
-			// SLL, SRL, and SRA perform logical left, logical right, and arithmetic right shifts on the value in
-			// register rs1 by the shift amount held in the lower 5 bits of register rs2.
-			// 11111=31
-			filter_5_bit := uint32(31)
-			rd = rs1 << rs2 & filter_5_bit
-		} else if Inst.func7 == FUNC7_SRA && Inst.func3 == FUNC3_SRA {
-			// arithmetic shift so keep the sign
-			filter_5_bit := uint32(31)
-			rd_signed := ReinterpreteAsSigned(rs1) >> uint32(rs2&filter_5_bit)
-			rd = ReinterpreteAsUnsigned(rd_signed)
-		} else if Inst.func7 == FUNC7_SRL && Inst.func3 == FUNC3_SRL {
-			filter_5_bit := uint32(31)
-			// logical shift
-			rd = rs1 >> (rs2 & filter_5_bit)
+	if Inst.func7 == FUNC7_MULDIV {
+		return Inst.executeMulDiv(rs1, rs2, xlen), nil
+	} else if Inst.func7 == FUNC7_ADD && Inst.func3 == FUNC3_ADD {
+		// ignore overflow
+		return rs1 + rs2, nil
+	} else if Inst.func7 == FUNC7_SUB && Inst.func3 == FUNC3_SUB {
+		// ignore overfloat
+		return rs1 - rs2, nil
+	} else if Inst.func7 == FUNC7_SLTU && Inst.func3 == FUNC3_SLTU {
+		// SLT and SLTU perform signed and unsigned compares respectively, writing 1 to rd if rs1 < rs2, 0 otherwise. Note
+		// SLTU rd, x0, rs2 sets rd to 1 if rs2 is not equal to zero, otherwise sets rd to zero (assembler
+		// pseudoinstruction SNEZ rd, rs).
+		if rs1 < rs2 {
+			return 1, nil
 		}
+		return 0, nil
+	} else if Inst.func7 == FUNC7_SLT && Inst.func3 == FUNC3_SLT {
+		if asSigned(rs1, xlen) < asSigned(rs2, xlen) {
+			return 1, nil
+		}
+		return 0, nil
+	} else if Inst.func7 == FUNC7_AND && Inst.func3 == FUNC3_AND {
+		// AND, OR, and XOR perform bitwise logical operations.
+		return rs1 & rs2, nil
+	} else if Inst.func7 == FUNC7_OR && Inst.func3 == FUNC3_OR {
+		return rs1 | rs2, nil
+	} else if Inst.func7 == FUNC7_XOR && Inst.func3 == FUNC3_XOR {
+		return rs1 ^ rs2, nil
+	} else if Inst.func7 == FUNC7_SLL && Inst.func3 == FUNC3_SLL {
+		return rs1 << shamt, nil
+	} else if Inst.func7 == FUNC7_SRA && Inst.func3 == FUNC3_SRA {
+		// arithmetic shift so keep the sign
+		return uint64(asSigned(rs1, xlen) >> shamt), nil
+	} else if Inst.func7 == FUNC7_SRL && Inst.func3 == FUNC3_SRL {
+		// logical shift
+		return rs1 >> shamt, nil
+	}
+	return 0, fmt.Errorf("invalid func7(val=%v) func3(val=%v) combination on RInstr", Inst.func7, Inst.func3)
+}
 
+// isWordOp returns if the OP instruction has a word variant in OP_32, which
+// are all but the compares, the logical operations and the upper half
+// multiplications.
+func (Inst RInstr) isWordOp() bool {
+	switch Inst.func7 {
+	case FUNC7_RINST_0:
+		return Inst.func3 == FUNC3_ADD || Inst.func3 == FUNC3_SLL || Inst.func3 == FUNC3_SRL
+	case FUNC7_RINST_1:
+		return Inst.func3 == FUNC3_SUB || Inst.func3 == FUNC3_SRA
+	case FUNC7_MULDIV:
+		return Inst.func3 == FUNC3_MUL || Inst.func3 >= FUNC3_DIV
+	}
+	return false
+}
+
+func (Inst RInstr) Execute(mem Memory, regs Registers) error {
+	if Inst.opcode == OP {
+		rd, err := Inst.executeOp(regs.Reg(Inst.rs1), regs.Reg(Inst.rs2), regs.Xlen())
+		if err != nil {
+			return err
+		}
 		regs.SetReg(Inst.rd, rd)
+		regs.SetPc(regs.Pc() + 4)
+	} else if Inst.opcode == OP_32 {
+		// The RV64 word instructions (ADDW, SLLW, ..., MULW, DIVW, ...) operate on the lower 32 bits of
+		// the operands and sign extend the 32 bit result to 64 bits.
+		if regs.Xlen() != XLEN_64 {
+			return unknowOpcodeError(Inst.opcode, RInstrType)
+		}
+		if !Inst.isWordOp() {
+			return fmt.Errorf("invalid func7(val=%v) func3(val=%v) combination on word RInstr", Inst.func7, Inst.func3)
+		}
+		rd, err := Inst.executeOp(regs.Reg(Inst.rs1)&0xffffffff, regs.Reg(Inst.rs2)&0xffffffff, XLEN_32)
+		if err != nil {
+			return err
+		}
+		regs.SetReg(Inst.rd, sextWord(rd))
+		regs.SetPc(regs.Pc() + 4)
+	} else if Inst.opcode == AMO {
+		return Inst.executeAtomic(mem, regs)
+	} else if Inst.opcode == OP_FP {
+		return Inst.executeFloat(regs)
 	} else {
 		return unknowOpcodeError(Inst.opcode, RInstrType)
 	}
@@ -208,10 +351,90 @@ const (
 	FUNC3_LB  int8 = 0
 	FUNC3_LH  int8 = 1
 	FUNC3_LW  int8 = 2
+	FUNC3_LD  int8 = 3 // RV64 only
 	FUNC3_LBU int8 = 4
 	FUNC3_LHU int8 = 5
+	FUNC3_LWU int8 = 6 // RV64 only
 )
 
+// loadSize returns the number of bytes a load (or store) with func3 accesses,
+// the lowest 2 bits of func3 encode the width for both.
+func loadSize(func3 int8) uint32 {
+	return uint32(1) << (func3 & 3)
+}
+
+// effectiveAddress returns the address of a load or store, rs1 plus the
+// sign extended 12 bit offset wrapped to XLEN bits.
+func effectiveAddress(regs Registers, rs1 int, offset uint32) uint64 {
+	return (regs.Reg(rs1) + sext64(offset, 11)) & xlenMask(regs.Xlen())
+}
+
+// executeOpImm returns the result of the OP_IMM instruction on the XLEN bit
+// value of rs1.
+func (Inst IInstr) executeOpImm(rs1 uint64, xlen int) (uint64, error) {
+	imm := sext64(Inst.imm, 11) & xlenMask(xlen)
+	// the shift amount is imm[4:0], or imm[5:0] on RV64, the bits above tell
+	// SRLI and SRAI apart
+	shamtBits := uint32(5)
+	if xlen == XLEN_64 {
+		shamtBits = 6
+	}
+	shamt := bitSliceBetween(Inst.imm, 0, shamtBits-1)
+	imm_static := bitSliceBetween(Inst.imm, shamtBits, 11)
+
+	switch Inst.func3 {
+	case FUNC3_ADDI:
+		// ADDI adds the sign-extended 12-bit immediate to register rs1. Arithmetic overflow is ignored and
+		// the result is simply the low XLEN bits of the result. ADDI rd, rs1, 0 is used to implement the MV
+		// rd, rs1 assembler pseudoinstruction.
+		return rs1 + imm, nil
+	case FUNC3_SLTI:
+		// SLTI (set less than immediate) places the value 1 in register rd if register rs1 is less than the sign-
+		// extended immediate when both are treated as signed numbers, else 0 is written to rd. SLTIU is
+		// similar but compares the values as unsigned numbers (i.e., the immediate is first sign-extended to
+		// XLEN bits then treated as an unsigned number).
+		if asSigned(rs1, xlen) < asSigned(imm, xlen) {
+			return 1, nil
+		}
+		return 0, nil
+	case FUNC3_SLTIU:
+		if rs1 < imm {
+			return 1, nil
+		}
+		return 0, nil
+	case FUNC3_ANDI:
+		// ANDI, ORI, XORI are logical operations that perform bitwise AND, OR, and XOR on register rs1
+		// and the sign-extended 12-bit immediate and place the result in rd. Note, XORI rd, rs1, -1 performs
+		// a bitwise logical inversion of register rs1 (assembler pseudoinstruction NOT rd, rs).
+		return rs1 & imm, nil
+	case FUNC3_ORI:
+		return rs1 | imm, nil
+	case FUNC3_XORI:
+		return rs1 ^ imm, nil
+	case FUNC3_SLLI:
+		// SLLI is a logical left shift (zeros are shifted into the lower bits)
+		if imm_static != 0 {
+			return 0, fmt.Errorf("invalid SLLI instruction, the imm[11:%d] should be equal to 0 but is %d", shamtBits, imm_static)
+		}
+		return rs1 << shamt, nil
+	case FUNC3_SRLI: // also FUNC3_SRAI
+		switch imm_static {
+		case 0:
+			// SRLI is a logical right shift (zeros are shifted into the upper bits);
+			// the value is zero extended to XLEN bits so the sign bit is shifted too.
+			return rs1 >> shamt, nil
+		case 0x400 >> shamtBits:
+			// SRAI is an arithmetic right shift (the original sign bit is copied into the vacated upper bits),
+			// it has imm[10] set.
+			return uint64(asSigned(rs1, xlen) >> shamt), nil
+		default:
+			return 0, fmt.Errorf("invalid SRLI/SRAI instruction, the imm[11:%d] should be equal to 0 or %d but is %d",
+				shamtBits, 0x400>>shamtBits, imm_static)
+		}
+	}
+	return 0, fmt.Errorf("invalid func3(val=%v) value on op_imm instruction", Inst.func3)
+}
+
 func (Inst IInstr) Execute(mem Memory, regs Registers) error {
 	switch Inst.opcode {
 	case JALR:
@@ -220,103 +443,92 @@ func (Inst IInstr) Execute(mem Memory, regs Registers) error {
 		// the least-significant bit of the result to zero. The address of the instruction following the jump
 		// (pc+4) is written to register rd. Register x0 can be used as the destination if the result is not
 		// required.
+		// The target is computed before the link register is written, as rd and rs1 may be the same register.
+		newPc := regs.Reg(Inst.rs1) + sext64(Inst.imm, 11)
+		newPc = newPc &^ 1                // set lsb to zero
 		regs.SetReg(Inst.rd, regs.Pc()+4) // set link register
-		newPc := regs.Reg(Inst.rs1) + Inst.imm
-		newPc = newPc - (newPc % 2) // set lsb to zero
 		regs.SetPc(newPc)
 	case OP_IMM:
+		rd, err := Inst.executeOpImm(regs.Reg(Inst.rs1), regs.Xlen())
+		if err != nil {
+			return err
+		}
+		regs.SetReg(Inst.rd, rd)
+		regs.SetPc(regs.Pc() + 4)
+	case OP_IMM_32:
+		// ADDIW, SLLIW, SRLIW and SRAIW only exist on RV64, they operate on the lower 32 bits of rs1
+		// and sign extend the 32 bit result to 64 bits.
+		if regs.Xlen() != XLEN_64 {
+			return unknowOpcodeError(Inst.opcode, IInstrType)
+		}
 		switch Inst.func3 {
-		case FUNC3_ADDI:
-			// ADDI adds the sign-extended 12-bit immediate to register rs1. Arithmetic overflow is ignored and
-			// the result is simply the low XLEN bits of the result. ADDI rd, rs1, 0 is used to implement the MV
-			// rd, rs1 assembler pseudoinstruction.
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)+sext(Inst.imm, 12))
-		case FUNC3_SLTI:
-			// SLTI (set less than immediate) places the value 1 in register rd if register rs1 is less than the sign-
-			// extended immediate when both are treated as signed numbers, else 0 is written to rd. SLTIU is
-			// similar but compares the values as unsigned numbers (i.e., the immediate is first sign-extended to
-			if ReinterpreteAsSigned(regs.Reg(Inst.rs1)) < ReinterpreteAsSigned(sext(Inst.imm, 11)) {
-				regs.SetReg(Inst.rd, 1)
-			} else {
-				regs.SetReg(Inst.rd, 0)
-			}
-		case FUNC3_ANDI:
-			// ANDI, ORI, XORI are logical operations that perform bitwise AND, OR, and XOR on register rs1
-			// and the sign-extended 12-bit immediate and place the result in rd. Note, XORI rd, rs1, -1 performs
-			// a bitwise logical inversion of register rs1 (assembler pseudoinstruction NOT rd, rs).
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)&Inst.imm)
-		case FUNC3_ORI:
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)|Inst.imm)
-		case FUNC3_XORI:
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)^Inst.imm)
-		case FUNC3_SLLI:
-			// SLLI is a logical left shift (zeros are shifted into the lower bits)
-			imm_static := bitSliceBetween(Inst.imm, 5, 11)
-			if imm_static != 0 {
-				return fmt.Errorf("invalid SLLI instruction, the imm[11:5] should be equal to 0 but is %d", imm_static)
-			}
-			imm_shamt := bitSliceBetween(Inst.imm, 0, 4)
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)<<int32(imm_shamt))
-		case FUNC3_SRLI:
-			// SRLI is a logical right shift (zeros are shifted into the upper bits);
-			// A logical shift also shifts the sign bit, we convert to unsigned
-			// in there to make sure the shift also shifts the sign bit.
-			imm_static := bitSliceBetween(Inst.imm, 5, 11)
-			if imm_static != 0 {
-				return fmt.Errorf("invalid SRLI instruction, the imm[11:5] should be equal to 0 but is %d", imm_static)
-			}
-			imm_shamt := bitSliceBetween(Inst.imm, 0, 4)
-			regs.SetReg(Inst.rs1, regs.Reg(Inst.rd)>>imm_shamt)
-		case FUNC3_SRAI:
-			// SRAI is an arithmetic right shift (the original sign bit is copied into the vacated upper bits)
-			// We don't cap the input as the sign bit should not be shifted here.
-			// so the sext makes sense here.
-			imm_static := bitSliceBetween(Inst.imm, 5, 11)
-			if imm_static != 32 {
-				return fmt.Errorf("invalid SRLI instruction, the imm[11:5] should be equal to 32 but is %d", imm_static)
-			}
-			imm_shamt := bitSliceBetween(Inst.imm, 0, 4)
-			val := ReinterpreteAsSigned(regs.Reg(Inst.rd)) >> int32(imm_shamt)
-			regs.SetReg(Inst.rs1, ReinterpreteAsUnsigned(val))
+		case FUNC3_ADDI, FUNC3_SLLI, FUNC3_SRLI:
 		default:
-			return fmt.Errorf("invalid func3(val=%v) value on op_imm instruction", Inst.func3)
+			return fmt.Errorf("invalid func3(val=%v) value on op_imm_32 instruction", Inst.func3)
 		}
+		rd, err := Inst.executeOpImm(regs.Reg(Inst.rs1)&0xffffffff, XLEN_32)
+		if err != nil {
+			return err
+		}
+		regs.SetReg(Inst.rd, sextWord(rd))
 		regs.SetPc(regs.Pc() + 4)
-		return nil
 	case LOAD:
 		// Loads are encoded in the I-type format and stores are S-type. The effective address is obtained by
 		// adding register rs1 to the sign-extended 12-bit offset. Loads copy a value from memory to register rd.
 		// Stores copy the value in register rs2 to memory.
-		addr := regs.Reg(Inst.rs1) + sext(Inst.imm, 11)
+		if (Inst.func3 == FUNC3_LD || Inst.func3 == FUNC3_LWU) && regs.Xlen() != XLEN_64 {
+			return fmt.Errorf("invalid func3 (value=%d) in load instruction, LD and LWU are RV64 only", Inst.func3)
+		}
+		addr := effectiveAddress(regs, Inst.rs1, Inst.imm)
+		if addr%uint64(loadSize(Inst.func3)) != 0 {
+			return Exception{Cause: EXC_LOAD_MISALIGNED, Tval: addr}
+		}
+		paddr, err := Translate(mem, regs, addr, AccessLoad)
+		if err != nil {
+			return err
+		}
 
-		var err error
-		var rd uint32
+		var rd uint64
 		switch Inst.func3 {
+		case FUNC3_LD:
+			// The LD instruction loads a 64-bit value from memory into rd.
+			rd, err = mem.Load(paddr, 8)
 		case FUNC3_LW:
-			// The LW instruction loads a 32-bit value from memory into rd.
-			rd, err = mem.Load(addr, 4)
+			// The LW instruction loads a 32-bit value from memory into rd, on RV64 it is sign-extended to 64 bits.
+			rd, err = mem.Load(paddr, 4)
+			rd = sextWord(rd)
+		case FUNC3_LWU:
+			// LWU zero extends the 32-bit value to 64 bits.
+			rd, err = mem.Load(paddr, 4)
 		case FUNC3_LH:
-			// LH loads a 16-bit value from memory, then sign-extends to 32-bits before storing in rd.
-			rd, err = mem.Load(addr, 2)
-			rd = sext(rd, 15)
+			// LH loads a 16-bit value from memory, then sign-extends to XLEN bits before storing in rd.
+			rd, err = mem.Load(paddr, 2)
+			rd = sext64(uint32(rd), 15)
 		case FUNC3_LHU:
-			// LHU loads a 16-bit value from memory but then zero extends to 32-bits before storing in rd.
-			rd, err = mem.Load(addr, 2)
+			// LHU loads a 16-bit value from memory but then zero extends to XLEN bits before storing in rd.
+			rd, err = mem.Load(paddr, 2)
 		case FUNC3_LB:
 			// LB and LBU are defined analogously for 8-bit values.
-			rd, err = mem.Load(addr, 1)
-			rd = sext(rd, 7)
+			rd, err = mem.Load(paddr, 1)
+			rd = sext64(uint32(rd), 7)
 		case FUNC3_LBU:
-			rd, err = mem.Load(addr, 1)
+			rd, err = mem.Load(paddr, 1)
 		default:
-			err = fmt.Errorf("invalid func3 (value=%d) in loda instruction", Inst.func3)
+			return fmt.Errorf("invalid func3 (value=%d) in loda instruction", Inst.func3)
 		}
 
 		if err != nil {
-			return err
+			return Exception{Cause: EXC_LOAD_ACCESS_FAULT, Tval: addr, Err: err}
 		}
 		regs.SetReg(Inst.rd, rd)
+		regs.SetPc(regs.Pc() + 4)
 
+	case LOAD_FP:
+		return Inst.executeLoadFloat(mem, regs)
+	case MISC_MEM:
+		return Inst.executeMiscMem(regs)
+	case SYSTEM:
+		return Inst.executeSystem(regs)
 	default:
 		return unknowOpcodeError(Inst.opcode, IInstrType)
 	}
@@ -324,6 +536,208 @@ func (Inst IInstr) Execute(mem Memory, regs Registers) error {
 	return nil
 }
 
+// MISC_MEM
+const (
+	FUNC3_FENCE   int8 = 0
+	FUNC3_FENCE_I int8 = 1
+)
+
+// The predecessor and successor sets of FENCE, the pred field is imm[7:4]
+// and succ is imm[3:0]. The fm field (imm[11:8]) selects the fence mode.
+const (
+	FENCE_W      uint32 = 1
+	FENCE_R      uint32 = 2
+	FENCE_O      uint32 = 4
+	FENCE_I      uint32 = 8
+	FENCE_FM_TSO uint32 = 8
+)
+
+func (Inst IInstr) executeMiscMem(regs Registers) error {
+	switch Inst.func3 {
+	case FUNC3_FENCE:
+		// The FENCE instruction is used to order device I/O and memory accesses as viewed by other RISC-V
+		// harts and external devices or coprocessors. FENCE.TSO (fm=1000) and PAUSE (pred=W, succ=0) are
+		// encoded as FENCE. There is one hart and memory accesses are executed in program order, so they
+		// are all nops. Unknown fm values and the unused rs1 and rd fields are ignored.
+	case FUNC3_FENCE_I:
+		// The FENCE.I instruction is used to synchronize the instruction and data streams, it makes the
+		// stores before it visible to the instruction fetches after it. The emulator drops its decoded
+		// instructions after executing it.
+	default:
+		return unknownFunc3Error(Inst.func3, Inst.opcode, IInstrType)
+	}
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+// isFenceI returns if the instruction is a FENCE.I, after which the
+// decoded instructions are stale.
+func isFenceI(instr Instruction) bool {
+	fence, ok := instr.(IInstr)
+	return ok && fence.opcode == MISC_MEM && fence.func3 == FUNC3_FENCE_I
+}
+
+// SYSTEM
+const (
+	FUNC3_PRIV   int8 = 0
+	FUNC3_CSRRW  int8 = 1
+	FUNC3_CSRRS  int8 = 2
+	FUNC3_CSRRC  int8 = 3
+	FUNC3_CSRRWI int8 = 5
+	FUNC3_CSRRSI int8 = 6
+	FUNC3_CSRRCI int8 = 7
+)
+
+// The privileged instructions (func3=0) are told apart by the imm field.
+const (
+	PRIV_ECALL  uint32 = 0x000
+	PRIV_EBREAK uint32 = 0x001
+	PRIV_SRET   uint32 = 0x102
+	PRIV_WFI    uint32 = 0x105
+	PRIV_MRET   uint32 = 0x302
+
+	// SFENCE.VMA is R-type, funct7 is the top of the imm field and rs2 the
+	// bottom
+	FUNCT7_SFENCE_VMA uint32 = 0x09
+)
+
+func (Inst IInstr) executeSfenceVma(regs Registers) error {
+	if Inst.rd != reg_zero {
+		return fmt.Errorf("invalid sfence.vma instruction, rd(val=%d) should be zero", Inst.rd)
+	}
+	csrs := regs.Csrs()
+	mode := csrs.Mode()
+	if mode == MODE_U || (mode == MODE_S && csrs.mstatus&MSTATUS_TVM != 0) {
+		return fmt.Errorf("sfence.vma is not allowed in mode=%d", mode)
+	}
+
+	// rs1=x0 flushes all addresses and rs2=x0 all address spaces, otherwise
+	// the registers hold the virtual address and the asid
+	rs2 := int(bitSliceBetween(Inst.imm, 0, 4))
+	asidMask := SATP_ASID >> SATP_ASID_SHIFT
+	if regs.Xlen() == XLEN_64 {
+		asidMask = SATP64_ASID >> SATP64_ASID_SHIFT
+	}
+	asid := regs.Reg(rs2) & asidMask
+	regs.TLB().Flush(regs.Reg(Inst.rs1), asid, Inst.rs1 == reg_zero, rs2 == reg_zero)
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
+func (Inst IInstr) executePriv(regs Registers) error {
+	if Inst.rs1 != reg_zero || Inst.rd != reg_zero {
+		return fmt.Errorf("invalid privileged instruction, rs1(val=%d) and rd(val=%d) should be zero", Inst.rs1, Inst.rd)
+	}
+
+	csrs := regs.Csrs()
+	mode := csrs.Mode()
+	switch bitSliceBetween(Inst.imm, 0, 11) {
+	case PRIV_ECALL:
+		// The ECALL instruction is used to make a service request to the execution environment.
+		// The cause tells the handler which mode made the call.
+		return Exception{Cause: EXC_ECALL_U + mode}
+	case PRIV_EBREAK:
+		// The EBREAK instruction is used to return control to a debugging environment.
+		return Exception{Cause: EXC_BREAKPOINT, Tval: regs.Pc()}
+	case PRIV_WFI:
+		// WFI is a hint, so it is legal to implement it as a nop. Outside of machine mode it may
+		// only wait a bounded time, which is no time at all in user mode and with TW set.
+		if mode == MODE_U || (mode == MODE_S && csrs.mstatus&MSTATUS_TW != 0) {
+			return fmt.Errorf("wfi is not allowed in mode=%d", mode)
+		}
+		regs.SetPc(regs.Pc() + 4)
+	case PRIV_SRET:
+		// with TSR supervisor mode can't return, so machine mode can emulate it
+		if mode == MODE_U || (mode == MODE_S && csrs.mstatus&MSTATUS_TSR != 0) {
+			return fmt.Errorf("sret is not allowed in mode=%d", mode)
+		}
+		ReturnFromSupervisorTrap(regs)
+	case PRIV_MRET:
+		if mode != MODE_M {
+			return fmt.Errorf("mret is not allowed in mode=%d", mode)
+		}
+		ReturnFromTrap(regs)
+	default:
+		return fmt.Errorf("unknown privileged instruction with imm=%#x", Inst.imm)
+	}
+	return nil
+}
+
+func (Inst IInstr) executeSystem(regs Registers) error {
+	if Inst.func3 == FUNC3_PRIV {
+		if bitSliceBetween(Inst.imm, 5, 11) == FUNCT7_SFENCE_VMA {
+			return Inst.executeSfenceVma(regs)
+		}
+		return Inst.executePriv(regs)
+	}
+
+	// The CSR instructions atomically read-modify-write a single CSR, whose CSR specifier is encoded in
+	// the 12-bit csr field of the instruction held in bits 31–20. The immediate forms use a 5-bit
+	// zero-extended immediate encoded in the rs1 field.
+	csrs := regs.Csrs()
+	csr := bitSliceBetween(Inst.imm, 0, 11)
+	var src uint64
+	if Inst.func3 >= FUNC3_CSRRWI {
+		src = uint64(Inst.rs1)
+	} else {
+		src = regs.Reg(Inst.rs1)
+	}
+
+	switch Inst.func3 {
+	case FUNC3_CSRRW, FUNC3_CSRRWI:
+		// CSRRW reads the old value of the CSR, zero-extends the value to XLEN bits, then writes it to
+		// integer register rd. If rd=x0, then the instruction shall not read the CSR and shall not cause
+		// any of the side effects that might occur on a CSR read.
+		err := csrs.checkAccess(csr, true)
+		if err != nil {
+			return err
+		}
+		var old uint64
+		if Inst.rd != reg_zero {
+			old, err = csrs.Read(csr)
+			if err != nil {
+				return err
+			}
+		}
+		err = csrs.Write(csr, src)
+		if err != nil {
+			return err
+		}
+		regs.SetReg(Inst.rd, old)
+	case FUNC3_CSRRS, FUNC3_CSRRC, FUNC3_CSRRSI, FUNC3_CSRRCI:
+		// CSRRS sets the bits in the CSR that are set in rs1, CSRRC clears them. If rs1=x0 (or uimm=0),
+		// then the instruction will not write to the CSR at all, and so shall not cause any of the side
+		// effects that might otherwise occur on a CSR write, nor raise illegal instruction exceptions on
+		// accesses to read-only CSRs.
+		err := csrs.checkAccess(csr, Inst.rs1 != reg_zero)
+		if err != nil {
+			return err
+		}
+		old, err := csrs.Read(csr)
+		if err != nil {
+			return err
+		}
+		if Inst.rs1 != reg_zero {
+			var value uint64
+			if Inst.func3 == FUNC3_CSRRS || Inst.func3 == FUNC3_CSRRSI {
+				value = old | src
+			} else {
+				value = old &^ src
+			}
+			err = csrs.Write(csr, value)
+			if err != nil {
+				return err
+			}
+		}
+		regs.SetReg(Inst.rd, old)
+	default:
+		return unknownFunc3Error(Inst.func3, Inst.opcode, IInstrType)
+	}
+
+	regs.SetPc(regs.Pc() + 4)
+	return nil
+}
+
 type SInstr struct {
 	imm1   uint32
 	rs2    int
@@ -354,6 +768,7 @@ const (
 	FUNC3_SB int8 = 0
 	FUNC3_SH int8 = 1
 	FUNC3_SW int8 = 2
+	FUNC3_SD int8 = 3 // RV64 only
 )
 
 func (Instr SInstr) Execute(mem Memory, regs Registers) error {
@@ -362,23 +777,40 @@ func (Instr SInstr) Execute(mem Memory, regs Registers) error {
 		// Loads are encoded in the I-type format and stores are S-type. The effective address is obtained by
 		// adding register rs1 to the sign-extended 12-bit offset. Loads copy a value from memory to register rd.
 		// Stores copy the value in register rs2 to memory.
-		addr := regs.Reg(Instr.rs1) + sext(Instr.imm(), 11)
+		if Instr.func3 == FUNC3_SD && regs.Xlen() != XLEN_64 {
+			return fmt.Errorf("invalid func3 (value=%d) in store instruction, SD is RV64 only", Instr.func3)
+		}
+		addr := effectiveAddress(regs, Instr.rs1, Instr.imm())
+		if addr%uint64(loadSize(Instr.func3)) != 0 {
+			return Exception{Cause: EXC_STORE_MISALIGNED, Tval: addr}
+		}
+		paddr, err := Translate(mem, regs, addr, AccessStore)
+		if err != nil {
+			return err
+		}
 		rs2 := regs.Reg(Instr.rs2)
-		var err error = nil
 		switch Instr.func3 {
-		// The SW, SH, and SB instructions store 32-bit, 16-bit, and 8-bit values from the low bits of register
-		// rs2 to memory
+		// The SD, SW, SH, and SB instructions store 64-bit, 32-bit, 16-bit, and 8-bit values from the low bits
+		// of register rs2 to memory
 		case FUNC3_SB:
-			err = mem.Store(addr, rs2, 1)
+			err = mem.Store(paddr, rs2, 1)
 		case FUNC3_SH:
-			err = mem.Store(addr, rs2, 2)
+			err = mem.Store(paddr, rs2, 2)
 		case FUNC3_SW:
-			err = mem.Store(addr, rs2, 4)
+			err = mem.Store(paddr, rs2, 4)
+		case FUNC3_SD:
+			err = mem.Store(paddr, rs2, 8)
 		default:
 			return unknownFunc3Error(Instr.func3, Instr.opcode, SInstrType)
 		}
 
-		return err
+		if err != nil {
+			return Exception{Cause: EXC_STORE_ACCESS_FAULT, Tval: addr, Err: err}
+		}
+		regs.SetPc(regs.Pc() + 4)
+		return nil
+	case STORE_FP:
+		return Instr.executeStoreFloat(mem, regs)
 	}
 	return unknowOpcodeError(Instr.opcode, SInstrType)
 }
@@ -416,50 +848,52 @@ const (
 	FUNC3_BGEU uint32 = 7
 )
 
-func (Instr BInstr) Execute(mem Memory, regs Registers) error {
-	offset := sext(Instr.imm(), 11)
-
+// taken returns if the branch condition holds for the current registers.
+func (Instr BInstr) taken(regs Registers) (bool, error) {
 	rs1 := regs.Reg(Instr.rs1)
 	rs2 := regs.Reg(Instr.rs2)
 
 	// If the comparison is gte/tle then we need to take into
 	// the sign bit:
-	rs1_signed := ReinterpreteAsSigned(rs1)
-	rs2_signed := ReinterpreteAsSigned(rs2)
+	rs1_signed := asSigned(rs1, regs.Xlen())
+	rs2_signed := asSigned(rs2, regs.Xlen())
 
 	switch Instr.func3 {
 	// BEQ and BNE take the branch if registers rs1 and rs2
 	// are equal or unequal respectively.
 	case FUNC3_BEQ:
-		if rs1 == rs2 {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1 == rs2, nil
 	case FUNC3_BNE:
-		if rs1 != rs2 {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1 != rs2, nil
 	// BLT and BLTU take the branch if rs1 is less than rs2, using
 	// signed and unsigned comparison respectively.
 	case FUNC3_BLT:
-		if rs1_signed < rs2_signed {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1_signed < rs2_signed, nil
 	case FUNC3_BLTU:
-		if rs1 < rs2 {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1 < rs2, nil
 	// BGE and BGEU take the branch if rs1 is greater
 	// than or equal to rs2, using signed and unsigned comparison respectively.
 	case FUNC3_BGE:
-		if rs1_signed >= rs2_signed {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1_signed >= rs2_signed, nil
 	case FUNC3_BGEU:
-		if rs1 >= rs2 {
-			regs.SetPc(regs.Pc() + offset)
-		}
+		return rs1 >= rs2, nil
 	default:
-		return fmt.Errorf("invalid func3(val=%v) on BInstr", Instr.func3)
+		return false, fmt.Errorf("invalid func3(val=%v) on BInstr", Instr.func3)
+	}
+}
+
+func (Instr BInstr) Execute(mem Memory, regs Registers) error {
+	// 13 bit offset (imm[12:1]) so the sign bit is on position 12
+	offset := sext64(Instr.imm(), 12)
+
+	taken, err := Instr.taken(regs)
+	if err != nil {
+		return err
+	}
+	if taken {
+		regs.SetPc(regs.Pc() + offset)
+	} else {
+		regs.SetPc(regs.Pc() + 4)
 	}
 
 	return nil
@@ -480,7 +914,8 @@ func (Instr UInstr) String() string {
 }
 
 func (Inst UInstr) Execute(mem Memory, regs Registers) error {
-	imm1_shifted := Inst.imm << 12 // fill lowest 12 bits with zero
+	// fill lowest 12 bits with zero, on RV64 the 32 bit value is sign extended
+	imm1_shifted := sext64(Inst.imm<<12, 31)
 	switch Inst.opcode {
 	case AUIPC:
 		// AUIPC (add upper immediate to pc) is used to build pc-relative addresses and uses the U-type
@@ -493,7 +928,6 @@ func (Inst UInstr) Execute(mem Memory, regs Registers) error {
 		// places the U-immediate value in the top 20 bits of the destination register rd, filling in the lowest
 		// 12 bits with zeros.
 		regs.SetReg(Inst.rd, imm1_shifted)
-		regs.SetPc(regs.Pc() + 4)
 	default:
 		return unknowOpcodeError(Inst.opcode, UInstrType)
 	}
@@ -551,7 +985,7 @@ func (Instr JInstr) Execute(mem Memory, regs Registers) error {
 		regs.SetReg(Instr.rd, regs.Pc()+4)
 
 		// jump to the new location
-		regs.SetPc(regs.Pc() + Instr.Imm())
+		regs.SetPc(regs.Pc() + sext64(Instr.Imm(), 31))
 	} else {
 		return unknowOpcodeError(Instr.opcode, JInstrType)
 	}
@@ -568,23 +1002,65 @@ type PInstr struct {
 }
 
 func CreateADDI(src int, dst int, imm uint32) IInstr {
-	return IInstr{rs1: dst, rd: src, imm: imm, func3: FUNC3_ADDI, opcode: OP_IMM}
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_ADDI, opcode: OP_IMM}
+}
+
+func CreateSLTI(src int, dst int, imm uint32) IInstr {
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_SLTI, opcode: OP_IMM}
+}
+
+func CreateSLTIU(src int, dst int, imm uint32) IInstr {
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_SLTIU, opcode: OP_IMM}
+}
+
+func CreateXORI(src int, dst int, imm uint32) IInstr {
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_XORI, opcode: OP_IMM}
+}
+
+func CreateORI(src int, dst int, imm uint32) IInstr {
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_ORI, opcode: OP_IMM}
+}
+
+func CreateANDI(src int, dst int, imm uint32) IInstr {
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_ANDI, opcode: OP_IMM}
 }
 
 func CreateSLLI(src int, dst int, imm uint32) IInstr {
-	return IInstr{rs1: dst, rd: src, imm: imm, func3: FUNC3_SLLI, opcode: OP_IMM}
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_SLLI, opcode: OP_IMM}
 }
 
 func CreateSLRI(src int, dst int, imm uint32) IInstr {
-	return IInstr{rs1: dst, rd: src, imm: imm, func3: FUNC3_SRLI, opcode: OP_IMM}
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_SRLI, opcode: OP_IMM}
 }
 
 func CreateSRAI(src int, dst int, imm uint32) IInstr {
-	if imm > 31 {
-		panic("Invalid SRAI, the immediate should not be bigger then 31")
+	if imm > 63 {
+		panic("Invalid SRAI, the immediate should not be bigger then 63")
 	}
 	imm = imm + (32 << 5)
-	return IInstr{rs1: dst, rd: src, imm: imm, func3: FUNC3_SRAI, opcode: OP_IMM}
+	return IInstr{rs1: src, rd: dst, imm: imm, func3: FUNC3_SRAI, opcode: OP_IMM}
+}
+
+// createWordImm returns the RV64 word variant of the OP_IMM instruction.
+func createWordImm(instr IInstr) IInstr {
+	instr.opcode = OP_IMM_32
+	return instr
+}
+
+func CreateADDIW(src int, dst int, imm uint32) IInstr {
+	return createWordImm(CreateADDI(src, dst, imm))
+}
+
+func CreateSLLIW(src int, dst int, imm uint32) IInstr {
+	return createWordImm(CreateSLLI(src, dst, imm))
+}
+
+func CreateSRLIW(src int, dst int, imm uint32) IInstr {
+	return createWordImm(CreateSLRI(src, dst, imm))
+}
+
+func CreateSRAIW(src int, dst int, imm uint32) IInstr {
+	return createWordImm(CreateSRAI(src, dst, imm))
 }
 
 func CreateMV(src int, dst int) IInstr {
@@ -644,6 +1120,32 @@ func CreateSRL(rd int, rs1 int, rs2 int) RInstr {
 	return RInstr{rd: rd, rs1: rs1, rs2: rs2, func3: FUNC3_SRL, func7: FUNC7_SRL, opcode: OP}
 }
 
+// createWordOp returns the RV64 word variant of the OP instruction.
+func createWordOp(instr RInstr) RInstr {
+	instr.opcode = OP_32
+	return instr
+}
+
+func CreateADDW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateADD(rd, rs1, rs2))
+}
+
+func CreateSUBW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateSUB(rd, rs1, rs2))
+}
+
+func CreateSLLW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateSLL(rd, rs1, rs2))
+}
+
+func CreateSRLW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateSRL(rd, rs1, rs2))
+}
+
+func CreateSRAW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateSRA(rd, rs1, rs2))
+}
+
 func CreateJAL(imm int32, link_reg int) JInstr {
 	if imm%2 == 1 {
 		log.Panic("imm in jal must be an even number")
@@ -734,6 +1236,14 @@ func CreateLB(offset int32, addr int, dst int) IInstr {
 	return CreateLoad(offset, addr, FUNC3_LB, dst)
 }
 
+func CreateLD(offset int32, addr int, dst int) IInstr {
+	return CreateLoad(offset, addr, FUNC3_LD, dst)
+}
+
+func CreateLWU(offset int32, addr int, dst int) IInstr {
+	return CreateLoad(offset, addr, FUNC3_LWU, dst)
+}
+
 func CreateStore(offset int32, data int, addr int, func3 int8) SInstr {
 	// imm1   uint32
 	// rs2    uint32
@@ -745,8 +1255,8 @@ func CreateStore(offset int32, data int, addr int, func3 int8) SInstr {
 	offset_unsigned := ReinterpreteAsUnsigned(offset)
 	// imm0 -> offset[0:4]
 	// imm1 -> offset[5:11]
-	imm0 := (offset_unsigned << (32 - 5)) >> (32 - 5)
-	imm1 := offset_unsigned >> 5
+	imm0 := bitSliceBetween(offset_unsigned, 0, 4)
+	imm1 := bitSliceBetween(offset_unsigned, 5, 11)
 
 	return SInstr{
 		imm1:   imm1,
@@ -769,3 +1279,143 @@ func CreateSH(offset int32, data int, addr int) SInstr {
 func CreateSW(offset int32, data int, addr int) SInstr {
 	return CreateStore(offset, data, addr, FUNC3_SW)
 }
+
+func CreateSD(offset int32, data int, addr int) SInstr {
+	return CreateStore(offset, data, addr, FUNC3_SD)
+}
+
+func createCSR(rd int, csr uint32, rs1 int, func3 int8) IInstr {
+	// the decoder sign extends the csr field like every 12 bit immediate
+	return IInstr{imm: sext(csr, 11), rs1: rs1, func3: func3, rd: rd, opcode: SYSTEM}
+}
+
+func CreateCSRRW(rd int, csr uint32, rs1 int) IInstr {
+	return createCSR(rd, csr, rs1, FUNC3_CSRRW)
+}
+
+func CreateCSRRS(rd int, csr uint32, rs1 int) IInstr {
+	return createCSR(rd, csr, rs1, FUNC3_CSRRS)
+}
+
+func CreateCSRRC(rd int, csr uint32, rs1 int) IInstr {
+	return createCSR(rd, csr, rs1, FUNC3_CSRRC)
+}
+
+func CreateCSRRWI(rd int, csr uint32, uimm uint32) IInstr {
+	return createCSR(rd, csr, int(uimm), FUNC3_CSRRWI)
+}
+
+func CreateCSRRSI(rd int, csr uint32, uimm uint32) IInstr {
+	return createCSR(rd, csr, int(uimm), FUNC3_CSRRSI)
+}
+
+func CreateCSRRCI(rd int, csr uint32, uimm uint32) IInstr {
+	return createCSR(rd, csr, int(uimm), FUNC3_CSRRCI)
+}
+
+func createPriv(imm uint32) IInstr {
+	return IInstr{imm: imm, func3: FUNC3_PRIV, opcode: SYSTEM}
+}
+
+func CreateECALL() IInstr {
+	return createPriv(PRIV_ECALL)
+}
+
+func CreateEBREAK() IInstr {
+	return createPriv(PRIV_EBREAK)
+}
+
+func CreateWFI() IInstr {
+	return createPriv(PRIV_WFI)
+}
+
+func CreateSRET() IInstr {
+	return createPriv(PRIV_SRET)
+}
+
+func CreateMRET() IInstr {
+	return createPriv(PRIV_MRET)
+}
+
+func CreateSFENCEVMA(rs1 int, rs2 int) IInstr {
+	return IInstr{imm: FUNCT7_SFENCE_VMA<<5 | uint32(rs2), rs1: rs1, func3: FUNC3_PRIV, rd: reg_zero, opcode: SYSTEM}
+}
+
+func createMiscMem(imm uint32, func3 int8) IInstr {
+	// the decoder sign extends the 12 bit immediate, which has the top bit
+	// set for FENCE.TSO
+	return IInstr{imm: sext(imm, 11), rs1: reg_zero, func3: func3, rd: reg_zero, opcode: MISC_MEM}
+}
+
+func CreateFENCE(pred uint32, succ uint32) IInstr {
+	return createMiscMem(pred<<4|succ, FUNC3_FENCE)
+}
+
+func CreateFENCETSO() IInstr {
+	rw := FENCE_R | FENCE_W
+	return createMiscMem(FENCE_FM_TSO<<8|rw<<4|rw, FUNC3_FENCE)
+}
+
+func CreatePAUSE() IInstr {
+	return CreateFENCE(FENCE_W, 0)
+}
+
+func CreateFENCEI() IInstr {
+	return createMiscMem(0, FUNC3_FENCE_I)
+}
+
+func createMulDiv(rd int, rs1 int, rs2 int, func3 int8) RInstr {
+	return RInstr{rd: rd, rs1: rs1, rs2: rs2, func3: func3, func7: FUNC7_MULDIV, opcode: OP}
+}
+
+func CreateMUL(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_MUL)
+}
+
+func CreateMULH(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_MULH)
+}
+
+func CreateMULHSU(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_MULHSU)
+}
+
+func CreateMULHU(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_MULHU)
+}
+
+func CreateDIV(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_DIV)
+}
+
+func CreateDIVU(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_DIVU)
+}
+
+func CreateREM(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_REM)
+}
+
+func CreateREMU(rd int, rs1 int, rs2 int) RInstr {
+	return createMulDiv(rd, rs1, rs2, FUNC3_REMU)
+}
+
+func CreateMULW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateMUL(rd, rs1, rs2))
+}
+
+func CreateDIVW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateDIV(rd, rs1, rs2))
+}
+
+func CreateDIVUW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateDIVU(rd, rs1, rs2))
+}
+
+func CreateREMW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateREM(rd, rs1, rs2))
+}
+
+func CreateREMUW(rd int, rs1 int, rs2 int) RInstr {
+	return createWordOp(CreateREMU(rd, rs1, rs2))
+}
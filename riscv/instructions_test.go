@@ -4,14 +4,14 @@ import (
 	"testing"
 )
 
-func CheckReg(regIndex int, expected uint32, r Registers, t *testing.T) {
+func CheckReg(regIndex int, expected uint64, r Registers, t *testing.T) {
 	if r.Reg(regIndex) != expected {
 		t.Logf("reg[%d]==%d and should be %d", regIndex, r.Reg(regIndex), expected)
 		t.Fail()
 	}
 }
 
-func CheckPc(expected uint32, r Registers, t *testing.T) {
+func CheckPc(expected uint64, r Registers, t *testing.T) {
 	if r.Pc() != expected {
 		t.Logf("pc==%d and should be %d", r.Pc(), expected)
 		t.Fail()
@@ -27,7 +27,7 @@ func TestAddI(t *testing.T) {
 
 	r.reg[0] = 4
 	addi.Execute(&mem, &r)
-	expected := uint32(6)
+	expected := uint64(6)
 	CheckReg(1, expected, &r, t)
 }
 
@@ -39,7 +39,7 @@ func TestSLLI(t *testing.T) {
 	addi := CreateSLLI(0, 1, 2)
 
 	r.reg[0] = 8
-	expected := uint32(32)
+	expected := uint64(32)
 	addi.Execute(&mem, &r)
 	CheckReg(1, expected, &r, t)
 }
@@ -52,7 +52,7 @@ func TestSRLI(t *testing.T) {
 	addi := CreateSLRI(0, 1, 2)
 
 	r.reg[0] = 8
-	expected := uint32(2)
+	expected := uint64(2)
 	addi.Execute(&mem, &r)
 	CheckReg(1, expected, &r, t)
 }
@@ -64,8 +64,8 @@ func TestSRLINegative(t *testing.T) {
 	// read from x0, write to x1, right shift of 2
 	addi := CreateSLRI(0, 1, 2)
 
-	r.reg[0] = ReinterpreteAsUnsigned(-32) // 11111111111111111111111111100000
-	expected := uint32(1073741816)         // 00111111111111111111111111111000
+	r.reg[0] = uint64(ReinterpreteAsUnsigned(-32)) // 11111111111111111111111111100000
+	expected := uint64(1073741816)                 // 00111111111111111111111111111000
 	addi.Execute(&mem, &r)
 	CheckReg(1, expected, &r, t)
 }
@@ -77,8 +77,8 @@ func TestSRAI(t *testing.T) {
 	// read from x0, write to x1, right shift of 2
 	addi := CreateSRAI(0, 1, 2)
 
-	r.reg[0] = ReinterpreteAsUnsigned(-32) // sext(11100000)
-	expected := ReinterpreteAsUnsigned(-8) // sect(11111000)
+	r.reg[0] = uint64(ReinterpreteAsUnsigned(-32)) // sext(11100000)
+	expected := uint64(ReinterpreteAsUnsigned(-8)) // sect(11111000)
 	addi.Execute(&mem, &r)
 	CheckReg(1, expected, &r, t)
 }
@@ -91,7 +91,7 @@ func TestLui(t *testing.T) {
 	I := CreateLui(1, 1)
 	I.Execute(&mem, &r)
 
-	expected := uint32(4096)
+	expected := uint64(4096)
 
 	CheckReg(1, expected, &r, t)
 }
@@ -104,7 +104,7 @@ func TestAUIPC(t *testing.T) {
 	I := CreateAUIPC(1, 1)
 	I.Execute(&mem, &r)
 
-	expected := uint32(4100)
+	expected := uint64(4100)
 
 	CheckReg(1, expected, &r, t)
 }
@@ -116,7 +116,7 @@ func TestADD(t *testing.T) {
 	// reg[1] = reg[2] + reg[3]
 	r.reg[2] = 2
 	r.reg[3] = 3
-	expected := uint32(5)
+	expected := uint64(5)
 
 	I := CreateADD(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -131,7 +131,7 @@ func TestSUB(t *testing.T) {
 	// reg[1] = reg[2] - reg[3]
 	r.reg[2] = 2
 	r.reg[3] = 3
-	expected := ReinterpreteAsUnsigned(-1)
+	expected := uint64(ReinterpreteAsUnsigned(-1))
 
 	I := CreateSUB(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -146,7 +146,7 @@ func TestSLT(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 4
 	r.reg[3] = 5
-	expected := uint32(1) // 4<5==true
+	expected := uint64(1) // 4<5==true
 
 	I := CreateSLT(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -160,8 +160,8 @@ func TestSLTU(t *testing.T) {
 
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 4
-	r.reg[3] = ReinterpreteAsUnsigned(-5)
-	expected := uint32(1) // abs(4)<abs(-5)==true
+	r.reg[3] = uint64(ReinterpreteAsUnsigned(-5))
+	expected := uint64(1) // abs(4)<abs(-5)==true
 
 	I := CreateSLTU(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -176,7 +176,7 @@ func TestAND(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 5          // 0101
 	r.reg[3] = 4          // 0110
-	expected := uint32(4) // 0100
+	expected := uint64(4) // 0100
 
 	I := CreateAND(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -191,7 +191,7 @@ func TestOR(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 5          // 0101
 	r.reg[3] = 6          // 0110
-	expected := uint32(7) // 0111
+	expected := uint64(7) // 0111
 
 	I := CreateOR(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -206,7 +206,7 @@ func TestXOR(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 5          // 0101
 	r.reg[3] = 4          // 0110
-	expected := uint32(1) // 0011
+	expected := uint64(1) // 0011
 
 	I := CreateXOR(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -221,7 +221,7 @@ func TestSLL(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 5 // 0101
 	r.reg[3] = 2
-	expected := uint32(20) // 010100
+	expected := uint64(20) // 010100
 
 	I := CreateSLL(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -236,7 +236,7 @@ func TestSRA(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 20 // 010100
 	r.reg[3] = 2
-	expected := uint32(5) // 0101
+	expected := uint64(5) // 0101
 
 	I := CreateSRA(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -251,7 +251,7 @@ func TestSRL(t *testing.T) {
 	// reg[1] = reg[2] < reg[3]
 	r.reg[2] = 20 // 010100
 	r.reg[3] = 2
-	expected := uint32(5) // 0101
+	expected := uint64(5) // 0101
 
 	I := CreateSRL(1, 2, 3)
 	I.Execute(&mem, &r)
@@ -263,7 +263,7 @@ func TestJAL(t *testing.T) {
 	r := RegistersImpl{}
 	mem := NewMemory(0)
 
-	begin_pc := uint32(10)
+	begin_pc := uint64(10)
 	r.pc = begin_pc
 	pc_offset := int32(16)
 
@@ -275,7 +275,7 @@ func TestJAL(t *testing.T) {
 
 	// make sure the link is saved
 	// CheckReg(reg_a0, begin_pc+1, &r, t)
-	CheckPc(begin_pc+uint32(pc_offset), &r, t)
+	CheckPc(begin_pc+uint64(pc_offset), &r, t)
 }
 
 func TestJALR(t *testing.T) {
@@ -285,7 +285,7 @@ func TestJALR(t *testing.T) {
 	offset := uint32(10)
 	link_reg := reg_a0
 	addr_reg := reg_a1
-	begin_pc := uint32(5)
+	begin_pc := uint64(5)
 
 	r.reg[addr_reg] = 15
 	r.pc = begin_pc
@@ -304,7 +304,7 @@ func TestBEQ(t *testing.T) {
 	mem := NewMemory(0)
 
 	offset := ReinterpreteAsUnsigned(int32(10))
-	begin_pc := uint32(5)
+	begin_pc := uint64(5)
 
 	r.reg[1] = 1
 	r.reg[2] = 2
@@ -317,10 +317,11 @@ func TestBEQ(t *testing.T) {
 
 	I.Execute(&mem, &r)
 
-	// x1 != x2 -> should not branch
-	CheckPc(begin_pc, &r, t)
+	// x1 != x2 -> should not branch, continue with the next instruction
+	CheckPc(begin_pc+4, &r, t)
 
 	// x1 == x3 -> should branch
+	r.pc = begin_pc
 	I = CreateBEQ(offset, 1, 3)
 	I.Execute(&mem, &r)
 
@@ -350,13 +351,13 @@ func TestSWLW(t *testing.T) {
 	mem := NewMemory(20)
 
 	offset := int32(10)
-	addr := uint32(1)
+	addr := uint64(2) // addr+offset must be word aligned
 	addrReg := 0
 	LdReg := 1
 	StReg := 2
 
 	r.reg[addrReg] = addr
-	wordToBeStored := uint32(5)
+	wordToBeStored := uint64(5)
 	r.SetReg(StReg, wordToBeStored) // the word to be store==5
 
 	IStore := CreateSW(offset, StReg, addrReg)
@@ -365,7 +366,7 @@ func TestSWLW(t *testing.T) {
 		t.Errorf("store instruction failed with error=%v", errStore.Error())
 	}
 
-	val, _ := mem.Load(1+uint32(offset), 4) // assume offset is not negative
+	val, _ := mem.Load(addr+uint64(offset), 4) // assume offset is not negative
 	if val != wordToBeStored {
 		t.Errorf("word not saved in memory, mem load results in %d but should be %d", val, wordToBeStored)
 	}
@@ -380,3 +381,235 @@ func TestSWLW(t *testing.T) {
 		t.Errorf("Load result(value=%d) != %d", r.Reg(LdReg), wordToBeStored)
 	}
 }
+
+func TestMUL(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// reg[1] = reg[2] * reg[3]
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-3))
+	r.reg[3] = 5
+	expected := uint64(ReinterpreteAsUnsigned(-15))
+
+	I := CreateMUL(1, 2, 3)
+	I.Execute(&mem, &r)
+
+	CheckReg(1, expected, &r, t)
+	CheckPc(4, &r, t)
+}
+
+func TestMULH(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// -1 * -1 = 1 -> upper 32 bits are all zero
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-1))
+	r.reg[3] = uint64(ReinterpreteAsUnsigned(-1))
+	CreateMULH(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0, &r, t)
+
+	// 0x80000000 * 0x80000000 = 0x40000000_00000000
+	r.reg[2] = 0x80000000
+	r.reg[3] = 0x80000000
+	CreateMULH(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0x40000000, &r, t)
+}
+
+func TestMULHSU(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// -1 * 0xffffffff (unsigned) = -0xffffffff = 0xffffffff_00000001
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-1))
+	r.reg[3] = 0xffffffff
+	CreateMULHSU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0xffffffff, &r, t)
+}
+
+func TestMULHU(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// 0xffffffff * 0xffffffff = 0xfffffffe_00000001
+	r.reg[2] = 0xffffffff
+	r.reg[3] = 0xffffffff
+	CreateMULHU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0xfffffffe, &r, t)
+}
+
+func TestDIV(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// rounds towards zero: -7 / 2 = -3
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-7))
+	r.reg[3] = 2
+	CreateDIV(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, uint64(ReinterpreteAsUnsigned(-3)), &r, t)
+
+	// division by zero -> all bits set
+	r.reg[3] = 0
+	CreateDIV(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0xffffffff, &r, t)
+
+	// overflow -> the dividend
+	r.reg[2] = 0x80000000
+	r.reg[3] = uint64(ReinterpreteAsUnsigned(-1))
+	CreateDIV(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0x80000000, &r, t)
+}
+
+func TestDIVU(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-7)) // 0xfffffff9
+	r.reg[3] = 2
+	CreateDIVU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0x7ffffffc, &r, t)
+
+	r.reg[3] = 0
+	CreateDIVU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0xffffffff, &r, t)
+}
+
+func TestREM(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	// the sign of the remainder equals the sign of the dividend: -7 % 2 = -1
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-7))
+	r.reg[3] = 2
+	CreateREM(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, uint64(ReinterpreteAsUnsigned(-1)), &r, t)
+
+	// division by zero -> the dividend
+	r.reg[3] = 0
+	CreateREM(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, uint64(ReinterpreteAsUnsigned(-7)), &r, t)
+
+	// overflow -> zero
+	r.reg[2] = 0x80000000
+	r.reg[3] = uint64(ReinterpreteAsUnsigned(-1))
+	CreateREM(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0, &r, t)
+}
+
+func TestREMU(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.reg[2] = uint64(ReinterpreteAsUnsigned(-7)) // 0xfffffff9
+	r.reg[3] = 2
+	CreateREMU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 1, &r, t)
+
+	r.reg[3] = 0
+	CreateREMU(1, 2, 3).Execute(&mem, &r)
+	CheckReg(1, 0xfffffff9, &r, t)
+}
+
+func TestRV64ALU(t *testing.T) {
+	r := NewRegisters(XLEN_64)
+	mem := NewMemory(0)
+
+	// the full 64 bits take part in the operations
+	r.reg[2] = 0x7fffffffffffffff
+	r.reg[3] = 1
+	CreateADD(1, 2, 3).Execute(&mem, r)
+	CheckReg(1, 0x8000000000000000, r, t)
+	CreateSLT(1, 1, 2).Execute(&mem, r)
+	CheckReg(1, 1, r, t)
+
+	// shifts use 6 bits of the shift amount
+	r.reg[3] = 40
+	CreateSLL(1, 3, 3).Execute(&mem, r)
+	CheckReg(1, 40<<40, r, t)
+	r.reg[2] = 0x8000000000000000
+	CreateSRAI(2, 1, 63).Execute(&mem, r)
+	CheckReg(1, 0xffffffffffffffff, r, t)
+
+	CreateMULHU(1, 2, 2).Execute(&mem, r)
+	CheckReg(1, 0x4000000000000000, r, t)
+
+	// addresses and immediates are sign extended to 64 bits
+	CreateLui(0x80000, 1).Execute(&mem, r)
+	CheckReg(1, 0xffffffff80000000, r, t)
+}
+
+func TestRV64WordOps(t *testing.T) {
+	r := NewRegisters(XLEN_64)
+	mem := NewMemory(0)
+
+	// the W instructions work on the low 32 bits and sign extend the result
+	r.reg[2] = 0x7fffffff
+	r.reg[3] = 0xffffffff00000001
+	CreateADDW(1, 2, 3).Execute(&mem, r)
+	CheckReg(1, 0xffffffff80000000, r, t)
+	CreateADDIW(1, 1, 0).Execute(&mem, r)
+	CheckReg(1, 0xffffffff80000000, r, t)
+	CreateSUBW(1, 2, 2).Execute(&mem, r)
+	CheckReg(1, 0, r, t)
+
+	// the shift amount is 5 bits
+	r.reg[3] = 33
+	CreateSLLW(1, 2, 3).Execute(&mem, r)
+	CheckReg(1, 0xfffffffffffffffe, r, t)
+	r.reg[2] = 0x0000000180000000
+	CreateSRAIW(2, 1, 4).Execute(&mem, r)
+	CheckReg(1, 0xfffffffff8000000, r, t)
+	CreateSRLIW(2, 1, 4).Execute(&mem, r)
+	CheckReg(1, 0x08000000, r, t)
+
+	r.reg[2] = 0x80000000
+	r.reg[3] = 0xffffffffffffffff
+	CreateDIVW(1, 2, 3).Execute(&mem, r)
+	CheckReg(1, 0xffffffff80000000, r, t)
+	CreateREMUW(1, 2, 0).Execute(&mem, r)
+	CheckReg(1, 0xffffffff80000000, r, t)
+	CreateMULW(1, 2, 2).Execute(&mem, r)
+	CheckReg(1, 0, r, t)
+	CheckPc(9*4, r, t)
+}
+
+func TestRV64LoadStore(t *testing.T) {
+	r := NewRegisters(XLEN_64)
+	mem := NewMemory(32)
+	r.reg[reg_a1] = 8
+	r.reg[reg_a2] = 0x8000000012345678
+
+	err := CreateSD(8, reg_a2, reg_a1).Execute(&mem, r)
+	if err != nil {
+		t.Fatalf("sd failed with error %v", err)
+	}
+	CheckMem(16, 0x12345678, &mem, t)
+	CheckMem(20, 0x80000000, &mem, t)
+
+	CreateLD(8, reg_a1, reg_a0).Execute(&mem, r)
+	CheckReg(reg_a0, 0x8000000012345678, r, t)
+	// lw sign extends, lwu zero extends
+	CreateLW(12, reg_a1, reg_a0).Execute(&mem, r)
+	CheckReg(reg_a0, 0xffffffff80000000, r, t)
+	CreateLWU(12, reg_a1, reg_a0).Execute(&mem, r)
+	CheckReg(reg_a0, 0x80000000, r, t)
+	CheckPc(16, r, t)
+}
+
+func TestRV64OnlyInstructions(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(32)
+
+	for _, instr := range []Instruction{
+		CreateLD(0, reg_zero, reg_a0),
+		CreateLWU(0, reg_zero, reg_a0),
+		CreateSD(0, reg_a0, reg_zero),
+		CreateADDIW(reg_zero, reg_a0, 1),
+		CreateADDW(reg_a0, reg_zero, reg_zero),
+		CreateSLLI(reg_zero, reg_a0, 32),
+	} {
+		err := instr.Execute(&mem, &r)
+		if err == nil {
+			t.Errorf("%s should fail on RV32", instr.String())
+		}
+	}
+}
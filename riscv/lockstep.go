@@ -0,0 +1,477 @@
+package riscv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// TraceEntry is a retired instruction of a golden trace. Only the register
+// writes and the stores are in the trace, the loads are not compared. Text
+// is the entry like it is in the trace file, for the divergence report.
+type TraceEntry struct {
+	Line   int
+	Text   string
+	Mode   uint64
+	Pc     uint64
+	Word   uint32
+	Writes []RegisterWrite
+	Stores []MemoryAccess
+}
+
+// TraceReader reads the instructions of a golden trace in order.
+type TraceReader interface {
+	// Next returns the next instruction, or io.EOF at the end of the trace.
+	Next() (TraceEntry, error)
+}
+
+// NewTraceReader returns the reader for the format of the trace, a spike
+// commit log (spike -l --log-commits) or the JSON lines of JSONString.
+func NewTraceReader(r io.Reader) TraceReader {
+	in := bufio.NewReader(r)
+	// the error is io.EOF for traces shorter than the peeked bytes
+	start, _ := in.Peek(512)
+	if strings.HasPrefix(strings.TrimSpace(string(start)), "{") {
+		return &jsonTraceReader{scanner: bufio.NewScanner(in)}
+	}
+	return &spikeTraceReader{scanner: bufio.NewScanner(in)}
+}
+
+// parseRegisterName parses the register names of the traces, x10, f10 and
+// c768_mstatus (c768 in the JSON traces).
+func parseRegisterName(name string) (RegisterKind, int, error) {
+	if name == "" {
+		return 0, 0, fmt.Errorf("empty register name")
+	}
+	kind := RegisterInt
+	count := 32
+	switch name[0] {
+	case 'x':
+	case 'f':
+		kind = RegisterFloat
+	case 'c':
+		kind = RegisterCSR
+		count = 4096
+		name, _, _ = strings.Cut(name, "_")
+	default:
+		return 0, 0, fmt.Errorf("unknown register %s", name)
+	}
+	index, err := strconv.Atoi(name[1:])
+	if err != nil || index < 0 || index >= count {
+		return 0, 0, fmt.Errorf("unknown register %s", name)
+	}
+	return kind, index, nil
+}
+
+func parseHex(s string) (uint64, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, fmt.Errorf("%s is not a hex value", s)
+	}
+	return strconv.ParseUint(s[2:], 16, 64)
+}
+
+// spikeTraceReader reads spike commit logs, the lines that are not commits
+// (like the disassembly of -l and the exceptions) are skipped.
+type spikeTraceReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *spikeTraceReader) Next() (TraceEntry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := r.scanner.Text()
+		fields := strings.Fields(text)
+		// core   0: 3 0x80000000 (0x06800513) x10 0x00000068
+		if len(fields) < 5 || fields[0] != "core" || len(fields[2]) != 1 || !strings.HasPrefix(fields[4], "(0x") {
+			continue
+		}
+		entry, err := parseSpikeCommit(fields[2:])
+		if err != nil {
+			return entry, fmt.Errorf("line %d of the trace: %w", r.line, err)
+		}
+		entry.Line = r.line
+		entry.Text = text
+		return entry, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return TraceEntry{}, err
+	}
+	return TraceEntry{}, io.EOF
+}
+
+// parseSpikeCommit parses the fields after the core number.
+func parseSpikeCommit(fields []string) (TraceEntry, error) {
+	var entry TraceEntry
+	var err error
+	entry.Mode, err = strconv.ParseUint(fields[0], 10, 2)
+	if err != nil {
+		return entry, fmt.Errorf("invalid privilege mode %s", fields[0])
+	}
+	entry.Pc, err = parseHex(fields[1])
+	if err != nil {
+		return entry, err
+	}
+	word, err := parseHex(strings.Trim(fields[2], "()"))
+	if err != nil {
+		return entry, err
+	}
+	entry.Word = uint32(word)
+
+	fields = fields[3:]
+	for len(fields) > 0 {
+		if fields[0] == "mem" {
+			if len(fields) < 2 {
+				return entry, fmt.Errorf("mem without address")
+			}
+			addr, err := parseHex(fields[1])
+			if err != nil {
+				return entry, err
+			}
+			// loads only have the address, stores the address and value
+			if len(fields) < 3 || !strings.HasPrefix(fields[2], "0x") {
+				fields = fields[2:]
+				continue
+			}
+			value, err := parseHex(fields[2])
+			if err != nil {
+				return entry, err
+			}
+			// the value has the digits of the store size
+			numBytes := uint32(len(fields[2])-2) / 2
+			entry.Stores = append(entry.Stores, MemoryAccess{Addr: addr, NumBytes: numBytes, Value: value, Write: true})
+			fields = fields[3:]
+			continue
+		}
+
+		if len(fields) < 2 {
+			return entry, fmt.Errorf("register %s without value", fields[0])
+		}
+		kind, index, err := parseRegisterName(fields[0])
+		if err != nil {
+			return entry, err
+		}
+		value, err := parseHex(fields[1])
+		if err != nil {
+			return entry, err
+		}
+		entry.Writes = append(entry.Writes, RegisterWrite{Kind: kind, Index: index, Value: value})
+		fields = fields[2:]
+	}
+	return entry, nil
+}
+
+// jsonCommit is a line of the JSON traces, the numbers are hex strings so
+// 64 bit values survive tools that parse numbers as doubles.
+type jsonCommit struct {
+	Mode   uint64      `json:"mode"`
+	Pc     string      `json:"pc"`
+	Insn   string      `json:"insn"`
+	Asm    string      `json:"asm,omitempty"`
+	Writes []jsonWrite `json:"writes,omitempty"`
+	Stores []jsonStore `json:"stores,omitempty"`
+}
+
+type jsonWrite struct {
+	Reg   string `json:"reg"`
+	Value string `json:"value"`
+}
+
+type jsonStore struct {
+	Addr  string `json:"addr"`
+	Size  uint32 `json:"size"`
+	Value string `json:"value"`
+}
+
+func registerName(w RegisterWrite) string {
+	switch w.Kind {
+	case RegisterFloat:
+		return fmt.Sprintf("f%d", w.Index)
+	case RegisterCSR:
+		return fmt.Sprintf("c%d", w.Index)
+	}
+	return fmt.Sprintf("x%d", w.Index)
+}
+
+// JSONString returns the instruction as a line of the JSON trace format,
+// like
+//
+//	{"mode":3,"pc":"0x80000000","insn":"0x6800513","asm":"li a0, 104","writes":[{"reg":"x10","value":"0x68"}]}
+func (c *CommitRecord) JSONString() string {
+	commit := jsonCommit{Mode: c.Mode, Pc: fmt.Sprintf("%#x", c.Pc), Insn: fmt.Sprintf("%#x", c.Word), Asm: c.Disassembly()}
+	for _, w := range c.Writes {
+		commit.Writes = append(commit.Writes, jsonWrite{Reg: registerName(w), Value: fmt.Sprintf("%#x", w.Value)})
+	}
+	for _, a := range c.Accesses {
+		if a.Write {
+			commit.Stores = append(commit.Stores, jsonStore{Addr: fmt.Sprintf("%#x", a.Addr), Size: a.NumBytes, Value: fmt.Sprintf("%#x", a.Value)})
+		}
+	}
+	// marshaling strings and numbers can't fail
+	line, _ := json.Marshal(commit)
+	return string(line)
+}
+
+type jsonTraceReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *jsonTraceReader) Next() (TraceEntry, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := strings.TrimSpace(r.scanner.Text())
+		if text == "" {
+			continue
+		}
+		entry, err := parseJSONCommit(text)
+		if err != nil {
+			return entry, fmt.Errorf("line %d of the trace: %w", r.line, err)
+		}
+		entry.Line = r.line
+		entry.Text = text
+		return entry, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return TraceEntry{}, err
+	}
+	return TraceEntry{}, io.EOF
+}
+
+func parseJSONCommit(text string) (TraceEntry, error) {
+	var commit jsonCommit
+	var entry TraceEntry
+	if err := json.Unmarshal([]byte(text), &commit); err != nil {
+		return entry, err
+	}
+	var err error
+	entry.Mode = commit.Mode
+	if entry.Pc, err = parseHex(commit.Pc); err != nil {
+		return entry, err
+	}
+	word, err := parseHex(commit.Insn)
+	if err != nil {
+		return entry, err
+	}
+	entry.Word = uint32(word)
+	for _, w := range commit.Writes {
+		kind, index, err := parseRegisterName(w.Reg)
+		if err != nil {
+			return entry, err
+		}
+		value, err := parseHex(w.Value)
+		if err != nil {
+			return entry, err
+		}
+		entry.Writes = append(entry.Writes, RegisterWrite{Kind: kind, Index: index, Value: value})
+	}
+	for _, s := range commit.Stores {
+		addr, err := parseHex(s.Addr)
+		if err != nil {
+			return entry, err
+		}
+		value, err := parseHex(s.Value)
+		if err != nil {
+			return entry, err
+		}
+		entry.Stores = append(entry.Stores, MemoryAccess{Addr: addr, NumBytes: s.Size, Value: value, Write: true})
+	}
+	return entry, nil
+}
+
+// ErrTraceEnded is returned by the commit handler of Lockstep when all the
+// instructions of the trace matched, there is nothing left to compare.
+var ErrTraceEnded = errors.New("end of the golden trace")
+
+// DivergenceError is the first instruction that doesn't match the trace.
+type DivergenceError struct {
+	// the number of instructions that matched
+	Matched uint64
+	// what is different, like "pc 0x80000004 != 0x80000008"
+	Differences []string
+	// the commit of the emulator, empty when the emulator halted before the
+	// end of the trace
+	Actual   string
+	Expected TraceEntry
+	// the last matching instructions and the trace entries after the
+	// expected one
+	Before []string
+	After  []string
+}
+
+func (e DivergenceError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "divergence from the trace after %d matching instructions at line %d of the trace: %s\n",
+		e.Matched, e.Expected.Line, strings.Join(e.Differences, ", "))
+	for _, line := range e.Before {
+		fmt.Fprintf(&b, "           %s\n", line)
+	}
+	fmt.Fprintf(&b, "emulator:  %s\n", e.Actual)
+	fmt.Fprintf(&b, "trace:     %s\n", e.Expected.Text)
+	for _, line := range e.After {
+		fmt.Fprintf(&b, "           %s\n", line)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Lockstep compares every retired instruction of the emulator with the next
+// instruction of a golden trace. The trace can start earlier than the
+// emulator (like with the boot rom of spike), its entries before the first
+// pc of the emulator are skipped.
+type Lockstep struct {
+	trace   TraceReader
+	context int
+	matched uint64
+	before  []string
+	started bool
+}
+
+// NewLockstep returns the checker, context is the number of instructions
+// shown before and after a divergence.
+func NewLockstep(trace TraceReader, context int) *Lockstep {
+	return &Lockstep{trace: trace, context: context}
+}
+
+// Matched returns the number of instructions that matched the trace.
+func (l *Lockstep) Matched() uint64 {
+	return l.matched
+}
+
+// Check is the commit handler of the emulator, it returns a DivergenceError
+// at the first difference and ErrTraceEnded when the trace is over.
+func (l *Lockstep) Check(c *CommitRecord) error {
+	expected, err := l.trace.Next()
+	for err == nil && !l.started && expected.Pc != c.Pc {
+		expected, err = l.trace.Next()
+	}
+	if err == io.EOF {
+		if !l.started {
+			return fmt.Errorf("the trace has no instruction at pc=%#x", c.Pc)
+		}
+		return ErrTraceEnded
+	}
+	if err != nil {
+		return err
+	}
+	l.started = true
+
+	actual := c.SpikeString()
+	if diffs := compareCommit(c, expected); len(diffs) > 0 {
+		return l.divergence(diffs, actual, expected)
+	}
+	l.matched++
+	if l.context > 0 {
+		if len(l.before) == l.context {
+			l.before = l.before[1:]
+		}
+		l.before = append(l.before, actual)
+	}
+	return nil
+}
+
+// Finish checks that the trace ended too, after the emulator halted.
+func (l *Lockstep) Finish() error {
+	expected, err := l.trace.Next()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return l.divergence([]string{"the emulator halted"}, "", expected)
+}
+
+func (l *Lockstep) divergence(diffs []string, actual string, expected TraceEntry) error {
+	after := []string{}
+	for i := 0; i < l.context; i++ {
+		entry, err := l.trace.Next()
+		if err != nil {
+			break
+		}
+		after = append(after, entry.Text)
+	}
+	return DivergenceError{
+		Matched:     l.matched,
+		Differences: diffs,
+		Actual:      actual,
+		Expected:    expected,
+		Before:      append([]string{}, l.before...),
+		After:       after,
+	}
+}
+
+// compareCommit returns the differences between the commit and the trace.
+// CSR writes are only compared when both wrote the CSR, spike also logs the
+// CSRs written implicitly (like mstatus by mret) and the emulator doesn't.
+func compareCommit(c *CommitRecord, expected TraceEntry) []string {
+	var diffs []string
+	if c.Pc != expected.Pc {
+		diffs = append(diffs, fmt.Sprintf("pc %#x != %#x", c.Pc, expected.Pc))
+	}
+	if c.Word != expected.Word {
+		diffs = append(diffs, fmt.Sprintf("instruction %#x != %#x", c.Word, expected.Word))
+	}
+	if c.Mode != expected.Mode {
+		diffs = append(diffs, fmt.Sprintf("mode %d != %d", c.Mode, expected.Mode))
+	}
+
+	actualWrites := map[int]RegisterWrite{}
+	expectedWrites := map[int]RegisterWrite{}
+	for _, w := range c.Writes {
+		actualWrites[w.spikeKey()] = w
+	}
+	for _, w := range expected.Writes {
+		expectedWrites[w.spikeKey()] = w
+	}
+	keys := []int{}
+	for key := range actualWrites {
+		keys = append(keys, key)
+	}
+	for key := range expectedWrites {
+		if _, ok := actualWrites[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		actual, hasActual := actualWrites[key]
+		wanted, hasExpected := expectedWrites[key]
+		switch {
+		case hasActual && hasExpected:
+			if actual.Value != wanted.Value {
+				diffs = append(diffs, fmt.Sprintf("%s %#x != %#x", registerName(actual), actual.Value, wanted.Value))
+			}
+		case actual.Kind == RegisterCSR || wanted.Kind == RegisterCSR:
+		case hasActual:
+			diffs = append(diffs, fmt.Sprintf("%s written but not in the trace", registerName(actual)))
+		default:
+			diffs = append(diffs, fmt.Sprintf("%s not written", registerName(wanted)))
+		}
+	}
+
+	var stores []MemoryAccess
+	for _, a := range c.Accesses {
+		if a.Write {
+			stores = append(stores, a)
+		}
+	}
+	for i := 0; i < len(stores) || i < len(expected.Stores); i++ {
+		switch {
+		case i >= len(stores):
+			diffs = append(diffs, fmt.Sprintf("missing store to %#x", expected.Stores[i].Addr))
+		case i >= len(expected.Stores):
+			diffs = append(diffs, fmt.Sprintf("store to %#x not in the trace", stores[i].Addr))
+		case stores[i].Addr != expected.Stores[i].Addr || stores[i].NumBytes != expected.Stores[i].NumBytes ||
+			stores[i].Value != expected.Stores[i].Value:
+			diffs = append(diffs, fmt.Sprintf("store of %d bytes %#x to %#x != %d bytes %#x to %#x",
+				stores[i].NumBytes, stores[i].Value, stores[i].Addr,
+				expected.Stores[i].NumBytes, expected.Stores[i].Value, expected.Stores[i].Addr))
+		}
+	}
+	return diffs
+}
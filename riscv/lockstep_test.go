@@ -0,0 +1,120 @@
+package riscv
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// runLockstep runs the newCommitTestEmulator program against the trace.
+func runLockstep(t *testing.T, trace string) (*Lockstep, error) {
+	e := newCommitTestEmulator(t)
+	l := NewLockstep(NewTraceReader(strings.NewReader(trace)), 2)
+	e.SetCommitHandler(l.Check)
+	_, err := e.Run(0)
+	if err != nil {
+		return l, err
+	}
+	return l, l.Finish()
+}
+
+func TestLockstepSpike(t *testing.T) {
+	// the boot rom of spike, the disassembly of -l and the exceptions are
+	// not compared
+	trace := []string{
+		"core   0: 3 0x00001000 (0x00000297) x5  0x00001000",
+		"core   0: 3 0x00001004 (0x0202a583) x11 0x00001020 mem 0x00001024",
+		"core   0: 0x0000000080000000 (0x06800513) li      a0, 104",
+	}
+	trace = append(trace, commitTestLog...)
+	trace = append(trace, "core   0: exception trap_illegal_instruction, epc 0x80000020")
+
+	l, err := runLockstep(t, strings.Join(trace, "\n"))
+	if err != nil {
+		t.Fatalf("lockstep failed with error %v", err)
+	}
+	Assert(t, l.Matched(), uint64(len(commitTestLog)))
+}
+
+func TestLockstepDivergence(t *testing.T) {
+	trace := append([]string{}, commitTestLog...)
+	trace[3] = "core   0: 3 0x8000000c (0x0205a603) x12 0x00000067 mem 0x80000024"
+	trace[4] = "core   0: 3 0x80000010 (0x340516f3) x13 0x00000000 c832_mscratch 0x00000067 c768_mstatus 0x00001800"
+
+	_, err := runLockstep(t, strings.Join(trace, "\n"))
+	var divergence DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("lockstep should fail with DivergenceError but got %v", err)
+	}
+	Assert(t, divergence.Matched, uint64(3))
+	Assert(t, divergence.Expected.Line, 4)
+	Assert(t, strings.Join(divergence.Differences, ", "), "x12 0x68 != 0x67")
+	Assert(t, strings.Join(divergence.Before, "\n"), strings.Join(commitTestLog[1:3], "\n"))
+	Assert(t, divergence.Actual, commitTestLog[3])
+	Assert(t, strings.Join(divergence.After, "\n"), strings.Join(trace[4:6], "\n"))
+
+	// only the CSRs written by both are compared
+	trace[3] = commitTestLog[3]
+	_, err = runLockstep(t, strings.Join(trace, "\n"))
+	if !errors.As(err, &divergence) {
+		t.Fatalf("lockstep should fail with DivergenceError but got %v", err)
+	}
+	Assert(t, strings.Join(divergence.Differences, ", "), "c832 0x68 != 0x67")
+
+	trace[4] = commitTestLog[4]
+	trace[7] = "core   0: 3 0x80000018 (0x00a5a72f) x14 0x00000597 mem 0x80000004"
+	_, err = runLockstep(t, strings.Join(trace, "\n"))
+	if !errors.As(err, &divergence) {
+		t.Fatalf("lockstep should fail with DivergenceError but got %v", err)
+	}
+	Assert(t, strings.Join(divergence.Differences, ", "), "store to 0x80000004 not in the trace")
+}
+
+func TestLockstepTraceLength(t *testing.T) {
+	// the emulator keeps running after the trace
+	l, err := runLockstep(t, strings.Join(commitTestLog[:4], "\n"))
+	if !errors.Is(err, ErrTraceEnded) {
+		t.Fatalf("lockstep should fail with ErrTraceEnded but got %v", err)
+	}
+	Assert(t, l.Matched(), uint64(4))
+
+	// the emulator halts before the end of the trace
+	trace := append(append([]string{}, commitTestLog...), "core   0: 3 0x8000001c (0x0000006f)")
+	_, err = runLockstep(t, strings.Join(trace, "\n"))
+	var divergence DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("lockstep should fail with DivergenceError but got %v", err)
+	}
+	Assert(t, strings.Join(divergence.Differences, ", "), "the emulator halted")
+
+	_, err = runLockstep(t, commitTestLog[4])
+	if err == nil || !strings.Contains(err.Error(), "no instruction at pc=0x80000000") {
+		t.Errorf("lockstep should fail when the trace doesn't have the first pc but got %v", err)
+	}
+}
+
+func TestLockstepJSON(t *testing.T) {
+	e := newCommitTestEmulator(t)
+	var lines []string
+	e.SetCommitHandler(func(c *CommitRecord) error {
+		lines = append(lines, c.JSONString())
+		return nil
+	})
+	e.Run(0)
+	Assert(t, lines[2], `{"mode":3,"pc":"0x80000008","insn":"0x2a58023","asm":"sb a0, 32(a1)","stores":[{"addr":"0x80000024","size":1,"value":"0x68"}]}`)
+	Assert(t, lines[4], `{"mode":3,"pc":"0x80000010","insn":"0x340516f3","asm":"csrrw a3, mscratch, a0","writes":[{"reg":"x13","value":"0x0"},{"reg":"c832","value":"0x68"}]}`)
+
+	l, err := runLockstep(t, strings.Join(lines, "\n"))
+	if err != nil {
+		t.Fatalf("lockstep failed with error %v", err)
+	}
+	Assert(t, l.Matched(), uint64(len(commitTestLog)))
+
+	lines[5] = strings.Replace(lines[5], "0x69", "0x6a", 1)
+	_, err = runLockstep(t, strings.Join(lines, "\n"))
+	var divergence DivergenceError
+	if !errors.As(err, &divergence) {
+		t.Fatalf("lockstep should fail with DivergenceError but got %v", err)
+	}
+	Assert(t, strings.Join(divergence.Differences, ", "), "x10 0x69 != 0x6a")
+}
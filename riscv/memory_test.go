@@ -5,13 +5,13 @@ import (
 )
 
 func TestStoreLoadByte(t *testing.T) {
-	dataToStore := uint32(256 + 1) // 100000001
+	dataToStore := uint64(256 + 1) // 100000001
 	// the bit of 256 should not be stored as we will
 	// store just one byte
-	expectedLoadValue := uint32(1)
+	expectedLoadValue := uint64(1)
 	mem := NewMemory(10)
 
-	for addr := uint32(0); addr < 4; addr++ {
+	for addr := uint64(0); addr < 4; addr++ {
 
 		mem.StoreByte(addr, dataToStore)
 		loadRes, err := mem.LoadByte(addr)
@@ -26,7 +26,7 @@ func TestStoreLoadByte(t *testing.T) {
 			t.Fail()
 		}
 
-		if expectedLoadValue != uint32(mem.data[addr]) {
+		if expectedLoadValue != uint64(mem.data[addr]) {
 			t.Logf("Invalid value in memory expected=%d but value=%d", expectedLoadValue, mem.data[addr])
 			t.Fail()
 		}
@@ -34,11 +34,11 @@ func TestStoreLoadByte(t *testing.T) {
 }
 
 func TestStoreLoad(t *testing.T) {
-	dataToStore := uint32(256 + 1) // 100000001
+	dataToStore := uint64(256 + 1) // 100000001
 	expectedLoadValue := dataToStore
 	mem := NewMemory(10)
 
-	for addr := uint32(0); addr < 4; addr++ {
+	for addr := uint64(0); addr < 4; addr++ {
 		errStore := mem.Store(addr, dataToStore, 2)
 		if errStore != nil {
 			t.Logf("Failed to store at addr=%d with error %v", addr, errStore)
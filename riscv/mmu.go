@@ -0,0 +1,306 @@
+package riscv
+
+// AccessType tells the address translation what the address is used for,
+// it selects the permission that is checked and the page fault raised.
+type AccessType int
+
+const (
+	AccessLoad AccessType = iota
+	// AMOs and SC.W are stores, even though AMOs also load
+	AccessStore
+	AccessFetch
+)
+
+const (
+	PAGE_SHIFT        = 12
+	PAGE_SIZE  uint64 = 1 << PAGE_SHIFT
+	// the virtual page number of Sv32 is split in two 10 bit parts, each one
+	// indexes a level of the page table
+	VPN_BITS = 10
+	PTE_SIZE = 4
+	// Sv39 has three levels with 9 bit parts and 8 byte ptes
+	SV39_VPN_BITS = 9
+	SV39_PTE_SIZE = 8
+)
+
+// Page table entry bits, the physical page number starts at bit 10. Sv39
+// ptes are 64 bit, the bits above the physical page number are reserved.
+const (
+	PTE_V uint64 = 1 << 0
+	PTE_R uint64 = 1 << 1
+	PTE_W uint64 = 1 << 2
+	PTE_X uint64 = 1 << 3
+	PTE_U uint64 = 1 << 4
+	PTE_G uint64 = 1 << 5
+	PTE_A uint64 = 1 << 6
+	PTE_D uint64 = 1 << 7
+
+	PTE_PPN_SHIFT = 10
+)
+
+// pagingScheme is the page table format of a satp mode.
+type pagingScheme struct {
+	levels  int
+	vpnBits uint64
+	pteSize uint32
+	// the width of the physical page number in a pte
+	ppnBits uint64
+	// the width of the virtual addresses, the bits above have to be
+	// copies of the highest bit
+	vaBits uint64
+}
+
+var (
+	sv32 = pagingScheme{levels: 2, vpnBits: VPN_BITS, pteSize: PTE_SIZE, ppnBits: 22, vaBits: 32}
+	sv39 = pagingScheme{levels: 3, vpnBits: SV39_VPN_BITS, pteSize: SV39_PTE_SIZE, ppnBits: 44, vaBits: 39}
+)
+
+// paging returns the paging scheme enabled in satp with the page number of
+// the root page table and the address space, the scheme is nil when the
+// addresses are not translated.
+func (c *CSRFile) paging() (*pagingScheme, uint64, uint64) {
+	if c.rv64 {
+		if c.satp>>SATP64_MODE_SHIFT != SATP_MODE_SV39 {
+			return nil, 0, 0
+		}
+		return &sv39, c.satp & SATP64_PPN, (c.satp & SATP64_ASID) >> SATP64_ASID_SHIFT
+	}
+	if c.satp&SATP_MODE == 0 {
+		return nil, 0, 0
+	}
+	return &sv32, c.satp & SATP_PPN, (c.satp & SATP_ASID) >> SATP_ASID_SHIFT
+}
+
+// The number of translations the TLB holds, it is direct mapped by the
+// virtual page number.
+const TLB_SIZE = 64
+
+type tlbEntry struct {
+	valid bool
+	asid  uint64
+	// The page numbers of the 4KiB page, superpages are cached per 4KiB page
+	// they are accessed in.
+	vpn uint64
+	ppn uint64
+	// the number of low vpn bits that are the offset in the superpage, zero
+	// for 4KiB pages
+	superpageBits uint64
+	// the flags of the leaf pte
+	pte uint64
+}
+
+// TLB caches the translations of the page table walks, like on hardware it
+// is only flushed by SFENCE.VMA. The zero value is an empty TLB.
+type TLB struct {
+	entries [TLB_SIZE]tlbEntry
+}
+
+func (t *TLB) lookup(vpn uint64, asid uint64) *tlbEntry {
+	e := &t.entries[vpn%TLB_SIZE]
+	if e.valid && e.vpn == vpn && (e.asid == asid || e.pte&PTE_G != 0) {
+		return e
+	}
+	return nil
+}
+
+func (t *TLB) insert(entry tlbEntry) {
+	entry.valid = true
+	t.entries[entry.vpn%TLB_SIZE] = entry
+}
+
+// Flush drops the cached translations like SFENCE.VMA. Only the translations
+// of the page of vaddr are dropped unless allAddrs is set, and only the ones
+// of the address space asid unless allASIDs is set. Global mappings are only
+// dropped for all address spaces.
+func (t *TLB) Flush(vaddr uint64, asid uint64, allAddrs bool, allASIDs bool) {
+	vpn := vaddr >> PAGE_SHIFT
+	for i := range t.entries {
+		e := &t.entries[i]
+		addrMatches := allAddrs || e.vpn>>e.superpageBits == vpn>>e.superpageBits
+		asidMatches := allASIDs || (e.asid == asid && e.pte&PTE_G == 0)
+		if addrMatches && asidMatches {
+			e.valid = false
+		}
+	}
+}
+
+func pageFault(access AccessType, vaddr uint64) Exception {
+	cause := EXC_LOAD_PAGE_FAULT
+	switch access {
+	case AccessStore:
+		cause = EXC_STORE_PAGE_FAULT
+	case AccessFetch:
+		cause = EXC_INSTRUCTION_PAGE_FAULT
+	}
+	return Exception{Cause: cause, Tval: vaddr}
+}
+
+func accessFault(access AccessType, vaddr uint64, err error) Exception {
+	cause := EXC_LOAD_ACCESS_FAULT
+	switch access {
+	case AccessStore:
+		cause = EXC_STORE_ACCESS_FAULT
+	case AccessFetch:
+		cause = EXC_INSTRUCTION_ACCESS_FAULT
+	}
+	return Exception{Cause: cause, Tval: vaddr, Err: err}
+}
+
+// translationMode returns the privilege mode the access is checked against,
+// with MPRV loads and stores in machine mode use the mode in MPP.
+func translationMode(c *CSRFile, access AccessType) uint64 {
+	mode := c.Mode()
+	if access != AccessFetch && mode == MODE_M && c.mstatus&MSTATUS_MPRV != 0 {
+		mode = (c.mstatus & MSTATUS_MPP) >> MSTATUS_MPP_SHIFT
+	}
+	return mode
+}
+
+// permitted returns if the leaf pte allows the access from the mode.
+func permitted(pte uint64, mode uint64, mstatus uint64, access AccessType) bool {
+	if pte&PTE_U != 0 {
+		// supervisor mode can never execute user pages and can only load
+		// and store to them with SUM
+		if mode == MODE_S && (access == AccessFetch || mstatus&MSTATUS_SUM == 0) {
+			return false
+		}
+	} else if mode == MODE_U {
+		return false
+	}
+
+	switch access {
+	case AccessLoad:
+		// with MXR executable pages are readable too
+		return pte&PTE_R != 0 || (mstatus&MSTATUS_MXR != 0 && pte&PTE_X != 0)
+	case AccessStore:
+		return pte&PTE_W != 0
+	default:
+		return pte&PTE_X != 0
+	}
+}
+
+// canonical returns if the bits of the virtual address above the ones
+// that are translated are copies of the highest translated bit.
+func (p *pagingScheme) canonical(vaddr uint64) bool {
+	if p.vaBits == 32 {
+		return true
+	}
+	upper := int64(vaddr) >> (p.vaBits - 1)
+	return upper == 0 || upper == -1
+}
+
+// walk finds the leaf pte of the virtual address in the page table, see
+// section 4.3.2 of the privileged spec. The accessed and dirty bits of the
+// pte are set in memory when the access is permitted.
+func walk(mem Memory, c *CSRFile, vaddr uint64, mode uint64, access AccessType) (tlbEntry, error) {
+	scheme, ppn, asid := c.paging()
+	if !scheme.canonical(vaddr) {
+		return tlbEntry{}, pageFault(access, vaddr)
+	}
+	vpnMask := uint64(1)<<scheme.vpnBits - 1
+	for level := scheme.levels - 1; level >= 0; level-- {
+		superpageBits := scheme.vpnBits * uint64(level)
+		vpn := (vaddr >> (PAGE_SHIFT + superpageBits)) & vpnMask
+		pteAddr := ppn<<PAGE_SHIFT + vpn*uint64(scheme.pteSize)
+		pte, err := mem.Load(pteAddr, scheme.pteSize)
+		if err != nil {
+			return tlbEntry{}, accessFault(access, vaddr, err)
+		}
+
+		// writable pages have to be readable, and the reserved bits above
+		// the physical page number have to be zero
+		if pte&PTE_V == 0 || (pte&PTE_R == 0 && pte&PTE_W != 0) ||
+			pte>>(PTE_PPN_SHIFT+scheme.ppnBits) != 0 {
+			return tlbEntry{}, pageFault(access, vaddr)
+		}
+		ppn = pte >> PTE_PPN_SHIFT
+		if pte&(PTE_R|PTE_X) == 0 {
+			// a pointer to the next level
+			continue
+		}
+
+		if !permitted(pte, mode, c.mstatus, access) {
+			return tlbEntry{}, pageFault(access, vaddr)
+		}
+		// superpages have to be aligned to their size, the low part of
+		// the page number comes from the virtual address
+		superpageMask := uint64(1)<<superpageBits - 1
+		if ppn&superpageMask != 0 {
+			return tlbEntry{}, pageFault(access, vaddr)
+		}
+		ppn |= (vaddr >> PAGE_SHIFT) & superpageMask
+
+		updated := pte | PTE_A
+		if access == AccessStore {
+			updated |= PTE_D
+		}
+		if updated != pte {
+			err = mem.Store(pteAddr, updated, scheme.pteSize)
+			if err != nil {
+				return tlbEntry{}, accessFault(access, vaddr, err)
+			}
+		}
+
+		return tlbEntry{
+			asid:          asid,
+			vpn:           vaddr >> PAGE_SHIFT,
+			ppn:           ppn,
+			superpageBits: superpageBits,
+			pte:           updated & (1<<PTE_PPN_SHIFT - 1),
+		}, nil
+	}
+
+	// the last level has to be a leaf
+	return tlbEntry{}, pageFault(access, vaddr)
+}
+
+// translationObserver is implemented by the memory the emulator passes to
+// the instructions while it records their accesses, to learn the virtual
+// addresses of the accesses.
+type translationObserver interface {
+	// physical returns the memory without the recording, for the page
+	// table walk.
+	physical() Memory
+	translated(vaddr uint64, paddr uint64)
+}
+
+// Translate returns the physical address of the virtual address. With Sv32
+// (or Sv39 on RV64) enabled in satp, and outside of machine mode, the page
+// table is walked unless the TLB has the translation. The error is a page
+// fault or access fault exception for the access.
+func Translate(mem Memory, regs Registers, vaddr uint64, access AccessType) (uint64, error) {
+	if o, ok := mem.(translationObserver); ok {
+		paddr, err := Translate(o.physical(), regs, vaddr, access)
+		if err == nil {
+			o.translated(vaddr, paddr)
+		}
+		return paddr, err
+	}
+
+	c := regs.Csrs()
+	mode := translationMode(c, access)
+	scheme, _, asid := c.paging()
+	if mode == MODE_M || scheme == nil {
+		return vaddr, nil
+	}
+
+	tlb := regs.TLB()
+	offset := vaddr & (PAGE_SIZE - 1)
+	entry := tlb.lookup(vaddr>>PAGE_SHIFT, asid)
+	if entry != nil {
+		if !permitted(entry.pte, mode, c.mstatus, access) {
+			return 0, pageFault(access, vaddr)
+		}
+		// the first store to a page has to set the dirty bit in memory
+		if access != AccessStore || entry.pte&PTE_D != 0 {
+			return entry.ppn<<PAGE_SHIFT | offset, nil
+		}
+	}
+
+	walked, err := walk(mem, c, vaddr, mode, access)
+	if err != nil {
+		return 0, err
+	}
+	tlb.insert(walked)
+	return walked.ppn<<PAGE_SHIFT | offset, nil
+}
@@ -0,0 +1,234 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+// The page table of the tests, the root table is at 0x1000 and the second
+// level table of the 4MiB at 0x00400000 at 0x2000.
+//
+//	0x00400000 -> 0x3000 4KiB page, RWX
+//	0x00401000 -> 0x4000 4KiB user page, RW
+//	0x00402000 -> 0x5000 4KiB page, execute only
+//	0x00800000 -> 0x0    4MiB megapage, RX
+func newPagedMemory(t *testing.T) MemoryImpl {
+	mem := NewMemory(0x10000)
+	storePTEs(t, &mem, 0x1000+1*PTE_SIZE, PTE_SIZE, []uint64{
+		2<<PTE_PPN_SHIFT | PTE_V,
+		0<<PTE_PPN_SHIFT | PTE_R | PTE_X | PTE_V,
+	})
+	storePTEs(t, &mem, 0x2000, PTE_SIZE, []uint64{
+		3<<PTE_PPN_SHIFT | PTE_R | PTE_W | PTE_X | PTE_V,
+		4<<PTE_PPN_SHIFT | PTE_R | PTE_W | PTE_U | PTE_A | PTE_D | PTE_V,
+		5<<PTE_PPN_SHIFT | PTE_X | PTE_V,
+	})
+	return mem
+}
+
+func storePTEs(t *testing.T, mem Memory, addr uint64, pteSize uint32, ptes []uint64) {
+	for i, pte := range ptes {
+		err := mem.Store(addr+uint64(i)*uint64(pteSize), pte, pteSize)
+		if err != nil {
+			t.Fatalf("failed to store pte %d with error %v", i, err)
+		}
+	}
+}
+
+func newPagedRegisters(mode uint64) *RegistersImpl {
+	r := &RegistersImpl{}
+	r.csr.satp = SATP_MODE | 1
+	r.csr.SetMode(mode)
+	return r
+}
+
+func checkTranslate(t *testing.T, mem Memory, r Registers, vaddr uint64, access AccessType, expected uint64) {
+	paddr, err := Translate(mem, r, vaddr, access)
+	if err != nil {
+		t.Errorf("translating %#x failed with error %v", vaddr, err)
+	} else if paddr != expected {
+		t.Errorf("%#x translated to %#x but should be %#x", vaddr, paddr, expected)
+	}
+}
+
+func checkPageFault(t *testing.T, mem Memory, r Registers, vaddr uint64, access AccessType, cause uint64) {
+	_, err := Translate(mem, r, vaddr, access)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != cause || exc.Tval != vaddr {
+		t.Errorf("translating %#x should raise %s but got %v", vaddr, CauseString(cause), err)
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	mem := newPagedMemory(t)
+	r := newPagedRegisters(MODE_S)
+
+	checkTranslate(t, &mem, r, 0x00400010, AccessLoad, 0x3010)
+	// the first access sets the accessed bit, the first store the dirty bit
+	CheckMem(0x2000, 3<<PTE_PPN_SHIFT|PTE_R|PTE_W|PTE_X|PTE_A|PTE_V, &mem, t)
+	checkTranslate(t, &mem, r, 0x00400ffc, AccessStore, 0x3ffc)
+	CheckMem(0x2000, 3<<PTE_PPN_SHIFT|PTE_R|PTE_W|PTE_X|PTE_A|PTE_D|PTE_V, &mem, t)
+
+	// the page offset inside the megapage comes from the virtual address
+	checkTranslate(t, &mem, r, 0x00800123, AccessFetch, 0x123)
+	checkTranslate(t, &mem, r, 0x00801123, AccessLoad, 0x1123)
+
+	// machine mode is never translated
+	r.csr.SetMode(MODE_M)
+	checkTranslate(t, &mem, r, 0x00400010, AccessLoad, 0x00400010)
+	// unless MPRV translates loads and stores like in MPP
+	r.csr.mstatus = MSTATUS_MPRV | MODE_S<<MSTATUS_MPP_SHIFT
+	checkTranslate(t, &mem, r, 0x00400010, AccessLoad, 0x3010)
+	checkTranslate(t, &mem, r, 0x00400010, AccessFetch, 0x00400010)
+}
+
+func TestTranslatePageFaults(t *testing.T) {
+	mem := newPagedMemory(t)
+	r := newPagedRegisters(MODE_S)
+
+	checkPageFault(t, &mem, r, 0x00c00000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+	checkPageFault(t, &mem, r, 0x00403000, AccessFetch, EXC_INSTRUCTION_PAGE_FAULT)
+	checkPageFault(t, &mem, r, 0x00800000, AccessStore, EXC_STORE_PAGE_FAULT)
+
+	// supervisor mode can only load and store to user pages with SUM
+	checkPageFault(t, &mem, r, 0x00401000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+	r.csr.mstatus = MSTATUS_SUM
+	checkTranslate(t, &mem, r, 0x00401000, AccessLoad, 0x4000)
+	checkPageFault(t, &mem, r, 0x00401000, AccessFetch, EXC_INSTRUCTION_PAGE_FAULT)
+
+	// execute only pages are only readable with MXR
+	checkPageFault(t, &mem, r, 0x00402000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+	r.csr.mstatus = MSTATUS_MXR
+	checkTranslate(t, &mem, r, 0x00402000, AccessLoad, 0x5000)
+
+	// user mode can't access supervisor pages
+	r.csr.SetMode(MODE_U)
+	checkTranslate(t, &mem, r, 0x00401004, AccessStore, 0x4004)
+	checkPageFault(t, &mem, r, 0x00400000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+
+	// a page fault does not set the accessed bit
+	CheckMem(0x1000+2*PTE_SIZE, PTE_R|PTE_X|PTE_V, &mem, t)
+}
+
+func TestTranslateSv39(t *testing.T) {
+	mem := NewMemory(0x10000)
+	// the root table at 0x1000 maps a tree of tables for 0x0 and gigapages
+	// at 0x40000000 and the misaligned 0x80000000
+	storePTEs(t, &mem, 0x1000, SV39_PTE_SIZE, []uint64{
+		2<<PTE_PPN_SHIFT | PTE_V,
+		0<<PTE_PPN_SHIFT | PTE_R | PTE_X | PTE_A | PTE_V,
+		1<<PTE_PPN_SHIFT | PTE_R | PTE_A | PTE_V,
+	})
+	storePTEs(t, &mem, 0x2000+2*SV39_PTE_SIZE, SV39_PTE_SIZE, []uint64{3<<PTE_PPN_SHIFT | PTE_V})
+	storePTEs(t, &mem, 0x3000+1*SV39_PTE_SIZE, SV39_PTE_SIZE, []uint64{
+		4<<PTE_PPN_SHIFT | PTE_R | PTE_W | PTE_A | PTE_D | PTE_V,
+	})
+	r := NewRegisters(XLEN_64)
+	r.csr.satp = SATP_MODE_SV39<<SATP64_MODE_SHIFT | 1
+	r.csr.SetMode(MODE_S)
+
+	checkTranslate(t, &mem, r, 0x00401010, AccessStore, 0x4010)
+	checkTranslate(t, &mem, r, 0x40000123, AccessFetch, 0x123)
+	checkTranslate(t, &mem, r, 0x40001123, AccessLoad, 0x1123)
+
+	checkPageFault(t, &mem, r, 0x80000000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+	checkPageFault(t, &mem, r, 0xffffffffc0000000, AccessLoad, EXC_LOAD_PAGE_FAULT)
+	// the bits above the 39 bit virtual address must be equal to bit 38
+	checkPageFault(t, &mem, r, 0x0000008000401010, AccessStore, EXC_STORE_PAGE_FAULT)
+}
+
+func TestTLBSfenceVma(t *testing.T) {
+	mem := newPagedMemory(t)
+	r := newPagedRegisters(MODE_S)
+	r.reg[reg_a0] = 0x00400000
+
+	checkTranslate(t, &mem, r, 0x00400000, AccessLoad, 0x3000)
+	// the translation is cached, so changing the page table has no effect
+	mem.Store(0x2000, 6<<PTE_PPN_SHIFT|PTE_R|PTE_V, 4)
+	checkTranslate(t, &mem, r, 0x00400000, AccessLoad, 0x3000)
+
+	// user mode can't flush the TLB
+	r.csr.SetMode(MODE_U)
+	err := CreateSFENCEVMA(reg_a0, reg_zero).Execute(&mem, r)
+	if err == nil {
+		t.Fatalf("sfence.vma in user mode should fail")
+	}
+
+	r.csr.SetMode(MODE_S)
+	err = CreateSFENCEVMA(reg_a0, reg_zero).Execute(&mem, r)
+	if err != nil {
+		t.Fatalf("sfence.vma failed with error %v", err)
+	}
+	CheckPc(4, r, t)
+	checkTranslate(t, &mem, r, 0x00400000, AccessLoad, 0x6000)
+
+	// flushing another address space keeps the translation
+	mem.Store(0x2000, 7<<PTE_PPN_SHIFT|PTE_R|PTE_V, 4)
+	r.reg[reg_a1] = 1
+	CreateSFENCEVMA(reg_zero, reg_a1).Execute(&mem, r)
+	checkTranslate(t, &mem, r, 0x00400000, AccessLoad, 0x6000)
+	CreateSFENCEVMA(reg_zero, reg_zero).Execute(&mem, r)
+	checkTranslate(t, &mem, r, 0x00400000, AccessLoad, 0x7000)
+}
+
+func TestPagedLoadStore(t *testing.T) {
+	mem := newPagedMemory(t)
+	r := newPagedRegisters(MODE_S)
+	r.reg[reg_a1] = 0x00400000
+	r.reg[reg_a2] = 42
+
+	err := CreateSW(8, reg_a2, reg_a1).Execute(&mem, r)
+	if err != nil {
+		t.Fatalf("sw failed with error %v", err)
+	}
+	CheckMem(0x3008, 42, &mem, t)
+
+	CreateLW(8, reg_a1, reg_a0).Execute(&mem, r)
+	CheckReg(reg_a0, 42, r, t)
+
+	// amos are stores, even on a page that is only readable
+	r.reg[reg_a1] = 0x00800000
+	err = CreateAMOADDW(reg_a0, reg_a1, reg_a2).Execute(&mem, r)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != EXC_STORE_PAGE_FAULT {
+		t.Fatalf("amoadd.w on a read only page should raise a store page fault but got %v", err)
+	}
+}
+
+func TestRunPaged(t *testing.T) {
+	mem := newPagedMemory(t)
+	storeProgram(t, &mem, 0, []uint32{
+		0x800002b7, // lui t0, 0x80000
+		0x00128293, // addi t0, t0, 1
+		0x18029073, // csrw satp, t0
+		0x40000293, // addi t0, zero, 0x400
+		0x00129293, // slli t0, t0, 1
+		0x30029073, // csrw mstatus, t0, MPP=S
+		0x004002b7, // lui t0, 0x400
+		0x34129073, // csrw mepc, t0
+		0x10000293, // addi t0, zero, 0x100
+		0x30529073, // csrw mtvec, t0
+		0x30200073, // mret
+	})
+	storeProgram(t, &mem, 0x100, []uint32{
+		0x0000006f, // j .
+	})
+	// runs in supervisor mode at 0x00400000
+	storeProgram(t, &mem, 0x3000, []uint32{
+		0x004005b7, // lui a1, 0x400
+		0x1005a503, // lw a0, 0x100(a1)
+		0x00000073, // ecall
+	})
+	mem.Store(0x3100, 17, 4)
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltSelfLoop)
+	CheckReg(reg_a0, 17, r, t)
+	CheckCsr(CSR_MCAUSE, EXC_ECALL_S, r, t)
+	CheckCsr(CSR_MEPC, 0x00400008, r, t)
+}
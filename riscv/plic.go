@@ -0,0 +1,273 @@
+package riscv
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Register offsets of the PLIC (platform-level interrupt controller), the
+// layout of the SiFive PLIC used by the qemu `virt` machine. All registers
+// are 32 bits wide.
+const (
+	PLIC_PRIORITY  uint64 = 0x0      // + 4*source
+	PLIC_PENDING   uint64 = 0x1000   // + 4*(source/32)
+	PLIC_ENABLE    uint64 = 0x2000   // + 0x80*context + 4*(source/32)
+	PLIC_THRESHOLD uint64 = 0x200000 // + 0x1000*context
+	PLIC_CLAIM     uint64 = 0x200004 // + 0x1000*context, also the complete register
+
+	PLIC_ENABLE_STRIDE  uint64 = 0x80
+	PLIC_CONTEXT_STRIDE uint64 = 0x1000
+
+	// Number of bytes the PLIC occupies on the bus.
+	PLIC_SIZE uint64 = 0x4000000
+)
+
+const (
+	// Source 0 does not exist, it's what a claim returns when there is no
+	// interrupt.
+	PLIC_MAX_SOURCES = 1024
+	// Priorities are 0 (never interrupts) to 7.
+	PLIC_MAX_PRIORITY uint32 = 7
+)
+
+// The contexts of the only hart, they raise the machine and supervisor
+// external interrupts.
+const (
+	PLIC_CONTEXT_M = 0
+	PLIC_CONTEXT_S = 1
+
+	plicContexts = 2
+)
+
+type plicContext struct {
+	enabled   []uint32
+	threshold uint32
+	irqLevel  bool
+	irq       func(level bool)
+}
+
+// PLIC routes the level triggered interrupt lines of the devices to the
+// external interrupts of the hart. A context is interrupted while a source
+// it enables is pending with a priority above its threshold. Claiming the
+// interrupt clears the pending bit, the source can only become pending
+// again after the claim is completed.
+type PLIC struct {
+	lock       sync.Mutex
+	numSources int
+	priority   []uint32
+	level      []bool
+	pending    []uint32
+	claimed    []uint32
+	contexts   [plicContexts]plicContext
+}
+
+// NewPLIC creates a PLIC with sources 1 to numSources-1.
+func NewPLIC(numSources int) *PLIC {
+	if numSources < 1 || numSources > PLIC_MAX_SOURCES {
+		panic(fmt.Sprintf("the number of plic sources must be 1 to %d, not %d", PLIC_MAX_SOURCES, numSources))
+	}
+	words := (numSources + 31) / 32
+	p := &PLIC{
+		numSources: numSources,
+		priority:   make([]uint32, numSources),
+		level:      make([]bool, numSources),
+		pending:    make([]uint32, words),
+		claimed:    make([]uint32, words),
+	}
+	for i := range p.contexts {
+		p.contexts[i].enabled = make([]uint32, words)
+	}
+	return p
+}
+
+// SetInterruptHandlers registers the functions called every time the level
+// of the interrupt output of the machine and supervisor context changes,
+// they are usually connected to the MEIP and SEIP interrupt lines of the
+// hart.
+func (p *PLIC) SetInterruptHandlers(machine func(level bool), supervisor func(level bool)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.contexts[PLIC_CONTEXT_M].irq = machine
+	p.contexts[PLIC_CONTEXT_S].irq = supervisor
+	for i := range p.contexts {
+		if p.contexts[i].irq != nil {
+			p.contexts[i].irq(p.contexts[i].irqLevel)
+		}
+	}
+	p.updateInterrupts()
+}
+
+// SetLevel sets the level of the interrupt line of the source, it's safe to
+// call from another goroutine.
+func (p *PLIC) SetLevel(source int, level bool) {
+	if source <= 0 || source >= p.numSources {
+		panic(fmt.Sprintf("invalid plic source %d", source))
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.level[source] = level
+	p.updatePending(source)
+	p.updateInterrupts()
+}
+
+// InterruptLine returns the function that sets the level of the source, it
+// can be passed as the interrupt handler of a device, e.g.
+// uart.SetInterruptHandler(plic.InterruptLine(10)).
+func (p *PLIC) InterruptLine(source int) func(level bool) {
+	if source <= 0 || source >= p.numSources {
+		panic(fmt.Sprintf("invalid plic source %d", source))
+	}
+	return func(level bool) {
+		p.SetLevel(source, level)
+	}
+}
+
+func testBit(bits []uint32, i int) bool {
+	return bits[i/32]&(1<<(i%32)) != 0
+}
+
+func setBit(bits []uint32, i int, value bool) {
+	if value {
+		bits[i/32] |= 1 << (i % 32)
+	} else {
+		bits[i/32] &^= 1 << (i % 32)
+	}
+}
+
+// updatePending must be called with the lock taken. A source with a high
+// line is pending unless its last interrupt is still claimed.
+func (p *PLIC) updatePending(source int) {
+	if p.level[source] && !testBit(p.claimed, source) {
+		setBit(p.pending, source, true)
+	}
+}
+
+// best returns the pending source with the highest priority that the
+// context can claim, or zero. Ties go to the lowest source id. Must be
+// called with the lock taken.
+func (p *PLIC) best(ctx *plicContext) int {
+	best := 0
+	priority := ctx.threshold
+	for source := 1; source < p.numSources; source++ {
+		if testBit(p.pending, source) && testBit(ctx.enabled, source) && p.priority[source] > priority {
+			best = source
+			priority = p.priority[source]
+		}
+	}
+	return best
+}
+
+// updateInterrupts must be called with the lock taken.
+func (p *PLIC) updateInterrupts() {
+	for i := range p.contexts {
+		ctx := &p.contexts[i]
+		level := p.best(ctx) != 0
+		if level != ctx.irqLevel {
+			ctx.irqLevel = level
+			if ctx.irq != nil {
+				ctx.irq(level)
+			}
+		}
+	}
+}
+
+// register decodes the address, it returns the context for the context
+// registers and the index of the source or source word.
+func (p *PLIC) register(addr uint64, numBytes uint32) (reg uint64, ctx int, index int, err error) {
+	if numBytes != 4 || addr%4 != 0 {
+		return 0, 0, 0, fmt.Errorf("plic registers can only be accessed with aligned 4 bytes (offset=%#x, numBytes=%d)", addr, numBytes)
+	}
+	words := uint64(len(p.pending))
+	switch {
+	case addr < PLIC_PENDING:
+		if addr/4 < uint64(p.numSources) {
+			return PLIC_PRIORITY, 0, int(addr / 4), nil
+		}
+	case addr < PLIC_ENABLE:
+		if (addr-PLIC_PENDING)/4 < words {
+			return PLIC_PENDING, 0, int((addr - PLIC_PENDING) / 4), nil
+		}
+	case addr < PLIC_THRESHOLD:
+		offset := addr - PLIC_ENABLE
+		ctx := offset / PLIC_ENABLE_STRIDE
+		if ctx < plicContexts && offset%PLIC_ENABLE_STRIDE/4 < words {
+			return PLIC_ENABLE, int(ctx), int(offset % PLIC_ENABLE_STRIDE / 4), nil
+		}
+	default:
+		offset := addr - PLIC_THRESHOLD
+		ctx := offset / PLIC_CONTEXT_STRIDE
+		if ctx < plicContexts && offset%PLIC_CONTEXT_STRIDE <= PLIC_CLAIM-PLIC_THRESHOLD {
+			return PLIC_THRESHOLD + offset%PLIC_CONTEXT_STRIDE, int(ctx), 0, nil
+		}
+	}
+	return 0, 0, 0, fmt.Errorf("no plic register at offset=%#x", addr)
+}
+
+func (p *PLIC) Store(addr uint64, data uint64, numBytes uint32) error {
+	reg, c, index, err := p.register(addr, numBytes)
+	if err != nil {
+		return err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	value := uint32(data)
+	ctx := &p.contexts[c]
+	switch reg {
+	case PLIC_PRIORITY:
+		// source 0 does not exist, so its priority is hardwired to zero
+		if index != 0 {
+			p.priority[index] = value & PLIC_MAX_PRIORITY
+		}
+	case PLIC_PENDING:
+		// read only, writes are ignored
+	case PLIC_ENABLE:
+		if index == 0 {
+			value &^= 1
+		}
+		ctx.enabled[index] = value
+	case PLIC_THRESHOLD:
+		ctx.threshold = value & PLIC_MAX_PRIORITY
+	case PLIC_CLAIM:
+		// completing the interrupt, completions for sources the context
+		// does not enable are ignored
+		source := int(value)
+		if source > 0 && source < p.numSources && testBit(ctx.enabled, source) {
+			setBit(p.claimed, source, false)
+			p.updatePending(source)
+		}
+	}
+	p.updateInterrupts()
+	return nil
+}
+
+func (p *PLIC) Load(addr uint64, numBytes uint32) (uint64, error) {
+	reg, c, index, err := p.register(addr, numBytes)
+	if err != nil {
+		return 0, err
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	ctx := &p.contexts[c]
+	switch reg {
+	case PLIC_PRIORITY:
+		return uint64(p.priority[index]), nil
+	case PLIC_PENDING:
+		return uint64(p.pending[index]), nil
+	case PLIC_ENABLE:
+		return uint64(ctx.enabled[index]), nil
+	case PLIC_THRESHOLD:
+		return uint64(ctx.threshold), nil
+	case PLIC_CLAIM:
+		// claiming the interrupt clears the pending bit until it's completed
+		source := p.best(ctx)
+		if source != 0 {
+			setBit(p.pending, source, false)
+			setBit(p.claimed, source, true)
+			p.updateInterrupts()
+		}
+		return uint64(source), nil
+	}
+	return 0, nil
+}
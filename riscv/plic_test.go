@@ -0,0 +1,106 @@
+package riscv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func claim(p *PLIC, ctx uint64) uint64 {
+	source, _ := p.Load(PLIC_CLAIM+ctx*PLIC_CONTEXT_STRIDE, 4)
+	return source
+}
+
+func complete(p *PLIC, ctx uint64, source uint64) {
+	p.Store(PLIC_CLAIM+ctx*PLIC_CONTEXT_STRIDE, source, 4)
+}
+
+func TestPLICClaimComplete(t *testing.T) {
+	p := NewPLIC(64)
+	level := false
+	p.SetInterruptHandlers(func(l bool) { level = l }, nil)
+
+	p.Store(PLIC_PRIORITY+4*3, 1, 4)
+	p.Store(PLIC_PRIORITY+4*40, 2, 4)
+	p.Store(PLIC_ENABLE, 1<<3, 4)
+	p.Store(PLIC_ENABLE+4, 1<<(40-32), 4)
+
+	p.SetLevel(3, true)
+	Assert(t, level, true)
+	p.SetLevel(40, true)
+	pending, _ := p.Load(PLIC_PENDING+4, 4)
+	Assert(t, pending, uint64(1<<(40-32)))
+
+	// the highest priority is claimed first
+	Assert(t, claim(p, PLIC_CONTEXT_M), uint64(40))
+	Assert(t, claim(p, PLIC_CONTEXT_M), uint64(3))
+	Assert(t, level, false)
+	Assert(t, claim(p, PLIC_CONTEXT_M), uint64(0))
+
+	// the line is still high, so the source is pending again after the
+	// completion
+	p.SetLevel(40, false)
+	complete(p, PLIC_CONTEXT_M, 40)
+	complete(p, PLIC_CONTEXT_M, 3)
+	Assert(t, level, true)
+	Assert(t, claim(p, PLIC_CONTEXT_M), uint64(3))
+}
+
+func TestPLICThresholdAndContexts(t *testing.T) {
+	p := NewPLIC(32)
+	machine, supervisor := false, false
+	p.SetInterruptHandlers(func(l bool) { machine = l }, func(l bool) { supervisor = l })
+
+	p.Store(PLIC_PRIORITY+4*5, 3, 4)
+	p.Store(PLIC_ENABLE+PLIC_ENABLE_STRIDE*PLIC_CONTEXT_S, 1<<5, 4)
+	p.SetLevel(5, true)
+	Assert(t, machine, false)
+	Assert(t, supervisor, true)
+
+	// only priorities above the threshold interrupt
+	p.Store(PLIC_THRESHOLD+PLIC_CONTEXT_STRIDE*PLIC_CONTEXT_S, 3, 4)
+	Assert(t, supervisor, false)
+	Assert(t, claim(p, PLIC_CONTEXT_S), uint64(0))
+	p.Store(PLIC_PRIORITY+4*5, 0xff, 4)
+	priority, _ := p.Load(PLIC_PRIORITY+4*5, 4)
+	Assert(t, priority, uint64(PLIC_MAX_PRIORITY))
+	Assert(t, supervisor, true)
+
+	for _, addr := range []uint64{PLIC_PRIORITY + 4*32, PLIC_PENDING + 4, PLIC_CLAIM + 4, PLIC_THRESHOLD + 2*PLIC_CONTEXT_STRIDE} {
+		_, err := p.Load(addr, 4)
+		if err == nil {
+			t.Errorf("loading plic offset %#x should fail", addr)
+		}
+	}
+}
+
+func TestPLICExternalInterrupt(t *testing.T) {
+	r := RegistersImpl{}
+	r.csr.mie = MIP_MEIP
+	r.csr.mstatus = MSTATUS_MIE
+	p := NewPLIC(32)
+	p.SetInterruptHandlers(
+		func(level bool) { r.csr.SetInterruptLine(MIP_MEIP, level) },
+		func(level bool) { r.csr.SetInterruptLine(MIP_SEIP, level) })
+	p.Store(PLIC_PRIORITY+4*10, 1, 4)
+	p.Store(PLIC_ENABLE, 1<<10, 4)
+
+	// the uart raises its interrupt through the plic
+	uart := NewUART(nil, new(bytes.Buffer))
+	uart.SetInterruptHandler(p.InterruptLine(10))
+	uart.Store(UART_IER, uint64(UART_IER_RDI), 1)
+	uart.Receive('a')
+
+	cause, ok := r.csr.PendingInterrupt()
+	Assert(t, ok, true)
+	Assert(t, cause, INT_MACHINE_EXTERNAL)
+	Assert(t, claim(p, PLIC_CONTEXT_M), uint64(10))
+	_, ok = r.csr.PendingInterrupt()
+	Assert(t, ok, false)
+
+	// reading the data lowers the line, so completing does not raise the
+	// interrupt again
+	uart.Load(UART_RBR, 1)
+	complete(p, PLIC_CONTEXT_M, 10)
+	_, ok = r.csr.PendingInterrupt()
+	Assert(t, ok, false)
+}
@@ -2,33 +2,107 @@ package riscv
 
 import "log"
 
+// The supported widths of the integer registers, selected when the
+// registers are created.
+const (
+	XLEN_32 = 32
+	XLEN_64 = 64
+)
+
+// RegistersImpl holds the state of a hart. The zero value is an RV32 hart,
+// use NewRegisters for RV64.
 type RegistersImpl struct {
-	reg [32]uint32
-	pc  uint32
+	// on RV32 only the lower half is used, the upper half is always zero
+	reg [32]uint64
+	// the fp registers are 64 bit, single precision values are NaN-boxed
+	freg [32]uint64
+	pc   uint64
+	csr  CSRFile
+	res  Reservation
+	tlb  TLB
 }
 
 type Registers interface {
-	Reg(i int) uint32
-	SetReg(i int, data uint32)
+	Reg(i int) uint64
+	SetReg(i int, data uint64)
+
+	FReg(i int) uint64
+	SetFReg(i int, data uint64)
+
+	Pc() uint64
+	SetPc(uint64)
+
+	// Xlen returns the width of the integer registers and addresses,
+	// XLEN_32 or XLEN_64.
+	Xlen() int
+
+	Csrs() *CSRFile
+	Reservation() *Reservation
+	TLB() *TLB
+}
+
+func NewRegisters(xlen int) *RegistersImpl {
+	return &RegistersImpl{csr: NewCSRFile(0, xlen)}
+}
 
-	Pc() uint32
-	SetPc(uint32)
+// xlenMask returns the mask of the bits that exist in a register.
+func xlenMask(xlen int) uint64 {
+	if xlen == XLEN_32 {
+		return 0xffffffff
+	}
+	return 0xffffffffffffffff
 }
 
-func (r *RegistersImpl) Reg(i int) uint32 {
+// asSigned interprets the XLEN bit value as a signed number.
+func asSigned(value uint64, xlen int) int64 {
+	if xlen == XLEN_32 {
+		return int64(int32(value))
+	}
+	return int64(value)
+}
+
+func (r *RegistersImpl) Reg(i int) uint64 {
 	return r.reg[i]
 }
 
-func (r *RegistersImpl) SetReg(i int, data uint32) {
-	r.reg[i] = data
+func (r *RegistersImpl) SetReg(i int, data uint64) {
+	// x0 is hardwired to zero, writes to it are discarded
+	if i == reg_zero {
+		return
+	}
+	r.reg[i] = data & xlenMask(r.Xlen())
+}
+
+func (r *RegistersImpl) FReg(i int) uint64 {
+	return r.freg[i]
+}
+
+func (r *RegistersImpl) SetFReg(i int, data uint64) {
+	r.freg[i] = data
 }
 
-func (r *RegistersImpl) Pc() uint32 {
+func (r *RegistersImpl) Pc() uint64 {
 	return r.pc
 }
 
-func (r *RegistersImpl) SetPc(pc uint32) {
-	r.pc = pc
+func (r *RegistersImpl) SetPc(pc uint64) {
+	r.pc = pc & xlenMask(r.Xlen())
+}
+
+func (r *RegistersImpl) Xlen() int {
+	return r.csr.Xlen()
+}
+
+func (r *RegistersImpl) Csrs() *CSRFile {
+	return &r.csr
+}
+
+func (r *RegistersImpl) Reservation() *Reservation {
+	return &r.res
+}
+
+func (r *RegistersImpl) TLB() *TLB {
+	return &r.tlb
 }
 
 const (
@@ -67,28 +141,69 @@ const (
 	reg_t6   int = 31
 )
 
+// RegisterNames are the ABI names of the integer registers.
+var RegisterNames = [32]string{
+	"zero", "ra", "sp", "gp", "tp", "t0", "t1", "t2",
+	"s0", "s1", "a0", "a1", "a2", "a3", "a4", "a5",
+	"a6", "a7", "s2", "s3", "s4", "s5", "s6", "s7",
+	"s8", "s9", "s10", "s11", "t3", "t4", "t5", "t6",
+}
+
+// FRegisterNames are the ABI names of the fp registers.
+var FRegisterNames = [32]string{
+	"ft0", "ft1", "ft2", "ft3", "ft4", "ft5", "ft6", "ft7",
+	"fs0", "fs1", "fa0", "fa1", "fa2", "fa3", "fa4", "fa5",
+	"fa6", "fa7", "fs2", "fs3", "fs4", "fs5", "fs6", "fs7",
+	"fs8", "fs9", "fs10", "fs11", "ft8", "ft9", "ft10", "ft11",
+}
+
 type LoggedRegisters struct {
 	reg Registers
 }
 
-func (r *LoggedRegisters) Reg(i int) uint32 {
+func (r *LoggedRegisters) Reg(i int) uint64 {
 	return r.reg.Reg(i)
 }
 
-func (r *LoggedRegisters) SetReg(i int, data uint32) {
+func (r *LoggedRegisters) SetReg(i int, data uint64) {
 	log.Printf("Setting reg[%d]=%d", i, data)
 	r.reg.SetReg(i, data)
 }
 
-func (r *LoggedRegisters) Pc() uint32 {
+func (r *LoggedRegisters) FReg(i int) uint64 {
+	return r.reg.FReg(i)
+}
+
+func (r *LoggedRegisters) SetFReg(i int, data uint64) {
+	log.Printf("Setting freg[%d]=%#x", i, data)
+	r.reg.SetFReg(i, data)
+}
+
+func (r *LoggedRegisters) Pc() uint64 {
 	return r.reg.Pc()
 }
 
-func (r *LoggedRegisters) SetPc(pc uint32) {
+func (r *LoggedRegisters) SetPc(pc uint64) {
 	log.Printf("Setting pc=%d", pc)
 	r.reg.SetPc(pc)
 }
 
+func (r *LoggedRegisters) Xlen() int {
+	return r.reg.Xlen()
+}
+
+func (r *LoggedRegisters) Csrs() *CSRFile {
+	return r.reg.Csrs()
+}
+
+func (r *LoggedRegisters) Reservation() *Reservation {
+	return r.reg.Reservation()
+}
+
+func (r *LoggedRegisters) TLB() *TLB {
+	return r.reg.TLB()
+}
+
 func NewLoggedRegisters(r Registers) *LoggedRegisters {
 	return &LoggedRegisters{r}
 }
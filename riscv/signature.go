@@ -0,0 +1,43 @@
+package riscv
+
+import (
+	"bufio"
+	"debug/elf"
+	"fmt"
+	"io"
+)
+
+// ElfSignatureRange returns the memory between the begin_signature and
+// end_signature symbols, the tests of riscv-arch-test store their results
+// there.
+func ElfSignatureRange(f *elf.File) (uint64, uint64, error) {
+	begin, err := ElfSymbol(f, "begin_signature")
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := ElfSymbol(f, "end_signature")
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < begin {
+		return 0, 0, fmt.Errorf("signature ends at %#x before it begins at %#x", end, begin)
+	}
+	return begin, end, nil
+}
+
+// WriteSignature writes the memory from begin to end (exclusive) in the
+// signature format of RISCOF, one 32 bit word per line as 8 hex digits.
+func WriteSignature(w io.Writer, mem Memory, begin uint64, end uint64) error {
+	if begin%4 != 0 || end%4 != 0 {
+		return fmt.Errorf("signature from %#x to %#x is not word aligned", begin, end)
+	}
+	b := bufio.NewWriter(w)
+	for addr := begin; addr < end; addr += 4 {
+		word, err := mem.Load(addr, 4)
+		if err != nil {
+			return fmt.Errorf("can't load signature at addr=%#x with error: %w", addr, err)
+		}
+		fmt.Fprintf(b, "%08x\n", word)
+	}
+	return b.Flush()
+}
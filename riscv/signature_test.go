@@ -0,0 +1,33 @@
+package riscv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteSignature(t *testing.T) {
+	f := buildElfWithSymbols(t, 0x1000, []testSegment{{addr: 0x1000, data: []byte{1, 2, 3, 4}, memsz: 16}},
+		map[string]uint32{"begin_signature": 0x1004, "end_signature": 0x1010})
+	begin, end, err := ElfSignatureRange(f)
+	if err != nil {
+		t.Fatalf("ElfSignatureRange failed with error %v", err)
+	}
+	Assert(t, begin, uint64(0x1004))
+	Assert(t, end, uint64(0x1010))
+
+	mem := NewMemoryWithOffset(16, 0x1000)
+	mem.Store(0x1004, 0xdeadbeef, 4)
+	mem.Store(0x1008, 0x0102, 2)
+	var out bytes.Buffer
+	if err := WriteSignature(&out, &mem, begin, end); err != nil {
+		t.Fatalf("WriteSignature failed with error %v", err)
+	}
+	Assert(t, out.String(), "deadbeef\n00000102\n00000000\n")
+
+	if err := WriteSignature(&out, &mem, 0x1002, end); err == nil {
+		t.Fatalf("WriteSignature accepted an unaligned signature")
+	}
+	if _, _, err := ElfSignatureRange(buildElf(t, 0x1000, nil)); err == nil {
+		t.Fatalf("ElfSignatureRange found a signature without symbols")
+	}
+}
@@ -0,0 +1,281 @@
+package riscv
+
+import (
+	"fmt"
+)
+
+// Exception codes written to mcause, see table 3.6 of the privileged spec.
+const (
+	EXC_INSTRUCTION_MISALIGNED   uint64 = 0
+	EXC_INSTRUCTION_ACCESS_FAULT uint64 = 1
+	EXC_ILLEGAL_INSTRUCTION      uint64 = 2
+	EXC_BREAKPOINT               uint64 = 3
+	EXC_LOAD_MISALIGNED          uint64 = 4
+	EXC_LOAD_ACCESS_FAULT        uint64 = 5
+	EXC_STORE_MISALIGNED         uint64 = 6
+	EXC_STORE_ACCESS_FAULT       uint64 = 7
+	EXC_ECALL_U                  uint64 = 8
+	EXC_ECALL_S                  uint64 = 9
+	EXC_ECALL_M                  uint64 = 11
+	EXC_INSTRUCTION_PAGE_FAULT   uint64 = 12
+	EXC_LOAD_PAGE_FAULT          uint64 = 13
+	EXC_STORE_PAGE_FAULT         uint64 = 15
+)
+
+// Interrupt codes written to mcause, with the interrupt bit (the highest
+// bit of mcause) set. The code is also the bit of the interrupt in mip/mie.
+const (
+	INT_SUPERVISOR_SOFTWARE uint64 = 1
+	INT_MACHINE_SOFTWARE    uint64 = 3
+	INT_SUPERVISOR_TIMER    uint64 = 5
+	INT_MACHINE_TIMER       uint64 = 7
+	INT_SUPERVISOR_EXTERNAL uint64 = 9
+	INT_MACHINE_EXTERNAL    uint64 = 11
+)
+
+// interruptPriority is the order in which simultaneous interrupts are
+// taken, see section 3.1.9 of the privileged spec.
+var interruptPriority = []uint64{
+	INT_MACHINE_EXTERNAL, INT_MACHINE_SOFTWARE, INT_MACHINE_TIMER,
+	INT_SUPERVISOR_EXTERNAL, INT_SUPERVISOR_SOFTWARE, INT_SUPERVISOR_TIMER,
+}
+
+func CauseString(cause uint64) string {
+	switch cause {
+	case EXC_INSTRUCTION_MISALIGNED:
+		return "instruction address misaligned"
+	case EXC_INSTRUCTION_ACCESS_FAULT:
+		return "instruction access fault"
+	case EXC_ILLEGAL_INSTRUCTION:
+		return "illegal instruction"
+	case EXC_BREAKPOINT:
+		return "breakpoint"
+	case EXC_LOAD_MISALIGNED:
+		return "load address misaligned"
+	case EXC_LOAD_ACCESS_FAULT:
+		return "load access fault"
+	case EXC_STORE_MISALIGNED:
+		return "store/amo address misaligned"
+	case EXC_STORE_ACCESS_FAULT:
+		return "store/amo access fault"
+	case EXC_ECALL_U:
+		return "environment call from U-mode"
+	case EXC_ECALL_S:
+		return "environment call from S-mode"
+	case EXC_ECALL_M:
+		return "environment call from M-mode"
+	case EXC_INSTRUCTION_PAGE_FAULT:
+		return "instruction page fault"
+	case EXC_LOAD_PAGE_FAULT:
+		return "load page fault"
+	case EXC_STORE_PAGE_FAULT:
+		return "store/amo page fault"
+	default:
+		return fmt.Sprintf("Unknown cause (val=%d)", cause)
+	}
+}
+
+func InterruptString(cause uint64) string {
+	switch cause {
+	case INT_SUPERVISOR_SOFTWARE:
+		return "supervisor software interrupt"
+	case INT_MACHINE_SOFTWARE:
+		return "machine software interrupt"
+	case INT_SUPERVISOR_TIMER:
+		return "supervisor timer interrupt"
+	case INT_MACHINE_TIMER:
+		return "machine timer interrupt"
+	case INT_SUPERVISOR_EXTERNAL:
+		return "supervisor external interrupt"
+	case INT_MACHINE_EXTERNAL:
+		return "machine external interrupt"
+	default:
+		return fmt.Sprintf("Unknown interrupt (val=%d)", cause)
+	}
+}
+
+// Exception is returned by Instruction.Execute when the instruction has to
+// trap. The emulator turns it into a trap to the handler in mtvec, or stvec
+// when the exception is delegated to supervisor mode.
+type Exception struct {
+	Cause uint64
+	// The value written to mtval (or stval), e.g. the faulting address.
+	Tval uint64
+	// The error that caused the exception, can be nil.
+	Err error
+}
+
+func (e Exception) Error() string {
+	msg := fmt.Sprintf("exception %s (cause=%d, tval=%#x)", CauseString(e.Cause), e.Cause, e.Tval)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e Exception) Unwrap() error {
+	return e.Err
+}
+
+// trapVector returns the address of the handler for the cause, in vectored
+// mode interrupts jump to BASE+4*cause but exceptions always go to BASE.
+func trapVector(mtvec uint64, cause uint64, interrupt bool) uint64 {
+	base := mtvec &^ MTVEC_MODE
+	if interrupt && mtvec&MTVEC_MODE == 1 {
+		return base + 4*cause
+	}
+	return base
+}
+
+// delegated returns if the exception is handled in supervisor mode. Traps
+// never move to a less privileged mode, so exceptions in machine mode are
+// always handled there.
+func (c *CSRFile) delegated(cause uint64) bool {
+	return c.Mode() <= MODE_S && c.medeleg&(1<<cause) != 0
+}
+
+// interruptDelegated returns if the interrupt is handled in supervisor
+// mode, interrupts are delegated in mideleg instead of medeleg.
+func (c *CSRFile) interruptDelegated(cause uint64) bool {
+	return c.Mode() <= MODE_S && c.mideleg&(1<<cause) != 0
+}
+
+// trapHandler returns the address TakeTrap jumps to for the exception.
+func (c *CSRFile) trapHandler(cause uint64) uint64 {
+	if c.delegated(cause) {
+		return trapVector(c.stvec, cause, false)
+	}
+	return trapVector(c.mtvec, cause, false)
+}
+
+// enabledInterrupts returns the interrupts in mie that are taken in the
+// current mode when they are pending. Interrupts for a more privileged mode
+// are always enabled, those for the current mode only when its global
+// interrupt enable bit in mstatus is set.
+func (c *CSRFile) enabledInterrupts() uint64 {
+	mode := c.Mode()
+	enabled := uint64(0)
+	if mode < MODE_M || c.mstatus&MSTATUS_MIE != 0 {
+		enabled |= c.mie &^ c.mideleg
+	}
+	if mode < MODE_S || (mode == MODE_S && c.mstatus&MSTATUS_SIE != 0) {
+		enabled |= c.mie & c.mideleg
+	}
+	return enabled
+}
+
+// PendingInterrupt returns the highest priority interrupt that is pending
+// and enabled, the emulator takes it before the next instruction.
+func (c *CSRFile) PendingInterrupt() (uint64, bool) {
+	pending := c.pending() & c.enabledInterrupts()
+	for _, cause := range interruptPriority {
+		if pending&(1<<cause) != 0 {
+			return cause, true
+		}
+	}
+	return 0, false
+}
+
+// TakeTrap saves the state of the interrupted instruction in the CSRs of the
+// mode handling the trap, which is machine mode unless the exception is
+// delegated in medeleg, and jumps to the trap handler.
+func TakeTrap(regs Registers, cause uint64, tval uint64) {
+	takeTrap(regs, cause, tval, false)
+}
+
+// TakeInterrupt is TakeTrap for interrupts, which are delegated in mideleg
+// and set the interrupt bit in mcause. The pc is the instruction that did
+// not execute yet, so the handler returns to it.
+func TakeInterrupt(regs Registers, cause uint64) {
+	takeTrap(regs, cause, 0, true)
+}
+
+func takeTrap(regs Registers, cause uint64, tval uint64, interrupt bool) {
+	c := regs.Csrs()
+	delegated := c.delegated(cause)
+	if interrupt {
+		delegated = c.interruptDelegated(cause)
+	}
+	tvec := c.mtvec
+	if delegated {
+		tvec = c.stvec
+	}
+	handler := trapVector(tvec, cause, interrupt)
+	if interrupt {
+		cause |= 1 << (c.Xlen() - 1)
+	}
+
+	if delegated {
+		c.sepc = regs.Pc()
+		c.scause = cause
+		c.stval = tval
+
+		// SPIE=SIE, SIE=0, SPP=the mode the trap came from
+		mstatus := c.mstatus &^ (MSTATUS_SIE | MSTATUS_SPIE | MSTATUS_SPP)
+		if c.mstatus&MSTATUS_SIE != 0 {
+			mstatus |= MSTATUS_SPIE
+		}
+		if c.Mode() == MODE_S {
+			mstatus |= MSTATUS_SPP
+		}
+		c.mstatus = mstatus
+		c.SetMode(MODE_S)
+	} else {
+		c.mepc = regs.Pc()
+		c.mcause = cause
+		c.mtval = tval
+
+		// MPIE=MIE, MIE=0 so the handler is not interrupted, MPP=the mode
+		// the trap came from
+		mstatus := c.mstatus &^ (MSTATUS_MIE | MSTATUS_MPIE | MSTATUS_MPP)
+		if c.mstatus&MSTATUS_MIE != 0 {
+			mstatus |= MSTATUS_MPIE
+		}
+		mstatus |= c.Mode() << MSTATUS_MPP_SHIFT
+		c.mstatus = mstatus
+		c.SetMode(MODE_M)
+	}
+
+	regs.SetPc(handler)
+}
+
+// ReturnFromTrap implements MRET, it restores the interrupt enable bit and
+// the privilege mode and jumps back to mepc.
+func ReturnFromTrap(regs Registers) {
+	c := regs.Csrs()
+	mode := (c.mstatus & MSTATUS_MPP) >> MSTATUS_MPP_SHIFT
+
+	// MIE=MPIE, MPIE=1, MPP=U
+	mstatus := c.mstatus&^(MSTATUS_MIE|MSTATUS_MPP) | MSTATUS_MPIE
+	if c.mstatus&MSTATUS_MPIE != 0 {
+		mstatus |= MSTATUS_MIE
+	}
+	// MPRV only stays set when returning to machine mode
+	if mode != MODE_M {
+		mstatus &^= MSTATUS_MPRV
+	}
+	c.mstatus = mstatus
+	c.SetMode(mode)
+
+	regs.SetPc(c.mepc)
+}
+
+// ReturnFromSupervisorTrap implements SRET, it restores the supervisor
+// interrupt enable bit and the privilege mode and jumps back to sepc.
+func ReturnFromSupervisorTrap(regs Registers) {
+	c := regs.Csrs()
+	mode := MODE_U
+	if c.mstatus&MSTATUS_SPP != 0 {
+		mode = MODE_S
+	}
+
+	// SIE=SPIE, SPIE=1, SPP=U and MPRV=0 as the new mode is never machine mode
+	mstatus := c.mstatus&^(MSTATUS_SIE|MSTATUS_SPP|MSTATUS_MPRV) | MSTATUS_SPIE
+	if c.mstatus&MSTATUS_SPIE != 0 {
+		mstatus |= MSTATUS_SIE
+	}
+	c.mstatus = mstatus
+	c.SetMode(mode)
+
+	regs.SetPc(c.sepc)
+}
@@ -0,0 +1,283 @@
+package riscv
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEcallMret(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0x02000293, // addi t0, zero, 0x20
+		0x30529073, // csrw mtvec, t0
+		0x00000073, // ecall
+		0x0000006f, // j .
+	})
+	// trap handler, skip the ecall
+	storeProgram(t, &mem, 0x20, []uint32{
+		0x34102373, // csrr t1, mepc
+		0x00430313, // addi t1, t1, 4
+		0x34131073, // csrw mepc, t1
+		0x30200073, // mret
+	})
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltSelfLoop)
+	CheckPc(12, r, t)
+	CheckCsr(CSR_MCAUSE, EXC_ECALL_M, r, t)
+	CheckCsr(CSR_MEPC, 12, r, t)
+	CheckReg(reg_t1, 12, r, t)
+}
+
+func TestTrapMstatus(t *testing.T) {
+	r := RegistersImpl{}
+	r.csr.mstatus = MSTATUS_MIE
+	r.csr.mtvec = 0x100
+	r.pc = 0x40
+
+	TakeTrap(&r, EXC_BREAKPOINT, 0x40)
+	CheckPc(0x100, &r, t)
+	// interrupts are disabled in the handler, the old state is saved in MPIE
+	CheckCsr(CSR_MSTATUS, MSTATUS_MPIE|MSTATUS_MPP|MSTATUS_FS|MSTATUS_SD, &r, t)
+	CheckCsr(CSR_MEPC, 0x40, &r, t)
+	CheckCsr(CSR_MCAUSE, EXC_BREAKPOINT, &r, t)
+	CheckCsr(CSR_MTVAL, 0x40, &r, t)
+
+	// the return goes back to machine mode and sets MPP to user mode
+	ReturnFromTrap(&r)
+	CheckPc(0x40, &r, t)
+	CheckCsr(CSR_MSTATUS, MSTATUS_MIE|MSTATUS_MPIE|MSTATUS_FS|MSTATUS_SD, &r, t)
+	Assert(t, r.csr.Mode(), MODE_M)
+}
+
+func TestUserModeEcall(t *testing.T) {
+	mem := NewMemory(0x100)
+	storeProgram(t, &mem, 0, []uint32{
+		0x08000293, // addi t0, zero, 0x80
+		0x30529073, // csrw mtvec, t0
+		0x04000293, // addi t0, zero, 0x40
+		0x34129073, // csrw mepc, t0
+		0x30200073, // mret, MPP is user mode after reset
+	})
+	storeProgram(t, &mem, 0x40, []uint32{
+		0x00000073, // ecall
+	})
+	storeProgram(t, &mem, 0x80, []uint32{
+		0x0000006f, // j .
+	})
+	e, r := newTestEmulator(&mem)
+
+	reason, err := e.Run(100)
+	if err != nil {
+		t.Fatalf("run failed with error %v", err)
+	}
+
+	Assert(t, reason, HaltSelfLoop)
+	CheckPc(0x80, r, t)
+	CheckCsr(CSR_MCAUSE, EXC_ECALL_U, r, t)
+	CheckCsr(CSR_MEPC, 0x40, r, t)
+	// the trap came from user mode, so MPP is zero
+	CheckCsr(CSR_MSTATUS, MSTATUS_FS|MSTATUS_SD, r, t)
+	Assert(t, r.csr.Mode(), MODE_M)
+}
+
+func TestDelegatedTrap(t *testing.T) {
+	r := RegistersImpl{}
+	r.csr.mtvec = 0x100
+	r.csr.stvec = 0x200
+	r.csr.medeleg = 1 << EXC_BREAKPOINT
+	r.csr.mstatus = MSTATUS_SIE
+	r.pc = 0x40
+
+	// traps from machine mode are never delegated
+	TakeTrap(&r, EXC_BREAKPOINT, 0x40)
+	CheckPc(0x100, &r, t)
+	ReturnFromTrap(&r)
+
+	r.csr.SetMode(MODE_S)
+	TakeTrap(&r, EXC_BREAKPOINT, 0x40)
+	CheckPc(0x200, &r, t)
+	Assert(t, r.csr.Mode(), MODE_S)
+	CheckCsr(CSR_SEPC, 0x40, &r, t)
+	CheckCsr(CSR_SCAUSE, EXC_BREAKPOINT, &r, t)
+	CheckCsr(CSR_STVAL, 0x40, &r, t)
+	CheckCsr(CSR_SSTATUS, MSTATUS_SPIE|MSTATUS_SPP|MSTATUS_FS|MSTATUS_SD, &r, t)
+
+	// the other exceptions still go to machine mode
+	TakeTrap(&r, EXC_ILLEGAL_INSTRUCTION, 0)
+	CheckPc(0x100, &r, t)
+	Assert(t, r.csr.Mode(), MODE_M)
+	Assert(t, (r.csr.mstatus&MSTATUS_MPP)>>MSTATUS_MPP_SHIFT, MODE_S)
+
+	r.csr.SetMode(MODE_S)
+	ReturnFromSupervisorTrap(&r)
+	CheckPc(0x40, &r, t)
+	Assert(t, r.csr.Mode(), MODE_S)
+	CheckCsr(CSR_SSTATUS, MSTATUS_SIE|MSTATUS_SPIE|MSTATUS_FS|MSTATUS_SD, &r, t)
+}
+
+func TestPrivilegedInstructions(t *testing.T) {
+	r := RegistersImpl{}
+	mem := NewMemory(0)
+
+	r.csr.SetMode(MODE_S)
+	err := CreateMRET().Execute(&mem, &r)
+	if err == nil {
+		t.Fatalf("mret in supervisor mode should fail")
+	}
+	r.csr.mstatus = MSTATUS_TSR | MSTATUS_TW
+	err = CreateSRET().Execute(&mem, &r)
+	if err == nil {
+		t.Fatalf("sret in supervisor mode with TSR should fail")
+	}
+	err = CreateWFI().Execute(&mem, &r)
+	if err == nil {
+		t.Fatalf("wfi in supervisor mode with TW should fail")
+	}
+
+	r.csr.SetMode(MODE_U)
+	err = CreateSRET().Execute(&mem, &r)
+	if err == nil {
+		t.Fatalf("sret in user mode should fail")
+	}
+	err = CreateECALL().Execute(&mem, &r)
+	var exc Exception
+	if !errors.As(err, &exc) || exc.Cause != EXC_ECALL_U {
+		t.Fatalf("ecall in user mode should raise an ecall from U-mode exception but got %v", err)
+	}
+	CheckPc(0, &r, t)
+}
+
+func TestIllegalInstructionTrap(t *testing.T) {
+	mem := NewMemory(64)
+	storeProgram(t, &mem, 0, []uint32{
+		0xffffffff, // not a valid instruction
+	})
+	e, r := newTestEmulator(&mem)
+	r.csr.mtvec = 0x20
+
+	_, err := e.Step()
+	if err != nil {
+		t.Fatalf("step failed with error %v", err)
+	}
+	CheckPc(0x20, r, t)
+	CheckCsr(CSR_MCAUSE, EXC_ILLEGAL_INSTRUCTION, r, t)
+	CheckCsr(CSR_MEPC, 0, r, t)
+	CheckCsr(CSR_MTVAL, 0xffffffff, r, t)
+	// the instruction did not retire
+	CheckCsr(CSR_MINSTRET, 0, r, t)
+}
+
+func TestLoadStoreExceptions(t *testing.T) {
+	mem := NewMemory(16)
+	r := RegistersImpl{}
+	r.reg[reg_a1] = 1
+
+	err := CreateLW(0, reg_a1, reg_a0).Execute(&mem, &r)
+	exc, ok := err.(Exception)
+	if !ok {
+		t.Fatalf("misaligned load should raise an exception but got %v", err)
+	}
+	Assert(t, exc.Cause, EXC_LOAD_MISALIGNED)
+	Assert(t, exc.Tval, uint64(1))
+
+	err = CreateSH(0, reg_a0, reg_a1).Execute(&mem, &r)
+	exc, ok = err.(Exception)
+	if !ok {
+		t.Fatalf("misaligned store should raise an exception but got %v", err)
+	}
+	Assert(t, exc.Cause, EXC_STORE_MISALIGNED)
+
+	r.reg[reg_a1] = 0x100
+	err = CreateLB(0, reg_a1, reg_a0).Execute(&mem, &r)
+	exc, ok = err.(Exception)
+	if !ok {
+		t.Fatalf("load outside of memory should raise an exception but got %v", err)
+	}
+	Assert(t, exc.Cause, EXC_LOAD_ACCESS_FAULT)
+	Assert(t, exc.Tval, uint64(0x100))
+
+	err = CreateSW(0, reg_a0, reg_a1).Execute(&mem, &r)
+	exc, ok = err.(Exception)
+	if !ok {
+		t.Fatalf("store outside of memory should raise an exception but got %v", err)
+	}
+	Assert(t, exc.Cause, EXC_STORE_ACCESS_FAULT)
+
+	// nothing changed, so the pc still points to the faulting instruction
+	CheckPc(0, &r, t)
+}
+
+func TestPendingInterrupt(t *testing.T) {
+	c := CSRFile{}
+	c.SetInterruptLine(MIP_MTIP|MIP_MEIP, true)
+
+	// nothing is enabled in mie
+	_, ok := c.PendingInterrupt()
+	Assert(t, ok, false)
+
+	// machine mode needs MIE
+	c.mie = MIP_MTIP | MIP_MEIP
+	_, ok = c.PendingInterrupt()
+	Assert(t, ok, false)
+	c.mstatus = MSTATUS_MIE
+	cause, ok := c.PendingInterrupt()
+	Assert(t, ok, true)
+	Assert(t, cause, INT_MACHINE_EXTERNAL)
+	mip, _ := c.Read(CSR_MIP)
+	Assert(t, mip, MIP_MTIP|MIP_MEIP)
+
+	// the lines are read only for software
+	c.Write(CSR_MIP, 0)
+	c.SetInterruptLine(MIP_MEIP, false)
+	cause, _ = c.PendingInterrupt()
+	Assert(t, cause, INT_MACHINE_TIMER)
+
+	// machine interrupts are always enabled in less privileged modes, the
+	// delegated ones are not taken in machine mode
+	c.mstatus = 0
+	c.SetMode(MODE_U)
+	cause, _ = c.PendingInterrupt()
+	Assert(t, cause, INT_MACHINE_TIMER)
+	c.SetInterruptLine(MIP_MTIP, false)
+	c.mideleg = MIP_STIP
+	c.mie = MIP_STIP
+	c.mip = MIP_STIP
+	cause, _ = c.PendingInterrupt()
+	Assert(t, cause, INT_SUPERVISOR_TIMER)
+	c.SetMode(MODE_M)
+	_, ok = c.PendingInterrupt()
+	Assert(t, ok, false)
+}
+
+func TestTakeInterrupt(t *testing.T) {
+	r := RegistersImpl{}
+	r.csr.mstatus = MSTATUS_MIE
+	// vectored mode jumps to BASE+4*cause for interrupts
+	r.csr.mtvec = 0x101
+	r.pc = 0x40
+
+	TakeInterrupt(&r, INT_MACHINE_TIMER)
+	CheckPc(0x100+4*INT_MACHINE_TIMER, &r, t)
+	CheckCsr(CSR_MCAUSE, 1<<31|INT_MACHINE_TIMER, &r, t)
+	CheckCsr(CSR_MEPC, 0x40, &r, t)
+	CheckCsr(CSR_MSTATUS, MSTATUS_MPIE|MSTATUS_MPP|MSTATUS_FS|MSTATUS_SD, &r, t)
+
+	// delegated interrupts go to stvec, the interrupt bit is the highest
+	// bit of XLEN
+	r64 := NewRegisters(XLEN_64)
+	r64.csr.mideleg = MIP_SSIP
+	r64.csr.stvec = 0x200
+	r64.csr.SetMode(MODE_U)
+	r64.pc = 0x40
+	TakeInterrupt(r64, INT_SUPERVISOR_SOFTWARE)
+	CheckPc(0x200, r64, t)
+	CheckCsr(CSR_SCAUSE, 1<<63|INT_SUPERVISOR_SOFTWARE, r64, t)
+	CheckCsr(CSR_SEPC, 0x40, r64, t)
+	Assert(t, r64.csr.Mode(), MODE_S)
+}
@@ -0,0 +1,250 @@
+package riscv
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sync"
+)
+
+// Register offsets of the 16550 UART, the registers are one byte apart.
+const (
+	UART_RBR uint64 = 0 // receive buffer (read, DLAB=0)
+	UART_THR uint64 = 0 // transmit holding (write, DLAB=0)
+	UART_DLL uint64 = 0 // divisor latch low (DLAB=1)
+	UART_IER uint64 = 1 // interrupt enable (DLAB=0)
+	UART_DLM uint64 = 1 // divisor latch high (DLAB=1)
+	UART_IIR uint64 = 2 // interrupt identification (read)
+	UART_FCR uint64 = 2 // fifo control (write)
+	UART_LCR uint64 = 3 // line control
+	UART_MCR uint64 = 4 // modem control
+	UART_LSR uint64 = 5 // line status
+	UART_MSR uint64 = 6 // modem status
+	UART_SCR uint64 = 7 // scratch
+
+	// Number of bytes the UART occupies on the bus.
+	UART_SIZE uint64 = 8
+)
+
+// Interrupt enable register bits
+const (
+	UART_IER_RDI  uint8 = 0x01 // received data available
+	UART_IER_THRI uint8 = 0x02 // transmit holding register empty
+	UART_IER_RLSI uint8 = 0x04 // receiver line status
+	UART_IER_MSI  uint8 = 0x08 // modem status
+)
+
+// Interrupt identification register values
+const (
+	UART_IIR_NO_INT uint8 = 0x01
+	UART_IIR_THRI   uint8 = 0x02
+	UART_IIR_RDI    uint8 = 0x04
+	UART_IIR_FIFO   uint8 = 0xc0 // set when the fifo's are enabled
+)
+
+// Line status register bits
+const (
+	UART_LSR_DR   uint8 = 0x01 // data ready
+	UART_LSR_OE   uint8 = 0x02 // overrun error
+	UART_LSR_THRE uint8 = 0x20 // transmit holding register empty
+	UART_LSR_TEMT uint8 = 0x40 // transmitter empty
+)
+
+const (
+	UART_LCR_DLAB uint8 = 0x80 // divisor latch access
+	UART_FCR_FIFO uint8 = 0x01 // enable the fifo's
+)
+
+// UART is a 16550 compatible serial port. Everything written by the guest
+// is passed to out immediately, so the transmitter is always empty. Bytes
+// received from the host are buffered until the guest reads them.
+type UART struct {
+	lock sync.Mutex
+	out  io.Writer
+	rx   []byte
+
+	ier uint8
+	lcr uint8
+	mcr uint8
+	lsr uint8
+	scr uint8
+	fcr uint8
+	dll uint8
+	dlm uint8
+
+	// the transmit holding register empty interrupt is pending until
+	// the IIR is read or the THR is written.
+	thriPending bool
+	irqLevel    bool
+	irq         func(level bool)
+}
+
+// NewUART creates a UART that writes the guest output to out. When in
+// is not nil everything read from it is passed on to the guest.
+func NewUART(in io.Reader, out io.Writer) *UART {
+	u := &UART{out: out, lsr: UART_LSR_THRE | UART_LSR_TEMT}
+	if in != nil {
+		go u.readFrom(in)
+	}
+	return u
+}
+
+func (u *UART) readFrom(in io.Reader) {
+	buf := make([]byte, 256)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			u.Receive(buf[:n]...)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("uart stopped reading input with error: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// SetInterruptHandler registers the function that is called every time
+// the level of the interrupt output changes.
+func (u *UART) SetInterruptHandler(handler func(level bool)) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.irq = handler
+	u.updateInterrupt()
+}
+
+// InterruptPending returns the level of the interrupt output.
+func (u *UART) InterruptPending() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.irqLevel
+}
+
+// Receive makes data available to the guest, as if it arrived on the line.
+func (u *UART) Receive(data ...byte) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	u.rx = append(u.rx, data...)
+	u.updateInterrupt()
+}
+
+func (u *UART) iir() uint8 {
+	iir := UART_IIR_NO_INT
+	if u.ier&UART_IER_RDI != 0 && len(u.rx) > 0 {
+		iir = UART_IIR_RDI
+	} else if u.ier&UART_IER_THRI != 0 && u.thriPending {
+		iir = UART_IIR_THRI
+	}
+	if u.fcr&UART_FCR_FIFO != 0 {
+		iir |= UART_IIR_FIFO
+	}
+	return iir
+}
+
+// updateInterrupt must be called with the lock taken.
+func (u *UART) updateInterrupt() {
+	if len(u.rx) > 0 {
+		u.lsr |= UART_LSR_DR
+	} else {
+		u.lsr &^= UART_LSR_DR
+	}
+
+	level := u.iir()&UART_IIR_NO_INT == 0
+	if level != u.irqLevel {
+		u.irqLevel = level
+		if u.irq != nil {
+			u.irq(level)
+		}
+	}
+}
+
+func (u *UART) Store(addr uint64, data uint64, numBytes uint32) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	value := uint8(data)
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	switch addr {
+	case UART_THR:
+		if dlab {
+			u.dll = value
+		} else {
+			_, err := u.out.Write([]byte{value})
+			if err != nil {
+				return fmt.Errorf("uart failed to write output with error: %w", err)
+			}
+			// the byte is sent right away, so the THR is empty again
+			u.thriPending = true
+		}
+	case UART_IER:
+		if dlab {
+			u.dlm = value
+		} else {
+			// enabling the THR empty interrupt while the THR is empty raises it
+			if value&UART_IER_THRI != 0 && u.ier&UART_IER_THRI == 0 {
+				u.thriPending = true
+			}
+			u.ier = value & 0x0f
+		}
+	case UART_FCR:
+		u.fcr = value
+	case UART_LCR:
+		u.lcr = value
+	case UART_MCR:
+		u.mcr = value
+	case UART_LSR, UART_MSR:
+		// read only, writes are ignored
+	case UART_SCR:
+		u.scr = value
+	default:
+		return UnmappedAddressError{addr, numBytes}
+	}
+	u.updateInterrupt()
+
+	return nil
+}
+
+func (u *UART) Load(addr uint64, numBytes uint32) (uint64, error) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	var value uint8
+	dlab := u.lcr&UART_LCR_DLAB != 0
+	switch addr {
+	case UART_RBR:
+		if dlab {
+			value = u.dll
+		} else if len(u.rx) > 0 {
+			value = u.rx[0]
+			u.rx = u.rx[1:]
+		}
+	case UART_IER:
+		if dlab {
+			value = u.dlm
+		} else {
+			value = u.ier
+		}
+	case UART_IIR:
+		value = u.iir()
+		// reading the IIR clears the THR empty interrupt when it's the one reported
+		if value&0x0f == UART_IIR_THRI {
+			u.thriPending = false
+		}
+	case UART_LCR:
+		value = u.lcr
+	case UART_MCR:
+		value = u.mcr
+	case UART_LSR:
+		value = u.lsr
+	case UART_MSR:
+		value = 0
+	case UART_SCR:
+		value = u.scr
+	default:
+		return 0, UnmappedAddressError{addr, numBytes}
+	}
+	u.updateInterrupt()
+
+	return uint64(value), nil
+}
@@ -0,0 +1,93 @@
+package riscv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUARTTransmit(t *testing.T) {
+	out := new(bytes.Buffer)
+	uart := NewUART(nil, out)
+
+	for _, c := range []byte("hello") {
+		lsr, _ := uart.Load(UART_LSR, 1)
+		if uint8(lsr)&UART_LSR_THRE == 0 {
+			t.Fatalf("THR should always be empty but lsr=%#x", lsr)
+		}
+		uart.Store(UART_THR, uint64(c), 1)
+	}
+
+	Assert(t, out.String(), "hello")
+}
+
+func TestUARTReceive(t *testing.T) {
+	uart := NewUART(nil, new(bytes.Buffer))
+
+	lsr, _ := uart.Load(UART_LSR, 1)
+	Assert(t, uint8(lsr)&UART_LSR_DR, 0)
+
+	uart.Receive('o', 'k')
+	lsr, _ = uart.Load(UART_LSR, 1)
+	Assert(t, uint8(lsr)&UART_LSR_DR, UART_LSR_DR)
+
+	c, _ := uart.Load(UART_RBR, 1)
+	Assert(t, c, uint64('o'))
+	c, _ = uart.Load(UART_RBR, 1)
+	Assert(t, c, uint64('k'))
+
+	lsr, _ = uart.Load(UART_LSR, 1)
+	Assert(t, uint8(lsr)&UART_LSR_DR, 0)
+}
+
+func TestUARTInterrupts(t *testing.T) {
+	uart := NewUART(nil, new(bytes.Buffer))
+	level := false
+	uart.SetInterruptHandler(func(l bool) { level = l })
+
+	// nothing enabled, so no interrupt
+	uart.Receive('a')
+	Assert(t, level, false)
+	iir, _ := uart.Load(UART_IIR, 1)
+	Assert(t, uint8(iir), UART_IIR_NO_INT)
+
+	uart.Store(UART_IER, uint64(UART_IER_RDI), 1)
+	Assert(t, level, true)
+	iir, _ = uart.Load(UART_IIR, 1)
+	Assert(t, uint8(iir), UART_IIR_RDI)
+
+	// reading the data clears the interrupt
+	uart.Load(UART_RBR, 1)
+	Assert(t, level, false)
+
+	// enabling the THR empty interrupt raises it, reading the IIR clears it
+	uart.Store(UART_IER, uint64(UART_IER_RDI|UART_IER_THRI), 1)
+	Assert(t, level, true)
+	iir, _ = uart.Load(UART_IIR, 1)
+	Assert(t, uint8(iir), UART_IIR_THRI)
+	Assert(t, level, false)
+
+	// sending a byte empties the THR again
+	uart.Store(UART_THR, 'b', 1)
+	Assert(t, level, true)
+}
+
+func TestUARTDivisorLatch(t *testing.T) {
+	out := new(bytes.Buffer)
+	uart := NewUART(nil, out)
+
+	uart.Store(UART_LCR, uint64(UART_LCR_DLAB), 1)
+	uart.Store(UART_DLL, 3, 1)
+	uart.Store(UART_DLM, 1, 1)
+	uart.Store(UART_LCR, 3, 1)
+
+	// nothing should be send while setting the divisor latch
+	Assert(t, out.Len(), 0)
+	ier, _ := uart.Load(UART_IER, 1)
+	Assert(t, ier, uint64(0))
+
+	uart.Store(UART_LCR, uint64(UART_LCR_DLAB)|3, 1)
+	dll, _ := uart.Load(UART_DLL, 1)
+	dlm, _ := uart.Load(UART_DLM, 1)
+	Assert(t, dll, uint64(3))
+	Assert(t, dlm, uint64(1))
+}
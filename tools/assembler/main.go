@@ -0,0 +1,68 @@
+package main
+
+import (
+	"emu/riscv"
+	"flag"
+	"log"
+	"os"
+)
+
+func main() {
+	file := flag.String("file", "", "Assembly source file to assemble.")
+	out := flag.String("out", "", "Output file, by default the source file with the extension of the format")
+	format := flag.String("format", "elf", "Output format: elf (an executable elf file) or bin (the memory image from the first section)")
+	xlen := flag.Int("xlen", 32, "Assemble for RV32 (32) or RV64 (64)")
+	base := flag.Uint64("base", 0x80000000, "Address of the first section")
+	flag.Parse()
+
+	if *file == "" {
+		println("Please provide the --file argument.")
+		return
+	}
+
+	source, err := os.ReadFile(*file)
+	if err != nil {
+		log.Fatalf("can't read source with error: %v", err.Error())
+	}
+	program, err := riscv.Assemble(string(source), *xlen, *base)
+	if err != nil {
+		log.Fatalf("can't assemble %s with error: %v", *file, err.Error())
+	}
+
+	if *out == "" {
+		*out = trimExtension(*file) + "." + *format
+	}
+	output, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("can't create output with error: %v", err.Error())
+	}
+	switch *format {
+	case "elf":
+		err = program.WriteElf(output)
+	case "bin":
+		_, err = output.Write(program.Binary())
+	default:
+		output.Close()
+		os.Remove(*out)
+		log.Fatalf("invalid value for -format=%s, should be elf or bin", *format)
+	}
+	if err == nil {
+		err = output.Close()
+	}
+	if err != nil {
+		log.Fatalf("can't write %s with error: %v", *out, err.Error())
+	}
+	for _, s := range program.Sections {
+		log.Printf("section %s at addr=%#x with size=%d \n", s.Name, s.Addr, len(s.Data))
+	}
+	log.Printf("wrote %s with entry=%#x \n", *out, program.Entry)
+}
+
+func trimExtension(path string) string {
+	for i := len(path) - 1; i >= 0 && path[i] != '/'; i-- {
+		if path[i] == '.' {
+			return path[:i]
+		}
+	}
+	return path
+}
@@ -4,39 +4,57 @@ import (
 	"debug/elf"
 	"emu/riscv"
 	"flag"
+	"fmt"
 	"log"
 )
 
-func PrintExecutableCodeSection(s *elf.Section, decoder *riscv.Decoder) {
+// PrintExecutableCodeSection prints the instructions of the section like
+// objdump, with the symbols starting at them. Without a decoder only the
+// instruction words are printed.
+func PrintExecutableCodeSection(s *elf.Section, decoder *riscv.Decoder, dis *riscv.Disassembler, symbols *riscv.SymbolTable) {
 	data, err := s.Data()
 	if err != nil {
 		log.Panic("Invalid section passed to print function.")
 	}
 
-	// No compressed instructions right now, so we can assume all instructions are 4 bytes wide
-	var cache [4]byte
-	for i, b := range data {
-		cache_i := i % 4
-		cache[cache_i] = b
-		if i > 0 && cache_i == 3 {
-			// if we just read the last byte, fomat the instruction
-			log.Printf("prog[%d]=%x%x%x%x \n", i-3, cache[3], cache[2], cache[1], cache[0])
-			if decoder != nil {
-				word := riscv.ByteArrayToWord(cache)
-				instr, err := decoder.Decode(word)
-				if err != nil {
-					log.Printf("can't decode instruction with error: %v \n", err.Error())
-					return
-				}
-				log.Printf("decoded as %v \n", instr)
+	// Instructions are 2 (compressed) or 4 bytes wide, the lowest 2 bits of
+	// the first half tell which one it is.
+	for i := 0; i+2 <= len(data); {
+		low := uint32(data[i]) | uint32(data[i+1])<<8
+		length := int(riscv.InstructionLength(low))
+		if i+length > len(data) {
+			log.Printf("prog[%d] is cut off at the end of the section \n", i)
+			return
+		}
+
+		addr := s.Addr + uint64(i)
+		if symbol, ok := symbols.At(addr); ok && symbol.Value == addr {
+			log.Printf("%s: \n", symbols.AddrString(addr))
+		}
+		word := low
+		encoding := fmt.Sprintf("%04x    ", word)
+		if length == 4 {
+			word = riscv.ByteArrayToWord([4]byte{data[i], data[i+1], data[i+2], data[i+3]})
+			encoding = fmt.Sprintf("%08x", word)
+		}
+		text := ""
+		if decoder != nil {
+			instr, err := decoder.Decode(word)
+			if err != nil {
+				log.Printf("can't decode instruction at addr=%#x with error: %v \n", addr, err.Error())
+				return
 			}
+			text = dis.Disassemble(instr, addr)
 		}
+		log.Printf("%8x: %s  %s \n", addr, encoding, text)
+		i += length
 	}
 }
 
 func main() {
 	file := flag.String("file", "", "Elf file with risc machine code in it.")
 	decodeInstr := flag.Bool("decode", true, "Decodes the instructions/")
+	noAliases := flag.Bool("no-aliases", false, "Print the instructions instead of the pseudo-instructions they implement")
 	flag.Parse()
 
 	if *file == "" {
@@ -69,11 +87,22 @@ func main() {
 	var decoder *riscv.Decoder = nil
 	if *decodeInstr {
 		decoder = riscv.NewDecoder()
-		// at the moment no extensions are supported
 		decoder.RegisterBaseInstructionSet()
-		log.Printf("Decoding instructions of base instruction set\n")
+		decoder.RegisterCompressedInstructionSet()
+		log.Printf("Decoding instructions of base and compressed instruction set\n")
+		if f.Class == elf.ELFCLASS64 {
+			decoder.RegisterRV64InstructionSet()
+			log.Printf("Decoding instructions of RV64 instruction set\n")
+		}
+	}
+	xlen, err := riscv.ElfXlen(f)
+	if err != nil {
+		log.Panic(err.Error())
 	}
-	PrintExecutableCodeSection(f.Sections[section_index], decoder)
+	symbols := riscv.ElfSymbols(f)
+	dis := riscv.NewDisassembler(xlen, symbols)
+	dis.NoAliases = *noAliases
+	PrintExecutableCodeSection(f.Sections[section_index], decoder, dis, symbols)
 
 	f.Close()
 }
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bufio"
+	"debug/elf"
+	"emu/riscv"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+// debugger is the interactive command line debugger of -debug. Memory is
+// accessed with physical addresses, like the gdb server does.
+type debugger struct {
+	emu     *riscv.Emulator
+	regs    riscv.Registers
+	mem     riscv.Memory
+	decoder *riscv.Decoder
+	symbols *riscv.SymbolTable
+	dis     *riscv.Disassembler
+	out     io.Writer
+}
+
+func newDebugger(emu *riscv.Emulator, regs riscv.Registers, mem riscv.Memory, decoder *riscv.Decoder, f *elf.File, out io.Writer) *debugger {
+	symbols := riscv.ElfSymbols(f)
+	return &debugger{
+		emu: emu, regs: regs, mem: mem, decoder: decoder, symbols: symbols,
+		dis: riscv.NewDisassembler(regs.Xlen(), symbols), out: out,
+	}
+}
+
+const debuggerHelp = `commands:
+  s, step [n]             execute n (default 1) instructions
+  c, continue             run until a breakpoint or the program halts
+  r, regs                 print the registers
+  set <reg> <value>       change a register (x0-x31, ABI name or pc)
+  x <addr> [n]            print n (default 4) words of memory
+  w <addr> <value> [size] write a size (default 4) byte value to memory
+  l, dis [addr] [n]       disassemble n (default 8) instructions around the pc or from addr
+  b, break [addr]         set a breakpoint, without addr list the breakpoints
+  d, delete <addr>        delete a breakpoint
+  q, quit                 exit the debugger
+addresses are numbers (0x for hex) or elf symbols with an optional +offset
+`
+
+// run reads commands until the input ends or quit is entered.
+func (d *debugger) run(in io.Reader) {
+	fmt.Fprintf(d.out, "debugging, pc=%s, enter h for help\n", d.symbols.AddrString(d.regs.Pc()))
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(d.out, "(emu) ")
+		if !scanner.Scan() {
+			return
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "q" || args[0] == "quit" {
+			return
+		}
+		if err := d.execute(args[0], args[1:]); err != nil {
+			fmt.Fprintf(d.out, "error: %v\n", err)
+		}
+	}
+}
+
+func (d *debugger) execute(cmd string, args []string) error {
+	switch cmd {
+	case "h", "help":
+		fmt.Fprint(d.out, debuggerHelp)
+	case "s", "step":
+		return d.step(args)
+	case "c", "continue":
+		return d.cont()
+	case "r", "regs":
+		d.printRegisters()
+	case "set":
+		return d.setRegister(args)
+	case "x":
+		return d.examine(args)
+	case "w":
+		return d.write(args)
+	case "l", "dis":
+		return d.disassemble(args)
+	case "b", "break":
+		return d.setBreakpoint(args)
+	case "d", "delete":
+		return d.deleteBreakpoint(args)
+	default:
+		return fmt.Errorf("unknown command %s, enter h for help", cmd)
+	}
+	return nil
+}
+
+// parseAddr parses a number or a symbol with an optional +offset.
+func (d *debugger) parseAddr(s string) (uint64, error) {
+	if addr, err := strconv.ParseUint(s, 0, 64); err == nil {
+		return addr, nil
+	}
+	name, offsetStr, hasOffset := strings.Cut(s, "+")
+	offset := uint64(0)
+	if hasOffset {
+		var err error
+		offset, err = strconv.ParseUint(offsetStr, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid offset %s", offsetStr)
+		}
+	}
+	if sym, ok := d.symbols.Lookup(name); ok {
+		return sym.Value + offset, nil
+	}
+	return 0, fmt.Errorf("%s is not a number or symbol", s)
+}
+
+func (d *debugger) step(args []string) error {
+	n := uint64(1)
+	if len(args) > 0 {
+		var err error
+		n, err = strconv.ParseUint(args[0], 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of steps %s", args[0])
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		if _, err := d.emu.Step(); err != nil {
+			return err
+		}
+	}
+	return d.disassembleAt(d.regs.Pc(), 1)
+}
+
+func (d *debugger) cont() error {
+	// Ctrl-C stops the program instead of the debugger
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-interrupts:
+			d.emu.Interrupt()
+		case <-stop:
+		}
+	}()
+	reason, err := d.emu.Run(0)
+	signal.Stop(interrupts)
+	close(stop)
+	<-done
+	// a Ctrl-C after the program stopped must not stop the next continue
+	if reason != riscv.HaltInterrupted {
+		d.emu.ClearInterrupt()
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(d.out, "halted (%s) after %d instructions\n", reason, d.emu.Steps())
+	if reason == riscv.HaltWatchpoint {
+		hit := d.emu.WatchHit()
+		fmt.Fprintf(d.out, "watchpoint hit at %#x, write=%t\n", hit.Addr, hit.Write)
+	}
+	return d.disassembleAt(d.regs.Pc(), 1)
+}
+
+func (d *debugger) printRegisters() {
+	xlenDigits := d.regs.Xlen() / 4
+	for i, name := range riscv.RegisterNames {
+		fmt.Fprintf(d.out, "%-4s x%-2d 0x%0*x", name, i, xlenDigits, d.regs.Reg(i))
+		if i%4 == 3 {
+			fmt.Fprintln(d.out)
+		} else {
+			fmt.Fprint(d.out, "  ")
+		}
+	}
+	mode := map[uint64]string{riscv.MODE_U: "U", riscv.MODE_S: "S", riscv.MODE_M: "M"}[d.regs.Csrs().Mode()]
+	fmt.Fprintf(d.out, "pc   %s  mode %s\n", d.symbols.AddrString(d.regs.Pc()), mode)
+}
+
+// registerIndex returns the index of x0-x31 or the ABI name.
+func registerIndex(name string) (int, bool) {
+	for i, abiName := range riscv.RegisterNames {
+		if name == abiName || name == fmt.Sprintf("x%d", i) {
+			return i, true
+		}
+	}
+	// fp is the second name of s0
+	if name == "fp" {
+		return 8, true
+	}
+	return 0, false
+}
+
+func (d *debugger) setRegister(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set <reg> <value>")
+	}
+	value, err := d.parseAddr(args[1])
+	if err != nil {
+		return err
+	}
+	if args[0] == "pc" {
+		d.regs.SetPc(value)
+		return nil
+	}
+	i, ok := registerIndex(args[0])
+	if !ok {
+		return fmt.Errorf("unknown register %s", args[0])
+	}
+	d.regs.SetReg(i, value)
+	return nil
+}
+
+func (d *debugger) examine(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: x <addr> [n]")
+	}
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	n := uint64(4)
+	if len(args) == 2 {
+		n, err = strconv.ParseUint(args[1], 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number of words %s", args[1])
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		if i%4 == 0 {
+			if i > 0 {
+				fmt.Fprintln(d.out)
+			}
+			fmt.Fprintf(d.out, "%s:", d.symbols.AddrString(addr+4*i))
+		}
+		word, err := d.mem.Load(addr+4*i, 4)
+		if err != nil {
+			fmt.Fprintln(d.out)
+			return err
+		}
+		fmt.Fprintf(d.out, " %08x", word)
+	}
+	fmt.Fprintln(d.out)
+	return nil
+}
+
+func (d *debugger) write(args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: w <addr> <value> [size]")
+	}
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(args[1], 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid value %s", args[1])
+	}
+	size := uint64(4)
+	if len(args) == 3 {
+		size, err = strconv.ParseUint(args[2], 0, 8)
+		if err != nil || (size != 1 && size != 2 && size != 4 && size != 8) {
+			return fmt.Errorf("invalid size %s, should be 1, 2, 4 or 8", args[2])
+		}
+	}
+	if err := d.mem.Store(addr, value, uint32(size)); err != nil {
+		return err
+	}
+	// the memory can contain instructions
+	d.emu.FlushDecoded()
+	return nil
+}
+
+func (d *debugger) disassemble(args []string) error {
+	n := 8
+	if len(args) > 1 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid number of instructions %s", args[1])
+		}
+	}
+	if len(args) > 0 {
+		addr, err := d.parseAddr(args[0])
+		if err != nil {
+			return err
+		}
+		return d.disassembleAt(addr, n)
+	}
+
+	// Instructions can only be decoded forward, so the ones before the pc
+	// are found by decoding from the start of the function.
+	pc := d.regs.Pc()
+	start := pc
+	if s, ok := d.symbols.At(pc); ok {
+		var addrs []uint64
+		for addr := s.Value; addr < pc; {
+			word, err := d.fetch(addr)
+			if err != nil {
+				break
+			}
+			addrs = append(addrs, addr)
+			addr += uint64(riscv.InstructionLength(word))
+		}
+		if len(addrs) > n/2 {
+			addrs = addrs[len(addrs)-n/2:]
+		}
+		if len(addrs) > 0 {
+			start = addrs[0]
+		}
+	}
+	return d.disassembleAt(start, n)
+}
+
+// fetch loads the 16 or 32 bit instruction at the address.
+func (d *debugger) fetch(addr uint64) (uint32, error) {
+	low, err := d.mem.Load(addr, 2)
+	if err != nil {
+		return 0, err
+	}
+	if riscv.InstructionLength(uint32(low)) == 2 {
+		return uint32(low), nil
+	}
+	high, err := d.mem.Load(addr+2, 2)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(low | high<<16), nil
+}
+
+// disassembleAt prints n instructions from addr, the pc and the breakpoints
+// are marked.
+func (d *debugger) disassembleAt(addr uint64, n int) error {
+	breakpoints := map[uint64]bool{}
+	for _, b := range d.emu.Breakpoints() {
+		breakpoints[b] = true
+	}
+	for i := 0; i < n; i++ {
+		word, err := d.fetch(addr)
+		if err != nil {
+			return err
+		}
+		marker := "  "
+		if addr == d.regs.Pc() {
+			marker = "=>"
+		} else if breakpoints[addr] {
+			marker = "b "
+		}
+		length := uint64(riscv.InstructionLength(word))
+		encoding := fmt.Sprintf("%08x", word)
+		if length == 2 {
+			encoding = fmt.Sprintf("%04x    ", word)
+		}
+		text := "<illegal instruction>"
+		if instr, err := d.decoder.Decode(word); err == nil {
+			text = d.dis.Disassemble(instr, addr)
+		}
+		fmt.Fprintf(d.out, "%s %s: %s  %s\n", marker, d.symbols.AddrString(addr), encoding, text)
+		addr += length
+	}
+	return nil
+}
+
+func (d *debugger) setBreakpoint(args []string) error {
+	if len(args) == 0 {
+		for _, addr := range d.emu.Breakpoints() {
+			fmt.Fprintf(d.out, "breakpoint at %s\n", d.symbols.AddrString(addr))
+		}
+		return nil
+	}
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	d.emu.SetBreakpoint(addr)
+	fmt.Fprintf(d.out, "breakpoint at %s\n", d.symbols.AddrString(addr))
+	return nil
+}
+
+func (d *debugger) deleteBreakpoint(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete <addr>")
+	}
+	addr, err := d.parseAddr(args[0])
+	if err != nil {
+		return err
+	}
+	if !d.emu.ClearBreakpoint(addr) {
+		return fmt.Errorf("no breakpoint at %s", d.symbols.AddrString(addr))
+	}
+	return nil
+}
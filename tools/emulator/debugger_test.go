@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"emu/riscv"
+	"strings"
+	"testing"
+)
+
+const debuggerProgram = `
+	.global _start
+_start:
+	li a0, 5
+	call add_one
+loop:
+	j loop
+add_one:
+	addi a0, a0, 1
+	ret
+	.data
+counter:
+	.word 0x12345678
+`
+
+// newTestDebugger loads the debugger program into a machine like main does.
+func newTestDebugger(t *testing.T, out *bytes.Buffer) *debugger {
+	p, err := riscv.Assemble(debuggerProgram, riscv.XLEN_32, 0x80000000)
+	if err != nil {
+		t.Fatalf("Assemble failed with error %v", err)
+	}
+	var image bytes.Buffer
+	if err := p.WriteElf(&image); err != nil {
+		t.Fatalf("WriteElf failed with error %v", err)
+	}
+	f, err := elf.NewFile(bytes.NewReader(image.Bytes()))
+	if err != nil {
+		t.Fatalf("can't read the elf file with error %v", err)
+	}
+	ram := riscv.NewMemory(0x1000)
+	bus := riscv.NewBus()
+	if err := bus.Map("ram", 0x80000000, 0x1000, &ram); err != nil {
+		t.Fatalf("can't map ram with error %v", err)
+	}
+	regs := riscv.NewRegisters(riscv.XLEN_32)
+	if err := riscv.LoadElf(f, bus, regs); err != nil {
+		t.Fatalf("LoadElf failed with error %v", err)
+	}
+	decoder := riscv.NewDecoder()
+	decoder.RegisterBaseInstructionSet()
+	decoder.RegisterCompressedInstructionSet()
+	emu := riscv.NewEmulator(bus, regs, decoder)
+	return newDebugger(emu, regs, bus, decoder, f, out)
+}
+
+func TestDebuggerCommands(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		commands string
+		expected []string
+	}{
+		{"examine symbol", "x counter 1", []string{"<counter>: 12345678\n"}},
+		{"examine symbol with offset", "x add_one+4 1", []string{"0x80000014 <add_one+0x4>: 00008067\n"}},
+		{"examine unknown symbol", "x nothing", []string{"error: nothing is not a number or symbol\n"}},
+		{"examine invalid offset", "x counter+zz", []string{"error: invalid offset zz\n"}},
+		{"registers by name", "set fp 0x10\nset x31 7\nset a1 counter+4\nr", []string{
+			"s0   x8  0x00000010", "t6   x31 0x00000007", "a1   x11 0x8000001c",
+		}},
+		{"unknown register", "set f0 1", []string{"error: unknown register f0\n"}},
+		{"set pc", "set pc add_one\nr", []string{"pc   0x80000010 <add_one>  mode M\n"}},
+		{"write byte", "w counter 0xab 1\nx counter 1", []string{"<counter>: 123456ab\n"}},
+		{"write invalid size", "w counter 0xab 3", []string{"error: invalid size 3, should be 1, 2, 4 or 8\n"}},
+		{"write without value", "w counter", []string{"error: usage: w <addr> <value> [size]\n"}},
+		{"step", "s 3", []string{"=> 0x80000010 <add_one>: 00150513  addi a0, a0, 1\n"}},
+		{"disassemble from the start of the function", "s 4\nl", []string{
+			"   0x80000010 <add_one>: 00150513  addi a0, a0, 1\n" +
+				"=> 0x80000014 <add_one+0x4>: 00008067  ret\n",
+		}},
+		{"disassemble at address", "l loop 1", []string{"   0x8000000c <loop>: 0000006f  j 0x8000000c <loop>\n"}},
+		{"breakpoint", "b add_one\nl _start 5\nc\nc", []string{
+			"b  0x80000010 <add_one>: 00150513",
+			"halted (breakpoint) after 3 instructions\n=> 0x80000010 <add_one>",
+			"halted (jump to self) after 6 instructions\n",
+		}},
+		{"unknown command", "frobnicate", []string{"error: unknown command frobnicate, enter h for help\n"}},
+	} {
+		var out bytes.Buffer
+		newTestDebugger(t, &out).run(strings.NewReader(test.commands + "\nq\n"))
+		for _, expected := range test.expected {
+			if !strings.Contains(out.String(), expected) {
+				t.Errorf("%s: output doesn't contain %q:\n%s", test.name, expected, out.String())
+			}
+		}
+	}
+}
@@ -2,41 +2,40 @@ package main
 
 // import "fmt"
 import (
+	"bufio"
 	"debug/elf"
 	"emu/riscv"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net"
+	"os"
 )
 
-func processExecutionBody(mem riscv.Memory, r riscv.Registers, executableData []byte, decoder *riscv.Decoder) {
-	var cache [4]byte
-	for i, b := range executableData {
-		cache_i := i % 4
-		cache[cache_i] = b
-		if i > 0 && cache_i == 3 {
-			// if we just read the last byte, fomat the instruction
-			if decoder != nil {
-				word := riscv.ByteArrayToWord(cache)
-				log.Printf("decoding instruction at byte offset %v", i-3)
-				instr, err := decoder.Decode(word)
-				if err != nil {
-					log.Fatalf("can't decode instruction with error: %v", err.Error())
-				}
-				log.Printf("executing instruction I=%s", instr.String())
-				err = instr.Execute(mem, r)
-				if err != nil {
-					log.Fatalf("can't execute instruction (%s) with error: %v", instr.String(), err.Error())
-				}
-			}
-		}
-	}
-}
-
 func main() {
 	file := flag.String("file", "", "Elf file with risc machine code in it.")
 	logRegisterChanged := flag.Bool("log-reg", false, "Log all register changes")
-	memory_size := flag.Int("memory_size", 100, "Size of the memory")
-	memory_offset := flag.Int("memory_offset", 0, "Begin address of memory")
+	memory_size := flag.Int("memory_size", 100, "Size of the memory, grown when the loadable segments don't fit")
+	memory_offset := flag.Int("memory_offset", -1, "Begin address of memory, by default the lowest address of the loadable segments")
+	max_steps := flag.Uint64("max_steps", 0, "Maximum number of instructions to execute, 0 means no limit")
+	trace := flag.Bool("trace", false, "Log every instruction before it is executed")
+	log_commits := flag.String("log_commits", "", "Write a line for every retired instruction to this file")
+	log_commits_format := flag.String("log_commits_format", "spike", "Format of -log_commits: spike (like spike --log-commits) or json")
+	lockstep := flag.String("lockstep", "", "Compare every retired instruction with this golden trace (a spike commit log or json) and stop at the first divergence")
+	lockstep_context := flag.Int("lockstep_context", 5, "Number of instructions shown before and after a divergence from the -lockstep trace")
+	uart := flag.String("uart", "stdio", "Where the 16550 uart is connected to: stdio or none")
+	uart_addr := flag.Uint("uart_addr", 0x10000000, "Address the uart is mapped at")
+	uart_irq := flag.Int("uart_irq", 10, "PLIC interrupt source of the uart")
+	plic_addr := flag.Uint("plic_addr", 0xc000000, "Address the plic (external interrupts) is mapped at")
+	clint_addr := flag.Uint("clint_addr", 0x2000000, "Address the clint (timer and software interrupts) is mapped at")
+	timebase := flag.String("timebase", "instret", "What mtime counts: instret (executed instructions) or host (wall clock time)")
+	timebase_freq := flag.Uint64("timebase_freq", 10000000, "Frequency of mtime in Hz with -timebase=host")
+	htif := flag.Bool("htif", true, "Handle the tohost/fromhost commands of riscv-tests and the proxy kernel when the elf file has a tohost symbol, the exit code of the program becomes the one of the emulator")
+	signature := flag.String("signature", "", "Write the memory between the begin_signature and end_signature symbols to this file when the program halts, in the format of RISCOF")
+	debug := flag.Bool("debug", false, "Start the interactive debugger instead of running the program")
+	gdb := flag.String("gdb", "", "Wait for gdb to connect on this address (e.g. localhost:1234) instead of running the program")
 	flag.Parse()
 
 	if *file == "" {
@@ -48,28 +47,256 @@ func main() {
 	if err != nil {
 		log.Panic(err.Error())
 	}
+	defer f.Close()
+
+	xlen, err := riscv.ElfXlen(f)
+	if err != nil {
+		log.Fatalf("can't load elf file with error: %v", err.Error())
+	}
+	begin, end, err := riscv.ElfLoadRange(f)
+	if err != nil {
+		log.Fatalf("can't load elf file with error: %v", err.Error())
+	}
+	if *memory_offset < 0 {
+		*memory_offset = int(begin)
+	}
+	if *memory_offset > int(begin) {
+		log.Fatalf("memory_offset=%#x is bigger then the first loadable address=%#x", *memory_offset, begin)
+	}
+	if *memory_size < int(end)-*memory_offset {
+		*memory_size = int(end) - *memory_offset
+	}
+	log.Printf("Memory from addr=%#x with size=%d \n", *memory_offset, *memory_size)
+	ram := riscv.NewMemory(*memory_size)
+	bus := riscv.NewBus()
+	err = bus.Map("ram", uint64(*memory_offset), uint64(*memory_size), &ram)
+	if err != nil {
+		log.Fatalf("can't map ram with error: %v", err.Error())
+	}
+	// the 96 interrupt sources of the qemu virt machine
+	plic := riscv.NewPLIC(96)
+	err = bus.Map("plic", uint64(*plic_addr), riscv.PLIC_SIZE, plic)
+	if err != nil {
+		log.Fatalf("can't map plic with error: %v", err.Error())
+	}
+	// stdin can only be read by one device, and by nothing when the
+	// debugger reads its commands from it
+	var guestIn io.Reader = os.Stdin
+	if *debug {
+		guestIn = nil
+	}
+	switch *uart {
+	case "stdio":
+		dev := riscv.NewUART(guestIn, os.Stdout)
+		err = bus.Map("uart", uint64(*uart_addr), riscv.UART_SIZE, dev)
+		if err != nil {
+			log.Fatalf("can't map uart with error: %v", err.Error())
+		}
+		if *uart_irq <= 0 || *uart_irq >= 96 {
+			log.Fatalf("invalid value for -uart_irq=%d, should be 1 to 95", *uart_irq)
+		}
+		dev.SetInterruptHandler(plic.InterruptLine(*uart_irq))
+	case "none":
+	default:
+		log.Fatalf("invalid value for -uart=%s, should be stdio or none", *uart)
+	}
 
-	mem := riscv.NewMemoryWithOffset(*memory_size, uint32(*memory_offset))
 	// var r *riscv.RegistersImpl = &riscv.RegistersImpl{}
-	var r riscv.Registers = &riscv.RegistersImpl{}
+	var r riscv.Registers = riscv.NewRegisters(xlen)
+
+	var tb riscv.Timebase
+	switch *timebase {
+	case "instret":
+		tb = riscv.NewInstructionTimebase()
+	case "host":
+		tb = riscv.NewHostTimebase(*timebase_freq)
+	default:
+		log.Fatalf("invalid value for -timebase=%s, should be instret or host", *timebase)
+	}
+	clint := riscv.NewCLINT(tb)
+	csrs := r.Csrs()
+	plic.SetInterruptHandlers(
+		func(level bool) { csrs.SetInterruptLine(riscv.MIP_MEIP, level) },
+		func(level bool) { csrs.SetInterruptLine(riscv.MIP_SEIP, level) })
+	clint.SetInterruptHandlers(
+		func(level bool) { csrs.SetInterruptLine(riscv.MIP_MSIP, level) },
+		func(level bool) { csrs.SetInterruptLine(riscv.MIP_MTIP, level) })
+	err = bus.Map("clint", uint64(*clint_addr), riscv.CLINT_SIZE, clint)
+	if err != nil {
+		log.Fatalf("can't map clint with error: %v", err.Error())
+	}
 	if *logRegisterChanged {
 		r = riscv.NewLoggedRegisters(r)
 	}
 
 	decoder := riscv.NewDecoder()
-	log.Println("Registering base instruction set in decoder")
+	log.Println("Registering base and compressed instruction set in decoder")
 	decoder.RegisterBaseInstructionSet()
+	decoder.RegisterCompressedInstructionSet()
+	if xlen == riscv.XLEN_64 {
+		log.Println("Registering RV64 instruction set in decoder")
+		decoder.RegisterRV64InstructionSet()
+	}
+
+	err = riscv.LoadElf(f, bus, r)
+	if err != nil {
+		log.Fatalf("can't load elf file with error: %v", err.Error())
+	}
 
-	for i, section := range f.Sections {
-		if section.Type == elf.SHT_PROGBITS {
-			log.Printf("Executing body of section %d with name %s \n", i, section.Name)
-			data, err := section.Data()
-			if err != nil {
-				log.Panicf("Invalid section passed to print function.")
+	var signatureBegin, signatureEnd uint64
+	if *signature != "" {
+		signatureBegin, signatureEnd, err = riscv.ElfSignatureRange(f)
+		if err != nil {
+			log.Fatalf("can't find the signature with error: %v", err.Error())
+		}
+	}
+
+	emu := riscv.NewEmulator(bus, r, decoder)
+	emu.AddTicker(clint)
+	emu.Trace = *trace
+	emu.SetSymbols(riscv.ElfSymbols(f))
+	var host *riscv.HTIF
+	if tohost, err := riscv.ElfSymbol(f, "tohost"); *htif && err == nil {
+		// programs that only exit have no fromhost
+		fromhost, _ := riscv.ElfSymbol(f, "fromhost")
+		var in io.Reader
+		if *uart == "none" {
+			in = guestIn
+		}
+		log.Printf("HTIF with tohost=%#x and fromhost=%#x \n", tohost, fromhost)
+		host = riscv.NewHTIF(bus, tohost, fromhost, in, os.Stdout)
+		host.SetExitHandler(func(code uint64) { emu.Interrupt() })
+		emu.AddTicker(host)
+	}
+	// log.Fatalf skips the deferred calls, so the log is flushed explicitly
+	flushCommitLog := func() {}
+	var commitHandlers []func(c *riscv.CommitRecord) error
+	if *log_commits != "" {
+		logFile, err := os.Create(*log_commits)
+		if err != nil {
+			log.Fatalf("can't create commit log with error: %v", err.Error())
+		}
+		defer logFile.Close()
+		w := bufio.NewWriter(logFile)
+		flushCommitLog = func() { w.Flush() }
+		defer flushCommitLog()
+		var format func(c *riscv.CommitRecord) string
+		switch *log_commits_format {
+		case "spike":
+			// the disassembly line of spike -l before every commit
+			format = func(c *riscv.CommitRecord) string {
+				return c.SpikeDisassemblyString() + "\n" + c.SpikeString()
+			}
+		case "json":
+			format = (*riscv.CommitRecord).JSONString
+		default:
+			log.Fatalf("invalid value for -log_commits_format=%s, should be spike or json", *log_commits_format)
+		}
+		commitHandlers = append(commitHandlers, func(c *riscv.CommitRecord) error {
+			_, err := fmt.Fprintln(w, format(c))
+			return err
+		})
+	}
+	var checker *riscv.Lockstep
+	if *lockstep != "" {
+		traceFile, err := os.Open(*lockstep)
+		if err != nil {
+			log.Fatalf("can't open lockstep trace with error: %v", err.Error())
+		}
+		defer traceFile.Close()
+		checker = riscv.NewLockstep(riscv.NewTraceReader(traceFile), *lockstep_context)
+		commitHandlers = append(commitHandlers, checker.Check)
+	}
+	if len(commitHandlers) > 0 {
+		emu.SetCommitHandler(func(c *riscv.CommitRecord) error {
+			for _, handler := range commitHandlers {
+				if err := handler(c); err != nil {
+					return err
+				}
 			}
+			return nil
+		})
+	}
+
+	if *gdb != "" {
+		listener, err := net.Listen("tcp", *gdb)
+		if err != nil {
+			log.Fatalf("can't listen for gdb with error: %v", err.Error())
+		}
+		log.Printf("Waiting for gdb on %s \n", listener.Addr())
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			log.Fatalf("can't accept gdb connection with error: %v", err.Error())
+		}
+		defer conn.Close()
+		err = riscv.NewGDBServer(emu).Serve(conn)
+		if err != nil {
+			log.Fatalf("gdb connection failed with error: %v", err.Error())
+		}
+		log.Printf("gdb disconnected after %d instructions at pc=%#x \n", emu.Steps(), r.Pc())
+		return
+	}
+
+	if *debug {
+		newDebugger(emu, r, bus, decoder, f, os.Stdout).run(os.Stdin)
+		return
+	}
 
-			processExecutionBody(&mem, r, data, decoder)
+	log.Printf("Starting execution at pc=%#x \n", r.Pc())
+	reason, err := emu.Run(*max_steps)
+	// the program halts normally when it outlives the trace, spike stops
+	// logging once the test wrote tohost
+	traceEnded := checker != nil && errors.Is(err, riscv.ErrTraceEnded)
+	if err != nil && !traceEnded {
+		flushCommitLog()
+		log.Fatalf("emulation stopped after %d instructions with error: %v", emu.Steps(), err.Error())
+	}
+	if traceEnded {
+		log.Printf("emulation stopped at the end of the lockstep trace after %d instructions at pc=%#x \n", emu.Steps(), r.Pc())
+	} else {
+		log.Printf("emulation halted (%s) after %d instructions at pc=%#x \n", reason, emu.Steps(), r.Pc())
+	}
+	if *signature != "" {
+		if err := writeSignature(*signature, bus, signatureBegin, signatureEnd); err != nil {
+			flushCommitLog()
+			log.Fatalf("can't write signature with error: %v", err.Error())
+		}
+	}
+	// the trace continues after -max_steps
+	if checker != nil && reason != riscv.HaltStepLimit && !traceEnded {
+		if err := checker.Finish(); err != nil {
+			flushCommitLog()
+			log.Fatalf("lockstep failed with error: %v", err.Error())
 		}
 	}
+	if checker != nil {
+		log.Printf("%d instructions of the lockstep trace matched \n", checker.Matched())
+	}
+	if host != nil {
+		// the program halts before the HTIF sees the command a second time
+		// when it spins right after writing tohost
+		host.Poll()
+		if code, exited := host.Exited(); exited {
+			log.Printf("program exited with code %d \n", code)
+			flushCommitLog()
+			// exit codes are only 8 bits, a failure must not become 0
+			if code > 255 {
+				code = 255
+			}
+			os.Exit(int(code))
+		}
+	}
+}
 
+func writeSignature(path string, mem riscv.Memory, begin uint64, end uint64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := riscv.WriteSignature(file, mem, begin, end); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
 }